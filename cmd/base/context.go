@@ -58,6 +58,7 @@ type GenericOptions func(i interface{})
 type GenericContext struct {
 	*http.Server
 	httpHandler   *process.HTTPHandler
+	mux           *http.ServeMux
 	healthChecker *HealthzChecker
 
 	// those following components are shared by all generic components.
@@ -185,6 +186,7 @@ func NewGenericContext(
 
 	c := &GenericContext{
 		httpHandler: httpHandler,
+		mux:         mux,
 		Server: &http.Server{
 			Handler: httpHandler.WithHandleChain(mux),
 			Addr:    genericConf.GenericEndpoint,
@@ -220,6 +222,16 @@ func (c *GenericContext) SetDefaultMetricsEmitter(metricEmitter metrics.MetricEm
 	c.EmitterPool.SetDefaultMetricsEmitter(metricEmitter)
 }
 
+// HandleFunc registers the handler for the pattern on the generic endpoint, and it's served behind the handle
+// chains of the endpoint, i.e. authentication and authorization are only skipped for patterns under the debug
+// and healthz paths. It's a no-op if the context is built without the generic endpoint.
+func (c *GenericContext) HandleFunc(pattern string, handler http.HandlerFunc) {
+	if c == nil || c.mux == nil {
+		return
+	}
+	c.mux.HandleFunc(pattern, handler)
+}
+
 // Run starts the generic components
 func (c *GenericContext) Run(ctx context.Context) {
 	c.httpHandler.Run(ctx)
@@ -41,6 +41,9 @@ func TestNewDynamicOptions(t *testing.T) {
 	if options.IRQTuningOptions == nil {
 		t.Errorf("IRQTuningOptions is nil")
 	}
+	if options.QuotaReconcileOptions == nil {
+		t.Errorf("QuotaReconcileOptions is nil")
+	}
 }
 
 func TestDynamicOptions_AddFlags(t *testing.T) {
@@ -68,6 +71,10 @@ func TestDynamicOptions_AddFlags(t *testing.T) {
 	if irqTuningFlagSet == nil {
 		t.Errorf("irq-tuning flag set not found")
 	}
+
+	if fss.FlagSet("quota_reconcile").Lookup("quota-reconcile-new-pod-grace-period") == nil {
+		t.Errorf("quota-reconcile-new-pod-grace-period flag not found")
+	}
 }
 
 func TestDynamicOptions_ApplyTo(t *testing.T) {
@@ -94,4 +101,7 @@ func TestDynamicOptions_ApplyTo(t *testing.T) {
 	if config.IRQTuningConfiguration == nil {
 		t.Errorf("IRQTuningConfiguration is nil after ApplyTo")
 	}
+	if config.QuotaReconcileConfiguration == nil || config.QuotaReconcileConfiguration.PodDirSearchDepth != 1 {
+		t.Errorf("QuotaReconcileConfiguration is not applied")
+	}
 }
@@ -0,0 +1,310 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quotareconcile
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	cliflag "k8s.io/component-base/cli/flag"
+
+	"github.com/kubewharf/katalyst-core/pkg/config/agent/dynamic/quotareconcile"
+)
+
+type QuotaReconcileOptions struct {
+	PodDirSearchDepth           int
+	CgroupWriteFailureThreshold int
+	CgroupWriteTimeout          time.Duration
+	MaxCgroupWritesPerRound     int
+	ReconcileBudget             time.Duration
+	PodFailureBackoffBase       time.Duration
+	PodFailureBackoffMax        time.Duration
+	WaitForNodeReady            bool
+	MinNodeUptime               time.Duration
+	PauseOnNodeMaintenance      bool
+	FullAuditRoundInterval      int
+	QuotaReadBackSettleDelay    time.Duration
+	NewPodQuotaGracePeriod      time.Duration
+
+	AdvisorPlanStalenessThreshold    time.Duration
+	StatusReportInterval             time.Duration
+	EnableMetricsExport              bool
+	AnnotationQuotaFallbackStaleness time.Duration
+	QuotaOvercommitWarningRatio      float64
+	ThrottleAlertRatio               float64
+	QuotaStarvationEvictionRounds    int
+	QuotaStarvationThrottleRatio     float64
+
+	ContainerQuotaFloorMilliCores              int64
+	ContainerQuotaFloorRequestRatio            float64
+	UsageWeightedContainerQuota                bool
+	UsageQuotaSafetyMarginPercent              float64
+	PodQuotaUsagePercentile                    float64
+	PodQuotaUsagePercentileMarginPercent       float64
+	PodQuotaUsageHistorySamples                int
+	ZeroRequestContainerPolicy                 string
+	ZeroRequestContainerDefaultQuotaMilliCores int64
+	ResetStalePodQuota                         bool
+	AuditOrphanedQuota                         bool
+	KubeletCPUManagerStateFile                 string
+	UnlimitedQuotaCapMilliCores                int64
+
+	QuotaRampStepMilliCores        int64
+	QuotaRampDecreaseOnly          bool
+	QuotaMaxIncreaseStepMilliCores int64
+	QuotaRoundingPolicy            string
+	QoSLevelCPUPeriods             map[string]string
+
+	PodLabelSelector           string
+	IncludeEphemeralContainers bool
+	PoolCgroupPathPrefixes     []string
+	AdvisorSourceMergeStrategy string
+	AdvisorSourcePriorities    []string
+
+	DecisionLogFile       string
+	DecisionLogMaxSizeMB  int
+	DecisionLogMaxBackups int
+
+	WebhookURL                       string
+	WebhookChangeThresholdMilliCores int64
+	WebhookTimeout                   time.Duration
+	WebhookMaxRetries                int
+
+	AdvisorPayloadLogEnabled      bool
+	AdvisorPayloadLogMaxBytes     int
+	AdvisorPayloadLogRedactedKeys []string
+}
+
+func NewQuotaReconcileOptions() *QuotaReconcileOptions {
+	return &QuotaReconcileOptions{
+		PodDirSearchDepth:           1,
+		CgroupWriteFailureThreshold: 10,
+		FullAuditRoundInterval:      10,
+		DecisionLogMaxSizeMB:        100,
+		DecisionLogMaxBackups:       3,
+		WebhookTimeout:              5 * time.Second,
+		WebhookMaxRetries:           3,
+		QuotaRoundingPolicy:         quotareconcile.QuotaRoundingPolicyNone,
+		AdvisorSourceMergeStrategy:  quotareconcile.AdvisorSourceMergeStrategyLastWriter,
+		PodQuotaUsageHistorySamples: 60,
+		ZeroRequestContainerPolicy:  quotareconcile.ZeroRequestContainerPolicyLimit,
+		KubeletCPUManagerStateFile:  "/var/lib/kubelet/cpu_manager_state",
+	}
+}
+
+func (o *QuotaReconcileOptions) AddFlags(fss *cliflag.NamedFlagSets) {
+	fs := fss.FlagSet("quota_reconcile")
+
+	fs.IntVar(&o.PodDirSearchDepth, "quota-reconcile-pod-dir-search-depth", o.PodDirSearchDepth,
+		"the number of directory levels under the advisor cgroup path searched for pod cgroup directories")
+	fs.IntVar(&o.CgroupWriteFailureThreshold, "quota-reconcile-cgroup-write-failure-threshold", o.CgroupWriteFailureThreshold,
+		"the number of consecutive cgroup write failures after which the remaining writes in the same round are skipped, "+
+			"zero means never skipping")
+	fs.DurationVar(&o.CgroupWriteTimeout, "quota-reconcile-cgroup-write-timeout", o.CgroupWriteTimeout,
+		"the timeout of a cgroup write, after which the write is abandoned and counted as a failure, zero means no timeout")
+	fs.IntVar(&o.MaxCgroupWritesPerRound, "quota-reconcile-max-cgroup-writes-per-round", o.MaxCgroupWritesPerRound,
+		"the max number of cgroup writes in a round, after which the remaining writes are left for the next round, "+
+			"zero means no cap")
+	fs.DurationVar(&o.ReconcileBudget, "quota-reconcile-budget", o.ReconcileBudget,
+		"the max duration of a round, after which the round stops after the current pod and the next round resumes "+
+			"from where it's cut off, zero means no budget")
+	fs.DurationVar(&o.PodFailureBackoffBase, "quota-reconcile-pod-failure-backoff-base", o.PodFailureBackoffBase,
+		"the backoff after which a pod that fails to be applied is retried, doubled with each consecutive failure, "+
+			"zero means retrying in every round")
+	fs.DurationVar(&o.PodFailureBackoffMax, "quota-reconcile-pod-failure-backoff-max", o.PodFailureBackoffMax,
+		"the max backoff of a pod that fails to be applied, zero means no max")
+	fs.BoolVar(&o.WaitForNodeReady, "quota-reconcile-wait-for-node-ready", o.WaitForNodeReady,
+		"whether the first reconcile is deferred until the node is ready, so that an incomplete view at node boot isn't acted on")
+	fs.DurationVar(&o.MinNodeUptime, "quota-reconcile-min-node-uptime", o.MinNodeUptime,
+		"the min uptime of the node before the first reconcile, zero means no min uptime")
+	fs.BoolVar(&o.PauseOnNodeMaintenance, "quota-reconcile-pause-on-node-maintenance", o.PauseOnNodeMaintenance,
+		"whether reconciles are paused while the node is cordoned or annotated to be under maintenance")
+	fs.IntVar(&o.FullAuditRoundInterval, "quota-reconcile-full-audit-round-interval", o.FullAuditRoundInterval,
+		"the interval (in rounds) of full audit rounds, in which every pod is read back and re-applied regardless of "+
+			"the fast path, zero means no full audit")
+	fs.DurationVar(&o.QuotaReadBackSettleDelay, "quota-reconcile-quota-read-back-settle-delay", o.QuotaReadBackSettleDelay,
+		"the delay after a quota write to a pod cgroup before it's read back for the drift check, zero means no delay")
+	fs.DurationVar(&o.AdvisorPlanStalenessThreshold, "quota-reconcile-advisor-plan-staleness-threshold", o.AdvisorPlanStalenessThreshold,
+		"the age of the last applied plan of cpu advisor after which a warning is logged, zero means no warning")
+	fs.DurationVar(&o.StatusReportInterval, "quota-reconcile-status-report-interval", o.StatusReportInterval,
+		"the min interval between writes of the reconcile status to the cnr of the node, zero means the status isn't written")
+	fs.BoolVar(&o.EnableMetricsExport, "quota-reconcile-enable-metrics-export", o.EnableMetricsExport,
+		"whether reconcile metrics are also exposed in the prometheus text format on /metrics/quota-reconcile of the agent endpoint, "+
+			"the path is only registered if it's enabled at startup")
+	fs.DurationVar(&o.AnnotationQuotaFallbackStaleness, "quota-reconcile-annotation-quota-fallback-staleness",
+		o.AnnotationQuotaFallbackStaleness, "the age of the last applied plan of cpu advisor, or the time without any plan, "+
+			"after which quota annotations of pods are applied as a fallback, zero means no fallback")
+	fs.Float64Var(&o.QuotaOvercommitWarningRatio, "quota-reconcile-quota-overcommit-warning-ratio", o.QuotaOvercommitWarningRatio,
+		"the ratio of quotas summed over cgroup configs of cpu advisor to node allocatable cpu, beyond which a warning is logged, "+
+			"zero means no warning")
+	fs.Float64Var(&o.ThrottleAlertRatio, "quota-reconcile-throttle-alert-ratio", o.ThrottleAlertRatio,
+		"the ratio of throttled periods to all periods of a pod between rounds, beyond which a warning event is recorded "+
+			"for the pod, zero means no alert")
+	fs.IntVar(&o.QuotaStarvationEvictionRounds, "quota-reconcile-quota-starvation-eviction-rounds", o.QuotaStarvationEvictionRounds,
+		"the number of consecutive rounds in which a pod's quota is held at the floor while it's throttled heavily, "+
+			"after which the pod is signaled to the cpu eviction, zero means never signaling")
+	fs.Float64Var(&o.QuotaStarvationThrottleRatio, "quota-reconcile-quota-starvation-throttle-ratio", o.QuotaStarvationThrottleRatio,
+		"the throttle ratio beyond which a pod at the quota floor is considered starved")
+	fs.DurationVar(&o.NewPodQuotaGracePeriod, "quota-reconcile-new-pod-grace-period", o.NewPodQuotaGracePeriod,
+		"the period after a pod's creation during which its quota is left untouched, zero means no grace period")
+	fs.Int64Var(&o.ContainerQuotaFloorMilliCores, "quota-reconcile-container-quota-floor-millicores", o.ContainerQuotaFloorMilliCores,
+		"the absolute minimum quota (in milli-cores) applied to a container")
+	fs.Float64Var(&o.ContainerQuotaFloorRequestRatio, "quota-reconcile-container-quota-floor-request-ratio", o.ContainerQuotaFloorRequestRatio,
+		"the minimum quota applied to a container as a fraction of its cpu request, the larger one of the two floors takes effect "+
+			"but never exceeds the cpu limit of the container")
+	fs.BoolVar(&o.UsageWeightedContainerQuota, "quota-reconcile-usage-weighted-container-quota", o.UsageWeightedContainerQuota,
+		"whether the pod quota is distributed among its containers in proportion to their cpu usage on top of their floors, "+
+			"instead of by their own limits")
+	fs.Float64Var(&o.UsageQuotaSafetyMarginPercent, "quota-reconcile-usage-quota-safety-margin-percent", o.UsageQuotaSafetyMarginPercent,
+		"the headroom (in percent of usage) above cpu usage of a container kept in its usage-weighted quota, "+
+			"clamped to the container limit, zero means no margin")
+	fs.Float64Var(&o.PodQuotaUsagePercentile, "quota-reconcile-pod-quota-usage-percentile", o.PodQuotaUsagePercentile,
+		"the percentile (in (0, 100]) of historical cpu usage of a pod its quota is sized to instead of its limit, "+
+			"zero means pod quota is sized by limits")
+	fs.Float64Var(&o.PodQuotaUsagePercentileMarginPercent, "quota-reconcile-pod-quota-usage-percentile-margin-percent",
+		o.PodQuotaUsagePercentileMarginPercent,
+		"the headroom (in percent of usage) above the usage percentile kept in the percentile-based pod quota, zero means no margin")
+	fs.IntVar(&o.PodQuotaUsageHistorySamples, "quota-reconcile-pod-quota-usage-history-samples", o.PodQuotaUsageHistorySamples,
+		"the number of latest usage samples of a pod the percentile is taken from")
+	fs.StringVar(&o.ZeroRequestContainerPolicy, "quota-reconcile-zero-request-container-policy", o.ZeroRequestContainerPolicy,
+		"how quota is applied to containers with no cpu request, one of limit, skip, unlimited and default")
+	fs.Int64Var(&o.ZeroRequestContainerDefaultQuotaMilliCores, "quota-reconcile-zero-request-container-default-quota-millicores",
+		o.ZeroRequestContainerDefaultQuotaMilliCores,
+		"the quota (in milli-cores) applied to containers with no cpu request with the default policy")
+	fs.BoolVar(&o.ResetStalePodQuota, "quota-reconcile-reset-stale-pod-quota", o.ResetStalePodQuota,
+		"whether to reset quota of pod cgroups with no live pod to unlimited before pruning their records")
+	fs.BoolVar(&o.AuditOrphanedQuota, "quota-reconcile-audit-orphaned-quota", o.AuditOrphanedQuota,
+		"whether to audit tracked quota records in each round, and reset quota of cgroups matching no pod, pool or kubepods root to unlimited")
+	fs.StringVar(&o.KubeletCPUManagerStateFile, "quota-reconcile-kubelet-cpu-manager-state-file", o.KubeletCPUManagerStateFile,
+		"the checkpoint file of kubelet cpu manager, pods with exclusive cpus of the static policy in it are left to kubelet, "+
+			"empty means no pods are left to kubelet")
+	fs.Int64Var(&o.UnlimitedQuotaCapMilliCores, "quota-reconcile-unlimited-quota-cap-millicores", o.UnlimitedQuotaCapMilliCores,
+		"the quota (in milli-cores) applied instead when cpu advisor requests unlimited quota for a cgroup, zero means keeping it unlimited")
+	fs.Int64Var(&o.QuotaRampStepMilliCores, "quota-reconcile-quota-ramp-step-millicores", o.QuotaRampStepMilliCores,
+		"the max change of quota (in milli-cores) applied to a pod in a round, containers follow the ramped quota of the pod, "+
+			"zero means applying the target directly")
+	fs.BoolVar(&o.QuotaRampDecreaseOnly, "quota-reconcile-quota-ramp-decrease-only", o.QuotaRampDecreaseOnly,
+		"whether only decreases of quota are ramped, and increases are applied directly")
+	fs.Int64Var(&o.QuotaMaxIncreaseStepMilliCores, "quota-reconcile-quota-max-increase-step-millicores", o.QuotaMaxIncreaseStepMilliCores,
+		"the max increase of quota (in milli-cores) applied to a pod in a round regardless of the ramp, zero means no limit")
+	fs.StringVar(&o.QuotaRoundingPolicy, "quota-reconcile-quota-rounding-policy", o.QuotaRoundingPolicy,
+		"how computed quota is rounded to whole milliseconds of the period before it's applied, one of none, up and nearest")
+	fs.StringToStringVar(&o.QoSLevelCPUPeriods, "quota-reconcile-qos-level-cpu-periods", o.QoSLevelCPUPeriods,
+		"cfs periods keyed by qos levels, in which quota of pods of the qos levels is derived and written along with it, "+
+			"e.g. shared_cores=50ms, qos levels with no period keep the period of their cgroups")
+	fs.StringVar(&o.PodLabelSelector, "quota-reconcile-pod-label-selector", o.PodLabelSelector,
+		"the label selector limiting quota reconcile to matching pods, e.g. for canary rollouts, empty means all pods")
+	fs.BoolVar(&o.IncludeEphemeralContainers, "quota-reconcile-include-ephemeral-containers", o.IncludeEphemeralContainers,
+		"whether quota is also applied to ephemeral containers besides app containers and restartable init containers")
+	fs.StringSliceVar(&o.PoolCgroupPathPrefixes, "quota-reconcile-pool-cgroup-path-prefixes", o.PoolCgroupPathPrefixes,
+		"the prefixes of cgroup paths of shared pools, whose quota is applied to the pools instead of pods under them")
+	fs.StringVar(&o.AdvisorSourceMergeStrategy, "quota-reconcile-advisor-source-merge-strategy", o.AdvisorSourceMergeStrategy,
+		"how knobs of multiple advisor sources for the same cgroup are merged, one of last-writer and priority")
+	fs.StringSliceVar(&o.AdvisorSourcePriorities, "quota-reconcile-advisor-source-priorities", o.AdvisorSourcePriorities,
+		"the advisor sources in descending priority for the priority merge strategy, sources not in it have the lowest priority")
+	fs.StringVar(&o.DecisionLogFile, "quota-reconcile-decision-log-file", o.DecisionLogFile,
+		"the file to which decisions of quota reconcile are written as JSON lines for offline analysis, empty means disabled")
+	fs.IntVar(&o.DecisionLogMaxSizeMB, "quota-reconcile-decision-log-max-size-mb", o.DecisionLogMaxSizeMB,
+		"the max size (in megabytes) of the decision log file before it's rotated")
+	fs.IntVar(&o.DecisionLogMaxBackups, "quota-reconcile-decision-log-max-backups", o.DecisionLogMaxBackups,
+		"the max number of rotated decision log files to retain, zero means retaining all")
+	fs.StringVar(&o.WebhookURL, "quota-reconcile-webhook-url", o.WebhookURL,
+		"the url to which significant quota changes of pods are posted as JSON payloads for audit, empty means disabled")
+	fs.Int64Var(&o.WebhookChangeThresholdMilliCores, "quota-reconcile-webhook-change-threshold-millicores", o.WebhookChangeThresholdMilliCores,
+		"the min change of quota (in milli-cores) posted to the webhook, changes from or to unlimited are always posted")
+	fs.DurationVar(&o.WebhookTimeout, "quota-reconcile-webhook-timeout", o.WebhookTimeout,
+		"the timeout of a post to the webhook, zero means no timeout")
+	fs.IntVar(&o.WebhookMaxRetries, "quota-reconcile-webhook-max-retries", o.WebhookMaxRetries,
+		"the max number of retries of a failed post to the webhook")
+	fs.BoolVar(&o.AdvisorPayloadLogEnabled, "quota-reconcile-advisor-payload-log-enabled", o.AdvisorPayloadLogEnabled,
+		"whether the raw control knobs pushed by cpu advisor are logged before they are decoded, for debugging only")
+	fs.IntVar(&o.AdvisorPayloadLogMaxBytes, "quota-reconcile-advisor-payload-log-max-bytes", o.AdvisorPayloadLogMaxBytes,
+		"the max size (in bytes) of a logged advisor payload, beyond which it's truncated, zero means the default size")
+	fs.StringSliceVar(&o.AdvisorPayloadLogRedactedKeys, "quota-reconcile-advisor-payload-log-redacted-keys", o.AdvisorPayloadLogRedactedKeys,
+		"the control knobs whose values are redacted in logged advisor payloads")
+}
+
+func (o *QuotaReconcileOptions) ApplyTo(conf *quotareconcile.QuotaReconcileConfiguration) error {
+	conf.PodDirSearchDepth = o.PodDirSearchDepth
+	conf.CgroupWriteFailureThreshold = o.CgroupWriteFailureThreshold
+	conf.CgroupWriteTimeout = o.CgroupWriteTimeout
+	conf.MaxCgroupWritesPerRound = o.MaxCgroupWritesPerRound
+	conf.ReconcileBudget = o.ReconcileBudget
+	conf.PodFailureBackoffBase = o.PodFailureBackoffBase
+	conf.PodFailureBackoffMax = o.PodFailureBackoffMax
+	conf.WaitForNodeReady = o.WaitForNodeReady
+	conf.MinNodeUptime = o.MinNodeUptime
+	conf.PauseOnNodeMaintenance = o.PauseOnNodeMaintenance
+	conf.FullAuditRoundInterval = o.FullAuditRoundInterval
+	conf.QuotaReadBackSettleDelay = o.QuotaReadBackSettleDelay
+	conf.AdvisorPlanStalenessThreshold = o.AdvisorPlanStalenessThreshold
+	conf.StatusReportInterval = o.StatusReportInterval
+	conf.EnableMetricsExport = o.EnableMetricsExport
+	conf.AnnotationQuotaFallbackStaleness = o.AnnotationQuotaFallbackStaleness
+	conf.QuotaOvercommitWarningRatio = o.QuotaOvercommitWarningRatio
+	conf.ThrottleAlertRatio = o.ThrottleAlertRatio
+	conf.QuotaStarvationEvictionRounds = o.QuotaStarvationEvictionRounds
+	conf.QuotaStarvationThrottleRatio = o.QuotaStarvationThrottleRatio
+	conf.NewPodQuotaGracePeriod = o.NewPodQuotaGracePeriod
+	conf.ContainerQuotaFloorMilliCores = o.ContainerQuotaFloorMilliCores
+	conf.ContainerQuotaFloorRequestRatio = o.ContainerQuotaFloorRequestRatio
+	conf.UnlimitedQuotaCapMilliCores = o.UnlimitedQuotaCapMilliCores
+	conf.UsageWeightedContainerQuota = o.UsageWeightedContainerQuota
+	conf.UsageQuotaSafetyMarginPercent = o.UsageQuotaSafetyMarginPercent
+	conf.PodQuotaUsagePercentile = o.PodQuotaUsagePercentile
+	conf.PodQuotaUsagePercentileMarginPercent = o.PodQuotaUsagePercentileMarginPercent
+	conf.PodQuotaUsageHistorySamples = o.PodQuotaUsageHistorySamples
+	conf.ZeroRequestContainerPolicy = o.ZeroRequestContainerPolicy
+	conf.ZeroRequestContainerDefaultQuotaMilliCores = o.ZeroRequestContainerDefaultQuotaMilliCores
+	conf.ResetStalePodQuota = o.ResetStalePodQuota
+	conf.AuditOrphanedQuota = o.AuditOrphanedQuota
+	conf.KubeletCPUManagerStateFile = o.KubeletCPUManagerStateFile
+	conf.QuotaRampStepMilliCores = o.QuotaRampStepMilliCores
+	conf.QuotaRampDecreaseOnly = o.QuotaRampDecreaseOnly
+	conf.QuotaMaxIncreaseStepMilliCores = o.QuotaMaxIncreaseStepMilliCores
+	conf.QuotaRoundingPolicy = o.QuotaRoundingPolicy
+	conf.QoSLevelCPUPeriods = make(map[string]time.Duration, len(o.QoSLevelCPUPeriods))
+	for qosLevel, period := range o.QoSLevelCPUPeriods {
+		d, err := time.ParseDuration(period)
+		if err != nil {
+			return fmt.Errorf("invalid cpu period %s of qos level %s: %v", period, qosLevel, err)
+		}
+		conf.QoSLevelCPUPeriods[qosLevel] = d
+	}
+	conf.IncludeEphemeralContainers = o.IncludeEphemeralContainers
+	conf.PoolCgroupPathPrefixes = o.PoolCgroupPathPrefixes
+	conf.AdvisorSourceMergeStrategy = o.AdvisorSourceMergeStrategy
+	conf.AdvisorSourcePriorities = o.AdvisorSourcePriorities
+	conf.DecisionLogFile = o.DecisionLogFile
+	conf.DecisionLogMaxSizeMB = o.DecisionLogMaxSizeMB
+	conf.DecisionLogMaxBackups = o.DecisionLogMaxBackups
+	conf.WebhookURL = o.WebhookURL
+	conf.WebhookChangeThresholdMilliCores = o.WebhookChangeThresholdMilliCores
+	conf.WebhookTimeout = o.WebhookTimeout
+	conf.WebhookMaxRetries = o.WebhookMaxRetries
+	conf.AdvisorPayloadLogEnabled = o.AdvisorPayloadLogEnabled
+	conf.AdvisorPayloadLogMaxBytes = o.AdvisorPayloadLogMaxBytes
+	conf.AdvisorPayloadLogRedactedKeys = o.AdvisorPayloadLogRedactedKeys
+
+	podLabelSelector, err := labels.Parse(o.PodLabelSelector)
+	if err != nil {
+		return fmt.Errorf("parse pod label selector %s failed with error: %v", o.PodLabelSelector, err)
+	}
+	conf.PodLabelSelector = podLabelSelector
+	return nil
+}
@@ -20,10 +20,13 @@ import (
 	"strconv"
 
 	"k8s.io/apimachinery/pkg/api/resource"
+	utilfeature "k8s.io/apiserver/pkg/util/feature"
 	cliflag "k8s.io/component-base/cli/flag"
 	"k8s.io/klog/v2"
 
 	"github.com/kubewharf/katalyst-core/pkg/config/agent/global"
+	// katalyst feature gates are registered before they are parsed from --feature-gates
+	_ "github.com/kubewharf/katalyst-core/pkg/features"
 )
 
 const (
@@ -110,6 +113,7 @@ func (o *BaseOptions) AddFlags(fss *cliflag.NamedFlagSets) {
 	fs.StringSliceVar(&o.Agents, "agents", o.Agents, "The agents need to be started")
 	fs.StringVar(&o.NodeName, "node-name", o.NodeName, "the name of this node")
 	fs.StringVar(&o.NodeAddress, "node-address", o.NodeAddress, "the address of this node")
+	utilfeature.DefaultMutableFeatureGate.AddFlag(fs)
 
 	fs.StringVar(&o.LockFileName, "locking-file", o.LockFileName, "The filename used as unique lock")
 	fs.BoolVar(&o.LockWaitingEnabled, "locking-waiting", o.LockWaitingEnabled,
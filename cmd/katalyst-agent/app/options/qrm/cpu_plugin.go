@@ -17,6 +17,7 @@ limitations under the License.
 package qrm
 
 import (
+	"fmt"
 	"time"
 
 	cliflag "k8s.io/component-base/cli/flag"
@@ -40,6 +41,7 @@ type CPUOptions struct {
 type CPUDynamicPolicyOptions struct {
 	EnableCPUAdvisor                          bool
 	AdvisorGetAdviceInterval                  time.Duration
+	AdvisorMinReconcileInterval               time.Duration
 	EnableCPUPressureEviction                 bool
 	LoadPressureEvictionSkipPools             []string
 	EnableSyncingCPUIdle                      bool
@@ -48,6 +50,9 @@ type CPUDynamicPolicyOptions struct {
 	CPUNUMAHintPreferLowThreshold             float64
 	SharedCoresNUMABindingResultAnnotationKey string
 	EnableReserveCPUReversely                 bool
+	CgroupRootOverride                        string
+	StaticPlanFile                            string
+	QoSLevelReconcileIntervals                map[string]string
 	*irqtuner.IRQTunerOptions
 	*hintoptimizer.HintOptimizerOptions
 }
@@ -94,6 +99,9 @@ func (o *CPUOptions) AddFlags(fss *cliflag.NamedFlagSets) {
 		o.EnableCPUAdvisor, "Whether cpu resource plugin should enable sys-advisor")
 	fs.DurationVar(&o.AdvisorGetAdviceInterval, "cpu-resource-plugin-advisor-interval",
 		o.AdvisorGetAdviceInterval, "If cpu advisor is enabled, this is the interval at which we get advice from sys-advisor")
+	fs.DurationVar(&o.AdvisorMinReconcileInterval, "cpu-resource-plugin-advisor-min-reconcile-interval",
+		o.AdvisorMinReconcileInterval, "If cpu advisor is enabled, this is the minimum interval between two reconciles by advice, "+
+			"advice pushed within the interval is coalesced into a single deferred reconcile, zero means no limit")
 	fs.IntVar(&o.ReservedCPUCores, "cpu-resource-plugin-reserved",
 		o.ReservedCPUCores, "The total cores cpu resource plugin should reserve")
 	fs.BoolVar(&o.SkipCPUStateCorruption, "skip-cpu-state-corruption",
@@ -119,6 +127,16 @@ func (o *CPUOptions) AddFlags(fss *cliflag.NamedFlagSets) {
 	fs.BoolVar(&o.EnableReserveCPUReversely, "enable-reserve-cpu-reversely",
 		o.EnableReserveCPUReversely, "by default, the reservation of cpu starts from the cpu with lower id,"+
 			"if set to true, it starts from the cpu with higher id")
+	fs.StringVar(&o.StaticPlanFile, "cpu-resource-plugin-static-plan-file",
+		o.StaticPlanFile, "The file of a static plan applied periodically instead of advice from sys-advisor, "+
+			"which is a JSON list of calculation infos, empty means no static plan")
+	fs.StringVar(&o.CgroupRootOverride, "cpu-resource-plugin-advisor-cgroup-root-override",
+		o.CgroupRootOverride, "If cpu advisor is enabled, this overrides the cgroupfs mount point on which cgroup paths of advice "+
+			"are resolved, e.g. in nested environments, empty means the host mount point")
+	fs.StringToStringVar(&o.QoSLevelReconcileIntervals, "cpu-resource-plugin-qos-level-reconcile-intervals",
+		o.QoSLevelReconcileIntervals, "Intervals keyed by qos levels, at which quota of pods of the qos levels is reconciled "+
+			"again with the last applied plan, e.g. dedicated_cores=10s,shared_cores=1m, qos levels with no interval are "+
+			"only reconciled with plans pushed by cpu advisor")
 	o.HintOptimizerOptions.AddFlags(fss)
 	o.IRQTunerOptions.AddFlags(fss)
 }
@@ -127,6 +145,17 @@ func (o *CPUOptions) ApplyTo(conf *qrmconfig.CPUQRMPluginConfig) error {
 	conf.PolicyName = o.PolicyName
 	conf.EnableCPUAdvisor = o.EnableCPUAdvisor
 	conf.GetAdviceInterval = o.AdvisorGetAdviceInterval
+	conf.MinReconcileInterval = o.AdvisorMinReconcileInterval
+	conf.CgroupRootOverride = o.CgroupRootOverride
+	conf.StaticPlanFile = o.StaticPlanFile
+	conf.QoSLevelReconcileIntervals = make(map[string]time.Duration, len(o.QoSLevelReconcileIntervals))
+	for qosLevel, interval := range o.QoSLevelReconcileIntervals {
+		d, err := time.ParseDuration(interval)
+		if err != nil {
+			return fmt.Errorf("invalid reconcile interval %s of qos level %s: %v", interval, qosLevel, err)
+		}
+		conf.QoSLevelReconcileIntervals[qosLevel] = d
+	}
 	conf.ReservedCPUCores = o.ReservedCPUCores
 	conf.SkipCPUStateCorruption = o.SkipCPUStateCorruption
 	conf.EnableCPUPressureEviction = o.EnableCPUPressureEviction
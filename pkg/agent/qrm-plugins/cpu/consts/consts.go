@@ -35,6 +35,18 @@ const (
 	CommunicateWithAdvisor     = CPUPluginDynamicPolicyName + "_communicate_with_advisor"
 )
 
+const (
+	// PodAnnotationAdvisorDisabledKey is the pod annotation to opt the pod out of quota reconcile
+	// by cpu-advisor, e.g. to freeze its cgroups for debugging; it's honored when the value is "true"
+	PodAnnotationAdvisorDisabledKey = "cpu.katalyst.kubewharf.io/advisor-disabled"
+	// PodAnnotationQuotaKey is the pod annotation of the cpu quota in cores, e.g. "1500m", which is applied to
+	// the pod as a fallback when there is no fresh plan of cpu-advisor
+	PodAnnotationQuotaKey = "cpu.katalyst.kubewharf.io/quota"
+	// NodeAnnotationMaintenanceKey is the node annotation to mark the node under maintenance, during which
+	// quota reconcile is paused if it's configured; it's honored when the value is "true"
+	NodeAnnotationMaintenanceKey = "cpu.katalyst.kubewharf.io/maintenance"
+)
+
 const (
 	// CPUStateAnnotationKeyNUMAHint is the key stored in allocationInfo.Annotations
 	// to indicate NUMA hint for the entry
@@ -0,0 +1,116 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/advisorsvc"
+	advisorapi "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/cpuadvisor"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+)
+
+// cpuAdvisorPlan is an allocation plan pushed by cpu-advisor.
+type cpuAdvisorPlan struct {
+	req          *advisorapi.GetAdviceRequest
+	resp         *advisorapi.ListAndWatchResponse
+	featureGates map[string]*advisorsvc.FeatureGate
+}
+
+// advisorPlanRunner guarantees that plans of cpu-advisor are applied one by one, and an in-progress
+// run is never preempted; plans pushed during a run are coalesced, so that only the latest one is
+// applied after the run completes. If minInterval is set, plans pushed within minInterval since the
+// last apply are coalesced in the same way into a single deferred apply, to protect the node against
+// storms of pushes. The zero value is ready to use.
+type advisorPlanRunner struct {
+	minInterval time.Duration
+
+	mutex   sync.Mutex
+	running bool
+	// deferred indicates whether a deferred apply of the pending plan is scheduled
+	deferred      bool
+	pending       *cpuAdvisorPlan
+	lastApplyTime time.Time
+}
+
+// run applies the plan if no plan is being applied, and then keeps applying the latest plan
+// pushed in the meanwhile until there is none, the returned error is the one of the given plan.
+// Otherwise, the plan is left pending for the in-progress or deferred run, and superseded is true
+// if it replaces an older pending plan which will never be applied.
+func (r *advisorPlanRunner) run(plan *cpuAdvisorPlan, apply func(*cpuAdvisorPlan) error) (superseded bool, err error) {
+	r.mutex.Lock()
+	if r.running || r.deferred {
+		superseded = r.pending != nil
+		r.pending = plan
+		r.mutex.Unlock()
+		return superseded, nil
+	}
+	if r.deferLocked(plan, apply) {
+		r.mutex.Unlock()
+		return false, nil
+	}
+	r.running = true
+	r.lastApplyTime = time.Now()
+	r.mutex.Unlock()
+
+	err = apply(plan)
+	for {
+		r.mutex.Lock()
+		pending := r.pending
+		r.pending = nil
+		if pending == nil || r.deferLocked(pending, apply) {
+			r.running = false
+			r.mutex.Unlock()
+			return false, err
+		}
+		r.lastApplyTime = time.Now()
+		r.mutex.Unlock()
+
+		if pendingErr := apply(pending); pendingErr != nil {
+			general.Errorf("apply pending cpu advisor plan failed with error: %v", pendingErr)
+		}
+	}
+}
+
+// deferLocked leaves the plan pending and schedules a deferred apply of it if the last apply is
+// within minInterval, and returns whether it's deferred; it must be called with the mutex held.
+func (r *advisorPlanRunner) deferLocked(plan *cpuAdvisorPlan, apply func(*cpuAdvisorPlan) error) bool {
+	wait := r.minInterval - time.Since(r.lastApplyTime)
+	if r.minInterval <= 0 || wait <= 0 {
+		return false
+	}
+
+	general.Infof("cpu advisor plan is deferred for %v to keep the min reconcile interval %v", wait, r.minInterval)
+	r.pending = plan
+	r.deferred = true
+	time.AfterFunc(wait, func() {
+		r.mutex.Lock()
+		pending := r.pending
+		r.pending = nil
+		r.deferred = false
+		r.mutex.Unlock()
+
+		if pending == nil {
+			return
+		}
+		if _, err := r.run(pending, apply); err != nil {
+			general.Errorf("apply deferred cpu advisor plan failed with error: %v", err)
+		}
+	})
+	return true
+}
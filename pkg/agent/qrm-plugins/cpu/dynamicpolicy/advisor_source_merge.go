@@ -0,0 +1,100 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"sort"
+
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/advisorsvc"
+	advisorapi "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/cpuadvisor"
+	"github.com/kubewharf/katalyst-core/pkg/config/agent/dynamic/quotareconcile"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+)
+
+// defaultAdvisorSource is the source of calculation infos without ControlKnobKeyAdvisorSource, i.e. cpu-advisor
+const defaultAdvisorSource = "cpu-advisor"
+
+// getAdvisorSource returns the advisor source giving the calculation info.
+func getAdvisorSource(calculationInfo *advisorsvc.CalculationInfo) string {
+	if calculationInfo.CalculationResult != nil {
+		if source := calculationInfo.CalculationResult.Values[string(advisorapi.ControlKnobKeyAdvisorSource)]; source != "" {
+			return source
+		}
+	}
+	return defaultAdvisorSource
+}
+
+// mergeCalculationInfosBySource merges calculation infos of multiple advisor sources for the same cgroup path into
+// one by the merge strategy, so that the final quota is derived from knobs of the winning sources; the merged one
+// takes the place of the first calculation info of the cgroup path. Each knob is taken from the calculation info
+// with the highest precedence carrying it, i.e. the last one of the plan for the last-writer strategy, or the one
+// of the source first in the priorities for the priority strategy and the last one among those of equal priority.
+// The calculation infos given are never modified.
+func (p *DynamicPolicy) mergeCalculationInfosBySource(calculationInfos []*advisorsvc.CalculationInfo) []*advisorsvc.CalculationInfo {
+	infosByCgroupPath := make(map[string][]*advisorsvc.CalculationInfo, len(calculationInfos))
+	for _, calculationInfo := range calculationInfos {
+		infosByCgroupPath[calculationInfo.CgroupPath] = append(infosByCgroupPath[calculationInfo.CgroupPath], calculationInfo)
+	}
+
+	conf := p.getQuotaReconcileConf()
+	sourcePriorities := make(map[string]int, len(conf.AdvisorSourcePriorities))
+	for i, source := range conf.AdvisorSourcePriorities {
+		if _, ok := sourcePriorities[source]; !ok {
+			sourcePriorities[source] = len(conf.AdvisorSourcePriorities) - i
+		}
+	}
+
+	mergedInfos := make([]*advisorsvc.CalculationInfo, 0, len(infosByCgroupPath))
+	for _, calculationInfo := range calculationInfos {
+		infos, ok := infosByCgroupPath[calculationInfo.CgroupPath]
+		if !ok {
+			// the cgroup path is already merged
+			continue
+		}
+		delete(infosByCgroupPath, calculationInfo.CgroupPath)
+		if len(infos) == 1 {
+			mergedInfos = append(mergedInfos, calculationInfo)
+			continue
+		}
+
+		// infos are sorted in ascending precedence, so that knobs of later ones override earlier ones
+		if conf.AdvisorSourceMergeStrategy == quotareconcile.AdvisorSourceMergeStrategyPriority {
+			sort.SliceStable(infos, func(i, j int) bool {
+				return sourcePriorities[getAdvisorSource(infos[i])] < sourcePriorities[getAdvisorSource(infos[j])]
+			})
+		}
+
+		values := make(map[string]string)
+		for _, info := range infos {
+			if info.CalculationResult == nil {
+				continue
+			}
+			for knob, value := range info.CalculationResult.Values {
+				values[knob] = value
+			}
+		}
+		winner := getAdvisorSource(infos[len(infos)-1])
+		values[string(advisorapi.ControlKnobKeyAdvisorSource)] = winner
+		general.Infof("calculation infos of %d advisor sources for %s are merged by the %q strategy, %s takes precedence",
+			len(infos), calculationInfo.CgroupPath, conf.AdvisorSourceMergeStrategy, winner)
+		mergedInfos = append(mergedInfos, &advisorsvc.CalculationInfo{
+			CgroupPath:        calculationInfo.CgroupPath,
+			CalculationResult: &advisorsvc.CalculationResult{Values: values},
+		})
+	}
+	return mergedInfos
+}
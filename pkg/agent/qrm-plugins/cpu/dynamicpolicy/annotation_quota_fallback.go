@@ -0,0 +1,141 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	cpuconsts "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/consts"
+	"github.com/kubewharf/katalyst-core/pkg/util/cgroup/common"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+)
+
+// getPodAnnotationQuota returns the quota (in milli-cores) of the pod annotation, and false if it's absent or invalid.
+func getPodAnnotationQuota(pod *v1.Pod) (int64, bool) {
+	value, ok := pod.Annotations[cpuconsts.PodAnnotationQuotaKey]
+	if !ok {
+		return 0, false
+	}
+
+	quantity, err := resource.ParseQuantity(value)
+	if err != nil || quantity.MilliValue() <= 0 {
+		general.Warningf("invalid quota annotation %s=%s of pod %s", cpuconsts.PodAnnotationQuotaKey, value, pod.Name)
+		return 0, false
+	}
+	return quantity.MilliValue(), true
+}
+
+// isAdvisorPlanFresh returns whether a plan of cpu-advisor is applied within the staleness, and the time without
+// any plan is measured from the first check.
+func (p *DynamicPolicy) isAdvisorPlanFresh(staleness time.Duration) bool {
+	now := p.getClock().Now()
+	if p.annotationQuotaFallbackStartTime.IsZero() {
+		p.annotationQuotaFallbackStartTime = now
+	}
+
+	lastPlanTime := p.lastAdvisorPlanTime
+	if lastPlanTime.IsZero() {
+		lastPlanTime = p.annotationQuotaFallbackStartTime
+	}
+	return now.Sub(lastPlanTime) < staleness
+}
+
+// applyAnnotationQuotaFallback applies quota annotations of pods through the normal pod pipeline when there is no
+// fresh plan of cpu-advisor, so that explicit quotas are still honored while cpu-advisor is unavailable. It never
+// overrides a fresh plan, and pods without the annotation are left to the last applied plan.
+func (p *DynamicPolicy) applyAnnotationQuotaFallback() {
+	p.Lock()
+	defer p.Unlock()
+
+	p.refreshQuotaReconcileConf()
+	staleness := p.getQuotaReconcileConf().AnnotationQuotaFallbackStaleness
+	if staleness <= 0 || p.isAdvisorPlanFresh(staleness) || common.CheckCgroup2UnifiedMode() || !isQuotaReconcileEnabled() ||
+		p.isReconcilePausedForMaintenance(context.Background()) {
+		return
+	}
+
+	podsPathMap, err := p.getAllPodsPathMap()
+	if err != nil {
+		general.Errorf("getAllPodsPathMap for annotation quota fallback failed with error: %v", err)
+		return
+	}
+
+	startTime := time.Now()
+	p.beginReconcileTransaction()
+	defer p.endReconcileTransaction()
+	p.cgroupWriteBreaker = newCgroupWriteBreaker(p.getQuotaReconcileConf().CgroupWriteFailureThreshold)
+	p.cgroupWriteCap = newCgroupWriteCap(p.getQuotaReconcileConf().MaxCgroupWritesPerRound)
+	p.cpuCgroupWrites = 0
+	defer p.emitCPUCgroupWrites()
+	p.annotationQuotaFallback = true
+	defer func() { p.annotationQuotaFallback = false }()
+
+	podAbsPaths := make([]string, 0, len(podsPathMap))
+	for podAbsPath, pod := range podsPathMap {
+		if _, ok := pod.Annotations[cpuconsts.PodAnnotationQuotaKey]; ok {
+			podAbsPaths = append(podAbsPaths, podAbsPath)
+		}
+	}
+	sort.Strings(podAbsPaths)
+
+	round := &podQuotaRound{
+		appliedQuotaByQoSLevel: make(map[string]int64),
+		skippedPodsByReason:    make(map[string]int64),
+		livePodPaths:           make(map[string]bool),
+		podErrors:              make(map[string]error),
+	}
+	rootPath := p.getAbsCgroupPath(common.DefaultSelectedSubsys, "/")
+	for _, podAbsPath := range podAbsPaths {
+		if err := p.checkCgroupWritesAllowed(); err != nil {
+			general.Warningf("%v, skip applying quota annotations of the remaining pods", err)
+			break
+		}
+
+		relativePath, err := filepath.Rel(rootPath, podAbsPath)
+		if err != nil {
+			general.Warningf("get relative path of %s failed with error: %v", podAbsPath, err)
+			continue
+		}
+		podRelativePath := filepath.Join("/", relativePath)
+		cgroupPath, podDir := filepath.Dir(podRelativePath), filepath.Base(podRelativePath)
+
+		// pods are bounded by the current quota of their parents, which is passed as it is, and -1 if unlimited
+		parentCPU, err := p.getCPUWithRelativePath(cgroupPath)
+		if err != nil {
+			general.Warningf("get quota of %s failed with error: %v", cgroupPath, err)
+			continue
+		}
+
+		round.processedPods++
+		err = p.checkAndApplyPodQuota(context.Background(), cgroupPath, podDir, podsPathMap, parentCPU.CpuQuota, round)
+		if err != nil {
+			round.failedPods++
+			round.podErrors[podDir] = err
+			general.Errorf("apply quota annotation of pod under %s failed with error: %v", podRelativePath, err)
+		}
+	}
+
+	general.Infof("no fresh plan of cpu advisor within %v, applied quota annotations of %d pods: applied %d, "+
+		"unchanged %d, failed %d, took %v", staleness, round.processedPods, round.appliedPods, round.unchangedPods,
+		round.failedPods, time.Since(startTime))
+}
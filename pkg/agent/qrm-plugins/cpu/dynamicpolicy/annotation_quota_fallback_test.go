@@ -0,0 +1,120 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bytedance/mockey"
+	"github.com/smartystreets/goconvey/convey"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	resource2 "k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	testingclock "k8s.io/utils/clock/testing"
+
+	cpuconsts "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/consts"
+	"github.com/kubewharf/katalyst-core/pkg/config/agent/dynamic/quotareconcile"
+	"github.com/kubewharf/katalyst-core/pkg/util/cgroup/common"
+	cgroupmgr "github.com/kubewharf/katalyst-core/pkg/util/cgroup/manager"
+)
+
+func TestDynamicPolicy_applyAnnotationQuotaFallback(t *testing.T) {
+	t.Parallel()
+
+	fakeClock := testingclock.NewFakeClock(time.Now())
+	p := newTestDynamicPolicy(withTestClock(fakeClock), withTestQuotaReconcileConf(&quotareconcile.QuotaReconcileConfiguration{
+		AnnotationQuotaFallbackStaleness: time.Minute,
+	}))
+
+	newPod := func(name string, annotations map[string]string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				UID:         types.UID(name),
+				Annotations: annotations,
+			},
+			Spec: v1.PodSpec{
+				Containers: []v1.Container{
+					{
+						Name: "test-container",
+						Resources: v1.ResourceRequirements{
+							Limits: v1.ResourceList{
+								v1.ResourceCPU: resource2.MustParse("4"),
+							},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test quota annotations are applied only without a fresh plan", t, func() {
+		mockey.Mock(common.CheckCgroup2UnifiedMode).IncludeCurrentGoRoutine().Return(false).Build()
+		mockey.Mock((*DynamicPolicy).getAllPodsPathMap).IncludeCurrentGoRoutine().Return(map[string]*v1.Pod{
+			"/sys/fs/cgroup/cpu/kubepods/burstable/podannotated": newPod("annotated",
+				map[string]string{cpuconsts.PodAnnotationQuotaKey: "1500m"}),
+			"/sys/fs/cgroup/cpu/kubepods/burstable/podplain": newPod("plain", nil),
+		}, nil).Build()
+		mockey.Mock((*DynamicPolicy).applyAllContainersQuota).IncludeCurrentGoRoutine().Return(nil).Build()
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(&common.CPUStats{CpuQuota: -1, CpuPeriod: 100000}, nil).Build()
+		applied := make(map[string]int64)
+		mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(relativePath string, data *common.CPUData) error {
+			applied[relativePath] = data.CpuQuota
+			return nil
+		}).Build()
+
+		// the time without any plan is measured from the first check
+		p.applyAnnotationQuotaFallback()
+		convey.So(applied, convey.ShouldBeEmpty)
+		fakeClock.Step(time.Minute)
+		p.applyAnnotationQuotaFallback()
+		convey.So(applied, convey.ShouldResemble, map[string]int64{"/kubepods/burstable/podannotated": 150000})
+
+		// the annotation never overrides a fresh plan
+		applied = make(map[string]int64)
+		p.lastAdvisorPlanTime = fakeClock.Now()
+		fakeClock.Step(30 * time.Second)
+		p.applyAnnotationQuotaFallback()
+		convey.So(applied, convey.ShouldBeEmpty)
+
+		// but it's applied again once the plan is stale
+		fakeClock.Step(30 * time.Second)
+		p.applyAnnotationQuotaFallback()
+		convey.So(applied, convey.ShouldResemble, map[string]int64{"/kubepods/burstable/podannotated": 150000})
+		convey.So(p.annotationQuotaFallback, convey.ShouldBeFalse)
+	})
+}
+
+func Test_getPodAnnotationQuota(t *testing.T) {
+	t.Parallel()
+
+	for value, expected := range map[string]int64{"2": 2000, "1500m": 1500, "0": 0, "-1": 0, "invalid": 0} {
+		quota, ok := getPodAnnotationQuota(&v1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{cpuconsts.PodAnnotationQuotaKey: value},
+		}})
+		assert.Equal(t, expected, quota, value)
+		assert.Equal(t, expected > 0, ok, value)
+	}
+
+	_, ok := getPodAnnotationQuota(&v1.Pod{})
+	assert.False(t, ok)
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"sync"
+
+	"github.com/kubewharf/katalyst-core/pkg/consts"
+	utilmetric "github.com/kubewharf/katalyst-core/pkg/util/metric"
+)
+
+// appliedQuotaPublisher publishes quotas applied to pod cgroups to the metric store of metaserver, so that
+// sysadvisor and other consumers can react to decisions of quota reconcile. Quotas are snapshotted after each
+// reconcile and set into the store when the metrics fetcher samples external metrics, which happens in its own
+// goroutine, so the snapshot is guarded by the mutex.
+type appliedQuotaPublisher struct {
+	mutex      sync.Mutex
+	registered bool
+	quotas     []TrackedPodQuota
+}
+
+// update replaces the snapshot of applied quotas.
+func (a *appliedQuotaPublisher) update(quotas []TrackedPodQuota) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.quotas = quotas
+}
+
+// sample sets the snapshot of applied quotas into the metric store as cgroup metrics keyed by pod relative paths.
+func (a *appliedQuotaPublisher) sample(store *utilmetric.MetricStore) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for _, quota := range a.quotas {
+		reconcileTime := quota.LastReconcileTime
+		store.SetCgroupMetric(quota.PodRelativePath, consts.MetricCPUQuotaAppliedCgroup, utilmetric.MetricData{
+			Value: float64(quota.Quota),
+			Time:  &reconcileTime,
+		})
+	}
+}
+
+// publishAppliedQuotas publishes quotas tracked after the reconcile to the metric store, and the publisher is
+// registered to the metrics fetcher at the first publication.
+func (p *DynamicPolicy) publishAppliedQuotas() {
+	if p.podQuotaTracker == nil || p.metaServer == nil || p.metaServer.MetaAgent == nil || p.metaServer.MetricsFetcher == nil {
+		return
+	}
+
+	p.appliedQuotaPublisher.update(p.podQuotaTracker.list())
+	if !p.appliedQuotaPublisher.registered {
+		p.metaServer.RegisterExternalMetric(p.appliedQuotaPublisher.sample)
+		p.appliedQuotaPublisher.registered = true
+	}
+}
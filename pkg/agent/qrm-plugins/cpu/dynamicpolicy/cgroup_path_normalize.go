@@ -0,0 +1,126 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/advisorsvc"
+	"github.com/kubewharf/katalyst-core/pkg/util/cgroup/common"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+)
+
+// cgroupV1Subsystems are subsystems mounted under the cgroupfs mount point in cgroup v1, by which an absolute
+// cgroup path is told from a relative one that happens to start with the mount point
+var cgroupV1Subsystems = map[string]bool{
+	common.CgroupSubsysCPU:     true,
+	"cpuacct":                  true,
+	common.CgroupSubsysCPUSet:  true,
+	common.CgroupSubsysMemory:  true,
+	common.CgroupSubsysIO:      true,
+	"blkio":                    true,
+	common.CgroupSubsysNetCls:  true,
+	common.CgroupSubsysPids:    true,
+	common.CgroupSubsysFreezer: true,
+}
+
+// getCgroupMountPoint returns the cgroupfs mount point on which cgroup paths are resolved.
+func (p *DynamicPolicy) getCgroupMountPoint() string {
+	if p.cgroupRootOverride != "" {
+		return filepath.Clean(p.cgroupRootOverride)
+	}
+	return common.CgroupFSMountPoint
+}
+
+// normalizeCgroupPath converts a cgroup path of cpu-advisor into the relative form, e.g. /kubepods/burstable,
+// since it may be given either relative to the cgroup root or absolute, e.g. /sys/fs/cgroup/cpu/kubepods/burstable
+// in cgroup v1, in different versions of cpu-advisor. Paths which can't be told apart are rejected.
+func (p *DynamicPolicy) normalizeCgroupPath(cgroupPath string) (string, error) {
+	if strings.TrimSpace(cgroupPath) == "" {
+		return "", fmt.Errorf("%w: empty path", ErrAmbiguousCgroupPath)
+	}
+	for _, element := range strings.Split(cgroupPath, "/") {
+		if element == ".." {
+			return "", fmt.Errorf("%w: %s", ErrPathEscape, cgroupPath)
+		}
+	}
+
+	// relative paths are joined to the cgroup root no matter whether they are led by a slash, so they're
+	// canonicalized with a leading slash for the same target to be keyed the same
+	cleanedPath := filepath.Clean("/" + cgroupPath)
+	mountPoint := p.getCgroupMountPoint()
+	if cleanedPath != mountPoint && !strings.HasPrefix(cleanedPath, mountPoint+"/") {
+		return cleanedPath, nil
+	}
+	if !filepath.IsAbs(cgroupPath) {
+		return "", fmt.Errorf("%w: %s is relative but starts with the cgroup mount point %s",
+			ErrAmbiguousCgroupPath, cgroupPath, mountPoint)
+	}
+
+	relativePath := strings.TrimPrefix(strings.TrimPrefix(cleanedPath, mountPoint), "/")
+	if !common.CheckCgroup2UnifiedMode() {
+		// the first element of an absolute path in cgroup v1 is the subsystem, e.g. cpu or cpu,cpuacct,
+		// or the dir of the unified hierarchy in hybrid mode
+		subsys, rest, _ := strings.Cut(relativePath, "/")
+		if subsys != common.CgroupFSUnifiedDir && !isCgroupV1Subsystem(subsys) {
+			return "", fmt.Errorf("%w: %s is under the cgroup mount point %s but not under any subsystem",
+				ErrAmbiguousCgroupPath, cgroupPath, mountPoint)
+		}
+		relativePath = rest
+	}
+	return "/" + relativePath, nil
+}
+
+func isCgroupV1Subsystem(element string) bool {
+	if element == "" {
+		return false
+	}
+	for _, subsys := range strings.Split(element, ",") {
+		if !cgroupV1Subsystems[subsys] {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeCalculationInfos returns the calculation infos with their cgroup paths normalized, and those whose
+// cgroup paths are rejected are left out. The calculation infos given are never modified.
+func (p *DynamicPolicy) normalizeCalculationInfos(calculationInfos []*advisorsvc.CalculationInfo) []*advisorsvc.CalculationInfo {
+	normalizedInfos := make([]*advisorsvc.CalculationInfo, 0, len(calculationInfos))
+	for _, calculationInfo := range calculationInfos {
+		if calculationInfo == nil {
+			continue
+		}
+
+		cgroupPath, err := p.normalizeCgroupPath(calculationInfo.CgroupPath)
+		if err != nil {
+			general.Errorf("normalize cgroup path of calculation info failed with error: %v, skip it", err)
+			continue
+		}
+		if cgroupPath != calculationInfo.CgroupPath {
+			general.InfofV(4, "cgroup path %s of calculation info is normalized to %s", calculationInfo.CgroupPath, cgroupPath)
+			calculationInfo = &advisorsvc.CalculationInfo{
+				CgroupPath:        cgroupPath,
+				CalculationResult: calculationInfo.CalculationResult,
+			}
+		}
+		normalizedInfos = append(normalizedInfos, calculationInfo)
+	}
+	return normalizedInfos
+}
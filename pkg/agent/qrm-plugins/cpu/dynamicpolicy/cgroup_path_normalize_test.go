@@ -0,0 +1,98 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bytedance/mockey"
+	"github.com/smartystreets/goconvey/convey"
+
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/advisorsvc"
+	"github.com/kubewharf/katalyst-core/pkg/util/cgroup/common"
+)
+
+func TestDynamicPolicy_normalizeCgroupPath(t *testing.T) {
+	t.Parallel()
+
+	p := newTestDynamicPolicy()
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test cgroup paths are normalized in cgroup v1", t, func() {
+		mockey.Mock(common.CheckCgroup2UnifiedMode).IncludeCurrentGoRoutine().Return(false).Build()
+
+		// absolute and relative paths resolve to the same target
+		for _, cgroupPath := range []string{
+			"/kubepods/burstable",
+			"/kubepods//burstable/",
+			"kubepods/burstable",
+			"./kubepods/burstable",
+			"/sys/fs/cgroup/cpu/kubepods/burstable",
+			"/sys/fs/cgroup/cpu,cpuacct/kubepods/burstable",
+		} {
+			normalizedPath, err := p.normalizeCgroupPath(cgroupPath)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(normalizedPath, convey.ShouldEqual, "/kubepods/burstable")
+			convey.So(p.getAbsCgroupPath(common.CgroupSubsysCPU, normalizedPath), convey.ShouldEqual,
+				"/sys/fs/cgroup/cpu/kubepods/burstable")
+		}
+
+		// paths which can't be told apart are rejected
+		for _, cgroupPath := range []string{
+			"",
+			"/sys/fs/cgroup",
+			"/sys/fs/cgroup/kubepods/burstable",
+			"sys/fs/cgroup/cpu/kubepods/burstable",
+		} {
+			_, err := p.normalizeCgroupPath(cgroupPath)
+			convey.So(errors.Is(err, ErrAmbiguousCgroupPath), convey.ShouldBeTrue)
+		}
+		_, err := p.normalizeCgroupPath("/kubepods/../../etc")
+		convey.So(errors.Is(err, ErrPathEscape), convey.ShouldBeTrue)
+
+		// rejected calculation infos are left out, and the given ones are kept as they are
+		calculationInfos := []*advisorsvc.CalculationInfo{
+			{CgroupPath: "/sys/fs/cgroup/cpu/kubepods/besteffort"},
+			{CgroupPath: "/sys/fs/cgroup/kubepods/besteffort"},
+			{CgroupPath: "/kubepods/burstable"},
+		}
+		normalizedInfos := p.normalizeCalculationInfos(calculationInfos)
+		convey.So(len(normalizedInfos), convey.ShouldEqual, 2)
+		convey.So(normalizedInfos[0].CgroupPath, convey.ShouldEqual, "/kubepods/besteffort")
+		convey.So(normalizedInfos[1], convey.ShouldEqual, calculationInfos[2])
+		convey.So(calculationInfos[0].CgroupPath, convey.ShouldEqual, "/sys/fs/cgroup/cpu/kubepods/besteffort")
+	})
+
+	mockey.PatchConvey("test cgroup paths are normalized in cgroup v2", t, func() {
+		mockey.Mock(common.CheckCgroup2UnifiedMode).IncludeCurrentGoRoutine().Return(true).Build()
+
+		for _, cgroupPath := range []string{"/kubepods/burstable", "/sys/fs/cgroup/kubepods/burstable"} {
+			normalizedPath, err := p.normalizeCgroupPath(cgroupPath)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(normalizedPath, convey.ShouldEqual, "/kubepods/burstable")
+		}
+
+		// paths are resolved on the cgroup root override if set
+		overriddenPolicy := newTestDynamicPolicy()
+		overriddenPolicy.cgroupRootOverride = "/host/sys/fs/cgroup"
+		normalizedPath, err := overriddenPolicy.normalizeCgroupPath("/host/sys/fs/cgroup/kubepods/burstable")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(normalizedPath, convey.ShouldEqual, "/kubepods/burstable")
+	})
+}
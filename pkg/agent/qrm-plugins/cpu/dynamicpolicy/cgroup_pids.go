@@ -0,0 +1,113 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+
+	"github.com/kubewharf/katalyst-core/pkg/util/cgroup/common"
+)
+
+// applyToCgroupPids applies a per-process setting, e.g. oom_score_adj or the scheduling policy, to all processes
+// under the cgroup path. Processes exiting before they are applied are ignored, and so are cgroups removed in the
+// meanwhile; errors of the other processes are aggregated after all processes are applied.
+func (p *DynamicPolicy) applyToCgroupPids(relativePath string, apply func(pid int) error) error {
+	pids, err := readCgroupProcs(p.getAbsCgroupPath(common.DefaultSelectedSubsys, relativePath))
+	if err != nil {
+		return fmt.Errorf("%w: get pids of %s failed with error: %v", ErrCgroupRead, relativePath, err)
+	}
+
+	var errList []error
+	for _, pid := range pids {
+		if err := apply(pid); err != nil && !isProcessExitedError(err) {
+			errList = append(errList, err)
+		}
+	}
+	return utilerrors.NewAggregate(errList)
+}
+
+// readCgroupProcs returns pids in cgroup.procs of the cgroup and all its descendant cgroups,
+// descendant cgroups removed during the walk are skipped.
+func readCgroupProcs(absCgroupPath string) ([]int, error) {
+	var pids []int
+	err := filepath.WalkDir(absCgroupPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path != absCgroupPath && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+
+		dirPids, err := readProcsFile(filepath.Join(path, common.CgroupProcsFile))
+		if err != nil {
+			if path != absCgroupPath && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		pids = append(pids, dirPids...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pids, nil
+}
+
+// readProcsFile parses the pids listed one per line in the procs file.
+func readProcsFile(file string) ([]int, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var pids []int
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		pid, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, fmt.Errorf("parse pid %s in %s failed with error: %v", line, file, err)
+		}
+		pids = append(pids, pid)
+	}
+	return pids, scanner.Err()
+}
+
+// isProcessExitedError returns true if the error is caused by the process that has exited,
+// i.e. its proc files are gone or the syscall finds no such process.
+func isProcessExitedError(err error) bool {
+	return errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ESRCH)
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"fmt"
+
+	"github.com/opencontainers/runc/libcontainer/cgroups/fscommon"
+
+	"github.com/kubewharf/katalyst-core/pkg/util/cgroup/common"
+	cgroupmgr "github.com/kubewharf/katalyst-core/pkg/util/cgroup/manager"
+)
+
+// The helpers below read and write cgroups of the advisor with relative paths, which are resolved on the host
+// mount point by the cgroup manager unless the cgroup root override is set, in which case they're resolved under
+// the override and served by the manager of the hierarchy detected there.
+
+// getCgroupManager returns the cgroup manager of the hierarchy serving the subsystem under the cgroup root override.
+func (p *DynamicPolicy) getCgroupManager(subsys string) cgroupmgr.Manager {
+	return cgroupmgr.GetManagerForHierarchy(p.isSubsysOnCgroupV2(subsys))
+}
+
+func (p *DynamicPolicy) getCPUWithRelativePath(relativePath string) (*common.CPUStats, error) {
+	if p.cgroupRootOverride == "" {
+		return cgroupmgr.GetCPUWithRelativePath(relativePath)
+	}
+	return p.getCgroupManager(common.CgroupSubsysCPU).GetCPU(p.getAbsCgroupPath(common.CgroupSubsysCPU, relativePath))
+}
+
+func (p *DynamicPolicy) applyCPUWithRelativePath(relativePath string, data *common.CPUData) error {
+	if p.cgroupRootOverride == "" {
+		return cgroupmgr.ApplyCPUWithRelativePath(relativePath, data)
+	}
+	if data == nil {
+		return fmt.Errorf("applyCPUWithRelativePath with nil cgroup data")
+	}
+	return p.getCgroupManager(common.CgroupSubsysCPU).ApplyCPU(p.getAbsCgroupPath(common.CgroupSubsysCPU, relativePath), data)
+}
+
+func (p *DynamicPolicy) getCPUSetWithRelativePath(relativePath string) (*common.CPUSetStats, error) {
+	if p.cgroupRootOverride == "" {
+		return cgroupmgr.GetCPUSetWithRelativePath(relativePath)
+	}
+	return p.getCgroupManager(common.CgroupSubsysCPUSet).GetCPUSet(p.getAbsCgroupPath(common.CgroupSubsysCPUSet, relativePath))
+}
+
+func (p *DynamicPolicy) applyCPUSetWithRelativePath(relativePath string, data *common.CPUSetData) error {
+	if p.cgroupRootOverride == "" {
+		return cgroupmgr.ApplyCPUSetWithRelativePath(relativePath, data)
+	}
+	if data == nil {
+		return fmt.Errorf("applyCPUSetWithRelativePath with nil cgroup data")
+	}
+	return p.getCgroupManager(common.CgroupSubsysCPUSet).ApplyCPUSet(p.getAbsCgroupPath(common.CgroupSubsysCPUSet, relativePath), data)
+}
+
+func (p *DynamicPolicy) getMemoryWithRelativePath(relativePath string) (*common.MemoryStats, error) {
+	if p.cgroupRootOverride == "" {
+		return cgroupmgr.GetMemoryWithRelativePath(relativePath)
+	}
+	return p.getCgroupManager(common.CgroupSubsysMemory).GetMemory(p.getAbsCgroupPath(common.CgroupSubsysMemory, relativePath))
+}
+
+func (p *DynamicPolicy) applyMemoryWithRelativePath(relativePath string, data *common.MemoryData) error {
+	if p.cgroupRootOverride == "" {
+		return cgroupmgr.ApplyMemoryWithRelativePath(relativePath, data)
+	}
+	if data == nil {
+		return fmt.Errorf("applyMemoryWithRelativePath with nil cgroup data")
+	}
+	return p.getCgroupManager(common.CgroupSubsysMemory).ApplyMemory(p.getAbsCgroupPath(common.CgroupSubsysMemory, relativePath), data)
+}
+
+// getMemoryRSSWithRelativePath returns the rss of the cgroup. The cgroup metrics of the manager are loaded on the
+// host mount point, so the rss is read from memory.stat under the cgroup root override, which is anon in cgroup v2.
+func (p *DynamicPolicy) getMemoryRSSWithRelativePath(relativePath string) (uint64, error) {
+	if p.cgroupRootOverride == "" {
+		cgroupMetrics, err := cgroupmgr.GetMetricsWithRelativePath(relativePath, map[string]struct{}{common.CgroupSubsysMemory: {}})
+		if err != nil {
+			return 0, err
+		} else if cgroupMetrics.Memory == nil {
+			return 0, fmt.Errorf("no memory metrics of %s", relativePath)
+		}
+		return cgroupMetrics.Memory.RSS, nil
+	}
+
+	rssKey := "rss"
+	if p.isSubsysOnCgroupV2(common.CgroupSubsysMemory) {
+		rssKey = "anon"
+	}
+	return fscommon.GetValueByKey(p.getAbsCgroupPath(common.CgroupSubsysMemory, relativePath), "memory.stat", rssKey)
+}
+
+func (p *DynamicPolicy) applyPidsWithRelativePath(relativePath string, data *common.PidsData) error {
+	if p.cgroupRootOverride == "" {
+		return cgroupmgr.ApplyPidsWithRelativePath(relativePath, data)
+	}
+	if data == nil {
+		return fmt.Errorf("applyPidsWithRelativePath with nil cgroup data")
+	}
+	return p.getCgroupManager(common.CgroupSubsysPids).ApplyPids(p.getAbsCgroupPath(common.CgroupSubsysPids, relativePath), data)
+}
+
+func (p *DynamicPolicy) applyFreezerWithRelativePath(relativePath string, data *common.FreezerData) error {
+	if p.cgroupRootOverride == "" {
+		return cgroupmgr.ApplyFreezerWithRelativePath(relativePath, data)
+	}
+	if data == nil {
+		return fmt.Errorf("applyFreezerWithRelativePath with nil cgroup data")
+	}
+	return p.getCgroupManager(common.CgroupSubsysFreezer).ApplyFreezer(p.getAbsCgroupPath(common.CgroupSubsysFreezer, relativePath), data)
+}
+
+// applyCgroupResources applies the cgroup configs to the cgroup. Libcontainer resolves cgroup paths on the host mount
+// point, so only cpu quota and period of the configs are applied by the cgroup manager under the cgroup root override.
+func (p *DynamicPolicy) applyCgroupResources(relativePath string, resources *common.CgroupResources) error {
+	if p.cgroupRootOverride == "" {
+		return common.ApplyCgroupConfigs(relativePath, resources)
+	}
+	if resources == nil {
+		return nil
+	}
+	return p.applyCPUWithRelativePath(relativePath, &common.CPUData{CpuQuota: resources.CpuQuota, CpuPeriod: resources.CpuPeriod})
+}
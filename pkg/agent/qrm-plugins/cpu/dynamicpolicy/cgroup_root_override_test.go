@@ -0,0 +1,163 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bytedance/mockey"
+	"github.com/smartystreets/goconvey/convey"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/advisorsvc"
+	advisorapi "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/cpuadvisor"
+	"github.com/kubewharf/katalyst-core/pkg/util/cgroup/common"
+	cgroupmgr "github.com/kubewharf/katalyst-core/pkg/util/cgroup/manager"
+)
+
+func TestDynamicPolicy_cgroupRootOverride(t *testing.T) {
+	t.Parallel()
+
+	cgroupRoot := t.TempDir()
+	podRelativePath := filepath.Join(common.CgroupFsRootPathBurstable, "podtest-pod-uid")
+	containerRelativePath := filepath.Join(podRelativePath, "test-container-id")
+	assert.NoError(t, os.MkdirAll(filepath.Join(cgroupRoot, common.DefaultSelectedSubsys, containerRelativePath), 0o755))
+
+	testPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pod", UID: "test-pod-uid"},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{{Name: "test-container"}},
+		},
+		Status: v1.PodStatus{
+			Phase: v1.PodRunning,
+			ContainerStatuses: []v1.ContainerStatus{
+				{Name: "test-container", ContainerID: "containerd://test-container-id"},
+			},
+		},
+	}
+	p := newTestDynamicPolicy(withTestPods(testPod))
+	p.cgroupRootOverride = cgroupRoot
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test cgroup paths are resolved under the overridden root", t, func() {
+		mockey.Mock(common.CheckCgroup2UnifiedMode).IncludeCurrentGoRoutine().Return(false).Build()
+
+		podAbsPath := filepath.Join(cgroupRoot, common.DefaultSelectedSubsys, podRelativePath)
+		convey.So(p.getAbsCgroupPath(common.DefaultSelectedSubsys, podRelativePath), convey.ShouldEqual, podAbsPath)
+
+		podsPathMap, err := p.getAllPodsPathMap()
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(podsPathMap, convey.ShouldResemble, map[string]*v1.Pod{podAbsPath: testPod})
+
+		podsPathMap, podDirs, err := p.getCurrentPathAllPodsDirAndMap(common.CgroupFsRootPathBurstable)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(podDirs, convey.ShouldResemble, []string{"podtest-pod-uid"})
+
+		pod, relativePath, err := p.getPodAndRelativePath(common.CgroupFsRootPathBurstable, podDirs[0], podsPathMap)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(pod, convey.ShouldEqual, testPod)
+		convey.So(relativePath, convey.ShouldEqual, podRelativePath)
+
+		convey.So(p.getAllContainersRelativePathMap(testPod), convey.ShouldContainKey, containerRelativePath)
+
+		// paths under the host mount point are resolved without the override
+		convey.So(newTestDynamicPolicy().getAbsCgroupPath(common.DefaultSelectedSubsys, podRelativePath),
+			convey.ShouldEqual, common.GetAbsCgroupPath(common.DefaultSelectedSubsys, podRelativePath))
+	})
+}
+
+// fakeCgroupManager records cpu and memory writes to absolute cgroup paths, and the other methods of the embedded
+// manager are left unimplemented.
+type fakeCgroupManager struct {
+	cgroupmgr.Manager
+	cpuStats      *common.CPUStats
+	appliedCPU    map[string]int64
+	appliedMemory []string
+}
+
+func (m *fakeCgroupManager) GetCPU(string) (*common.CPUStats, error) {
+	return m.cpuStats, nil
+}
+
+func (m *fakeCgroupManager) ApplyCPU(absCgroupPath string, data *common.CPUData) error {
+	if m.appliedCPU == nil {
+		m.appliedCPU = make(map[string]int64)
+	}
+	m.appliedCPU[absCgroupPath] = data.CpuQuota
+	return nil
+}
+
+func (m *fakeCgroupManager) ApplyMemory(absCgroupPath string, _ *common.MemoryData) error {
+	m.appliedMemory = append(m.appliedMemory, absCgroupPath)
+	return nil
+}
+
+func TestDynamicPolicy_hybridCgroupHierarchy(t *testing.T) {
+	t.Parallel()
+
+	// cpu is mounted as a cgroup v1 hierarchy, while memory is only enabled in the unified one
+	cgroupRoot := t.TempDir()
+	podRelativePath := filepath.Join(common.CgroupFsRootPathBurstable, "podtest-pod-uid")
+	unifiedRoot := filepath.Join(cgroupRoot, common.CgroupFSUnifiedDir)
+	assert.NoError(t, os.MkdirAll(filepath.Join(cgroupRoot, common.CgroupSubsysCPU, podRelativePath), 0o755))
+	assert.NoError(t, os.MkdirAll(filepath.Join(unifiedRoot, podRelativePath), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(unifiedRoot, "cgroup.controllers"), []byte("memory pids\n"), 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(unifiedRoot, podRelativePath, "memory.swap.max"), []byte("0\n"), 0o644))
+
+	p := newTestDynamicPolicy()
+	p.cgroupRootOverride = cgroupRoot
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test knobs are routed to the hierarchy serving their controller", t, func() {
+		mockey.Mock(common.CheckCgroup2UnifiedMode).IncludeCurrentGoRoutine().Return(false).Build()
+		v1Manager, unifiedManager := &fakeCgroupManager{}, &fakeCgroupManager{}
+		mockey.Mock(cgroupmgr.GetManagerForHierarchy).IncludeCurrentGoRoutine().To(func(unified bool) cgroupmgr.Manager {
+			if unified {
+				return unifiedManager
+			}
+			return v1Manager
+		}).Build()
+
+		convey.So(p.getAbsCgroupPath(common.CgroupSubsysCPU, podRelativePath), convey.ShouldEqual,
+			filepath.Join(cgroupRoot, common.CgroupSubsysCPU, podRelativePath))
+		convey.So(p.isSubsysOnCgroupV2(common.CgroupSubsysCPU), convey.ShouldBeFalse)
+		convey.So(p.getAbsCgroupPath(common.CgroupSubsysMemory, podRelativePath), convey.ShouldEqual,
+			filepath.Join(unifiedRoot, podRelativePath))
+		convey.So(p.isSubsysOnCgroupV2(common.CgroupSubsysMemory), convey.ShouldBeTrue)
+
+		// the v2-only swap knob is applied since memory is served by the unified hierarchy
+		err := p.applySwapMax(&advisorsvc.CalculationInfo{
+			CgroupPath: podRelativePath,
+			CalculationResult: &advisorsvc.CalculationResult{
+				Values: map[string]string{string(advisorapi.ControlKnobKeySwapMax): "max"},
+			},
+		})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(unifiedManager.appliedMemory, convey.ShouldResemble, []string{filepath.Join(unifiedRoot, podRelativePath)})
+		convey.So(v1Manager.appliedMemory, convey.ShouldBeEmpty)
+
+		// controllers neither mounted nor enabled in the unified hierarchy fall back to cgroup v1
+		convey.So(p.getAbsCgroupPath(common.CgroupSubsysCPUSet, podRelativePath), convey.ShouldEqual,
+			filepath.Join(cgroupRoot, common.CgroupSubsysCPUSet, podRelativePath))
+	})
+}
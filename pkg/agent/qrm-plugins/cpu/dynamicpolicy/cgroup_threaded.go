@@ -0,0 +1,125 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/advisorsvc"
+	"github.com/kubewharf/katalyst-core/pkg/util/cgroup/common"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+)
+
+const (
+	cgroupTypeFile = "cgroup.type"
+	// cgroupTypeThreaded is the type of cgroups in a threaded subtree of cgroup v2, which group threads of the
+	// processes in its threaded domain root, of type "domain threaded", rather than the processes themselves
+	cgroupTypeThreaded = "threaded"
+)
+
+// getCgroupConfigsPath returns the relative cgroup path to which cgroup configs of the given one are applied. In
+// cgroup v2, configs of a threaded cgroup are applied to the threaded domain root of its subtree instead, since
+// the quota is meant for the processes as a whole, and domain controllers e.g. memory can't be enabled in threaded
+// cgroups at all. Cgroups without cgroup.type, e.g. the root one or those in cgroup v1, are domains. The threaded
+// domain root is shared by all its threaded cgroups, so it's only written once in a round by cgroupConfigsTargets.
+func (p *DynamicPolicy) getCgroupConfigsPath(cgroupPath string) (string, error) {
+	if !common.CheckCgroup2UnifiedMode() {
+		return cgroupPath, nil
+	}
+
+	domainPath := filepath.Clean(cgroupPath)
+	for {
+		cgroupType, err := p.readCgroupType(domainPath)
+		if err != nil {
+			return "", err
+		} else if cgroupType != cgroupTypeThreaded {
+			break
+		}
+
+		parent := filepath.Dir(domainPath)
+		if parent == domainPath {
+			return "", fmt.Errorf("no threaded domain root found for threaded cgroup %s", cgroupPath)
+		}
+		domainPath = parent
+	}
+
+	if domainPath != filepath.Clean(cgroupPath) {
+		general.InfofV(4, "cgroup %s is threaded, apply cgroup configs to its threaded domain root %s", cgroupPath, domainPath)
+	}
+	return domainPath, nil
+}
+
+// cgroupConfigsTargets tracks cgroups written with cgroup configs in a round of reconcile by the calculation infos
+// applied to them. The threaded domain root is written once for the calculation infos of all its threaded cgroups,
+// i.e. the first one claiming it wins, unless the domain root has a calculation info of its own, which always wins
+// even if it's skipped by the reconcile cache.
+type cgroupConfigsTargets struct {
+	// owners are cgroup paths of the calculation infos applied to cgroups written with cgroup configs
+	owners map[string]string
+	// redirected are cgroup paths of the calculation infos applied to their threaded domain roots
+	redirected map[string]bool
+}
+
+func newCgroupConfigsTargets(calculationInfos []*advisorsvc.CalculationInfo) *cgroupConfigsTargets {
+	t := &cgroupConfigsTargets{
+		owners:     make(map[string]string),
+		redirected: make(map[string]bool),
+	}
+	for _, calculationInfo := range calculationInfos {
+		cgroupPath := filepath.Clean(calculationInfo.CgroupPath)
+		t.owners[cgroupPath] = cgroupPath
+	}
+	return t
+}
+
+// claim records that cgroup configs of the cgroup path are applied to the configs path, and false is returned along
+// with the cgroup path owning the configs path if another threaded cgroup has already claimed it in the round.
+func (t *cgroupConfigsTargets) claim(cgroupPath, configsPath string) (string, bool) {
+	if t == nil {
+		return "", true
+	}
+
+	cgroupPath = filepath.Clean(cgroupPath)
+	if configsPath != cgroupPath {
+		if owner, ok := t.owners[configsPath]; ok && owner != cgroupPath {
+			return owner, false
+		}
+		t.redirected[cgroupPath] = true
+	}
+	t.owners[configsPath] = cgroupPath
+	return cgroupPath, true
+}
+
+// isRedirected returns whether cgroup configs of the cgroup path are applied to its threaded domain root in the round.
+func (t *cgroupConfigsTargets) isRedirected(cgroupPath string) bool {
+	return t != nil && t.redirected[filepath.Clean(cgroupPath)]
+}
+
+// readCgroupType reads the type of the cgroup of the relative path from cgroup.type, and empty is returned if
+// the file doesn't exist.
+func (p *DynamicPolicy) readCgroupType(cgroupPath string) (string, error) {
+	content, err := os.ReadFile(filepath.Join(p.getAbsCgroupPath(common.DefaultSelectedSubsys, cgroupPath), cgroupTypeFile))
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("%w: read %s of %s failed with error: %v", ErrCgroupRead, cgroupTypeFile, cgroupPath, err)
+	}
+	return strings.TrimSpace(string(content)), nil
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bytedance/mockey"
+	"github.com/smartystreets/goconvey/convey"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/advisorsvc"
+	advisorapi "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/cpuadvisor"
+	"github.com/kubewharf/katalyst-core/pkg/util/cgroup/common"
+)
+
+func TestDynamicPolicy_threadedCgroupConfigs(t *testing.T) {
+	t.Parallel()
+
+	// the pod is the threaded domain root of a threaded subtree two levels deep
+	cgroupRoot := t.TempDir()
+	podRelativePath := filepath.Join(common.CgroupFsRootPathBurstable, "podtest-pod-uid")
+	threadedRelativePath := filepath.Join(podRelativePath, "workers", "io")
+	assert.NoError(t, os.MkdirAll(filepath.Join(cgroupRoot, threadedRelativePath), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(cgroupRoot, podRelativePath, "cgroup.type"), []byte("domain threaded\n"), 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(cgroupRoot, podRelativePath, "workers", "cgroup.type"), []byte("threaded\n"), 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(cgroupRoot, threadedRelativePath, "cgroup.type"), []byte("threaded\n"), 0o644))
+
+	p := newTestDynamicPolicy()
+	p.cgroupRootOverride = cgroupRoot
+	resourcesBytes, _ := json.Marshal(&common.CgroupResources{CpuQuota: 200000, CpuPeriod: 100000})
+	newCalculationInfo := func(cgroupPath string) *advisorsvc.CalculationInfo {
+		return &advisorsvc.CalculationInfo{
+			CgroupPath: cgroupPath,
+			CalculationResult: &advisorsvc.CalculationResult{
+				Values: map[string]string{
+					string(advisorapi.ControlKnobKeyCgroupConfig): string(resourcesBytes),
+				},
+			},
+		}
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test cgroup configs of threaded cgroups are applied to the threaded domain root", t, func() {
+		mockey.Mock(common.CheckCgroup2UnifiedMode).IncludeCurrentGoRoutine().Return(true).Build()
+		mockey.Mock((*DynamicPolicy).checkAndApplyCPUBurst).IncludeCurrentGoRoutine().Return(nil).Build()
+		var appliedPaths []string
+		mockey.Mock((*DynamicPolicy).applyCgroupResources).IncludeCurrentGoRoutine().To(
+			func(_ *DynamicPolicy, relativePath string, _ *common.CgroupResources) error {
+				appliedPaths = append(appliedPaths, relativePath)
+				return nil
+			}).Build()
+
+		applied, err := p.applyCalculationInfoKnobs(newCalculationInfo(threadedRelativePath))
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(applied, convey.ShouldBeTrue)
+
+		// domain cgroups, including the threaded domain root, are applied as is
+		_, err = p.applyCalculationInfoKnobs(newCalculationInfo(podRelativePath))
+		convey.So(err, convey.ShouldBeNil)
+		_, err = p.applyCalculationInfoKnobs(newCalculationInfo(common.CgroupFsRootPathBurstable))
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(appliedPaths, convey.ShouldResemble, []string{podRelativePath, podRelativePath, common.CgroupFsRootPathBurstable})
+	})
+}
+
+func TestDynamicPolicy_threadedCgroupConfigsSharedDomainRoot(t *testing.T) {
+	t.Parallel()
+
+	// the pod is the threaded domain root shared by two threaded cgroups
+	cgroupRoot := t.TempDir()
+	podRelativePath := filepath.Join(common.CgroupFsRootPathBurstable, "podtest-pod-uid")
+	ioRelativePath := filepath.Join(podRelativePath, "io")
+	netRelativePath := filepath.Join(podRelativePath, "net")
+	assert.NoError(t, os.MkdirAll(filepath.Join(cgroupRoot, ioRelativePath), 0o755))
+	assert.NoError(t, os.MkdirAll(filepath.Join(cgroupRoot, netRelativePath), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(cgroupRoot, podRelativePath, "cgroup.type"), []byte("domain threaded\n"), 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(cgroupRoot, ioRelativePath, "cgroup.type"), []byte("threaded\n"), 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(cgroupRoot, netRelativePath, "cgroup.type"), []byte("threaded\n"), 0o644))
+
+	p := newTestDynamicPolicy()
+	p.cgroupRootOverride = cgroupRoot
+	newCalculationInfo := func(cgroupPath string, quota int64) *advisorsvc.CalculationInfo {
+		resourcesBytes, _ := json.Marshal(&common.CgroupResources{CpuQuota: quota, CpuPeriod: 100000})
+		return &advisorsvc.CalculationInfo{
+			CgroupPath: cgroupPath,
+			CalculationResult: &advisorsvc.CalculationResult{
+				Values: map[string]string{
+					string(advisorapi.ControlKnobKeyCgroupConfig): string(resourcesBytes),
+				},
+			},
+		}
+	}
+	threadedResp := &advisorapi.ListAndWatchResponse{
+		ExtraEntries: []*advisorsvc.CalculationInfo{
+			newCalculationInfo(ioRelativePath, 200000),
+			newCalculationInfo(netRelativePath, 300000),
+		},
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test the threaded domain root shared by threaded cgroups is written once", t, func() {
+		mockey.Mock(common.CheckCgroup2UnifiedMode).IncludeCurrentGoRoutine().Return(true).Build()
+		mockey.Mock((*DynamicPolicy).checkAndApplyCPUBurst).IncludeCurrentGoRoutine().Return(nil).Build()
+		cgroupStats := map[string]common.CPUStats{
+			podRelativePath: {CpuQuota: -1, CpuPeriod: 100000},
+		}
+		var appliedPaths []string
+		mockey.Mock((*DynamicPolicy).getCPUWithRelativePath).IncludeCurrentGoRoutine().To(
+			func(_ *DynamicPolicy, relativePath string) (*common.CPUStats, error) {
+				stats := cgroupStats[relativePath]
+				return &stats, nil
+			}).Build()
+		mockey.Mock((*DynamicPolicy).applyCPUWithRelativePath).IncludeCurrentGoRoutine().To(
+			func(_ *DynamicPolicy, relativePath string, data *common.CPUData) error {
+				appliedPaths = append(appliedPaths, relativePath)
+				cgroupStats[relativePath] = common.CPUStats{CpuQuota: data.CpuQuota, CpuPeriod: data.CpuPeriod}
+				return nil
+			}).Build()
+
+		// the first threaded cgroup wins, and the other one doesn't overwrite the domain root
+		convey.So(p.applyCgroupConfigs(threadedResp), convey.ShouldBeNil)
+		convey.So(appliedPaths, convey.ShouldResemble, []string{podRelativePath})
+		convey.So(cgroupStats[podRelativePath].CpuQuota, convey.ShouldEqual, 200000)
+
+		// the domain root is restored under its own path
+		convey.So(p.lastReconcileTransaction.priorStats, convey.ShouldResemble, []cgroupPriorCPUStats{{
+			relativePath: podRelativePath,
+			stats:        common.CPUStats{CpuQuota: -1, CpuPeriod: 100000},
+		}})
+		convey.So(p.UndoLastCPUQuotaReconcile(), convey.ShouldBeNil)
+		convey.So(cgroupStats[podRelativePath].CpuQuota, convey.ShouldEqual, -1)
+
+		// the domain root may be changed by others, so it's written again with the same calculation infos
+		appliedPaths = nil
+		convey.So(p.applyCgroupConfigs(threadedResp), convey.ShouldBeNil)
+		convey.So(appliedPaths, convey.ShouldResemble, []string{podRelativePath})
+		convey.So(cgroupStats[podRelativePath].CpuQuota, convey.ShouldEqual, 200000)
+
+		// the calculation info of the domain root itself wins over the ones of its threaded cgroups
+		appliedPaths = nil
+		convey.So(p.applyCgroupConfigs(&advisorapi.ListAndWatchResponse{
+			ExtraEntries: []*advisorsvc.CalculationInfo{
+				newCalculationInfo(ioRelativePath, 200000),
+				newCalculationInfo(podRelativePath, 100000),
+			},
+		}), convey.ShouldBeNil)
+		convey.So(appliedPaths, convey.ShouldResemble, []string{podRelativePath})
+		convey.So(cgroupStats[podRelativePath].CpuQuota, convey.ShouldEqual, 100000)
+	})
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"fmt"
+)
+
+var (
+	errCgroupWriteBreakerOpen = fmt.Errorf("%w: cgroup write breaker is open", ErrCgroupWrite)
+	errCgroupWriteCapReached  = fmt.Errorf("%w: cgroup write cap of the round is reached", ErrCgroupWrite)
+)
+
+// cgroupWriteBreaker stops cgroup writes in a reconcile round after too many consecutive failures,
+// which usually means the cgroup manager is unavailable (e.g. the mount is not ready at boot);
+// it's created at the beginning of each round, and a nil breaker never opens.
+type cgroupWriteBreaker struct {
+	threshold           int
+	consecutiveFailures int
+}
+
+func newCgroupWriteBreaker(threshold int) *cgroupWriteBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &cgroupWriteBreaker{threshold: threshold}
+}
+
+func (b *cgroupWriteBreaker) isOpen() bool {
+	return b != nil && b.consecutiveFailures >= b.threshold
+}
+
+// record updates the breaker with the result of a cgroup write,
+// and it returns true only if the breaker is opened by this failure.
+func (b *cgroupWriteBreaker) record(err error) bool {
+	if b == nil {
+		return false
+	}
+
+	if err == nil {
+		b.consecutiveFailures = 0
+		return false
+	}
+
+	b.consecutiveFailures++
+	return b.consecutiveFailures == b.threshold
+}
+
+// cgroupWriteCap limits the number of cgroup writes in a reconcile round, to limit the blast radius of a bad
+// plan that would rewrite every pod, and the remaining writes are left for the next round once it's reached;
+// it's created at the beginning of each round, and a nil cap is never reached.
+type cgroupWriteCap struct {
+	limit  int
+	writes int
+}
+
+func newCgroupWriteCap(limit int) *cgroupWriteCap {
+	if limit <= 0 {
+		return nil
+	}
+	return &cgroupWriteCap{limit: limit}
+}
+
+func (c *cgroupWriteCap) isReached() bool {
+	return c != nil && c.writes >= c.limit
+}
+
+// record counts a cgroup write no matter whether it succeeds,
+// and it returns true only if the cap is reached by this write.
+func (c *cgroupWriteCap) record() bool {
+	if c == nil {
+		return false
+	}
+
+	c.writes++
+	return c.writes == c.limit
+}
@@ -0,0 +1,201 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/bytedance/mockey"
+	"github.com/smartystreets/goconvey/convey"
+	v1 "k8s.io/api/core/v1"
+	resource2 "k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/advisorsvc"
+	advisorapi "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/cpuadvisor"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/util"
+	"github.com/kubewharf/katalyst-core/pkg/config/agent/dynamic/quotareconcile"
+	"github.com/kubewharf/katalyst-core/pkg/config/generic"
+	"github.com/kubewharf/katalyst-core/pkg/metrics"
+	"github.com/kubewharf/katalyst-core/pkg/util/cgroup/common"
+	cgroupmgr "github.com/kubewharf/katalyst-core/pkg/util/cgroup/manager"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+)
+
+func TestDynamicPolicy_cgroupWriteBreaker(t *testing.T) {
+	t.Parallel()
+
+	p := &DynamicPolicy{
+		emitter: metrics.DummyMetrics{},
+	}
+
+	mockErr := fmt.Errorf("mock error")
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test cgroup write breaker opens after threshold", t, func() {
+		apply := mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().Return(mockErr).Build()
+		p.cgroupWriteBreaker = newCgroupWriteBreaker(2)
+
+		for i := 0; i < 2; i++ {
+			err := p.applyCPUQuotaWithRelativePath(context.TODO(), "test_relative_path", &common.CPUData{CpuQuota: -1})
+			convey.So(errors.Is(err, ErrCgroupWrite), convey.ShouldBeTrue)
+		}
+		convey.So(p.cgroupWriteBreaker.isOpen(), convey.ShouldBeTrue)
+
+		// the remaining writes in this round are skipped
+		for i := 0; i < 3; i++ {
+			err := p.applyCPUQuotaWithRelativePath(context.TODO(), "test_relative_path", &common.CPUData{CpuQuota: -1})
+			convey.So(err, convey.ShouldEqual, errCgroupWriteBreakerOpen)
+			convey.So(errors.Is(err, ErrCgroupWrite), convey.ShouldBeTrue)
+		}
+		convey.So(apply.Times(), convey.ShouldEqual, 2)
+
+		// pods are not touched any more after the breaker is open
+		getPod := mockey.Mock((*DynamicPolicy).getPodAndRelativePath).IncludeCurrentGoRoutine().Return(nil, "", mockErr).Build()
+		mockey.Mock((*DynamicPolicy).getCurrentPathAllPodsDirAndMap).IncludeCurrentGoRoutine().Return(map[string]*v1.Pod{}, []string{"test-pod-1-dir"}, nil).Build()
+		_, err := p.checkAndApplyAllPodsQuota(context.TODO(), &advisorsvc.CalculationInfo{CgroupPath: "test_cgroup_path"}, 1000)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(getPod.Times(), convey.ShouldEqual, 0)
+
+		// writes are retried in the next round
+		p.cgroupWriteBreaker = newCgroupWriteBreaker(2)
+		err = p.applyCPUQuotaWithRelativePath(context.TODO(), "test_relative_path", &common.CPUData{CpuQuota: -1})
+		convey.So(err, convey.ShouldNotEqual, errCgroupWriteBreakerOpen)
+		convey.So(errors.Is(err, ErrCgroupWrite), convey.ShouldBeTrue)
+		convey.So(apply.Times(), convey.ShouldEqual, 3)
+	})
+
+	mockey.PatchConvey("test cgroup write breaker resets after success", t, func() {
+		callTimes := 0
+		mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(_ string, _ *common.CPUData) error {
+			callTimes++
+			if callTimes%2 == 0 {
+				return nil
+			}
+			return mockErr
+		}).Build()
+		p.cgroupWriteBreaker = newCgroupWriteBreaker(2)
+
+		for i := 0; i < 5; i++ {
+			_ = p.applyCPUQuotaWithRelativePath(context.TODO(), "test_relative_path", &common.CPUData{CpuQuota: -1})
+		}
+		convey.So(callTimes, convey.ShouldEqual, 5)
+		convey.So(p.cgroupWriteBreaker.isOpen(), convey.ShouldBeFalse)
+	})
+}
+
+func TestDynamicPolicy_cgroupWriteCap(t *testing.T) {
+	t.Parallel()
+
+	p := &DynamicPolicy{
+		emitter:   metrics.DummyMetrics{},
+		qosConfig: generic.NewQoSConfiguration(),
+		quotaReconcileConf: &quotareconcile.QuotaReconcileConfiguration{
+			MaxCgroupWritesPerRound: 3,
+		},
+	}
+
+	groupPath := "/test_cgroup_path"
+	pods := map[string]*v1.Pod{}
+	var podDirs []string
+	for i := 0; i < 5; i++ {
+		podDir := fmt.Sprintf("test-pod-dir-%d", i)
+		podDirs = append(podDirs, podDir)
+		pods[podDir] = &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: fmt.Sprintf("test-pod-%d", i),
+			},
+			Spec: v1.PodSpec{
+				Containers: []v1.Container{
+					{
+						Name: "test-container",
+						Resources: v1.ResourceRequirements{
+							Limits: v1.ResourceList{
+								v1.ResourceCPU: resource2.MustParse("1"),
+							},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	resourcesBytes, _ := json.Marshal(&common.CgroupResources{CpuQuota: 1000000, CpuPeriod: 100000})
+	resp := &advisorapi.ListAndWatchResponse{
+		ExtraEntries: []*advisorsvc.CalculationInfo{
+			{
+				CgroupPath: groupPath,
+				CalculationResult: &advisorsvc.CalculationResult{
+					Values: map[string]string{
+						string(advisorapi.ControlKnobKeyCgroupConfig): string(resourcesBytes),
+					},
+				},
+			},
+		},
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test cgroup writes stop at the cap and resume in the next round", t, func() {
+		mockey.Mock(general.IsPathExists).IncludeCurrentGoRoutine().Return(true).Build()
+		mockey.Mock(common.CheckCgroup2UnifiedMode).IncludeCurrentGoRoutine().Return(false).Build()
+		mockey.Mock(common.ApplyCgroupConfigs).IncludeCurrentGoRoutine().Return(nil).Build()
+		mockTestPodDirs(podDirs, pods)
+		mockey.Mock((*DynamicPolicy).applyAllContainersQuota).IncludeCurrentGoRoutine().Return(nil).Build()
+		cgroupQuotas := map[string]int64{}
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(path string) (*common.CPUStats, error) {
+			quota, ok := cgroupQuotas[path]
+			if !ok {
+				quota = -1
+			}
+			return &common.CPUStats{CpuQuota: quota, CpuPeriod: 100000}, nil
+		}).Build()
+		apply := mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(path string, data *common.CPUData) error {
+			cgroupQuotas[path] = data.CpuQuota
+			return nil
+		}).Build()
+		var capReachedTimes int64
+		mockey.Mock(metrics.DummyMetrics.StoreInt64).IncludeCurrentGoRoutine().To(
+			func(_ metrics.DummyMetrics, key string, val int64, _ metrics.MetricTypeName, _ ...metrics.MetricTag) error {
+				if key == util.MetricNameCgroupWriteCapReached {
+					capReachedTimes += val
+				}
+				return nil
+			}).Build()
+
+		err := p.applyCgroupConfigs(resp)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(apply.Times(), convey.ShouldEqual, 3)
+		convey.So(cgroupQuotas, convey.ShouldHaveLength, 3)
+		convey.So(capReachedTimes, convey.ShouldEqual, 1)
+
+		// the remaining pods are reconciled in the next round
+		err = p.applyCgroupConfigs(resp)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(apply.Times(), convey.ShouldEqual, 5)
+		for _, podDir := range podDirs {
+			convey.So(cgroupQuotas[filepath.Join(groupPath, podDir)], convey.ShouldEqual, 100000)
+		}
+		convey.So(capReachedTimes, convey.ShouldEqual, 1)
+	})
+}
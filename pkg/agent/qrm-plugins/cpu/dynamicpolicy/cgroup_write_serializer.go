@@ -0,0 +1,83 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"errors"
+	"sync"
+)
+
+// errCgroupWriteSuperseded means a queued cgroup write is dropped since a newer one to the same path is queued.
+var errCgroupWriteSuperseded = errors.New("cgroup write is superseded by a newer one")
+
+// cgroupWriteSerializer serializes cgroup writes to each path, including the ones abandoned by the write timeout,
+// so that a late write never lands after a newer one to the same path; writes queued behind a hanging one are
+// dropped once a newer one to the same path is queued, since only the newest one matters.
+type cgroupWriteSerializer struct {
+	mutex sync.Mutex
+	paths map[string]*cgroupPathWrites
+}
+
+// cgroupPathWrites serializes writes to a cgroup path, and its mutex is held while a write to the path is issued.
+type cgroupPathWrites struct {
+	sync.Mutex
+	// latestSeq is the sequence number of the newest write to the path
+	latestSeq uint64
+	// refs is the number of writes issuing or waiting to issue, and the path is released once it's zero
+	refs int
+}
+
+func newCgroupWriteSerializer() *cgroupWriteSerializer {
+	return &cgroupWriteSerializer{
+		paths: make(map[string]*cgroupPathWrites),
+	}
+}
+
+// run issues the write to the cgroup path after the previous writes to the path finish, and it returns
+// errCgroupWriteSuperseded without issuing the write if a newer one to the path is queued in the meantime.
+func (s *cgroupWriteSerializer) run(relativePath string, write func() error) error {
+	s.mutex.Lock()
+	w, ok := s.paths[relativePath]
+	if !ok {
+		w = &cgroupPathWrites{}
+		s.paths[relativePath] = w
+	}
+	w.latestSeq++
+	seq := w.latestSeq
+	w.refs++
+	s.mutex.Unlock()
+
+	defer func() {
+		s.mutex.Lock()
+		w.refs--
+		if w.refs == 0 {
+			delete(s.paths, relativePath)
+		}
+		s.mutex.Unlock()
+	}()
+
+	w.Lock()
+	defer w.Unlock()
+
+	s.mutex.Lock()
+	superseded := seq != w.latestSeq
+	s.mutex.Unlock()
+	if superseded {
+		return errCgroupWriteSuperseded
+	}
+	return write()
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bytedance/mockey"
+	"github.com/smartystreets/goconvey/convey"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	resource2 "k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/advisorsvc"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/util"
+	"github.com/kubewharf/katalyst-core/pkg/config/agent/dynamic/quotareconcile"
+	"github.com/kubewharf/katalyst-core/pkg/config/generic"
+	"github.com/kubewharf/katalyst-core/pkg/metrics"
+	"github.com/kubewharf/katalyst-core/pkg/util/cgroup/common"
+	cgroupmgr "github.com/kubewharf/katalyst-core/pkg/util/cgroup/manager"
+)
+
+func TestDynamicPolicy_cgroupWriteTimeout(t *testing.T) {
+	t.Parallel()
+
+	p := &DynamicPolicy{
+		emitter:   metrics.DummyMetrics{},
+		qosConfig: generic.NewQoSConfiguration(),
+		quotaReconcileConf: &quotareconcile.QuotaReconcileConfiguration{
+			CgroupWriteTimeout: 100 * time.Millisecond,
+		},
+	}
+
+	newPod := func(name string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+			},
+			Spec: v1.PodSpec{
+				Containers: []v1.Container{
+					{
+						Name: "test-container",
+						Resources: v1.ResourceRequirements{
+							Limits: v1.ResourceList{
+								v1.ResourceCPU: resource2.MustParse("1"),
+							},
+						},
+					},
+				},
+			},
+		}
+	}
+	pods := map[string]*v1.Pod{
+		"hanging-pod-dir": newPod("hanging-pod"),
+		"normal-pod-dir":  newPod("normal-pod"),
+	}
+	hangingContainerPath := filepath.Join("test_cgroup_path", "hanging-pod-dir", "test-container")
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test a hanging cgroup write times out and the reconcile proceeds", t, func() {
+		mockTestPodDirs([]string{"hanging-pod-dir", "normal-pod-dir"}, pods)
+		mockey.Mock((*DynamicPolicy).getAllContainersRelativePathMap).IncludeCurrentGoRoutine().To(
+			func(_ *DynamicPolicy, pod *v1.Pod) map[string]*v1.Container {
+				podDir := strings.TrimSuffix(pod.Name, "-pod") + "-pod-dir"
+				return map[string]*v1.Container{
+					filepath.Join("test_cgroup_path", podDir, "test-container"): &pod.Spec.Containers[0],
+				}
+			}).Build()
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(&common.CPUStats{CpuQuota: -1, CpuPeriod: 100000}, nil).Build()
+
+		// cgroup writes run in other goroutines with the timeout, so the mock is not limited to the current goroutine
+		release := make(chan struct{})
+		var appliedMutex sync.Mutex
+		var appliedPaths []string
+		var hangingQuotas []int64
+		mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).To(func(relativePath string, data *common.CPUData) error {
+			if relativePath == hangingContainerPath {
+				<-release
+				appliedMutex.Lock()
+				defer appliedMutex.Unlock()
+				hangingQuotas = append(hangingQuotas, data.CpuQuota)
+				return nil
+			}
+			appliedMutex.Lock()
+			defer appliedMutex.Unlock()
+			appliedPaths = append(appliedPaths, relativePath)
+			return nil
+		}).Build()
+		var timeoutTimes int64
+		mockey.Mock(metrics.DummyMetrics.StoreInt64).IncludeCurrentGoRoutine().To(
+			func(_ metrics.DummyMetrics, key string, val int64, _ metrics.MetricTypeName, _ ...metrics.MetricTag) error {
+				if key == util.MetricNameCgroupWriteTimeout {
+					timeoutTimes += val
+				}
+				return nil
+			}).Build()
+
+		startTime := time.Now()
+		_, err := p.checkAndApplyAllPodsQuota(context.TODO(), &advisorsvc.CalculationInfo{CgroupPath: "test_cgroup_path"}, 1000000)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(time.Since(startTime), convey.ShouldBeLessThan, 5*time.Second)
+		convey.So(timeoutTimes, convey.ShouldEqual, 1)
+
+		// the pod with the hanging write is skipped, and the other pod is reconciled
+		appliedMutex.Lock()
+		convey.So(appliedPaths, convey.ShouldResemble, []string{
+			filepath.Join("test_cgroup_path", "normal-pod-dir", "test-container"),
+			filepath.Join("test_cgroup_path", "normal-pod-dir"),
+		})
+		appliedMutex.Unlock()
+		_, ok := p.getPodQuotaTracker().get(filepath.Join("test_cgroup_path", "hanging-pod-dir"))
+		convey.So(ok, convey.ShouldBeFalse)
+
+		// write failures are returned without waiting for the hanging write, and the writes are queued behind it
+		err = p.applyCPUQuotaWithRelativePath(context.TODO(), hangingContainerPath, &common.CPUData{CpuQuota: 200000})
+		convey.So(errors.Is(err, ErrCgroupWrite), convey.ShouldBeTrue)
+		err = p.applyCPUQuotaWithRelativePath(context.TODO(), hangingContainerPath, &common.CPUData{CpuQuota: 300000})
+		convey.So(errors.Is(err, ErrCgroupWrite), convey.ShouldBeTrue)
+
+		// once the hanging write finishes, the newest write lands last and the superseded one is dropped
+		close(release)
+		assert.Eventually(t, func() bool {
+			appliedMutex.Lock()
+			defer appliedMutex.Unlock()
+			return len(hangingQuotas) == 2
+		}, 5*time.Second, 10*time.Millisecond)
+		appliedMutex.Lock()
+		defer appliedMutex.Unlock()
+		convey.So(hangingQuotas, convey.ShouldResemble, []int64{100000, 300000})
+	})
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+)
+
+// containerPathRecord is the relative cgroup path resolved for a container with the given container id.
+type containerPathRecord struct {
+	containerID  string
+	relativePath string
+}
+
+// containerPathCache caches relative cgroup paths of containers keyed by pod uid and container name, so that
+// they are not resolved against all cgroup roots in every round; a cached path is only reused while the
+// container id is unchanged, since a restarted container gets a new id and the old cgroup path becomes stale.
+type containerPathCache struct {
+	records map[string]map[string]*containerPathRecord
+}
+
+func newContainerPathCache() *containerPathCache {
+	return &containerPathCache{
+		records: make(map[string]map[string]*containerPathRecord),
+	}
+}
+
+// get returns the cached path of the container with the given container id,
+// and the record is invalidated if the container id is changed, i.e. the container is restarted.
+func (c *containerPathCache) get(podUID, containerName, containerID string) (string, bool) {
+	r, ok := c.records[podUID][containerName]
+	if !ok {
+		return "", false
+	}
+
+	if r.containerID != containerID {
+		general.Infof("container id of %s/%s is changed from %s to %s, invalidate its cached cgroup path %s",
+			podUID, containerName, r.containerID, containerID, r.relativePath)
+		delete(c.records[podUID], containerName)
+		return "", false
+	}
+	return r.relativePath, true
+}
+
+// set records the path resolved for the container with the given container id.
+func (c *containerPathCache) set(podUID, containerName, containerID, relativePath string) {
+	if c.records[podUID] == nil {
+		c.records[podUID] = make(map[string]*containerPathRecord)
+	}
+	c.records[podUID][containerName] = &containerPathRecord{containerID: containerID, relativePath: relativePath}
+}
+
+// prune deletes records of pods that are not live any more.
+func (c *containerPathCache) prune(livePodUIDs map[string]bool) {
+	for podUID := range c.records {
+		if !livePodUIDs[podUID] {
+			delete(c.records, podUID)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/util"
+	"github.com/kubewharf/katalyst-core/pkg/metrics"
+	"github.com/kubewharf/katalyst-core/pkg/util/cgroup/common"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+)
+
+const (
+	// eventReasonCPUQuotaThrottled is the reason of events recorded for pods throttled excessively
+	eventReasonCPUQuotaThrottled = "CPUQuotaThrottled"
+	eventActionReconcileQuota    = "ReconcileQuota"
+)
+
+// cpuThrottleStats is the throttling statistics in cpu.stat of a cgroup.
+type cpuThrottleStats struct {
+	nrPeriods   uint64
+	nrThrottled uint64
+	// throttledTime is the total throttled time in nanoseconds
+	throttledTime uint64
+}
+
+// readCPUThrottleStats reads the throttling statistics in cpu.stat of the cgroup, it supports both throttled_time
+// in nanoseconds of cgroup v1 and throttled_usec in microseconds of cgroup v2.
+func readCPUThrottleStats(absCgroupPath string) (*cpuThrottleStats, error) {
+	f, err := os.Open(filepath.Join(absCgroupPath, common.CgroupCPUStatFile))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stats := &cpuThrottleStats{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s in %s: %v", fields[0], common.CgroupCPUStatFile, err)
+		}
+		switch fields[0] {
+		case "nr_periods":
+			stats.nrPeriods = value
+		case "nr_throttled":
+			stats.nrThrottled = value
+		case "throttled_time":
+			stats.throttledTime = value
+		case "throttled_usec":
+			stats.throttledTime = value * 1000
+		}
+	}
+	return stats, scanner.Err()
+}
+
+// cpuThrottleTracker keeps the last cpu.stat samples of pod cgroups, keyed by the relative path of the pod cgroup.
+type cpuThrottleTracker struct {
+	samples map[string]*cpuThrottleStats
+}
+
+func newCPUThrottleTracker() *cpuThrottleTracker {
+	return &cpuThrottleTracker{
+		samples: make(map[string]*cpuThrottleStats),
+	}
+}
+
+// update records the sample of the pod cgroup, and returns the ratio of throttled periods to all periods since
+// the last sample, along with the throttled time in nanoseconds; false is returned if it can't be told, e.g.
+// for the first sample or counters reset by a recreated cgroup.
+func (t *cpuThrottleTracker) update(podRelativePath string, stats *cpuThrottleStats) (float64, uint64, bool) {
+	last, ok := t.samples[podRelativePath]
+	t.samples[podRelativePath] = stats
+	if !ok || stats.nrPeriods <= last.nrPeriods || stats.nrThrottled < last.nrThrottled ||
+		stats.throttledTime < last.throttledTime {
+		return 0, 0, false
+	}
+
+	ratio := float64(stats.nrThrottled-last.nrThrottled) / float64(stats.nrPeriods-last.nrPeriods)
+	return ratio, stats.throttledTime - last.throttledTime, true
+}
+
+// prune deletes samples of pod cgroups under the parent path which are not matched with live pods in the round.
+func (t *cpuThrottleTracker) prune(parentPath string, livePaths map[string]bool) {
+	prefix := filepath.Clean(parentPath) + string(filepath.Separator)
+	for path := range t.samples {
+		if strings.HasPrefix(path, prefix) && !livePaths[path] {
+			delete(t.samples, path)
+		}
+	}
+}
+
+// checkPodThrottle emits the throttle ratio of the pod since the last round, and records a warning event for the
+// pod if the ratio exceeds the alert ratio, hinting that its quota may be too tight. The ratio is returned along
+// with whether it can be told. Errors are only logged since throttling is merely observed in quota reconcile.
+func (p *DynamicPolicy) checkPodThrottle(pod *v1.Pod, podRelativePath string) (float64, bool) {
+	stats, err := readCPUThrottleStats(p.getAbsCgroupPath(common.CgroupSubsysCPU, podRelativePath))
+	if err != nil {
+		general.InfofV(4, "read cpu throttle stats of pod %s failed with error: %v", pod.Name, err)
+		return 0, false
+	}
+
+	ratio, throttledTime, ok := p.getCPUThrottleTracker().update(podRelativePath, stats)
+	if !ok {
+		return 0, false
+	}
+	// the ratio is tagged by the qos level of the pod to bound the cardinality, and the pod itself is only logged
+	general.InfofV(4, "pod %s/%s is throttled in %.1f%% of periods since the last round", pod.Namespace, pod.Name, ratio*100)
+	_ = p.emitter.StoreFloat64(util.MetricNamePodCPUThrottleRatio, ratio, metrics.MetricTypeNameRaw,
+		metrics.ConvertMapToTags(map[string]string{
+			"qosLevel": p.getPodQoSLevelTag(pod),
+		})...)
+
+	alertRatio := p.getQuotaReconcileConf().ThrottleAlertRatio
+	if alertRatio <= 0 || ratio <= alertRatio {
+		return ratio, true
+	}
+	general.Warningf("pod %s is throttled in %.1f%% of periods since the last round, exceeding the alert ratio %.1f%%",
+		pod.Name, ratio*100, alertRatio*100)
+	if p.eventRecorder != nil {
+		p.eventRecorder.Eventf(pod, nil, v1.EventTypeWarning, eventReasonCPUQuotaThrottled, eventActionReconcileQuota,
+			"cpu quota is throttled in %.1f%% of periods (%.2fs throttled) since the last reconcile, exceeding %.1f%%, "+
+				"the quota may be too tight", ratio*100, float64(throttledTime)/1e9, alertRatio*100)
+	}
+	return ratio, true
+}
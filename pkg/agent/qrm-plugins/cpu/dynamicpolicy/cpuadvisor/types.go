@@ -21,6 +21,21 @@ type CPUControlKnobName string
 const (
 	ControlKnobKeyCPUNUMAHeadroom CPUControlKnobName = "cpu_numa_headroom"
 	ControlKnobKeyCgroupConfig    CPUControlKnobName = "cgroup_config"
+	ControlKnobKeyCPUUclampMin    CPUControlKnobName = "cpu_uclamp_min"
+	ControlKnobKeyCPUUclampMax    CPUControlKnobName = "cpu_uclamp_max"
+	ControlKnobKeyPidsMax         CPUControlKnobName = "pids_max"
+	ControlKnobKeyCPUSetMems      CPUControlKnobName = "cpuset_mems"
+	ControlKnobKeySwapMax         CPUControlKnobName = "swap_max"
+	ControlKnobKeyCgroupFreeze    CPUControlKnobName = "cgroup_freeze"
+	ControlKnobKeyOOMScoreAdj     CPUControlKnobName = "oom_score_adj"
+	// ControlKnobKeyContainerMemoryLimits is a JSON map from pod uid to container name to memory limit in bytes
+	ControlKnobKeyContainerMemoryLimits CPUControlKnobName = "container_memory_limits"
+	// ControlKnobKeyCPUCores is the allocation in whole or fractional cores, which is converted into cpu quota
+	// in the period of the cgroup and takes precedence over the quota in cgroup config
+	ControlKnobKeyCPUCores CPUControlKnobName = "cpu.cores"
+	// ControlKnobKeyAdvisorSource is the name of the advisor source giving the calculation info, by which calculation
+	// infos of multiple sources for the same cgroup are merged; empty means the default cpu-advisor source
+	ControlKnobKeyAdvisorSource CPUControlKnobName = "advisor_source"
 )
 
 type CPUNUMAHeadroom map[int]float64
@@ -68,10 +68,12 @@ func (c *CPUPressureEviction) Run(ctx context.Context) {
 	<-ctx.Done()
 }
 
+// NewCPUPressureEviction creates plugins of all registered initializers, along with the additional strategies
+// created by the caller, e.g. the ones signaled by the cpu plugin itself.
 func NewCPUPressureEviction(emitter metrics.MetricEmitter, metaServer *metaserver.MetaServer,
-	conf *config.Configuration, state state.ReadonlyState,
+	conf *config.Configuration, state state.ReadonlyState, additionalStrategies ...strategy.CPUPressureEviction,
 ) (*CPUPressureEviction, error) {
-	eviction, err := newCPUPressureEviction(emitter, metaServer, conf, state)
+	eviction, err := newCPUPressureEviction(emitter, metaServer, conf, state, additionalStrategies...)
 	if err != nil {
 		return nil, fmt.Errorf("create cpu eviction plugin failed: %s", err)
 	}
@@ -80,18 +82,25 @@ func NewCPUPressureEviction(emitter metrics.MetricEmitter, metaServer *metaserve
 }
 
 func newCPUPressureEviction(emitter metrics.MetricEmitter, metaServer *metaserver.MetaServer,
-	conf *config.Configuration, state state.ReadonlyState,
+	conf *config.Configuration, state state.ReadonlyState, additionalStrategies ...strategy.CPUPressureEviction,
 ) (*CPUPressureEviction, error) {
 	var errList []error
 
-	plugins := make(map[string]agent.Component)
+	strategies := make(map[string]strategy.CPUPressureEviction)
 	for name, f := range GetRegisteredInitializers() {
 		plugin, err := f(emitter, metaServer, conf, state)
 		if err != nil {
 			errList = append(errList, err)
 			continue
 		}
+		strategies[name] = plugin
+	}
+	for _, plugin := range additionalStrategies {
+		strategies[plugin.Name()] = plugin
+	}
 
+	plugins := make(map[string]agent.Component)
+	for name, plugin := range strategies {
 		wrappedEmitter := emitter.WithTags(name)
 		pluginWrapper, err := skeleton.NewRegistrationPluginWrapper(strategy.NewCPUPressureEvictionPlugin(plugin, wrappedEmitter),
 			[]string{conf.PluginRegistrationDir},
@@ -0,0 +1,106 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package strategy
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+
+	pluginapi "github.com/kubewharf/katalyst-api/pkg/protocol/evictionplugin/v1alpha1"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+)
+
+const EvictionNameQuotaStarvation = "cpu-quota-starvation-plugin"
+
+// CPUQuotaStarvation evicts pods signaled as quota-starved by the quota reconcile of the cpu plugin, i.e. pods
+// whose quota is held at the floor while they are still throttled heavily for consecutive rounds, since evicting
+// them may be better than keeping them starved. It's created by the cpu plugin instead of being registered as an
+// initializer, so that the quota reconcile can hold it to signal pods.
+type CPUQuotaStarvation struct {
+	mutex sync.Mutex
+	// starvedPods are reasons of signaled pods keyed by pod uid
+	starvedPods map[string]string
+}
+
+var _ CPUPressureEviction = &CPUQuotaStarvation{}
+
+func NewCPUQuotaStarvationEviction() *CPUQuotaStarvation {
+	return &CPUQuotaStarvation{
+		starvedPods: make(map[string]string),
+	}
+}
+
+func (p *CPUQuotaStarvation) Start(context.Context) error { return nil }
+func (p *CPUQuotaStarvation) Name() string                { return EvictionNameQuotaStarvation }
+
+func (p *CPUQuotaStarvation) ThresholdMet(_ context.Context, _ *pluginapi.GetThresholdMetRequest) (*pluginapi.ThresholdMetResponse, error) {
+	return &pluginapi.ThresholdMetResponse{}, nil
+}
+
+func (p *CPUQuotaStarvation) GetTopEvictionPods(_ context.Context, _ *pluginapi.GetTopEvictionPodsRequest) (*pluginapi.GetTopEvictionPodsResponse, error) {
+	return &pluginapi.GetTopEvictionPodsResponse{}, nil
+}
+
+// SignalStarvedPod marks the pod for eviction with the reason.
+func (p *CPUQuotaStarvation) SignalStarvedPod(podUID, reason string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.starvedPods[podUID] = reason
+}
+
+// ClearStarvedPod unmarks the pod once it's no longer starved.
+func (p *CPUQuotaStarvation) ClearStarvedPod(podUID string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.starvedPods, podUID)
+}
+
+// GetEvictPods returns the active pods signaled as starved, and signals of pods no longer active are dropped.
+func (p *CPUQuotaStarvation) GetEvictPods(_ context.Context, request *pluginapi.GetEvictPodsRequest) (*pluginapi.GetEvictPodsResponse, error) {
+	if request == nil {
+		return nil, fmt.Errorf("GetEvictPods got nil request")
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	activePods := make(map[string]*v1.Pod, len(request.ActivePods))
+	for _, pod := range request.ActivePods {
+		if pod != nil {
+			activePods[string(pod.UID)] = pod
+		}
+	}
+
+	var evictPods []*pluginapi.EvictPod
+	for podUID, reason := range p.starvedPods {
+		pod, ok := activePods[podUID]
+		if !ok {
+			delete(p.starvedPods, podUID)
+			continue
+		}
+
+		general.Infof("evict quota-starved pod %s/%s: %s", pod.Namespace, pod.Name, reason)
+		evictPods = append(evictPods, &pluginapi.EvictPod{
+			Pod:    pod,
+			Reason: reason,
+		})
+	}
+	return &pluginapi.GetEvictPodsResponse{EvictPods: evictPods}, nil
+}
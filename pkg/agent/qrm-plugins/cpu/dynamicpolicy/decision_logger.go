@@ -0,0 +1,84 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// quotaDecision is the decision made for a pod cgroup in a quota reconcile round,
+// it's written as a JSON line for offline analysis.
+type quotaDecision struct {
+	Timestamp time.Time `json:"timestamp"`
+	PodUID    string    `json:"podUID"`
+	Path      string    `json:"path"`
+	// ComputedQuota is the quota calculated from the pod limit, and AppliedQuota is the one actually applied
+	// after being ramped or relaxed to unlimited, and -1 means unlimited for both of them
+	ComputedQuota int64 `json:"computedQuota"`
+	AppliedQuota  int64 `json:"appliedQuota"`
+	// Delta is the change from the quota found in the pod cgroup to the applied one
+	Delta int64 `json:"delta"`
+}
+
+// quotaDecisionLogger writes decisions of quota reconcile to a file as JSON lines,
+// and the file is rotated once it reaches the max size.
+type quotaDecisionLogger struct {
+	file       string
+	maxSizeMB  int
+	maxBackups int
+	writer     *lumberjack.Logger
+}
+
+func newQuotaDecisionLogger(file string, maxSizeMB, maxBackups int) *quotaDecisionLogger {
+	return &quotaDecisionLogger{
+		file:       file,
+		maxSizeMB:  maxSizeMB,
+		maxBackups: maxBackups,
+		writer: &lumberjack.Logger{
+			Filename:   file,
+			MaxSize:    maxSizeMB,
+			MaxBackups: maxBackups,
+		},
+	}
+}
+
+// matches returns whether the logger is created with the given settings.
+func (l *quotaDecisionLogger) matches(file string, maxSizeMB, maxBackups int) bool {
+	return l.file == file && l.maxSizeMB == maxSizeMB && l.maxBackups == maxBackups
+}
+
+// log writes the decision as a JSON line.
+func (l *quotaDecisionLogger) log(decision *quotaDecision) error {
+	line, err := json.Marshal(decision)
+	if err != nil {
+		return fmt.Errorf("marshal quota decision failed with error: %v", err)
+	}
+
+	_, err = l.writer.Write(append(line, '\n'))
+	if err != nil {
+		return fmt.Errorf("write quota decision to %s failed with error: %v", l.file, err)
+	}
+	return nil
+}
+
+func (l *quotaDecisionLogger) close() error {
+	return l.writer.Close()
+}
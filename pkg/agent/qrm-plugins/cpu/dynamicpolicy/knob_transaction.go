@@ -0,0 +1,169 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"fmt"
+	"math"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+
+	"github.com/kubewharf/katalyst-core/pkg/util/cgroup/common"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+)
+
+const (
+	knobCPU         = "cpu"
+	knobCPUSetMems  = "cpuset.mems"
+	knobMemoryLimit = "memory.limit"
+)
+
+// knobUndo restores a knob of a cgroup to the value before it's first applied in the transaction.
+type knobUndo struct {
+	knob         string
+	relativePath string
+	undo         func() error
+}
+
+// knobTransaction records how to restore the knobs applied for a calculation info, so that
+// the knobs carried by it either all take effect or are all rolled back.
+// Knobs whose prior values can't be read back, e.g. pids.max and cgroup freeze, are not recorded.
+type knobTransaction struct {
+	// undos are in the order of the first apply of each knob of each cgroup
+	undos    []knobUndo
+	captured map[string]bool
+}
+
+func newKnobTransaction() *knobTransaction {
+	return &knobTransaction{
+		captured: make(map[string]bool),
+	}
+}
+
+// capture records the undo built by capturePrior for the knob of the cgroup, and only the one
+// built before the first apply of the knob is kept, since it holds the value to roll back to.
+func (t *knobTransaction) capture(knob, relativePath string, capturePrior func(string) (func() error, error)) error {
+	key := knob + ":" + relativePath
+	if t.captured[key] {
+		return nil
+	}
+
+	undo, err := capturePrior(relativePath)
+	if err != nil {
+		return err
+	}
+
+	t.undos = append(t.undos, knobUndo{
+		knob:         knob,
+		relativePath: relativePath,
+		undo:         undo,
+	})
+	t.captured[key] = true
+	return nil
+}
+
+// rollback restores the knobs in the reverse order they are applied, and it goes on with the
+// remaining ones if any fails, so that as many knobs as possible are back to the prior values.
+func (t *knobTransaction) rollback() error {
+	var errList []error
+	for i := len(t.undos) - 1; i >= 0; i-- {
+		undo := t.undos[i]
+		if err := undo.undo(); err != nil {
+			errList = append(errList, fmt.Errorf("%w: roll back %s of %s failed with error: %v",
+				ErrCgroupWrite, undo.knob, undo.relativePath, err))
+			continue
+		}
+		general.Infof("roll back %s of %s", undo.knob, undo.relativePath)
+	}
+	return utilerrors.NewAggregate(errList)
+}
+
+// beginKnobTransaction starts recording the prior values of knobs applied for a calculation info.
+func (p *DynamicPolicy) beginKnobTransaction() {
+	p.knobTransaction = newKnobTransaction()
+}
+
+// endKnobTransaction rolls back all knobs applied for the calculation info if applying any of them failed,
+// and the rollback failure, if any, is attached to the apply one.
+func (p *DynamicPolicy) endKnobTransaction(cgroupPath string, applyErr error) error {
+	transaction := p.knobTransaction
+	p.knobTransaction = nil
+	if applyErr == nil || transaction == nil {
+		return applyErr
+	}
+
+	general.Warningf("applying knobs of %s failed, roll back the ones applied: %v", cgroupPath, applyErr)
+	if err := transaction.rollback(); err != nil {
+		return fmt.Errorf("%w, and rollback failed: %v", applyErr, err)
+	}
+	return applyErr
+}
+
+// captureKnob records the prior value of the knob in the in-progress knob transaction, and a failure
+// fails the apply, since the knob couldn't be rolled back together with the others otherwise.
+func (p *DynamicPolicy) captureKnob(knob, relativePath string, capturePrior func(string) (func() error, error)) error {
+	if p.knobTransaction == nil {
+		return nil
+	}
+
+	if err := p.knobTransaction.capture(knob, relativePath, capturePrior); err != nil {
+		return fmt.Errorf("%w: capture prior %s of %s failed with error: %v", ErrCgroupRead, knob, relativePath, err)
+	}
+	return nil
+}
+
+func (p *DynamicPolicy) capturePriorCPU(relativePath string) (func() error, error) {
+	stats, err := p.getCPUWithRelativePath(relativePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() error {
+		return p.applyCPUWithRelativePath(relativePath, &common.CPUData{
+			CpuQuota:    stats.CpuQuota,
+			CpuPeriod:   stats.CpuPeriod,
+			CpuBurstPtr: stats.CpuBurst,
+		})
+	}, nil
+}
+
+func (p *DynamicPolicy) capturePriorCPUSetMems(relativePath string) (func() error, error) {
+	stats, err := p.getCPUSetWithRelativePath(relativePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() error {
+		return p.applyCPUSetWithRelativePath(relativePath, &common.CPUSetData{Mems: stats.Mems})
+	}, nil
+}
+
+func (p *DynamicPolicy) capturePriorMemoryLimit(relativePath string) (func() error, error) {
+	stats, err := p.getMemoryWithRelativePath(relativePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// an unlimited memory limit is read back as the max uint64, and it's written as -1
+	limit := int64(-1)
+	if stats.Limit <= math.MaxInt64 {
+		limit = int64(stats.Limit)
+	}
+	return func() error {
+		return p.applyMemoryWithRelativePath(relativePath, &common.MemoryData{LimitInBytes: limit})
+	}, nil
+}
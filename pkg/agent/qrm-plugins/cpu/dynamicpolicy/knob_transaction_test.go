@@ -0,0 +1,141 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/bytedance/mockey"
+	"github.com/smartystreets/goconvey/convey"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/advisorsvc"
+	advisorapi "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/cpuadvisor"
+	"github.com/kubewharf/katalyst-core/pkg/util/cgroup/common"
+	cgroupmgr "github.com/kubewharf/katalyst-core/pkg/util/cgroup/manager"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+	"github.com/kubewharf/katalyst-core/pkg/util/machine"
+)
+
+func TestDynamicPolicy_knobTransaction(t *testing.T) {
+	t.Parallel()
+
+	testPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test-pod",
+			UID:  "test-pod-uid",
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{
+				{Name: "test-container"},
+			},
+		},
+	}
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	assert.NoError(t, err)
+	p := newTestDynamicPolicy(withTestPods(testPod))
+	p.machineInfo = &machine.KatalystMachineInfo{CPUTopology: cpuTopology}
+
+	groupPath := "/test_cgroup_path"
+	containerPath := "test-container-path"
+	limitsBytes, _ := json.Marshal(map[string]map[string]int64{"test-pod-uid": {"test-container": 4 << 30}})
+	resourcesBytes, _ := json.Marshal(&common.CgroupResources{CpuQuota: 200000, CpuPeriod: 100000})
+	resp := &advisorapi.ListAndWatchResponse{
+		ExtraEntries: []*advisorsvc.CalculationInfo{
+			{
+				CgroupPath: groupPath,
+				CalculationResult: &advisorsvc.CalculationResult{
+					Values: map[string]string{
+						string(advisorapi.ControlKnobKeyCPUSetMems):            "1",
+						string(advisorapi.ControlKnobKeyContainerMemoryLimits): string(limitsBytes),
+						string(advisorapi.ControlKnobKeyCgroupConfig):          string(resourcesBytes),
+					},
+				},
+			},
+		},
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test knobs of a calculation info are rolled back together", t, func() {
+		mockey.Mock(general.IsPathExists).IncludeCurrentGoRoutine().Return(true).Build()
+		mockey.Mock(common.CheckCgroup2UnifiedMode).IncludeCurrentGoRoutine().Return(true).Build()
+		mockey.Mock((*DynamicPolicy).getAllContainersRelativePathMap).IncludeCurrentGoRoutine().Return(map[string]*v1.Container{
+			containerPath: &testPod.Spec.Containers[0],
+		}).Build()
+
+		mems := map[string]string{groupPath: "0-1"}
+		mockey.Mock(cgroupmgr.GetCPUSetWithRelativePath).IncludeCurrentGoRoutine().To(func(path string) (*common.CPUSetStats, error) {
+			return &common.CPUSetStats{Mems: mems[path]}, nil
+		}).Build()
+		mockey.Mock(cgroupmgr.ApplyCPUSetWithRelativePath).IncludeCurrentGoRoutine().To(func(path string, data *common.CPUSetData) error {
+			mems[path] = data.Mems
+			return nil
+		}).Build()
+
+		memoryLimits := map[string]uint64{containerPath: 8 << 30}
+		mockey.Mock(cgroupmgr.GetMetricsWithRelativePath).IncludeCurrentGoRoutine().To(
+			func(_ string, _ map[string]struct{}) (*common.CgroupMetrics, error) {
+				return &common.CgroupMetrics{Memory: &common.MemoryMetrics{RSS: 2 << 30}}, nil
+			}).Build()
+		mockey.Mock(cgroupmgr.GetMemoryWithRelativePath).IncludeCurrentGoRoutine().To(func(path string) (*common.MemoryStats, error) {
+			return &common.MemoryStats{Limit: memoryLimits[path]}, nil
+		}).Build()
+		mockey.Mock(cgroupmgr.ApplyMemoryWithRelativePath).IncludeCurrentGoRoutine().To(func(path string, data *common.MemoryData) error {
+			memoryLimits[path] = uint64(data.LimitInBytes)
+			return nil
+		}).Build()
+
+		quotas := map[string]int64{groupPath: 400000}
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(path string) (*common.CPUStats, error) {
+			return &common.CPUStats{CpuQuota: quotas[path], CpuPeriod: 100000}, nil
+		}).Build()
+		mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(path string, data *common.CPUData) error {
+			quotas[path] = data.CpuQuota
+			return nil
+		}).Build()
+
+		// the quota as the third knob fails, and the cpuset mems and memory limit applied before are rolled back
+		applyErr := fmt.Errorf("test error")
+		mockey.Mock(common.ApplyCgroupConfigs).IncludeCurrentGoRoutine().To(func(path string, resources *common.CgroupResources) error {
+			if applyErr != nil {
+				quotas[path] = 0
+				return applyErr
+			}
+			quotas[path] = resources.CpuQuota
+			return nil
+		}).Build()
+
+		err := p.applyCgroupConfigs(resp)
+		convey.So(err, convey.ShouldNotBeNil)
+		convey.So(mems[groupPath], convey.ShouldEqual, "0-1")
+		convey.So(memoryLimits[containerPath], convey.ShouldEqual, 8<<30)
+		convey.So(quotas[groupPath], convey.ShouldEqual, 400000)
+
+		// all knobs take effect once none of them fails
+		applyErr = nil
+		err = p.applyCgroupConfigs(resp)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(mems[groupPath], convey.ShouldEqual, "1")
+		convey.So(memoryLimits[containerPath], convey.ShouldEqual, 4<<30)
+		convey.So(quotas[groupPath], convey.ShouldEqual, 200000)
+	})
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+)
+
+// kubeletCPUManagerPolicyStatic is the cpu manager policy of kubelet that assigns exclusive cpus
+// to containers of guaranteed pods with integer cpu requests
+const kubeletCPUManagerPolicyStatic = "static"
+
+// kubeletCPUManagerCheckpoint is the checkpoint of cpu manager of kubelet, holding only the fields used here;
+// entries are cpusets of containers with exclusive cpus keyed by pod uids and container names
+type kubeletCPUManagerCheckpoint struct {
+	PolicyName    string                       `json:"policyName"`
+	DefaultCPUSet string                       `json:"defaultCpuSet"`
+	Entries       map[string]map[string]string `json:"entries,omitempty"`
+}
+
+// readKubeletCPUManagerCheckpoint reads the checkpoint of cpu manager of kubelet from the given file,
+// and a missing file means cpu manager isn't enabled, so nil is returned without error.
+func readKubeletCPUManagerCheckpoint(file string) (*kubeletCPUManagerCheckpoint, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read kubelet cpu manager checkpoint %s failed: %w", file, err)
+	}
+
+	checkpoint := &kubeletCPUManagerCheckpoint{}
+	if err := json.Unmarshal(data, checkpoint); err != nil {
+		return nil, fmt.Errorf("unmarshal kubelet cpu manager checkpoint %s failed: %w", file, err)
+	}
+	return checkpoint, nil
+}
+
+// isPodOwnedByKubeletStaticPolicy returns whether cpus of the pod are exclusively assigned by the static policy
+// of cpu manager of kubelet, in which case kubelet owns its cgroups and quota reconcile must defer to it.
+// The checkpoint is read once per round, and an unreadable one is logged and treated as owning no pods.
+func (p *DynamicPolicy) isPodOwnedByKubeletStaticPolicy(pod *v1.Pod, round *podQuotaRound) bool {
+	file := p.getQuotaReconcileConf().KubeletCPUManagerStateFile
+	if file == "" {
+		return false
+	}
+
+	if round.kubeletStaticPods == nil {
+		round.kubeletStaticPods = make(map[string]bool)
+		checkpoint, err := readKubeletCPUManagerCheckpoint(file)
+		if err != nil {
+			general.Warningf("%v, treat no pods as owned by kubelet static cpu manager policy in this round", err)
+		} else if checkpoint != nil && checkpoint.PolicyName == kubeletCPUManagerPolicyStatic {
+			for podUID, containers := range checkpoint.Entries {
+				if len(containers) > 0 {
+					round.kubeletStaticPods[podUID] = true
+				}
+			}
+		}
+	}
+	return round.kubeletStaticPods[string(pod.UID)]
+}
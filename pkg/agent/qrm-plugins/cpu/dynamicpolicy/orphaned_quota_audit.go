@@ -0,0 +1,80 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/util"
+	"github.com/kubewharf/katalyst-core/pkg/metrics"
+	"github.com/kubewharf/katalyst-core/pkg/util/cgroup/common"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+)
+
+// auditOrphanedQuotas scans the tracked quota records for cgroups matching no known pod, pool or kubepods root,
+// which are never meant to be applied, e.g. system cgroups applied by a bug, and resets their quota to unlimited.
+// Pod cgroups with no live pod are left to cleanupStalePodQuotas, which tolerates pods missing transiently.
+func (p *DynamicPolicy) auditOrphanedQuotas(ctx context.Context) {
+	if !p.getQuotaReconcileConf().AuditOrphanedQuota || p.podQuotaTracker == nil {
+		return
+	}
+
+	podsPathMap, err := p.getAllPodsPathMap()
+	if err != nil {
+		general.Warningf("get pods path map failed with error: %v, skip auditing orphaned quotas", err)
+		return
+	}
+
+	for _, record := range p.podQuotaTracker.list() {
+		if p.isKnownQuotaPath(record.PodRelativePath, podsPathMap) {
+			continue
+		}
+
+		general.Errorf("ORPHANED QUOTA: cgroup %s matches no pod, pool or kubepods root but has quota %d tracked, "+
+			"reset it to unlimited", record.PodRelativePath, record.Quota)
+		if general.IsPathExists(p.getAbsCgroupPath(common.DefaultSelectedSubsys, record.PodRelativePath)) {
+			if err := p.applyCPUQuotaWithRelativePath(ctx, record.PodRelativePath, &common.CPUData{CpuQuota: -1}); err != nil {
+				general.Errorf("reset orphaned quota of cgroup %s failed with error: %v, retry in the next round",
+					record.PodRelativePath, err)
+				continue
+			}
+		}
+
+		p.podQuotaTracker.remove(record.PodRelativePath)
+		p.getPodQuotaConvergenceTracker().remove(record.PodRelativePath)
+		_ = p.emitter.StoreInt64(util.MetricNameOrphanedQuotaCleared, 1, metrics.MetricTypeNameCount)
+	}
+}
+
+// isKnownQuotaPath returns whether quota of the cgroup path is meant to be applied, i.e. it's a pool cgroup,
+// the kubepods root, or a pod cgroup, and pod cgroups are told by their dir names even if no live pod matches.
+func (p *DynamicPolicy) isKnownQuotaPath(relativePath string, podsPathMap map[string]*v1.Pod) bool {
+	if p.isPoolCgroupPath(relativePath) || isKubepodsRootCgroupPath(relativePath) {
+		return true
+	}
+	if _, ok := podsPathMap[p.getAbsCgroupPath(common.DefaultSelectedSubsys, relativePath)]; ok {
+		return true
+	}
+
+	// pod dirs are named "pod<uid>" by cgroupfs, and "kubepods-<qos>-pod<uid>.slice" by systemd
+	dir := filepath.Base(relativePath)
+	return strings.HasPrefix(dir, common.PodCgroupPathPrefix) || strings.Contains(dir, "-"+common.PodCgroupPathPrefix)
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// podFailureRecord is the consecutive failures of a pod cgroup in quota reconcile.
+type podFailureRecord struct {
+	failures int
+	// retryTime is the time before which the pod cgroup is skipped
+	retryTime time.Time
+}
+
+// podFailureBackoff tracks consecutive failures of pod cgroups under advisor cgroup paths,
+// keyed by the relative path of the pod cgroup, so that persistently failing pods are retried less often.
+type podFailureBackoff struct {
+	records map[string]*podFailureRecord
+}
+
+func newPodFailureBackoff() *podFailureBackoff {
+	return &podFailureBackoff{
+		records: make(map[string]*podFailureRecord),
+	}
+}
+
+// recordFailure counts a failure of the pod cgroup, and backs it off for the base doubled with each
+// consecutive failure up to the max; zero base means no backoff, and zero max means no max.
+func (b *podFailureBackoff) recordFailure(podRelativePath string, now time.Time, base, max time.Duration) {
+	if base <= 0 {
+		delete(b.records, podRelativePath)
+		return
+	}
+
+	r, ok := b.records[podRelativePath]
+	if !ok {
+		r = &podFailureRecord{}
+		b.records[podRelativePath] = r
+	}
+	r.failures++
+
+	backoff := base
+	for i := 1; i < r.failures && (max <= 0 || backoff < max); i++ {
+		backoff *= 2
+	}
+	if max > 0 && backoff > max {
+		backoff = max
+	}
+	r.retryTime = now.Add(backoff)
+}
+
+// recordSuccess resets the backoff of the pod cgroup.
+func (b *podFailureBackoff) recordSuccess(podRelativePath string) {
+	delete(b.records, podRelativePath)
+}
+
+// isBackingOff returns whether the pod cgroup is skipped at the time, along with its consecutive failures.
+func (b *podFailureBackoff) isBackingOff(podRelativePath string, now time.Time) (bool, int) {
+	r, ok := b.records[podRelativePath]
+	if !ok {
+		return false, 0
+	}
+	return now.Before(r.retryTime), r.failures
+}
+
+// backingOffPods returns the number of pod cgroups under the parent path which are skipped at the time.
+func (b *podFailureBackoff) backingOffPods(parentPath string, now time.Time) int64 {
+	prefix := filepath.Clean(parentPath) + string(filepath.Separator)
+
+	var count int64
+	for path, r := range b.records {
+		if strings.HasPrefix(path, prefix) && now.Before(r.retryTime) {
+			count++
+		}
+	}
+	return count
+}
+
+// prune deletes records of pod cgroups under the parent path which are not matched with live pods in the round.
+func (b *podFailureBackoff) prune(parentPath string, livePaths map[string]bool) {
+	prefix := filepath.Clean(parentPath) + string(filepath.Separator)
+	for path := range b.records {
+		if strings.HasPrefix(path, prefix) && !livePaths[path] {
+			delete(b.records, path)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/util"
+	"github.com/kubewharf/katalyst-core/pkg/metrics"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+)
+
+// podQuotaConvergenceLagBuckets are the upper bounds of buckets of the convergence lag histogram,
+// and lags beyond the last one fall into the "+Inf" bucket.
+var podQuotaConvergenceLagBuckets = []time.Duration{
+	time.Second,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+	time.Minute,
+	5 * time.Minute,
+}
+
+// podQuotaConvergenceRecord is the desired quota of a pod cgroup and when it first appears.
+type podQuotaConvergenceRecord struct {
+	quota  int64
+	period uint64
+	// desiredTime is the time when the desired quota first appears in quota reconcile
+	desiredTime time.Time
+	// converged is true once the pod cgroup is applied with the desired quota
+	converged bool
+}
+
+// podQuotaConvergenceTracker tracks desired quotas of pod cgroups under advisor cgroup paths until they
+// converge, keyed by the relative path of the pod cgroup, to tell how long pods lag behind plan changes.
+type podQuotaConvergenceTracker struct {
+	records map[string]*podQuotaConvergenceRecord
+}
+
+func newPodQuotaConvergenceTracker() *podQuotaConvergenceTracker {
+	return &podQuotaConvergenceTracker{
+		records: make(map[string]*podQuotaConvergenceRecord),
+	}
+}
+
+// observe tracks the desired quota and period of the pod cgroup, and the time it first appears is kept
+// until it changes; a pod cgroup already at the desired quota when it's first observed has nothing to converge.
+func (t *podQuotaConvergenceTracker) observe(podRelativePath string, quota int64, period uint64, atDesired bool, now time.Time) {
+	r, ok := t.records[podRelativePath]
+	if ok && r.quota == quota && r.period == period {
+		return
+	}
+
+	t.records[podRelativePath] = &podQuotaConvergenceRecord{
+		quota:       quota,
+		period:      period,
+		desiredTime: now,
+		converged:   !ok && atDesired,
+	}
+}
+
+// converge marks the pod cgroup converged to its desired quota, and returns the lag since the desired
+// quota first appears only for the first convergence, so that a pod is counted once per plan change.
+func (t *podQuotaConvergenceTracker) converge(podRelativePath string, now time.Time) (time.Duration, bool) {
+	r, ok := t.records[podRelativePath]
+	if !ok || r.converged {
+		return 0, false
+	}
+
+	r.converged = true
+	return now.Sub(r.desiredTime), true
+}
+
+// remove deletes the record of the pod cgroup, it's safe to remove a record that doesn't exist.
+func (t *podQuotaConvergenceTracker) remove(podRelativePath string) {
+	delete(t.records, podRelativePath)
+}
+
+// getPodQuotaConvergenceLagBucket returns the label of the histogram bucket the lag falls into.
+func getPodQuotaConvergenceLagBucket(lag time.Duration) string {
+	for _, bound := range podQuotaConvergenceLagBuckets {
+		if lag <= bound {
+			return bound.String()
+		}
+	}
+	return "+Inf"
+}
+
+// observePodQuotaConvergence tracks the desired quota of the pod computed in the current round.
+func (p *DynamicPolicy) observePodQuotaConvergence(podRelativePath string, quota int64, period uint64, atDesired bool) {
+	p.getPodQuotaConvergenceTracker().observe(podRelativePath, quota, period, atDesired, p.getClock().Now())
+}
+
+// convergePodQuota marks the pod converged to its desired quota, and the lag since the desired quota first
+// appears is counted into the bucket of the convergence lag histogram it falls into.
+func (p *DynamicPolicy) convergePodQuota(pod *v1.Pod, podRelativePath string) {
+	lag, ok := p.getPodQuotaConvergenceTracker().converge(podRelativePath, p.getClock().Now())
+	if !ok {
+		return
+	}
+
+	general.InfofV(4, "quota of pod %s converges %v after the desired one appears", pod.Name, lag)
+	_ = p.emitter.StoreInt64(util.MetricNamePodQuotaConvergenceLag, 1, metrics.MetricTypeNameCount,
+		metrics.ConvertMapToTags(map[string]string{
+			"bucket": getPodQuotaConvergenceLagBucket(lag),
+		})...)
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// stalePodQuotaToleranceRounds is the number of consecutive rounds a tracked pod cgroup can miss its live pod
+// before being pruned, it guards against pods missing transiently from the pod fetcher.
+const stalePodQuotaToleranceRounds = 3
+
+// podQuotaRecord is the quota applied to a pod cgroup in quota reconcile.
+type podQuotaRecord struct {
+	quota int64
+	// reconcileTime is the time when the quota is applied or confirmed in the last reconcile
+	reconcileTime time.Time
+	// writeTime is the time when the quota is last written to the pod cgroup, and it's zero if never written
+	writeTime time.Time
+	// missedRounds is the number of consecutive rounds in which no live pod matches the pod cgroup
+	missedRounds int
+}
+
+// TrackedPodQuota is the last-applied state of a pod cgroup tracked in quota reconcile.
+type TrackedPodQuota struct {
+	PodRelativePath string
+	// Quota is the last-applied quota of the pod cgroup, and -1 means unlimited
+	Quota             int64
+	LastReconcileTime time.Time
+}
+
+// podQuotaTracker tracks quotas applied to pod cgroups under advisor cgroup paths,
+// keyed by the relative path of the pod cgroup.
+type podQuotaTracker struct {
+	records map[string]*podQuotaRecord
+}
+
+func newPodQuotaTracker() *podQuotaTracker {
+	return &podQuotaTracker{
+		records: make(map[string]*podQuotaRecord),
+	}
+}
+
+// record sets the quota applied to the pod cgroup, and the time of the last write is kept.
+func (t *podQuotaTracker) record(podRelativePath string, quota int64) {
+	r := &podQuotaRecord{quota: quota, reconcileTime: time.Now()}
+	if prior, ok := t.records[podRelativePath]; ok {
+		r.writeTime = prior.writeTime
+	}
+	t.records[podRelativePath] = r
+}
+
+// markWritten sets the time when the quota is written to the pod cgroup, it's a no-op if the pod isn't tracked.
+func (t *podQuotaTracker) markWritten(podRelativePath string, writeTime time.Time) {
+	if r, ok := t.records[podRelativePath]; ok {
+		r.writeTime = writeTime
+	}
+}
+
+// lastWriteTime returns the time when the quota is last written to the pod cgroup.
+func (t *podQuotaTracker) lastWriteTime(podRelativePath string) (time.Time, bool) {
+	r, ok := t.records[podRelativePath]
+	if !ok || r.writeTime.IsZero() {
+		return time.Time{}, false
+	}
+	return r.writeTime, true
+}
+
+// get returns the tracked quota of the pod cgroup.
+func (t *podQuotaTracker) get(podRelativePath string) (int64, bool) {
+	r, ok := t.records[podRelativePath]
+	if !ok {
+		return 0, false
+	}
+	return r.quota, true
+}
+
+// list returns copies of all tracked records sorted by the relative path of the pod cgroup.
+func (t *podQuotaTracker) list() []TrackedPodQuota {
+	trackedPodQuotas := make([]TrackedPodQuota, 0, len(t.records))
+	for path, r := range t.records {
+		trackedPodQuotas = append(trackedPodQuotas, TrackedPodQuota{
+			PodRelativePath:   path,
+			Quota:             r.quota,
+			LastReconcileTime: r.reconcileTime,
+		})
+	}
+	sort.Slice(trackedPodQuotas, func(i, j int) bool {
+		return trackedPodQuotas[i].PodRelativePath < trackedPodQuotas[j].PodRelativePath
+	})
+	return trackedPodQuotas
+}
+
+// remove deletes the record of the pod cgroup, it's safe to remove a record that doesn't exist.
+func (t *podQuotaTracker) remove(podRelativePath string) {
+	delete(t.records, podRelativePath)
+}
+
+// stalePaths returns tracked pod cgroups under the parent path which have missed their live pods
+// for enough rounds; livePaths are pod cgroups matched with live pods in the current round.
+func (t *podQuotaTracker) stalePaths(parentPath string, livePaths map[string]bool) []string {
+	prefix := filepath.Clean(parentPath) + string(filepath.Separator)
+
+	var stalePaths []string
+	for path, r := range t.records {
+		if !strings.HasPrefix(path, prefix) {
+			continue
+		}
+
+		if livePaths[path] {
+			r.missedRounds = 0
+			continue
+		}
+
+		r.missedRounds++
+		if r.missedRounds >= stalePodQuotaToleranceRounds {
+			stalePaths = append(stalePaths, path)
+		}
+	}
+	return stalePaths
+}
+
+// isPodQuotaSettling returns whether the quota was written to the pod cgroup less than the read-back settle delay
+// ago, in which case its read-back may still be transient. It never blocks, since it's called under the policy lock.
+func (p *DynamicPolicy) isPodQuotaSettling(podRelativePath string) bool {
+	settleDelay := p.getQuotaReconcileConf().QuotaReadBackSettleDelay
+	if settleDelay <= 0 {
+		return false
+	}
+
+	writeTime, ok := p.getPodQuotaTracker().lastWriteTime(podRelativePath)
+	return ok && p.getClock().Since(writeTime) < settleDelay
+}
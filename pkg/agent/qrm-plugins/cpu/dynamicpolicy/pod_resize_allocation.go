@@ -0,0 +1,122 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+
+	apiconsts "github.com/kubewharf/katalyst-api/pkg/consts"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+)
+
+// allocatedPodResourcesTTL is the time after which records of pods no longer seen are pruned
+const allocatedPodResourcesTTL = 10 * time.Minute
+
+// allocatedPodResources is the container resources of a pod allocated by kubelet, keyed by container names.
+type allocatedPodResources struct {
+	containers map[string]v1.ResourceRequirements
+	lastSeen   time.Time
+}
+
+// allocatedPodResourcesTracker tracks resources of pods allocated by kubelet, keyed by pod uids.
+//
+// During an in-place resize, the spec of a pod carries the desired resources while kubelet may not have allocated
+// them yet, and quota must be derived from the allocated ones instead. The allocated resources and resize status
+// of pod status (KEP-1287) are not available in the k8s.io/api version this module is pinned to, so the transition
+// is told by the in-place update resizing annotation, and the allocation by the resized Allocate of kubelet: resources
+// of pods out of the transition are recorded as allocated, and the record of a pod is dropped once kubelet allocates
+// its resize, after which its spec is recorded as allocated again. Pods with no record during the transition,
+// e.g. after the agent restarts, fall back to their spec.
+type allocatedPodResourcesTracker struct {
+	records map[string]*allocatedPodResources
+}
+
+func newAllocatedPodResourcesTracker() *allocatedPodResourcesTracker {
+	return &allocatedPodResourcesTracker{records: make(map[string]*allocatedPodResources)}
+}
+
+// record records the spec resources of the pod as allocated, and prunes records of pods no longer seen.
+func (t *allocatedPodResourcesTracker) record(pod *v1.Pod, now time.Time) {
+	containers := make(map[string]v1.ResourceRequirements, len(pod.Spec.InitContainers)+len(pod.Spec.Containers))
+	for i := range pod.Spec.InitContainers {
+		containers[pod.Spec.InitContainers[i].Name] = *pod.Spec.InitContainers[i].Resources.DeepCopy()
+	}
+	for i := range pod.Spec.Containers {
+		containers[pod.Spec.Containers[i].Name] = *pod.Spec.Containers[i].Resources.DeepCopy()
+	}
+	t.records[string(pod.UID)] = &allocatedPodResources{containers: containers, lastSeen: now}
+
+	for podUID, r := range t.records {
+		if now.Sub(r.lastSeen) > allocatedPodResourcesTTL {
+			delete(t.records, podUID)
+		}
+	}
+}
+
+// get returns the recorded allocated resources of the pod, and refreshes the time it's seen.
+func (t *allocatedPodResourcesTracker) get(podUID string, now time.Time) (*allocatedPodResources, bool) {
+	r, ok := t.records[podUID]
+	if ok {
+		r.lastSeen = now
+	}
+	return r, ok
+}
+
+// remove drops the record of the pod, it's safe to remove a record that doesn't exist.
+func (t *allocatedPodResourcesTracker) remove(podUID string) {
+	delete(t.records, podUID)
+}
+
+// isPodInplaceUpdateResizing returns whether the pod is in the transition of an in-place resize.
+func isPodInplaceUpdateResizing(pod *v1.Pod) bool {
+	return pod.Annotations[apiconsts.PodAnnotationInplaceUpdateResizingKey] == "true"
+}
+
+// getAllocatedPod returns the pod with resources allocated by kubelet, from which its quota is derived.
+// During an in-place resize not yet allocated, a copy of the pod with the recorded allocated resources is returned,
+// or else the pod itself is returned and its resources are recorded as allocated.
+func (p *DynamicPolicy) getAllocatedPod(pod *v1.Pod) *v1.Pod {
+	tracker := p.getAllocatedPodResourcesTracker()
+	now := p.getClock().Now()
+	if !isPodInplaceUpdateResizing(pod) {
+		tracker.record(pod, now)
+		return pod
+	}
+
+	allocated, ok := tracker.get(string(pod.UID), now)
+	if !ok {
+		tracker.record(pod, now)
+		return pod
+	}
+
+	general.InfofV(4, "pod %s is resizing in place and its resize isn't allocated yet, derive its quota from allocated resources",
+		pod.Name)
+	allocatedPod := pod.DeepCopy()
+	for i := range allocatedPod.Spec.InitContainers {
+		if resources, ok := allocated.containers[allocatedPod.Spec.InitContainers[i].Name]; ok {
+			allocatedPod.Spec.InitContainers[i].Resources = *resources.DeepCopy()
+		}
+	}
+	for i := range allocatedPod.Spec.Containers {
+		if resources, ok := allocated.containers[allocatedPod.Spec.Containers[i].Name]; ok {
+			allocatedPod.Spec.Containers[i].Resources = *resources.DeepCopy()
+		}
+	}
+	return allocatedPod
+}
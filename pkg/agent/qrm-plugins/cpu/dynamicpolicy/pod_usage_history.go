@@ -0,0 +1,172 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+
+	coreconsts "github.com/kubewharf/katalyst-core/pkg/consts"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+	"github.com/kubewharf/katalyst-core/pkg/util/native"
+)
+
+// podUsageHistory keeps the latest cpu usage samples (in cores) of pods keyed by their uids, since the metric store
+// of metaserver only holds the latest ones. Samples are taken by samplePodUsages in its own goroutine, so that the
+// sample rate is bound to time rather than to reconciles, and the history is guarded by the mutex.
+type podUsageHistory struct {
+	mutex   sync.Mutex
+	samples map[string][]float64
+	// epoch is increased by every sampling, by which quotas sized from the history are told to be stale
+	epoch uint64
+}
+
+func newPodUsageHistory() *podUsageHistory {
+	return &podUsageHistory{
+		samples: make(map[string][]float64),
+	}
+}
+
+// add appends a usage sample of the pod, and only the latest size samples are kept.
+func (h *podUsageHistory) add(podUID string, usage float64, size int) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	samples := append(h.samples[podUID], usage)
+	if len(samples) > size {
+		samples = samples[len(samples)-size:]
+	}
+	h.samples[podUID] = samples
+}
+
+// percentile returns the nearest-rank percentile of usage samples of the pod, along with the number of them.
+func (h *podUsageHistory) percentile(podUID string, percentile float64) (float64, int) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	samples := h.samples[podUID]
+	if len(samples) == 0 {
+		return 0, 0
+	}
+
+	sorted := append([]float64(nil), samples...)
+	sort.Float64s(sorted)
+	rank := int(math.Ceil(percentile / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1], len(sorted)
+}
+
+// prune deletes samples of pods which are not alive anymore, and the epoch is increased as a sampling is finished.
+func (h *podUsageHistory) prune(livePodUIDs map[string]bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for podUID := range h.samples {
+		if !livePodUIDs[podUID] {
+			delete(h.samples, podUID)
+		}
+	}
+	h.epoch++
+}
+
+// getEpoch returns the number of samplings finished so far.
+func (h *podUsageHistory) getEpoch() uint64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.epoch
+}
+
+// samplePodUsages samples the current cpu usage of active pods summed from their containers into the history,
+// which is a no-op if the percentile-based quota is disabled. Pods with usage of any container unavailable are
+// skipped in this sampling.
+func (p *DynamicPolicy) samplePodUsages() {
+	p.RLock()
+	conf := p.getQuotaReconcileConf()
+	percentile, size := conf.PodQuotaUsagePercentile, conf.PodQuotaUsageHistorySamples
+	history := p.getPodUsageHistory()
+	p.RUnlock()
+
+	if percentile <= 0 || p.metaServer == nil || p.metaServer.MetricsFetcher == nil {
+		return
+	}
+
+	pods, err := p.metaServer.GetPodList(context.Background(), native.PodIsActive)
+	if err != nil {
+		general.Warningf("get pod list for sampling pod usage failed with error: %v", err)
+		return
+	}
+
+	livePodUIDs := make(map[string]bool, len(pods))
+	for _, pod := range pods {
+		livePodUIDs[string(pod.UID)] = true
+
+		var podUsage float64
+		sampled := true
+		for _, container := range pod.Spec.Containers {
+			usage, err := p.metaServer.GetContainerMetric(string(pod.UID), container.Name, coreconsts.MetricCPUUsageContainer)
+			if err != nil || usage.Value < 0 {
+				general.InfofV(4, "cpu usage of container %s/%s is unavailable, skip sampling pod usage: %v", pod.Name, container.Name, err)
+				sampled = false
+				break
+			}
+			podUsage += usage.Value
+		}
+		if sampled {
+			history.add(string(pod.UID), podUsage, size)
+		}
+	}
+	history.prune(livePodUIDs)
+}
+
+// getPodUsagePercentileLimit returns the configured percentile of historical usage of the pod with the margin (in
+// milli-cores), clamped to the pod limit. false is returned if the percentile-based quota is disabled, or fewer
+// samples than configured are collected, and then the pod is sized by its limit instead.
+func (p *DynamicPolicy) getPodUsagePercentileLimit(pod *v1.Pod, podLimit int64) (int64, bool) {
+	conf := p.getQuotaReconcileConf()
+	if conf.PodQuotaUsagePercentile <= 0 {
+		return 0, false
+	}
+
+	usage, samples := p.getPodUsageHistory().percentile(string(pod.UID), conf.PodQuotaUsagePercentile)
+	if samples < conf.PodQuotaUsageHistorySamples {
+		general.InfofV(4, "only %d of %d usage samples of pod %s are collected, size its quota by limit",
+			samples, conf.PodQuotaUsageHistorySamples, pod.Name)
+		return 0, false
+	}
+
+	// usage is in cores
+	limit := int64(math.Round(usage * 1000 * (100 + conf.PodQuotaUsagePercentileMarginPercent) / 100))
+	limit = general.MinInt64(limit, podLimit)
+	general.InfofV(4, "size quota of pod %s to %d milli-cores by p%v usage %v of %d samples",
+		pod.Name, limit, conf.PodQuotaUsagePercentile, usage, samples)
+	return limit, true
+}
+
+// getPodUsageEpoch returns the epoch of the usage history if the percentile-based quota is enabled, or else zero,
+// so that the reconcile cache is invalidated by new samples only when quotas are sized from them.
+func (p *DynamicPolicy) getPodUsageEpoch() uint64 {
+	if p.getQuotaReconcileConf().PodQuotaUsagePercentile <= 0 {
+		return 0
+	}
+	return p.getPodUsageHistory().getEpoch()
+}
@@ -19,15 +19,20 @@ package dynamicpolicy
 import (
 	"context"
 	"fmt"
+	"reflect"
 	"strconv"
 	"sync"
 	"time"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/atomic"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/events"
 	pluginapi "k8s.io/kubelet/pkg/apis/resourceplugin/v1alpha1"
 	maputil "k8s.io/kubernetes/pkg/util/maps"
 	"k8s.io/utils/clock"
@@ -42,6 +47,7 @@ import (
 	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/calculator"
 	advisorapi "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/cpuadvisor"
 	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/cpueviction"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/cpueviction/strategy"
 	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/hintoptimizer"
 	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/hintoptimizer/policy"
 	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/hintoptimizer/registry"
@@ -53,9 +59,11 @@ import (
 	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/util"
 	"github.com/kubewharf/katalyst-core/pkg/agent/utilcomponent/featuregatenegotiation"
 	"github.com/kubewharf/katalyst-core/pkg/agent/utilcomponent/periodicalhandler"
+	"github.com/kubewharf/katalyst-core/pkg/client/control"
 	"github.com/kubewharf/katalyst-core/pkg/config"
 	dynamicconfig "github.com/kubewharf/katalyst-core/pkg/config/agent/dynamic"
 	"github.com/kubewharf/katalyst-core/pkg/config/agent/dynamic/crd"
+	"github.com/kubewharf/katalyst-core/pkg/config/agent/dynamic/quotareconcile"
 	"github.com/kubewharf/katalyst-core/pkg/config/generic"
 	"github.com/kubewharf/katalyst-core/pkg/metaserver"
 	"github.com/kubewharf/katalyst-core/pkg/metrics"
@@ -77,6 +85,15 @@ const (
 	maxResidualTime   = 5 * time.Minute
 	syncCPUIdlePeriod = 30 * time.Second
 
+	// advisorPlanStalenessCheckPeriod is the period of emitting the age of the last applied plan of cpu-advisor
+	advisorPlanStalenessCheckPeriod = 30 * time.Second
+	// annotationQuotaFallbackPeriod is the period of applying quota annotations of pods as a fallback
+	annotationQuotaFallbackPeriod = 30 * time.Second
+	// resizedPodReconcilePeriod is the period of reconciling quota of pods resized in place since the last check
+	resizedPodReconcilePeriod = time.Second
+	// podUsageSamplePeriod is the period of sampling cpu usage of pods for percentile-based quotas
+	podUsageSamplePeriod = 30 * time.Second
+
 	healthCheckTolerationTimes = 3
 )
 
@@ -139,6 +156,101 @@ type DynamicPolicy struct {
 
 	sharedCoresNUMABindingHintOptimizer    hintoptimizer.HintOptimizer
 	dedicatedCoresNUMABindingHintOptimizer hintoptimizer.HintOptimizer
+
+	quotaReconcileConf *quotareconcile.QuotaReconcileConfiguration
+	cgroupWriteBreaker *cgroupWriteBreaker
+	cgroupWriteCap     *cgroupWriteCap
+	// cgroupConfigsTargets tracks cgroups written with cgroup configs in the in-progress reconcile, so that the threaded
+	// domain root shared by threaded cgroups is written once
+	cgroupConfigsTargets *cgroupConfigsTargets
+	// cgroupWriteSerializer serializes cgroup writes to each path, so that writes abandoned by the timeout never
+	// land after newer ones
+	cgroupWriteSerializer *cgroupWriteSerializer
+	podQuotaTracker       *podQuotaTracker
+	// podQuotaConvergenceTracker tracks desired quotas of pods until they converge, to tell their reconcile lag
+	podQuotaConvergenceTracker *podQuotaConvergenceTracker
+	// cpuCgroupWrites is the number of cpu cgroup writes actually issued in the in-progress round
+	cpuCgroupWrites int64
+	// podFailureBackoff backs off pods that fail to be applied in consecutive rounds
+	podFailureBackoff *podFailureBackoff
+	// podUsageHistory keeps the latest cpu usage samples of pods taken every podUsageSamplePeriod, from which
+	// percentile-based quotas are sized
+	podUsageHistory *podUsageHistory
+	// cpuThrottleTracker keeps the last cpu.stat samples of pods, from which throttle ratios between rounds are told
+	cpuThrottleTracker *cpuThrottleTracker
+	// quotaStarvationTracker counts consecutive rounds in which pods are starved at the quota floor
+	quotaStarvationTracker *quotaStarvationTracker
+	// quotaStarvationEviction is signaled with quota-starved pods to be evicted, and it's nil if cpu eviction is disabled
+	quotaStarvationEviction *strategy.CPUQuotaStarvation
+	// eventRecorder records events of pods, e.g. alerts of excessive throttling, and it's nil if events are disabled
+	eventRecorder events.EventRecorder
+	// reconcileCache records last-applied calculation infos, so that identical ones are fast-pathed
+	reconcileCache *reconcileCache
+	// resizedPodUIDs records pods resized in place since the last check, whose quota is reconciled in a targeted way
+	resizedPodUIDs map[string]bool
+	// allocatedPodResourcesTracker tracks resources of pods allocated by kubelet, from which quota of pods
+	// resizing in place is derived until kubelet allocates their resizes
+	allocatedPodResourcesTracker *allocatedPodResourcesTracker
+	// containerPathCache caches relative cgroup paths of containers until they are restarted
+	containerPathCache *containerPathCache
+	// podPathMap is the map of absolute cgroup paths to pods resolved in the latest reconcile, kept for debugging
+	podPathMap map[string]*v1.Pod
+	// nodeReadyForReconcile is set once the node is ready for the first reconcile, and later ones are no longer gated
+	nodeReadyForReconcile bool
+	// reconcileRounds is the number of reconcile rounds so far, and fullAuditRound indicates whether the
+	// in-progress round is a full audit one bypassing the fast path
+	reconcileRounds uint64
+	fullAuditRound  bool
+	// reconcileBudget bounds the duration of a round, and records where the last round is cut off
+	reconcileBudget reconcileBudget
+	// advisorPlanRunner applies plans of cpu-advisor one by one, and coalesces plans pushed in the meanwhile
+	advisorPlanRunner advisorPlanRunner
+	// cgroupRootOverride overrides the cgroupfs mount point on which cgroup paths of advisor are resolved
+	cgroupRootOverride string
+	// staticPlanFile is the file of a static plan applied instead of advice from sys-advisor
+	staticPlanFile string
+	// qosLevelReconcileIntervals are intervals keyed by qos levels at which pods of the qos levels are reconciled
+	// again with lastCgroupConfigs, which are the calculation infos of the last applied plan
+	qosLevelReconcileIntervals map[string]time.Duration
+	lastCgroupConfigs          []*advisorsvc.CalculationInfo
+	// lastAdvisorPlanTime is the time when the last plan of cpu-advisor is applied successfully,
+	// and it's measured by clock, which is the real clock if nil
+	lastAdvisorPlanTime time.Time
+	clock               clock.Clock
+	// annotationQuotaFallback indicates whether the in-progress round applies quota annotations of pods as a fallback,
+	// and annotationQuotaFallbackStartTime is the time of the first check of the fallback, from which the time
+	// without any plan is measured
+	annotationQuotaFallback          bool
+	annotationQuotaFallbackStartTime time.Time
+	// reconcileTransaction records prior cpu stats of cgroups changed in the in-progress reconcile,
+	// and lastReconcileTransaction is the one of the last reconcile that changed any cgroup
+	reconcileTransaction     *reconcileTransaction
+	lastReconcileTransaction *reconcileTransaction
+	// knobTransaction records prior values of knobs applied for the in-progress calculation info
+	knobTransaction *knobTransaction
+	// quotaDecisionLogger writes decisions of quota reconcile for offline analysis, and it's nil if disabled
+	quotaDecisionLogger *quotaDecisionLogger
+	// quotaWebhookSink posts significant quota changes to the webhook for audit, and it's nil if disabled
+	quotaWebhookSink *quotaWebhookSink
+	// appliedQuotaPublisher publishes quotas applied to pod cgroups to the metric store of metaserver
+	appliedQuotaPublisher appliedQuotaPublisher
+	// cnrControl writes the reconcile status to the CNR of the node, reconcileStatus summarizes the in-progress round
+	// for it, and lastReconcileStatusReportTime is the time of the last write, by which writes are rate-limited.
+	// Writes are made in the background, reconcileStatusReporting is set while one is in flight, and
+	// reconcileStatusReportWG waits for it
+	cnrControl                    control.CNRControl
+	reconcileStatus               *reconcileStatus
+	lastReconcileStatusReportTime time.Time
+	reconcileStatusReporting      atomic.Bool
+	reconcileStatusReportWG       sync.WaitGroup
+	// reconcileMetricsExporter forwards reconcile metrics fed to the emitter, which wraps it, to reconcileMetricsPath
+	reconcileMetricsExporter *reconcileMetricsExporter
+	// reconcilePausedByAdmin is set by Pause and cleared by Resume, reconciles are skipped while it's set
+	reconcilePausedByAdmin atomic.Bool
+	// tracer traces the quota reconcile pipeline, it falls back to the global tracer provider if not set
+	tracer trace.Tracer
+	// simulation records quota writes instead of making them, and it's only set on policies of SimulateReconcile
+	simulation *reconcileSimulation
 }
 
 func NewDynamicPolicy(agentCtx *agent.GenericContext, conf *config.Configuration,
@@ -166,17 +278,33 @@ func NewDynamicPolicy(agentCtx *agent.GenericContext, conf *config.Configuration
 	state.SetReadWriteState(stateImpl)
 
 	var (
-		cpuPressureEviction agent.Component
-		err                 error
+		cpuPressureEviction     agent.Component
+		quotaStarvationEviction *strategy.CPUQuotaStarvation
+		err                     error
 	)
 	if conf.EnableCPUPressureEviction {
+		quotaStarvationEviction = strategy.NewCPUQuotaStarvationEviction()
 		cpuPressureEviction, err = cpueviction.NewCPUPressureEviction(
-			agentCtx.EmitterPool.GetDefaultMetricsEmitter(), agentCtx.MetaServer, conf, stateImpl)
+			agentCtx.EmitterPool.GetDefaultMetricsEmitter(), agentCtx.MetaServer, conf, stateImpl, quotaStarvationEviction)
 		if err != nil {
 			return false, agent.ComponentStub{}, err
 		}
 	}
 
+	// reconcile metrics are also stored to a Prometheus emitter of the pool if the export is enabled at startup,
+	// so that they can be scraped directly
+	policyEmitter, reconcileMetricsExporter, err := newReconcileMetricsEmitter(agentCtx.EmitterPool, wrappedEmitter,
+		conf.GetDynamicConfiguration().QuotaReconcileConfiguration.EnableMetricsExport)
+	if err != nil {
+		return false, agent.ComponentStub{}, err
+	}
+
+	// the reconcile status is only written to the CNR if it's reported and the agent is built with a client
+	var cnrControl control.CNRControl
+	if agentCtx.Client != nil && conf.GetDynamicConfiguration().QuotaReconcileConfiguration.StatusReportInterval > 0 {
+		cnrControl = control.NewCNRControlImpl(agentCtx.Client.InternalClient)
+	}
+
 	// since the reservedCPUs won't influence stateImpl directly.
 	// so we don't modify stateImpl with reservedCPUs here.
 	// for those pods have already been allocated reservedCPUs,
@@ -185,9 +313,11 @@ func NewDynamicPolicy(agentCtx *agent.GenericContext, conf *config.Configuration
 		name:   fmt.Sprintf("%s_%s", agentName, cpuconsts.CPUResourcePluginPolicyNameDynamic),
 		stopCh: make(chan struct{}),
 
-		machineInfo: agentCtx.KatalystMachineInfo,
-		emitter:     wrappedEmitter,
-		metaServer:  agentCtx.MetaServer,
+		machineInfo:              agentCtx.KatalystMachineInfo,
+		emitter:                  policyEmitter,
+		reconcileMetricsExporter: reconcileMetricsExporter,
+		metaServer:               agentCtx.MetaServer,
+		cnrControl:               cnrControl,
 
 		state:          stateImpl,
 		residualHitMap: make(map[string]int64),
@@ -195,7 +325,8 @@ func NewDynamicPolicy(agentCtx *agent.GenericContext, conf *config.Configuration
 		advisorValidator:   validator.NewCPUAdvisorValidator(stateImpl, agentCtx.KatalystMachineInfo),
 		featureGateManager: featuregatenegotiation.NewFeatureGateManager(conf),
 
-		cpuPressureEviction: cpuPressureEviction,
+		cpuPressureEviction:     cpuPressureEviction,
+		quotaStarvationEviction: quotaStarvationEviction,
 
 		conf:                          conf,
 		qosConfig:                     conf.QoSConfiguration,
@@ -206,6 +337,10 @@ func NewDynamicPolicy(agentCtx *agent.GenericContext, conf *config.Configuration
 		enableSNBHighNumaPreference:   conf.EnableSNBHighNumaPreference,
 		enableCPUAdvisor:              conf.CPUQRMPluginConfig.EnableCPUAdvisor,
 		getAdviceInterval:             conf.CPUQRMPluginConfig.GetAdviceInterval,
+		advisorPlanRunner:             advisorPlanRunner{minInterval: conf.CPUQRMPluginConfig.MinReconcileInterval},
+		cgroupRootOverride:            conf.CPUQRMPluginConfig.CgroupRootOverride,
+		staticPlanFile:                conf.CPUQRMPluginConfig.StaticPlanFile,
+		qosLevelReconcileIntervals:    conf.CPUQRMPluginConfig.QoSLevelReconcileIntervals,
 		reservedCPUs:                  reservedCPUs,
 		extraStateFileAbsPath:         conf.ExtraStateFileAbsPath,
 		enableSyncingCPUIdle:          conf.CPUQRMPluginConfig.EnableSyncingCPUIdle,
@@ -221,6 +356,10 @@ func NewDynamicPolicy(agentCtx *agent.GenericContext, conf *config.Configuration
 		reservedReclaimedCPUsSize:                 general.Max(reservedReclaimedCPUsSize, agentCtx.KatalystMachineInfo.NumNUMANodes),
 	}
 
+	if agentCtx.BroadcastAdapter != nil {
+		policyImplement.eventRecorder = agentCtx.BroadcastAdapter.NewRecorder(agentName)
+	}
+
 	// initialize hint optimizer
 	err = policyImplement.initHintOptimizers()
 	if err != nil {
@@ -271,6 +410,9 @@ func NewDynamicPolicy(agentCtx *agent.GenericContext, conf *config.Configuration
 		}
 	}
 
+	// the admin endpoint to pause quota reconciles is served behind the authentication of the agent endpoint
+	agentCtx.HandleFunc(reconcilePausePath, policyImplement.ReconcilePauseHandler())
+
 	err = agentCtx.MetaServer.ConfigurationManager.AddConfigWatcher(crd.AdminQoSConfigurationGVR)
 	if err != nil {
 		return false, nil, err
@@ -303,6 +445,201 @@ func (p *DynamicPolicy) ResourceName() string {
 	return string(v1.ResourceCPU)
 }
 
+// getQuotaReconcileConf returns configurations for reconciling quota of pods under advisor cgroup paths,
+// and the default one is returned if it's not set.
+func (p *DynamicPolicy) getQuotaReconcileConf() *quotareconcile.QuotaReconcileConfiguration {
+	if p.quotaReconcileConf == nil {
+		return quotareconcile.NewQuotaReconcileConfiguration()
+	}
+	return p.quotaReconcileConf
+}
+
+// getCgroupWriteSerializer returns the serializer of cgroup writes, and it's created on first use.
+func (p *DynamicPolicy) getCgroupWriteSerializer() *cgroupWriteSerializer {
+	if p.cgroupWriteSerializer == nil {
+		p.cgroupWriteSerializer = newCgroupWriteSerializer()
+	}
+	return p.cgroupWriteSerializer
+}
+
+// getPodQuotaTracker returns the tracker of quotas applied to pod cgroups, and it's created on first use.
+func (p *DynamicPolicy) getPodQuotaTracker() *podQuotaTracker {
+	if p.podQuotaTracker == nil {
+		p.podQuotaTracker = newPodQuotaTracker()
+	}
+	return p.podQuotaTracker
+}
+
+// getPodQuotaConvergenceTracker returns the tracker of desired quotas of pod cgroups, and it's created on first use.
+func (p *DynamicPolicy) getPodQuotaConvergenceTracker() *podQuotaConvergenceTracker {
+	if p.podQuotaConvergenceTracker == nil {
+		p.podQuotaConvergenceTracker = newPodQuotaConvergenceTracker()
+	}
+	return p.podQuotaConvergenceTracker
+}
+
+// getPodFailureBackoff returns the backoff of pods that fail to be applied, and it's created on first use.
+func (p *DynamicPolicy) getPodFailureBackoff() *podFailureBackoff {
+	if p.podFailureBackoff == nil {
+		p.podFailureBackoff = newPodFailureBackoff()
+	}
+	return p.podFailureBackoff
+}
+
+// getPodUsageHistory returns the usage history of pods, and it's created on first use.
+func (p *DynamicPolicy) getPodUsageHistory() *podUsageHistory {
+	if p.podUsageHistory == nil {
+		p.podUsageHistory = newPodUsageHistory()
+	}
+	return p.podUsageHistory
+}
+
+// getCPUThrottleTracker returns the tracker of cpu.stat samples of pods, and it's created on first use.
+func (p *DynamicPolicy) getCPUThrottleTracker() *cpuThrottleTracker {
+	if p.cpuThrottleTracker == nil {
+		p.cpuThrottleTracker = newCPUThrottleTracker()
+	}
+	return p.cpuThrottleTracker
+}
+
+// getQuotaStarvationTracker returns the tracker of quota-starved pods, and it's created on first use.
+func (p *DynamicPolicy) getQuotaStarvationTracker() *quotaStarvationTracker {
+	if p.quotaStarvationTracker == nil {
+		p.quotaStarvationTracker = newQuotaStarvationTracker()
+	}
+	return p.quotaStarvationTracker
+}
+
+// getAllocatedPodResourcesTracker returns the tracker of resources of pods allocated by kubelet,
+// and it's created on first use.
+func (p *DynamicPolicy) getAllocatedPodResourcesTracker() *allocatedPodResourcesTracker {
+	if p.allocatedPodResourcesTracker == nil {
+		p.allocatedPodResourcesTracker = newAllocatedPodResourcesTracker()
+	}
+	return p.allocatedPodResourcesTracker
+}
+
+// getContainerPathCache returns the cache of relative cgroup paths of containers, and it's created on first use.
+func (p *DynamicPolicy) getContainerPathCache() *containerPathCache {
+	if p.containerPathCache == nil {
+		p.containerPathCache = newContainerPathCache()
+	}
+	return p.containerPathCache
+}
+
+// getClock returns the clock measuring the staleness of plans of cpu-advisor.
+func (p *DynamicPolicy) getClock() clock.Clock {
+	if p.clock == nil {
+		return clock.RealClock{}
+	}
+	return p.clock
+}
+
+// getReconcileCache returns the cache of last-applied calculation infos, and it's created on first use.
+func (p *DynamicPolicy) getReconcileCache() *reconcileCache {
+	if p.reconcileCache == nil {
+		p.reconcileCache = newReconcileCache()
+	}
+	return p.reconcileCache
+}
+
+// GetTrackedPodQuotas returns the last-applied state of all pod cgroups tracked in quota reconcile,
+// the returned data is a copy and it's safe to be used by callers like admin endpoints.
+func (p *DynamicPolicy) GetTrackedPodQuotas() []TrackedPodQuota {
+	p.RLock()
+	defer p.RUnlock()
+
+	// the tracker is created lazily in quota reconcile under the write lock
+	if p.podQuotaTracker == nil {
+		return []TrackedPodQuota{}
+	}
+	return p.podQuotaTracker.list()
+}
+
+// getTracer returns the tracer of quota reconcile, the global tracer provider is a no-op one
+// unless it's registered otherwise, so spans cost nothing by default.
+func (p *DynamicPolicy) getTracer() trace.Tracer {
+	if p.tracer == nil {
+		p.tracer = otel.Tracer(quotaReconcileTracerName)
+	}
+	return p.tracer
+}
+
+// refreshQuotaReconcileConf picks up the latest quota reconcile configuration from dynamic config,
+// so that changes take effect in the next reconcile round without restarting; the new configuration
+// is validated before being swapped in, and the last valid one is kept if it's invalid. The dynamic config
+// manager rebuilds the configuration on every update, so it's compared by value to tell actual changes.
+func (p *DynamicPolicy) refreshQuotaReconcileConf() {
+	if p.dynamicConfig == nil {
+		return
+	}
+
+	conf := p.dynamicConfig.GetDynamicConfiguration().QuotaReconcileConfiguration
+	if conf == nil || conf == p.quotaReconcileConf ||
+		(p.quotaReconcileConf != nil && reflect.DeepEqual(*conf, *p.quotaReconcileConf)) {
+		return
+	}
+
+	if err := conf.Validate(); err != nil {
+		general.Errorf("invalid quota reconcile configuration %+v: %v, keep using %+v", *conf, err, *p.getQuotaReconcileConf())
+		return
+	}
+
+	general.Infof("quota reconcile configuration is changed to %+v", *conf)
+	p.quotaReconcileConf = conf
+}
+
+// refreshQuotaDecisionLogger creates, recreates or closes the decision logger according to the latest
+// quota reconcile configuration, and the logger is left nil if it's disabled so that it costs nothing.
+func (p *DynamicPolicy) refreshQuotaDecisionLogger() {
+	conf := p.getQuotaReconcileConf()
+	if p.quotaDecisionLogger != nil &&
+		p.quotaDecisionLogger.matches(conf.DecisionLogFile, conf.DecisionLogMaxSizeMB, conf.DecisionLogMaxBackups) {
+		return
+	}
+
+	p.closeQuotaDecisionLogger()
+	if conf.DecisionLogFile != "" {
+		general.Infof("quota decisions are logged to %s", conf.DecisionLogFile)
+		p.quotaDecisionLogger = newQuotaDecisionLogger(conf.DecisionLogFile, conf.DecisionLogMaxSizeMB, conf.DecisionLogMaxBackups)
+	}
+}
+
+func (p *DynamicPolicy) closeQuotaDecisionLogger() {
+	if p.quotaDecisionLogger == nil {
+		return
+	}
+
+	if err := p.quotaDecisionLogger.close(); err != nil {
+		general.Warningf("close quota decision logger failed with error: %v", err)
+	}
+	p.quotaDecisionLogger = nil
+}
+
+// refreshQuotaWebhookSink creates, recreates or stops the webhook sink according to the latest
+// quota reconcile configuration, and the sink is left nil if it's disabled.
+func (p *DynamicPolicy) refreshQuotaWebhookSink() {
+	conf := p.getQuotaReconcileConf()
+	if p.quotaWebhookSink != nil && p.quotaWebhookSink.matches(conf.WebhookURL, conf.WebhookTimeout, conf.WebhookMaxRetries) {
+		return
+	}
+
+	p.stopQuotaWebhookSink()
+	if conf.WebhookURL != "" {
+		general.Infof("quota changes are posted to webhook %s", conf.WebhookURL)
+		p.quotaWebhookSink = newQuotaWebhookSink(conf.WebhookURL, conf.WebhookTimeout, conf.WebhookMaxRetries)
+	}
+}
+
+func (p *DynamicPolicy) stopQuotaWebhookSink() {
+	if p.quotaWebhookSink == nil {
+		return
+	}
+
+	p.quotaWebhookSink.stop()
+	p.quotaWebhookSink = nil
+}
+
 func (p *DynamicPolicy) Start() (err error) {
 	general.Infof("called")
 
@@ -370,6 +707,15 @@ func (p *DynamicPolicy) Start() (err error) {
 	go wait.Until(func() {
 		periodicalhandler.ReadyToStartHandlersByGroup(qrm.QRMCPUPluginPeriodicalHandlerGroupName)
 	}, 5*time.Second, p.stopCh)
+	go wait.Until(p.samplePodUsages, podUsageSamplePeriod, p.stopCh)
+
+	if p.staticPlanFile != "" {
+		general.Infof("start dynamic policy cpu plugin with static plan file %s instead of sys-advisor", p.staticPlanFile)
+		go wait.Until(p.applyStaticPlanFile, staticPlanFileApplyPeriod, p.stopCh)
+		go wait.Until(p.reconcileResizedPods, resizedPodReconcilePeriod, p.stopCh)
+		p.startQoSLevelReconcilers(p.stopCh)
+		return nil
+	}
 
 	// pre-check necessary dirs if sys-advisor is enabled
 	if !p.enableCPUAdvisor {
@@ -382,6 +728,10 @@ func (p *DynamicPolicy) Start() (err error) {
 
 	general.Infof("start dynamic policy cpu plugin with sys-advisor")
 	general.RegisterHeartbeatCheck(cpuconsts.CommunicateWithAdvisor, 2*time.Minute, general.HealthzCheckStateNotReady, 2*time.Minute)
+	go wait.Until(p.checkAdvisorPlanStaleness, advisorPlanStalenessCheckPeriod, p.stopCh)
+	go wait.Until(p.applyAnnotationQuotaFallback, annotationQuotaFallbackPeriod, p.stopCh)
+	go wait.Until(p.reconcileResizedPods, resizedPodReconcilePeriod, p.stopCh)
+	p.startQoSLevelReconcilers(p.stopCh)
 
 	err = p.initAdvisorClientConn()
 	if err != nil {
@@ -471,6 +821,8 @@ func (p *DynamicPolicy) Stop() error {
 	}
 
 	periodicalhandler.StopHandlersByGroup(qrm.QRMCPUPluginPeriodicalHandlerGroupName)
+	p.closeQuotaDecisionLogger()
+	p.stopQuotaWebhookSink()
 
 	if p.advisorConn != nil {
 		return p.advisorConn.Close()
@@ -928,6 +1280,10 @@ func (p *DynamicPolicy) Allocate(ctx context.Context,
 		if err := p.state.StoreState(); err != nil {
 			general.ErrorS(err, "store state failed", "podName", req.PodName, "containerName", req.ContainerName)
 		}
+		// quota of the pod resized in place is reconciled with its new resources without waiting for the next plan
+		if respErr == nil && util.PodInplaceUpdateResizing(req) {
+			p.markPodResized(req.PodUid)
+		}
 
 		p.Unlock()
 		if respErr != nil {
@@ -0,0 +1,36 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"errors"
+)
+
+// errors returned by the advisor pipeline, callers can branch on the failure kind with errors.Is
+var (
+	ErrPathResolve = errors.New("failed to resolve cgroup path")
+	ErrCgroupRead  = errors.New("failed to read cgroup")
+	ErrCgroupWrite = errors.New("failed to write cgroup")
+	ErrPodNotFound = errors.New("pod not found")
+	ErrPathEscape  = errors.New("path escapes the cgroup root")
+	// ErrPodHasNoContainers is returned when a pod found under the cgroup path has no containers to apply quota to
+	ErrPodHasNoContainers = errors.New("pod has no containers")
+	// ErrAmbiguousCgroupPath is returned when a cgroup path of cpu-advisor can't be told whether relative or absolute
+	ErrAmbiguousCgroupPath = errors.New("ambiguous cgroup path")
+	// ErrMemoryLimitBelowUsage is returned when a memory limit below the current rss is rejected to avoid instant OOM
+	ErrMemoryLimitBelowUsage = errors.New("memory limit is below usage")
+)
@@ -19,22 +19,35 @@ package dynamicpolicy
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"io/fs"
+	"math"
 	"net"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/opencontainers/runc/libcontainer/cgroups/fscommon"
 	"github.com/samber/lo"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/status"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/wait"
+	utilfeature "k8s.io/apiserver/pkg/util/feature"
 	pluginapi "k8s.io/kubelet/pkg/apis/resourceplugin/v1alpha1"
-	"k8s.io/kubernetes/pkg/api/v1/resource"
+	v1qos "k8s.io/kubernetes/pkg/apis/core/v1/helper/qos"
 	maputil "k8s.io/kubernetes/pkg/util/maps"
 
 	"github.com/kubewharf/katalyst-api/pkg/consts"
@@ -48,9 +61,11 @@ import (
 	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/util"
 	"github.com/kubewharf/katalyst-core/pkg/agent/utilcomponent/featuregatenegotiation"
 	"github.com/kubewharf/katalyst-core/pkg/agent/utilcomponent/featuregatenegotiation/finders"
+	"github.com/kubewharf/katalyst-core/pkg/config/agent/dynamic/quotareconcile"
+	coreconsts "github.com/kubewharf/katalyst-core/pkg/consts"
+	"github.com/kubewharf/katalyst-core/pkg/features"
 	"github.com/kubewharf/katalyst-core/pkg/metrics"
 	"github.com/kubewharf/katalyst-core/pkg/util/cgroup/common"
-	cgroupmgr "github.com/kubewharf/katalyst-core/pkg/util/cgroup/manager"
 	"github.com/kubewharf/katalyst-core/pkg/util/general"
 	"github.com/kubewharf/katalyst-core/pkg/util/machine"
 	"github.com/kubewharf/katalyst-core/pkg/util/metric"
@@ -64,6 +79,49 @@ const (
 	cpuAdvisorHealthyThreshold      = 2 * time.Minute
 	cpuAdvisorHealthyCount          = 2
 	cpuAdvisorHealthMonitorInterval = 30 * time.Second
+
+	quotaReconcileTracerName = "katalyst-core/qrm-cpu-plugin/quota-reconcile"
+
+	// maxPodDirSearchDepth is the hard limit of directory levels searched for pod cgroup directories
+	maxPodDirSearchDepth = 5
+
+	defaultAdvisorPayloadLogMaxBytes = 4096
+	advisorPayloadRedactedValue      = "<redacted>"
+
+	// cpuQuotaTickUs is the granularity (in microseconds) to which quota is rounded by the rounding policy
+	cpuQuotaTickUs = 1000
+
+	// limitRangeFetchTimeout bounds fetching limit ranges on cache misses, since it's done under the policy lock
+	limitRangeFetchTimeout = 2 * time.Second
+)
+
+// reasons why pods are skipped in quota reconcile, used as the tag of MetricNameQuotaReconcileSkippedPods
+const (
+	podSkipReasonNotFound      = "not_found"
+	podSkipReasonNoContainers  = "no_containers"
+	podSkipReasonGracePeriod   = "grace_period"
+	podSkipReasonNoCPULimit    = "no_cpu_limit"
+	podSkipReasonPathEscape    = "path_escape"
+	podSkipReasonLabelMismatch = "label_mismatch"
+	podSkipReasonOptedOut      = "opted_out"
+	podSkipReasonTerminating   = "terminating"
+	podSkipReasonBackoff       = "backoff"
+	podSkipReasonKubeletStatic = "kubelet_static_cpu"
+)
+
+// podQoSLevelUnknown is the qos level tag of metrics for pods whose qos level can't be told
+const podQoSLevelUnknown = "unknown"
+
+// outcomes of quota applies, used as the tag of MetricNameQuotaApplyOutcome
+const (
+	// quotaApplyOutcomeSkippedIdempotent means the current quota is already the desired one, so nothing is written
+	quotaApplyOutcomeSkippedIdempotent = "skipped_idempotent"
+	quotaApplyOutcomeAppliedChanged    = "applied_changed"
+	quotaApplyOutcomeFailed            = "failed"
+	// quotaApplyOutcomeClamped means the desired quota is clamped by the floor or the ramp step before being applied
+	quotaApplyOutcomeClamped = "clamped"
+	// quotaApplyOutcomeSkippedPathMissing means the cgroup path of the container doesn't exist yet, e.g. during its startup
+	quotaApplyOutcomeSkippedPathMissing = "skipped_path_missing"
 )
 
 /* in the below, cpu-plugin works in server-mode, while cpu-advisor works in client-mode */
@@ -459,21 +517,43 @@ func (p *DynamicPolicy) lwCPUAdvisorServer(stopCh <-chan struct{}) error {
 }
 
 // allocateByCPUAdvisor perform allocate actions based on allocation response from cpu-advisor.
-// supportedFeatureGates means the feature gates than both qrm wanted and sysadvisor supported
+// supportedFeatureGates means the feature gates than both qrm wanted and sysadvisor supported.
+// If a plan is being applied, the response is coalesced and applied after the in-progress one.
 func (p *DynamicPolicy) allocateByCPUAdvisor(
 	req *advisorapi.GetAdviceRequest,
 	resp *advisorapi.ListAndWatchResponse,
 	featureGates map[string]*advisorsvc.FeatureGate,
-) (err error) {
+) error {
 	if resp == nil {
 		return fmt.Errorf("allocateByCPUAdvisor got nil qos aware lw response")
 	}
 
+	superseded, err := p.advisorPlanRunner.run(&cpuAdvisorPlan{
+		req:          req,
+		resp:         resp,
+		featureGates: featureGates,
+	}, p.applyCPUAdvisorPlan)
+	if superseded {
+		general.Infof("a pending cpu advisor plan is superseded by the latest one")
+		_ = p.emitter.StoreInt64(util.MetricNameAdvisorPlanCoalesced, 1, metrics.MetricTypeNameCount)
+	}
+	return err
+}
+
+// applyCPUAdvisorPlan applies the plan pushed by cpu-advisor, plans are applied one by one by advisorPlanRunner.
+// The policy mutex is held for the whole plan as the reconcile lock, which is shared by all sources triggering
+// reconciles, i.e. ListAndWatch and GetAdvice of cpu-advisor and UndoLastCPUQuotaReconcile, so that they never overlap.
+func (p *DynamicPolicy) applyCPUAdvisorPlan(plan *cpuAdvisorPlan) (err error) {
+	req, resp := plan.req, plan.resp
+
 	startTime := time.Now()
 	general.Infof("allocateByCPUAdvisor is called")
 	_ = p.emitter.StoreInt64(util.MetricNameHandleAdvisorRespCalled, 1, metrics.MetricTypeNameRaw)
 	p.Lock()
 	defer func() {
+		if err == nil {
+			p.lastAdvisorPlanTime = p.getClock().Now()
+		}
 		p.Unlock()
 		if err != nil {
 			_ = p.emitter.StoreInt64(util.MetricNameHandleAdvisorRespFailed, 1, metrics.MetricTypeNameRaw)
@@ -523,290 +603,2719 @@ func (p *DynamicPolicy) allocateByCPUAdvisor(
 	return nil
 }
 
-func (p *DynamicPolicy) applyCgroupConfigs(resp *advisorapi.ListAndWatchResponse) error {
-	for _, calculationInfo := range resp.ExtraEntries {
-		if !general.IsPathExists(common.GetAbsCgroupPath(common.DefaultSelectedSubsys, calculationInfo.CgroupPath)) {
-			general.Infof("cgroup path not exist, skip applyCgroupConfigs: %s", common.GetAbsCgroupPath(common.DefaultSelectedSubsys, calculationInfo.CgroupPath))
-			continue
+// checkAdvisorPlanStaleness emits the age of the last applied plan of cpu-advisor, and logs a warning if it exceeds
+// the threshold, since cgroups are still reconciled with the stale plan after cpu-advisor stops pushing.
+func (p *DynamicPolicy) checkAdvisorPlanStaleness() {
+	p.RLock()
+	lastAdvisorPlanTime := p.lastAdvisorPlanTime
+	threshold := p.getQuotaReconcileConf().AdvisorPlanStalenessThreshold
+	p.RUnlock()
+
+	if lastAdvisorPlanTime.IsZero() {
+		general.InfofV(4, "no plan of cpu advisor is applied yet")
+		return
+	}
+
+	staleness := p.getClock().Since(lastAdvisorPlanTime)
+	_ = p.emitter.StoreFloat64(util.MetricNameAdvisorPlanStaleness, staleness.Seconds(), metrics.MetricTypeNameRaw)
+	if threshold > 0 && staleness > threshold {
+		general.Warningf("the last plan of cpu advisor is applied %v ago, which exceeds the staleness threshold %v",
+			staleness, threshold)
+	}
+}
+
+func (p *DynamicPolicy) applyCgroupConfigs(resp *advisorapi.ListAndWatchResponse) (err error) {
+	p.refreshQuotaReconcileConf()
+	p.refreshQuotaDecisionLogger()
+	p.refreshQuotaWebhookSink()
+	p.refreshReconcileMetricsExport()
+
+	if !p.isNodeReadyForReconcile(context.Background()) {
+		general.Infof("node is not ready for reconcile yet, skip applying cgroup configs")
+		return nil
+	}
+	if p.isReconcilePausedForMaintenance(context.Background()) {
+		return nil
+	}
+
+	p.beginReconcileTransaction()
+	defer p.endReconcileTransaction()
+	p.beginReconcileStatus()
+	defer func() { p.reportReconcileStatus(err) }()
+	defer p.publishAppliedQuotas()
+
+	fullAuditRoundInterval := uint64(p.getQuotaReconcileConf().FullAuditRoundInterval)
+	p.fullAuditRound = fullAuditRoundInterval > 0 && p.reconcileRounds%fullAuditRoundInterval == 0
+	p.reconcileRounds++
+	if p.fullAuditRound {
+		general.Infof("round %d is a full audit one, all pods are read back and re-applied", p.reconcileRounds)
+	}
+
+	// cgroup writes are short-circuited in this round once the breaker is open, and they will be retried in the next round
+	p.cgroupWriteBreaker = newCgroupWriteBreaker(p.getQuotaReconcileConf().CgroupWriteFailureThreshold)
+	p.cgroupWriteCap = newCgroupWriteCap(p.getQuotaReconcileConf().MaxCgroupWritesPerRound)
+	p.cpuCgroupWrites = 0
+	defer p.emitCPUCgroupWrites()
+	p.startReconcileBudget()
+
+	// cgroup paths are normalized into the relative form first, so that they are resolved in the same way,
+	// and then calculation infos of multiple advisor sources for the same cgroup path are merged into one
+	normalizedInfos := p.mergeCalculationInfosBySource(p.normalizeCalculationInfos(resp.ExtraEntries))
+	p.cgroupConfigsTargets = newCgroupConfigsTargets(normalizedInfos)
+	// the round resumes from the cgroup path where the last one is cut off by the budget
+	calculationInfos := rotateCalculationInfos(normalizedInfos, p.reconcileBudget.resumeCgroupPath)
+	p.reconcileBudget.resumeCgroupPath = ""
+	for _, calculationInfo := range calculationInfos {
+		if err := p.checkCgroupWritesAllowed(); err != nil {
+			general.Warningf("%v, skip applying the remaining cgroup configs", err)
+			break
+		}
+		if p.isReconcileBudgetExceeded() {
+			// the cgroup path whose pods are cut off by the budget is resumed first if any
+			if p.reconcileBudget.resumeCgroupPath == "" {
+				p.reconcileBudget.resumeCgroupPath = calculationInfo.CgroupPath
+			}
+			general.Warningf("reconcile budget is exceeded, the remaining cgroup configs are applied from %s in the next round",
+				p.reconcileBudget.resumeCgroupPath)
+			break
 		}
 
-		cgConf, ok := calculationInfo.CalculationResult.Values[string(advisorapi.ControlKnobKeyCgroupConfig)]
-		if !ok {
+		if !general.IsPathExists(p.getAbsCgroupPath(common.DefaultSelectedSubsys, calculationInfo.CgroupPath)) {
+			general.Infof("cgroup path not exist, skip applyCgroupConfigs: %s", p.getAbsCgroupPath(common.DefaultSelectedSubsys, calculationInfo.CgroupPath))
 			continue
 		}
 
-		resources := &common.CgroupResources{}
-		err := json.Unmarshal([]byte(cgConf), resources)
-		if err != nil {
-			return fmt.Errorf("unmarshal %s: %s failed with error: %v",
-				advisorapi.ControlKnobKeyCgroupConfig, cgConf, err)
-		}
+		p.logAdvisorPayload(calculationInfo)
 
-		resources.SkipDevices = true
-		resources.SkipFreezeOnSet = true
+		if entry := p.getReconcileCacheEntry(calculationInfo); !p.fullAuditRound && entry != nil &&
+			p.getReconcileCache().matches(calculationInfo.CgroupPath, entry) {
+			general.InfofV(4, "calculation info of %s is unchanged and no drift is detected, skip reconciling it", calculationInfo.CgroupPath)
+			continue
+		}
 
-		err = p.checkAndApplyIfCgroupV1(calculationInfo, resources)
-		if err != nil {
-			_ = p.emitter.StoreInt64(util.MetricNameCheckApplyV1Error, 1, metrics.MetricTypeNameCount)
-			return fmt.Errorf("checkAndApplyIfCgroupV1 failed with error: %v", err)
+		// all knobs of the calculation info are applied as a transaction, so that a failure won't leave it half applied
+		p.beginKnobTransaction()
+		applied, err := p.applyCalculationInfoKnobs(calculationInfo)
+		if err = p.endKnobTransaction(calculationInfo.CgroupPath, err); err != nil {
+			return err
+		} else if !applied {
+			continue
 		}
 
-		err = common.ApplyCgroupConfigs(calculationInfo.CgroupPath, resources)
-		if err != nil {
-			return fmt.Errorf("ApplyCgroupConfigs failed: %s, %v", calculationInfo.CgroupPath, err)
+		// the threaded domain root may be changed by others, so redirected calculation infos are always reconciled
+		if entry := p.getReconcileCacheEntry(calculationInfo); entry != nil &&
+			!p.cgroupConfigsTargets.isRedirected(calculationInfo.CgroupPath) {
+			p.getReconcileCache().record(calculationInfo.CgroupPath, entry)
 		}
 	}
 
+	p.lastCgroupConfigs = normalizedInfos
+	p.checkQuotaOvercommit(resp)
+	p.auditOrphanedQuotas(context.Background())
 	return nil
 }
 
-func (p *DynamicPolicy) checkAndApplyIfCgroupV1(calculationInfo *advisorsvc.CalculationInfo, resources *common.CgroupResources) error {
-	if common.CheckCgroup2UnifiedMode() {
-		return nil
+// applyCalculationInfoKnobs applies all knobs carried by the calculation info to its cgroup path,
+// and false is returned if it carries neither cgroup configs nor cpu cores.
+func (p *DynamicPolicy) applyCalculationInfoKnobs(calculationInfo *advisorsvc.CalculationInfo) (bool, error) {
+	err := p.applyCPUUclamp(calculationInfo)
+	if err != nil {
+		return false, fmt.Errorf("applyCPUUclamp failed: %s, %v", calculationInfo.CgroupPath, err)
 	}
 
-	currentParentCgroupCPUStats, err := cgroupmgr.GetCPUWithRelativePath(calculationInfo.CgroupPath)
+	err = p.applyPidsMax(calculationInfo)
 	if err != nil {
-		return fmt.Errorf("Get big group quota failed with error: %v", err)
+		return false, fmt.Errorf("applyPidsMax failed: %s, %w", calculationInfo.CgroupPath, err)
 	}
 
-	// scale down the be group quota
-	if currentParentCgroupCPUStats.CpuQuota < 0 || resources.CpuQuota <= currentParentCgroupCPUStats.CpuQuota {
-		err := p.checkAndApplyAllPodsQuota(calculationInfo, resources.CpuQuota)
+	err = p.applyCPUSetMems(calculationInfo)
+	if err != nil {
+		return false, fmt.Errorf("applyCPUSetMems failed: %s, %w", calculationInfo.CgroupPath, err)
+	}
+
+	err = p.applySwapMax(calculationInfo)
+	if err != nil {
+		return false, fmt.Errorf("applySwapMax failed: %s, %w", calculationInfo.CgroupPath, err)
+	}
+
+	err = p.applyCgroupFreeze(calculationInfo)
+	if err != nil {
+		return false, fmt.Errorf("applyCgroupFreeze failed: %s, %w", calculationInfo.CgroupPath, err)
+	}
+
+	err = p.applyOOMScoreAdj(calculationInfo)
+	if err != nil {
+		return false, fmt.Errorf("applyOOMScoreAdj failed: %s, %w", calculationInfo.CgroupPath, err)
+	}
+
+	err = p.applyContainerMemoryLimits(context.Background(), calculationInfo)
+	if err != nil {
+		return false, fmt.Errorf("applyContainerMemoryLimits failed: %s, %w", calculationInfo.CgroupPath, err)
+	}
+
+	resources, ok, err := p.getCalculationInfoCgroupResources(calculationInfo)
+	if err != nil {
+		return false, err
+	} else if !ok {
+		return false, nil
+	}
+
+	if p.isPoolCgroupPath(calculationInfo.CgroupPath) {
+		err = p.applyPoolQuota(calculationInfo.CgroupPath, resources)
 		if err != nil {
-			return fmt.Errorf("checkAndApplyAllPodsQuota failed with error: %v", err)
+			return false, fmt.Errorf("applyPoolQuota failed: %s, %w", calculationInfo.CgroupPath, err)
 		}
-	} else {
-		minBGQuota := currentParentCgroupCPUStats.CpuQuota
-		if resources.CpuQuota < minBGQuota {
-			minBGQuota = resources.CpuQuota
+	} else if isKubepodsRootCgroupPath(calculationInfo.CgroupPath) {
+		err = p.applyKubepodsRootQuota(calculationInfo.CgroupPath, resources)
+		if err != nil {
+			return false, fmt.Errorf("applyKubepodsRootQuota failed: %s, %w", calculationInfo.CgroupPath, err)
 		}
-		err := p.checkAndApplyAllPodsQuota(calculationInfo, minBGQuota)
+	} else {
+		var result *ReconcileResult
+		result, err = p.checkAndApplyIfCgroupV1(calculationInfo, resources)
+		p.recordReconcileStatus(result)
 		if err != nil {
-			return fmt.Errorf("checkAndApplyAllPodsQuota failed with error: %v", err)
+			_ = p.emitter.StoreInt64(util.MetricNameCheckApplyV1Error, 1, metrics.MetricTypeNameCount)
+			return false, fmt.Errorf("checkAndApplyIfCgroupV1 failed with error: %w", err)
 		}
 	}
-	return nil
-}
 
-func (p *DynamicPolicy) checkAndApplyAllPodsQuota(calculationInfo *advisorsvc.CalculationInfo, bigGroupQuota int64) error {
-	podsPathMap, podDirs, err := p.getCurrentPathAllPodsDirAndMap(calculationInfo.CgroupPath)
+	cgroupConfigsPath, err := p.getCgroupConfigsPath(calculationInfo.CgroupPath)
 	if err != nil {
-		return err
+		return false, fmt.Errorf("getCgroupConfigsPath failed: %s, %w", calculationInfo.CgroupPath, err)
+	}
+	if owner, ok := p.cgroupConfigsTargets.claim(calculationInfo.CgroupPath, cgroupConfigsPath); !ok {
+		general.Warningf("skip cgroup configs of threaded cgroup %s, since its threaded domain root %s is already "+
+			"applied with those of %s in this round", calculationInfo.CgroupPath, cgroupConfigsPath, owner)
+		return false, nil
 	}
 
-	for _, podDir := range podDirs {
-		pod, podRelativePath, err := p.getPodAndRelativePath(calculationInfo.CgroupPath, podDir, podsPathMap)
-		if err != nil {
-			general.Warningf("getPodAndRelativePath error for pod dir %s: %v", podDir, err)
-			continue
-		}
+	err = p.captureKnob(knobCPU, cgroupConfigsPath, p.capturePriorCPU)
+	if err != nil {
+		return false, err
+	}
+	p.captureCPUStats(cgroupConfigsPath)
+	err = p.applyCgroupResources(cgroupConfigsPath, resources)
+	if err != nil {
+		return false, fmt.Errorf("ApplyCgroupConfigs failed: %s, %v", cgroupConfigsPath, err)
+	}
 
-		_, limit := resource.PodRequestsAndLimits(pod)
-		if _, ok := limit[v1.ResourceCPU]; !ok {
-			general.Warningf("no cpu limit for pod %s: %v", pod.Name, err)
-			continue
-		}
+	err = p.checkAndApplyCPUBurst(cgroupConfigsPath, resources.CpuBurst)
+	if err != nil {
+		return false, fmt.Errorf("checkAndApplyCPUBurst failed: %s, %v", cgroupConfigsPath, err)
+	}
+	return true, nil
+}
+
+// getCalculationInfoCgroupResources derives the cgroup resources to apply from the calculation info,
+// and false is returned if the calculation info carries neither cgroup configs nor cpu cores.
+func (p *DynamicPolicy) getCalculationInfoCgroupResources(calculationInfo *advisorsvc.CalculationInfo) (*common.CgroupResources, bool, error) {
+	cgConf, ok := calculationInfo.CalculationResult.Values[string(advisorapi.ControlKnobKeyCgroupConfig)]
+	cores, coresOk := calculationInfo.CalculationResult.Values[string(advisorapi.ControlKnobKeyCPUCores)]
+	if !ok && !coresOk {
+		return nil, false, nil
+	}
 
-		podLimit := limit.Cpu().MilliValue() // Value() will lose precision of data
-		podCpu, err := cgroupmgr.GetCPUWithRelativePath(podRelativePath)
+	resources := &common.CgroupResources{}
+	if ok {
+		err := json.Unmarshal([]byte(cgConf), resources)
 		if err != nil {
-			return fmt.Errorf("GetCPUWithRelativePath %s failed with error: %v", podRelativePath, err)
+			return nil, false, fmt.Errorf("unmarshal %s: %s failed with error: %v",
+				advisorapi.ControlKnobKeyCgroupConfig, cgConf, err)
 		}
+	}
 
-		podRealQuota := podLimit * int64(podCpu.CpuPeriod) / 1000
-		podCurrentQuota := podCpu.CpuQuota
-
-		if podRealQuota <= bigGroupQuota {
-			if podRealQuota == podCurrentQuota {
-				continue
-			}
+	resources.SkipDevices = true
+	resources.SkipFreezeOnSet = true
 
-			err = p.applyAllContainersQuota(pod, true)
-			if err != nil {
-				general.Errorf("applyAllContainersQuota for pod %v failed with error: %v", pod.Name, err)
-				continue
-			}
+	err := p.checkCPUPeriodChange(calculationInfo.CgroupPath, resources)
+	if err != nil {
+		return nil, false, fmt.Errorf("checkCPUPeriodChange failed: %s, %w", calculationInfo.CgroupPath, err)
+	}
 
-			err := cgroupmgr.ApplyCPUWithRelativePath(podRelativePath, &common.CPUData{CpuQuota: podRealQuota})
-			if err != nil {
-				return fmt.Errorf("ApplyCPUWithRelativePath %s to realQuota %v  failed with error: %v", podRelativePath, podRealQuota, err)
-			}
-		} else {
-			err = p.applyAllContainersQuota(pod, false)
-			if err != nil {
-				general.Errorf("applyAllContainersQuota for pod %v failed with error: %v", pod.Name, err)
-				continue
-			}
-			err := cgroupmgr.ApplyCPUWithRelativePath(podRelativePath, &common.CPUData{CpuQuota: -1})
-			if err != nil {
-				return fmt.Errorf("ApplyCPUWithRelativePath %s to -1 failed with error: %v", podRelativePath, err)
-			}
+	if coresOk {
+		err = p.convertCPUCoresToQuota(calculationInfo.CgroupPath, cores, resources)
+		if err != nil {
+			return nil, false, fmt.Errorf("convertCPUCoresToQuota failed: %s, %w", calculationInfo.CgroupPath, err)
 		}
 	}
-	return nil
-}
 
-func (p *DynamicPolicy) getAllDirs(parentPath string) ([]string, error) {
-	entries, err := os.ReadDir(parentPath)
+	err = p.capUnlimitedQuota(calculationInfo.CgroupPath, resources)
 	if err != nil {
-		return nil, err
+		return nil, false, fmt.Errorf("capUnlimitedQuota failed: %s, %w", calculationInfo.CgroupPath, err)
 	}
+	return resources, true, nil
+}
 
-	dirs := make([]string, 0)
+// checkQuotaOvercommit emits the ratio of quotas summed over cgroup configs of cpu-advisor to node allocatable cpu,
+// and logs a warning if it exceeds the threshold. Unlimited quota and cgroup configs whose quota can't be told in
+// cores are left out of the sum.
+func (p *DynamicPolicy) checkQuotaOvercommit(resp *advisorapi.ListAndWatchResponse) {
+	if p.metaServer == nil || p.metaServer.NodeFetcher == nil {
+		return
+	}
 
-	for _, entry := range entries {
-		if entry.IsDir() {
-			dirs = append(dirs, entry.Name())
+	node, err := p.metaServer.GetNode(context.Background())
+	if err != nil {
+		general.Warningf("get node for quota overcommit check failed with error: %v", err)
+		return
+	}
+	allocatableMilliCores := node.Status.Allocatable.Cpu().MilliValue()
+	if allocatableMilliCores <= 0 {
+		return
+	}
+
+	var quotaMilliCores int64
+	for _, calculationInfo := range resp.ExtraEntries {
+		if calculationInfo == nil || calculationInfo.CalculationResult == nil {
+			continue
 		}
+		quotaMilliCores += p.getCgroupConfigQuotaMilliCores(calculationInfo)
 	}
 
-	return dirs, nil
+	ratio := float64(quotaMilliCores) / float64(allocatableMilliCores)
+	_ = p.emitter.StoreFloat64(util.MetricNameAdvisorQuotaAllocatableRatio, ratio, metrics.MetricTypeNameRaw)
+	if threshold := p.getQuotaReconcileConf().QuotaOvercommitWarningRatio; threshold > 0 && ratio > threshold {
+		general.Warningf("quotas of cpu advisor sum up to %d milli-cores, which is %.2f of node allocatable %d milli-cores "+
+			"and exceeds the threshold %.2f", quotaMilliCores, ratio, allocatableMilliCores, threshold)
+	}
 }
 
-func (p *DynamicPolicy) getAllPodsPathMap() (map[string]*v1.Pod, error) {
-	pods, err := p.metaServer.GetPodList(context.Background(), native.PodIsActive)
-	if err != nil {
-		return nil, fmt.Errorf("GetPodList failed with error: %v", err)
+// getCgroupConfigQuotaMilliCores returns the quota (in milli-cores) given by the calculation info, which is told by
+// cpu cores or by the quota in cgroup config along with its period, and zero means unlimited or unknown.
+func (p *DynamicPolicy) getCgroupConfigQuotaMilliCores(calculationInfo *advisorsvc.CalculationInfo) int64 {
+	values := calculationInfo.CalculationResult.Values
+	if value, ok := values[string(advisorapi.ControlKnobKeyCPUCores)]; ok {
+		if cores, err := strconv.ParseFloat(value, 64); err == nil && cores > 0 {
+			return int64(math.Round(cores * 1000))
+		}
+		return 0
 	}
 
-	podAbsPathMap := make(map[string]*v1.Pod)
+	cgConf, ok := values[string(advisorapi.ControlKnobKeyCgroupConfig)]
+	if !ok {
+		return 0
+	}
+	resources := &common.CgroupResources{}
+	if err := json.Unmarshal([]byte(cgConf), resources); err != nil || resources.CpuQuota <= 0 {
+		return 0
+	}
 
-	for _, pod := range pods {
-		if pod == nil {
-			continue
+	period := resources.CpuPeriod
+	if period == 0 {
+		cpuStats, err := p.getCPUWithRelativePath(calculationInfo.CgroupPath)
+		if err != nil || cpuStats.CpuPeriod == 0 {
+			return 0
 		}
-		podAbsPath, err := common.GetPodAbsCgroupPath(common.DefaultSelectedSubsys, string(pod.UID))
-		if err != nil {
-			general.Errorf("get pod %s absolute path failed with error: %v", pod.Name, err)
-			continue
+		period = cpuStats.CpuPeriod
+	}
+	return resources.CpuQuota * 1000 / int64(period)
+}
+
+// isPoolCgroupPath returns whether the cgroup path is the one of a shared pool by the configured prefixes.
+func (p *DynamicPolicy) isPoolCgroupPath(cgroupPath string) bool {
+	for _, prefix := range p.getQuotaReconcileConf().PoolCgroupPathPrefixes {
+		if prefix != "" && strings.HasPrefix(cgroupPath, prefix) {
+			return true
 		}
-		podAbsPathMap[podAbsPath] = pod
 	}
+	return false
+}
 
-	return podAbsPathMap, nil
+// applyPoolQuota applies quota of the shared pool to the pool cgroup itself, rather than reconciling it to
+// pods under the pool like other cgroup paths; the quota is then cleared from resources, so that it's not
+// written again along with the rest cgroup configs.
+func (p *DynamicPolicy) applyPoolQuota(cgroupPath string, resources *common.CgroupResources) error {
+	return p.applyOwnQuota("pool", cgroupPath, resources)
 }
 
-func (p *DynamicPolicy) getPodAndRelativePath(currentCgroupPath string, podDir string, podsPathMap map[string]*v1.Pod) (*v1.Pod, string, error) {
-	podRelativePath := filepath.Join(currentCgroupPath, podDir)
-	podAbsPath := common.GetAbsCgroupPath(common.DefaultSelectedSubsys, podRelativePath)
-	pod, ok := podsPathMap[podAbsPath]
-	if !ok || pod == nil {
-		return nil, "", fmt.Errorf("can not get pod with abs path: %s", podAbsPath)
+// isKubepodsRootCgroupPath returns whether the cgroup path is the kubepods root, i.e. the parent of all pods.
+func isKubepodsRootCgroupPath(cgroupPath string) bool {
+	cleanedPath := filepath.Clean("/" + cgroupPath)
+	return cleanedPath == common.CgroupFsRootPath || cleanedPath == common.SystemdRootPath
+}
+
+// applyKubepodsRootQuota applies quota given by advisor to the kubepods root itself, e.g. to reserve cpu for system
+// daemons out of all pods, and it's never reconciled to pods under the root since that would touch every pod on
+// the node; the root is only applied if advisor pushes a cgroup config of it explicitly.
+func (p *DynamicPolicy) applyKubepodsRootQuota(cgroupPath string, resources *common.CgroupResources) error {
+	return p.applyOwnQuota("kubepods root", cgroupPath, resources)
+}
+
+// applyOwnQuota applies quota of resources to the cgroup itself, and clears it from resources,
+// so that it's not written again along with the rest cgroup configs.
+func (p *DynamicPolicy) applyOwnQuota(kind, cgroupPath string, resources *common.CgroupResources) error {
+	if resources.CpuQuota == 0 {
+		return nil
 	}
 
-	if len(pod.Spec.Containers) == 0 {
-		return nil, "", fmt.Errorf("pod %s has no containers", pod.Name)
+	err := p.applyCPUQuotaWithRelativePath(context.Background(), cgroupPath, &common.CPUData{
+		CpuQuota:  resources.CpuQuota,
+		CpuPeriod: resources.CpuPeriod,
+	})
+	if err != nil {
+		return err
 	}
 
-	return pod, podRelativePath, nil
+	general.InfofV(4, "apply quota %d with period %d to %s %s", resources.CpuQuota, resources.CpuPeriod, kind, cgroupPath)
+	resources.CpuQuota = 0
+	resources.CpuPeriod = 0
+	return nil
 }
 
-func (p *DynamicPolicy) getCurrentPathAllPodsDirAndMap(currentCgroupPath string) (map[string]*v1.Pod, []string, error) {
-	podsPathMap, err := p.getAllPodsPathMap()
+// getReconcileCacheEntry returns the current state of the cgroup path to be compared with the cached one, it's a
+// lightweight drift check which only reads cpu stats of the cgroup, its pods and their containers, without touching
+// the pod list; nil is returned if the state can't be read. Drift of the other knobs is left to full audit rounds.
+func (p *DynamicPolicy) getReconcileCacheEntry(calculationInfo *advisorsvc.CalculationInfo) *reconcileCacheEntry {
+	cpuStats, err := p.getCPUWithRelativePath(calculationInfo.CgroupPath)
 	if err != nil {
-		return nil, nil, fmt.Errorf("getAllPodsPathMap failed with error: %v", err)
+		general.InfofV(4, "get cpu stats of %s for reconcile cache failed with error: %v", calculationInfo.CgroupPath, err)
+		return nil
 	}
-	absPath := common.GetAbsCgroupPath(common.DefaultSelectedSubsys, currentCgroupPath)
-	podDirs, err := p.getAllDirs(absPath)
+
+	podDirs, err := p.getAllDirs(p.getAbsCgroupPath(common.DefaultSelectedSubsys, calculationInfo.CgroupPath))
 	if err != nil {
-		return nil, nil, fmt.Errorf("getAllPodsPath failed with error: %v", err)
+		general.InfofV(4, "get pod dirs of %s for reconcile cache failed with error: %v", calculationInfo.CgroupPath, err)
+		return nil
 	}
 
-	return podsPathMap, podDirs, nil
-}
+	podQuotasHash, err := p.hashPodQuotas(calculationInfo.CgroupPath, podDirs)
+	if err != nil {
+		general.InfofV(4, "get pod quotas of %s for reconcile cache failed with error: %v", calculationInfo.CgroupPath, err)
+		return nil
+	}
 
-func (p *DynamicPolicy) getAllContainersRelativePathMap(pod *v1.Pod) map[string]*v1.Container {
-	containerPathMap := make(map[string]*v1.Container)
+	return &reconcileCacheEntry{
+		infoHash:      hashCalculationInfo(calculationInfo),
+		podDirsHash:   hashPodDirs(podDirs),
+		podQuotasHash: podQuotasHash,
+		podUsageEpoch: p.getPodUsageEpoch(),
+		cpuQuota:      cpuStats.CpuQuota,
+		cpuPeriod:     cpuStats.CpuPeriod,
+		confHash:      hashQuotaReconcileConf(p.getQuotaReconcileConf()),
+	}
+}
 
-	for _, container := range pod.Spec.Containers {
-		// Create a copy of the container to avoid the loop variable capture issue
-		containerCopy := container
+// hashPodQuotas hashes quotas and periods of the pods under the cgroup path and their containers in order,
+// so that quota drift of any of them is detected while the calculation info is unchanged.
+func (p *DynamicPolicy) hashPodQuotas(cgroupPath string, podDirs []string) (uint64, error) {
+	sorted := append([]string(nil), podDirs...)
+	sort.Strings(sorted)
 
-		containerID, err := native.GetContainerID(pod, container.Name)
+	h := fnv.New64a()
+	hashCPUStats := func(relativePath string) error {
+		cpuStats, err := p.getCPUWithRelativePath(relativePath)
 		if err != nil {
-			general.Errorf("get container %s container id failed with error: %v", container.Name, err)
-			continue
+			return err
 		}
-		containerRelativeCgroupPath, err := common.GetContainerRelativeCgroupPath(string(pod.UID), containerID)
-		if err != nil {
-			general.Errorf("get container %s relative cgroup path failed with error: %v", container.Name, err)
-			continue
+		_, _ = fmt.Fprintf(h, "%s:%d/%d\x00", relativePath, cpuStats.CpuQuota, cpuStats.CpuPeriod)
+		return nil
+	}
+
+	for _, podDir := range sorted {
+		podRelativePath := filepath.Join(cgroupPath, podDir)
+		if err := hashCPUStats(podRelativePath); err != nil {
+			return 0, err
 		}
 
-		containerPathMap[containerRelativeCgroupPath] = &containerCopy
+		podAbsPath := p.getAbsCgroupPath(common.DefaultSelectedSubsys, podRelativePath)
+		entries, err := os.ReadDir(podAbsPath)
+		if os.IsNotExist(err) {
+			// the pod is gone, which is told by its dir anyway
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+		for _, entry := range entries {
+			if !isDirEntry(podAbsPath, entry) {
+				continue
+			}
+			if err := hashCPUStats(filepath.Join(podRelativePath, entry.Name())); err != nil {
+				return 0, err
+			}
+		}
 	}
-
-	return containerPathMap
+	return h.Sum64(), nil
 }
 
-func (p *DynamicPolicy) applyAllContainersQuota(pod *v1.Pod, setToLimit bool) error {
-	allContainersRelativePathMap := p.getAllContainersRelativePathMap(pod)
+// isNodeReadyForReconcile returns whether the node is ready for the first reconcile, since the cgroup hierarchy
+// and pod list may not be fully populated at node boot; once it's ready, later reconciles are no longer gated.
+func (p *DynamicPolicy) isNodeReadyForReconcile(ctx context.Context) bool {
+	if p.nodeReadyForReconcile {
+		return true
+	}
 
-	for relativePath, container := range allContainersRelativePathMap {
-		limit := container.Resources.Limits.Cpu().MilliValue() // Value() will lose precision of data
-		containerCpu, err := cgroupmgr.GetCPUWithRelativePath(relativePath)
+	conf := p.getQuotaReconcileConf()
+	if conf.MinNodeUptime > 0 {
+		uptime, err := getNodeUptime()
 		if err != nil {
-			return fmt.Errorf("GetCPUWithRelativePath %s failed with error: %v", relativePath, err)
+			general.Warningf("get node uptime failed with error: %v", err)
+			return false
+		} else if uptime < conf.MinNodeUptime {
+			general.Infof("node uptime %v is less than %v", uptime, conf.MinNodeUptime)
+			return false
 		}
-		realQuota := limit * int64(containerCpu.CpuPeriod) / 1000
-		if setToLimit {
-			if realQuota == containerCpu.CpuQuota {
-				continue
-			}
-			err := cgroupmgr.ApplyCPUWithRelativePath(relativePath, &common.CPUData{CpuQuota: realQuota})
-			if err != nil {
-				return fmt.Errorf("ApplyCPUWithRelativePath %s to %v failed with error: %v", relativePath, realQuota, err)
-			}
-		} else {
-			err := p.applyAllSubCgroupQuotaToUnLimit(relativePath)
-			if err != nil {
-				return fmt.Errorf("applyAllSubCgroupQuotaToUnLimit %s failed with error: %v", relativePath, err)
-			}
-			err = cgroupmgr.ApplyCPUWithRelativePath(relativePath, &common.CPUData{CpuQuota: -1})
-			if err != nil {
-				return fmt.Errorf("ApplyCPUWithRelativePath %s to -1 failed with error: %v", relativePath, err)
-			}
+	}
+
+	if conf.WaitForNodeReady {
+		if p.metaServer == nil || p.metaServer.NodeFetcher == nil {
+			general.Warningf("node readiness can't be checked without node fetcher")
+			return false
+		}
+
+		node, err := p.metaServer.GetNode(ctx)
+		if err != nil {
+			general.Warningf("get node failed with error: %v", err)
+			return false
+		} else if !native.NodeReady(node) {
+			general.Infof("node %s is not ready", node.Name)
+			return false
 		}
 	}
 
-	return nil
+	p.nodeReadyForReconcile = true
+	return true
 }
 
-func (p *DynamicPolicy) checkAndApplySubCgroupPath(path string, d os.DirEntry, err error) error {
-	if err != nil {
-		return err
+// isQuotaReconcileEnabled returns whether reconciling quota to pods is enabled by the QuotaReconcile feature gate.
+func isQuotaReconcileEnabled() bool {
+	return utilfeature.DefaultFeatureGate.Enabled(features.QuotaReconcile)
+}
+
+// isReconcilePausedForMaintenance returns whether reconciles are paused since the node is under maintenance, i.e.
+// it's cordoned or annotated with the maintenance annotation, and reconciles resume once it's cleared. Whether it's
+// paused is emitted in every check as a heartbeat, so that a paused reconcile isn't mistaken for a dead one. Errors
+// getting the node never pause reconciles. Reconciles paused by Pause are paused as well regardless of the node.
+func (p *DynamicPolicy) isReconcilePausedForMaintenance(ctx context.Context) bool {
+	if p.IsReconcilePaused() {
+		general.Infof("reconcile is paused by admin until it's resumed")
+		_ = p.emitter.StoreInt64(util.MetricNameQuotaReconcilePaused, 1, metrics.MetricTypeNameRaw,
+			metrics.MetricTag{Key: "reason", Val: reconcilePausedReasonAdmin})
+		return true
 	}
-	if !d.IsDir() {
-		return nil
+
+	if !p.getQuotaReconcileConf().PauseOnNodeMaintenance || p.metaServer == nil || p.metaServer.NodeFetcher == nil {
+		return false
 	}
 
-	subCPU, err := cgroupmgr.GetCPUWithAbsolutePath(path)
+	node, err := p.metaServer.GetNode(ctx)
 	if err != nil {
-		return fmt.Errorf("GetCPUWithRelativePath %s failed with error: %v", path, err)
+		general.Warningf("get node for maintenance check failed with error: %v", err)
+		return false
 	}
 
-	if subCPU.CpuQuota < 0 {
-		return nil
+	paused := node.Spec.Unschedulable || node.Annotations[cpuconsts.NodeAnnotationMaintenanceKey] == "true"
+	var pausedValue int64
+	if paused {
+		pausedValue = 1
+		general.Infof("node %s is under maintenance, reconcile is paused until it's cleared", node.Name)
 	}
+	_ = p.emitter.StoreInt64(util.MetricNameQuotaReconcilePaused, pausedValue, metrics.MetricTypeNameRaw)
+	return paused
+}
 
-	err = cgroupmgr.ApplyCPUWithAbsolutePath(path, &common.CPUData{CpuQuota: -1})
+// getNodeUptime returns the time elapsed since the node boots, which is read from /proc/uptime.
+func getNodeUptime() (time.Duration, error) {
+	content, err := os.ReadFile("/proc/uptime")
 	if err != nil {
-		general.Errorf("ApplyCPUWithAbsolutePath %s to -1 failed with error: %v", path, err)
-		return fmt.Errorf("ApplyCPUWithAbsolutePath %s to -1 failed with error: %v", path, err)
+		return 0, err
 	}
 
-	return nil
+	fields := strings.Fields(string(content))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("invalid content of /proc/uptime: %s", content)
+	}
+
+	seconds, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse uptime %s failed with error: %v", fields[0], err)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
 }
 
-func (p *DynamicPolicy) applyAllSubCgroupQuotaToUnLimit(containerRelativePath string) error {
-	containerAbsPath := common.GetAbsCgroupPath(common.DefaultSelectedSubsys, containerRelativePath)
+// logAdvisorPayload logs the raw control knobs of the calculation info if it's enabled, values of redacted knobs
+// are masked and the payload is truncated to the max size, so that a large plan doesn't flood the log.
+func (p *DynamicPolicy) logAdvisorPayload(calculationInfo *advisorsvc.CalculationInfo) {
+	conf := p.getQuotaReconcileConf()
+	if !conf.AdvisorPayloadLogEnabled || calculationInfo.CalculationResult == nil {
+		return
+	}
 
-	return filepath.WalkDir(containerAbsPath, func(path string, d fs.DirEntry, err error) error {
-		if path == containerAbsPath {
-			return nil
+	redactedKeys := sets.NewString(conf.AdvisorPayloadLogRedactedKeys...)
+	values := make(map[string]string, len(calculationInfo.CalculationResult.Values))
+	for key, value := range calculationInfo.CalculationResult.Values {
+		if redactedKeys.Has(key) {
+			value = advisorPayloadRedactedValue
+		}
+		values[key] = value
+	}
+
+	// keys of the map are sorted by json, so that payloads of the same plan are logged identically
+	payload, err := json.Marshal(values)
+	if err != nil {
+		general.Errorf("marshal advisor payload of %s failed with error: %v", calculationInfo.CgroupPath, err)
+		return
+	}
+
+	maxBytes := conf.AdvisorPayloadLogMaxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultAdvisorPayloadLogMaxBytes
+	}
+	if len(payload) > maxBytes {
+		general.Infof("raw advisor payload of %s (truncated from %d bytes): %s...", calculationInfo.CgroupPath, len(payload), payload[:maxBytes])
+		return
+	}
+	general.Infof("raw advisor payload of %s: %s", calculationInfo.CgroupPath, payload)
+}
+
+// applyCPUUclamp applies cpu.uclamp.min/cpu.uclamp.max given by advisor to the cgroup path, which hints
+// frequency floors/ceilings of the cgroup on schedutil kernels; it's only supported in cgroup v2.
+func (p *DynamicPolicy) applyCPUUclamp(calculationInfo *advisorsvc.CalculationInfo) error {
+	uclampMin, err := parseCPUUclamp(calculationInfo.CalculationResult.Values, advisorapi.ControlKnobKeyCPUUclampMin)
+	if err != nil {
+		return err
+	}
+	uclampMax, err := parseCPUUclamp(calculationInfo.CalculationResult.Values, advisorapi.ControlKnobKeyCPUUclampMax)
+	if err != nil {
+		return err
+	}
+
+	if uclampMin == nil && uclampMax == nil {
+		return nil
+	} else if uclampMin != nil && uclampMax != nil && *uclampMin > *uclampMax {
+		return fmt.Errorf("uclamp min %v is larger than uclamp max %v", *uclampMin, *uclampMax)
+	}
+
+	absCgroupPath := p.getAbsCgroupPath(common.CgroupSubsysCPU, calculationInfo.CgroupPath)
+	if !p.isSubsysOnCgroupV2(common.CgroupSubsysCPU) || !general.IsPathExists(filepath.Join(absCgroupPath, "cpu.uclamp.min")) {
+		general.Warningf("cpu uclamp is not supported for %s, skip applying it", calculationInfo.CgroupPath)
+		return nil
+	}
+
+	return p.applyCPUQuotaWithRelativePath(context.Background(), calculationInfo.CgroupPath, &common.CPUData{
+		CpuUclampMinPtr: uclampMin,
+		CpuUclampMaxPtr: uclampMax,
+	})
+}
+
+// parseCPUUclamp parses the uclamp percentage of the control knob, and nil is returned if it's not given.
+func parseCPUUclamp(values map[string]string, key advisorapi.CPUControlKnobName) (*float64, error) {
+	value, ok := values[string(key)]
+	if !ok {
+		return nil, nil
+	}
+
+	percent, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %s failed with error: %v", key, value, err)
+	} else if math.IsNaN(percent) || percent < 0 || percent > 100 {
+		return nil, fmt.Errorf("%s: %s is out of range [0, 100]", key, value)
+	}
+	return &percent, nil
+}
+
+// applyPidsMax applies pids.max given by advisor to the cgroup path, to contain fork bombs of workloads under it.
+func (p *DynamicPolicy) applyPidsMax(calculationInfo *advisorsvc.CalculationInfo) error {
+	pidsMax, err := parsePidsMax(calculationInfo.CalculationResult.Values)
+	if err != nil {
+		return err
+	} else if pidsMax == nil {
+		return nil
+	}
+
+	if err := p.checkCgroupWritesAllowed(); err != nil {
+		return err
+	}
+
+	err = p.runCgroupWrite(context.Background(), calculationInfo.CgroupPath, func() error {
+		return p.applyPidsWithRelativePath(calculationInfo.CgroupPath, &common.PidsData{PidsMax: *pidsMax})
+	})
+	if err != nil {
+		err = fmt.Errorf("%w: %v", ErrCgroupWrite, err)
+	}
+	p.recordCgroupWrite(calculationInfo.CgroupPath, err)
+	return err
+}
+
+// parsePidsMax parses the pids limit of the control knob, it's either a positive integer or "max" for unlimited,
+// and nil is returned if it's not given.
+func parsePidsMax(values map[string]string) (*int64, error) {
+	value, ok := values[string(advisorapi.ControlKnobKeyPidsMax)]
+	if !ok {
+		return nil, nil
+	}
+
+	var pidsMax int64 = common.PidsMaxUnlimit
+	if value != "max" {
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %s failed with error: %v", advisorapi.ControlKnobKeyPidsMax, value, err)
+		} else if limit <= 0 {
+			return nil, fmt.Errorf("%s: %s is not a positive integer", advisorapi.ControlKnobKeyPidsMax, value)
+		}
+		pidsMax = limit
+	}
+	return &pidsMax, nil
+}
+
+// applySwapMax applies memory.swap.max given by advisor to the cgroup path, to tune the swap usage of workloads
+// under it on swap-enabled nodes; it's only supported in cgroup v2 with swap accounting enabled.
+func (p *DynamicPolicy) applySwapMax(calculationInfo *advisorsvc.CalculationInfo) error {
+	swapMax, err := parseSwapMax(calculationInfo.CalculationResult.Values)
+	if err != nil {
+		return err
+	} else if swapMax == nil {
+		return nil
+	}
+
+	absCgroupPath := p.getAbsCgroupPath(common.CgroupSubsysMemory, calculationInfo.CgroupPath)
+	if !p.isSubsysOnCgroupV2(common.CgroupSubsysMemory) || !general.IsPathExists(filepath.Join(absCgroupPath, "memory.swap.max")) {
+		general.Warningf("memory swap max is not supported for %s, skip applying it", calculationInfo.CgroupPath)
+		return nil
+	}
+
+	// the kernel reads back "max" for unlimited and 0 for disabled swap, so both sides are compared in bytes
+	if currentSwapMax, err := readSwapMax(absCgroupPath); err != nil {
+		general.Warningf("read memory swap max of %s failed with error: %v", calculationInfo.CgroupPath, err)
+	} else if currentSwapMax == normalizeSwapMax(*swapMax) {
+		general.InfofV(4, "memory swap max of %s is already %d, skip applying it", calculationInfo.CgroupPath, currentSwapMax)
+		return nil
+	}
+
+	if err := p.checkCgroupWritesAllowed(); err != nil {
+		return err
+	}
+
+	err = p.runCgroupWrite(context.Background(), calculationInfo.CgroupPath, func() error {
+		return p.applyMemoryWithRelativePath(calculationInfo.CgroupPath, &common.MemoryData{SwapMaxInBytes: *swapMax})
+	})
+	if err != nil {
+		err = fmt.Errorf("%w: %v", ErrCgroupWrite, err)
+	}
+	p.recordCgroupWrite(calculationInfo.CgroupPath, err)
+	return err
+}
+
+// parseSwapMax parses the swap limit of the control knob into SwapMaxInBytes of the cgroup manager, it's either
+// "0" for disabling swap, a positive byte count or "max" for unlimited, and nil is returned if it's not given.
+func parseSwapMax(values map[string]string) (*int64, error) {
+	value, ok := values[string(advisorapi.ControlKnobKeySwapMax)]
+	if !ok {
+		return nil, nil
+	}
+
+	var swapMax int64 = math.MaxInt64
+	if value != "max" {
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %s failed with error: %v", advisorapi.ControlKnobKeySwapMax, value, err)
+		} else if limit < 0 {
+			return nil, fmt.Errorf("%s: %s is a negative integer", advisorapi.ControlKnobKeySwapMax, value)
+		}
+
+		// zero SwapMaxInBytes means leaving swap max untouched for the cgroup manager, and negative means disabling it
+		swapMax = limit
+		if limit == 0 {
+			swapMax = -1
+		}
+	}
+	return &swapMax, nil
+}
+
+// readSwapMax reads memory.swap.max of the absolute cgroup path in bytes, with "max" read as math.MaxInt64.
+func readSwapMax(absCgroupPath string) (int64, error) {
+	value, err := fscommon.GetCgroupParamString(absCgroupPath, "memory.swap.max")
+	if err != nil {
+		return 0, err
+	} else if value == "max" {
+		return math.MaxInt64, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// normalizeSwapMax converts SwapMaxInBytes of the cgroup manager into the value the kernel reads back.
+func normalizeSwapMax(swapMax int64) int64 {
+	if swapMax < 0 {
+		return 0
+	}
+	return swapMax
+}
+
+// applyCgroupFreeze freezes or thaws the cgroup path given by advisor, e.g. to quarantine a misbehaving pod
+// for incident response; it's written to cgroup.freeze in cgroup v2 and freezer.state in cgroup v1.
+func (p *DynamicPolicy) applyCgroupFreeze(calculationInfo *advisorsvc.CalculationInfo) error {
+	value, ok := calculationInfo.CalculationResult.Values[string(advisorapi.ControlKnobKeyCgroupFreeze)]
+	if !ok {
+		return nil
+	}
+
+	var frozen bool
+	switch value {
+	case "1":
+		frozen = true
+	case "0":
+		frozen = false
+	default:
+		return fmt.Errorf("%s: %s is neither 1 nor 0", advisorapi.ControlKnobKeyCgroupFreeze, value)
+	}
+
+	// the advisor pushes the freeze knob together with every other knob, so it's only written on a change
+	if currentFrozen, err := p.readCgroupFrozen(calculationInfo.CgroupPath); err != nil {
+		general.Warningf("read freezer state of cgroup %s failed with error: %v", calculationInfo.CgroupPath, err)
+	} else if currentFrozen == frozen {
+		general.InfofV(4, "cgroup %s is already in freezer state %s, skip applying it", calculationInfo.CgroupPath, value)
+		return nil
+	}
+
+	if err := p.checkCgroupWritesAllowed(); err != nil {
+		return err
+	}
+
+	// freezing pauses all workloads under the cgroup, so it's always logged loudly
+	if frozen {
+		general.Warningf("freeze cgroup %s by advisor, all tasks under it are paused until it's thawed", calculationInfo.CgroupPath)
+	} else {
+		general.Warningf("thaw cgroup %s by advisor", calculationInfo.CgroupPath)
+	}
+
+	err := p.runCgroupWrite(context.Background(), calculationInfo.CgroupPath, func() error {
+		return p.applyFreezerWithRelativePath(calculationInfo.CgroupPath, &common.FreezerData{FrozenPtr: &frozen})
+	})
+	if err != nil {
+		general.Errorf("apply freezer state %s to cgroup %s failed with error: %v", value, calculationInfo.CgroupPath, err)
+		err = fmt.Errorf("%w: %v", ErrCgroupWrite, err)
+	}
+	p.recordCgroupWrite(calculationInfo.CgroupPath, err)
+	return err
+}
+
+// readCgroupFrozen reads whether the cgroup path is frozen, from cgroup.freeze in cgroup v2 and freezer.state
+// in cgroup v1; a v1 cgroup still in the transient FREEZING state is reported as an error.
+func (p *DynamicPolicy) readCgroupFrozen(relativePath string) (bool, error) {
+	absCgroupPath := p.getAbsCgroupPath(common.CgroupSubsysFreezer, relativePath)
+	if p.isSubsysOnCgroupV2(common.CgroupSubsysFreezer) {
+		state, err := fscommon.GetCgroupParamString(absCgroupPath, "cgroup.freeze")
+		if err != nil {
+			return false, err
+		}
+		return state == "1", nil
+	}
+
+	state, err := fscommon.GetCgroupParamString(absCgroupPath, "freezer.state")
+	if err != nil {
+		return false, err
+	}
+	switch state {
+	case "FROZEN":
+		return true, nil
+	case "THAWED":
+		return false, nil
+	default:
+		return false, fmt.Errorf("unexpected freezer state %s", state)
+	}
+}
+
+// applyOOMScoreAdj applies oom_score_adj given by advisor to all processes under the cgroup path, to bias
+// OOM victim selection of the kernel towards or away from the pod; processes exited in the meanwhile are ignored.
+// All processes of the cgroup path are written as a single cgroup write, which is guarded like the other ones.
+func (p *DynamicPolicy) applyOOMScoreAdj(calculationInfo *advisorsvc.CalculationInfo) error {
+	value, ok := calculationInfo.CalculationResult.Values[string(advisorapi.ControlKnobKeyOOMScoreAdj)]
+	if !ok {
+		return nil
+	}
+
+	oomScoreAdj, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("parse %s: %s failed with error: %v", advisorapi.ControlKnobKeyOOMScoreAdj, value, err)
+	} else if oomScoreAdj < -1000 || oomScoreAdj > 1000 {
+		return fmt.Errorf("%s: %s is out of range [-1000, 1000]", advisorapi.ControlKnobKeyOOMScoreAdj, value)
+	}
+
+	if err := p.checkCgroupWritesAllowed(); err != nil {
+		return err
+	}
+
+	err = p.runCgroupWrite(context.Background(), calculationInfo.CgroupPath, func() error {
+		return p.applyToCgroupPids(calculationInfo.CgroupPath, func(pid int) error {
+			return process.SetProcessOOMScoreAdj(pid, oomScoreAdj)
+		})
+	})
+	// failing to read pids of the cgroup path is surfaced as a read failure, while it still counts for the breaker
+	if err != nil && !errors.Is(err, ErrCgroupRead) {
+		err = fmt.Errorf("%w: %v", ErrCgroupWrite, err)
+	}
+	p.recordCgroupWrite(calculationInfo.CgroupPath, err)
+	return err
+}
+
+// applyContainerMemoryLimits applies memory limits of containers given by advisor, containers are resolved to
+// cgroup paths in the same way as applying their quota; limits below the current rss of containers are rejected
+// to avoid instant OOM, and the other containers are still applied.
+func (p *DynamicPolicy) applyContainerMemoryLimits(ctx context.Context, calculationInfo *advisorsvc.CalculationInfo) error {
+	value, ok := calculationInfo.CalculationResult.Values[string(advisorapi.ControlKnobKeyContainerMemoryLimits)]
+	if !ok {
+		return nil
+	}
+
+	var podLimits map[string]map[string]int64
+	if err := json.Unmarshal([]byte(value), &podLimits); err != nil {
+		return fmt.Errorf("unmarshal %s: %s failed with error: %v", advisorapi.ControlKnobKeyContainerMemoryLimits, value, err)
+	}
+	if p.metaServer == nil || p.metaServer.MetaAgent == nil || p.metaServer.PodFetcher == nil {
+		return fmt.Errorf("%w: no pod fetcher to resolve containers of %s", ErrPodNotFound, calculationInfo.CgroupPath)
+	}
+
+	var errList []error
+	for podUID, containerLimits := range podLimits {
+		pod, err := p.metaServer.GetPod(ctx, podUID)
+		if err != nil {
+			errList = append(errList, fmt.Errorf("%w: get pod %s failed with error: %v", ErrPodNotFound, podUID, err))
+			continue
+		}
+
+		for relativePath, container := range p.getAllContainersRelativePathMap(pod) {
+			limit, ok := containerLimits[container.Name]
+			if !ok {
+				continue
+			}
+
+			if err := p.applyContainerMemoryLimit(relativePath, limit); err != nil {
+				errList = append(errList, fmt.Errorf("apply memory limit of container %s/%s failed: %w", pod.Name, container.Name, err))
+			}
+		}
+	}
+	return utilerrors.NewAggregate(errList)
+}
+
+// applyContainerMemoryLimit applies the memory limit to the container cgroup path if it's not below the current rss.
+func (p *DynamicPolicy) applyContainerMemoryLimit(relativePath string, limit int64) error {
+	if limit <= 0 {
+		return fmt.Errorf("%s: %d is not a positive integer", advisorapi.ControlKnobKeyContainerMemoryLimits, limit)
+	}
+
+	rss, err := p.getMemoryRSSWithRelativePath(relativePath)
+	if err != nil {
+		return fmt.Errorf("%w: get memory metrics of %s failed with error: %v", ErrCgroupRead, relativePath, err)
+	}
+
+	if uint64(limit) < rss {
+		return fmt.Errorf("%w: limit %d of %s is below rss %d", ErrMemoryLimitBelowUsage, limit, relativePath, rss)
+	}
+
+	if err := p.checkCgroupWritesAllowed(); err != nil {
+		return err
+	}
+	if err := p.captureKnob(knobMemoryLimit, relativePath, p.capturePriorMemoryLimit); err != nil {
+		return err
+	}
+
+	err = p.runCgroupWrite(context.Background(), relativePath, func() error {
+		return p.applyMemoryWithRelativePath(relativePath, &common.MemoryData{LimitInBytes: limit})
+	})
+	if err != nil {
+		err = fmt.Errorf("%w: %v", ErrCgroupWrite, err)
+	}
+	p.recordCgroupWrite(relativePath, err)
+	return err
+}
+
+// checkCPUPeriodChange reads back the cfs period of the cgroup path, and if the period given by advisor differs
+// from the current one without a quota, the current quota is rescaled to the new period to preserve the effective
+// cpu count, since only the period would be written otherwise and the effective cpu count changed along with it.
+func (p *DynamicPolicy) checkCPUPeriodChange(cgroupPath string, resources *common.CgroupResources) error {
+	if resources.CpuPeriod == 0 {
+		return nil
+	}
+
+	cpuStats, err := p.getCPUWithRelativePath(cgroupPath)
+	if err != nil {
+		return fmt.Errorf("%w: get cpu stats failed with error: %v", ErrCgroupRead, err)
+	}
+
+	if cpuStats.CpuPeriod == resources.CpuPeriod {
+		return nil
+	}
+
+	general.Infof("cpu period of %s is changed from %d to %d", cgroupPath, cpuStats.CpuPeriod, resources.CpuPeriod)
+	if resources.CpuQuota == 0 && cpuStats.CpuQuota > 0 {
+		resources.CpuQuota = scaleCPUQuotaToPeriod(cpuStats.CpuQuota, cpuStats.CpuPeriod, resources.CpuPeriod)
+		general.Infof("cpu quota of %s is rescaled from %d to %d along with the period",
+			cgroupPath, cpuStats.CpuQuota, resources.CpuQuota)
+	}
+	return nil
+}
+
+// convertCPUCoresToQuota sets the quota of resources to the given cores in the desired period of resources,
+// or in the current period of the cgroup if no period is desired. The cores must be positive and must not
+// exceed the cpu capacity of the node.
+func (p *DynamicPolicy) convertCPUCoresToQuota(cgroupPath, value string, resources *common.CgroupResources) error {
+	cores, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fmt.Errorf("parse %s: %s failed with error: %v", advisorapi.ControlKnobKeyCPUCores, value, err)
+	} else if cores <= 0 || math.IsInf(cores, 0) || math.IsNaN(cores) {
+		return fmt.Errorf("%s: %s is not a positive number of cores", advisorapi.ControlKnobKeyCPUCores, value)
+	}
+
+	if p.machineInfo == nil || p.machineInfo.CPUTopology == nil {
+		return fmt.Errorf("%s: %s can't be validated without cpu topology", advisorapi.ControlKnobKeyCPUCores, value)
+	} else if cores > float64(p.machineInfo.NumCPUs) {
+		return fmt.Errorf("%s: %s exceeds the cpu capacity %d of the node", advisorapi.ControlKnobKeyCPUCores, value, p.machineInfo.NumCPUs)
+	}
+
+	period, err := p.getDesiredCPUPeriod(cgroupPath, resources)
+	if err != nil {
+		return err
+	}
+
+	quota := int64(math.Round(cores * float64(period)))
+	if resources.CpuQuota != 0 && resources.CpuQuota != quota {
+		general.Infof("cpu quota %d of %s is overridden by %s %s", resources.CpuQuota, cgroupPath, advisorapi.ControlKnobKeyCPUCores, value)
+	}
+	resources.CpuQuota = quota
+	return nil
+}
+
+// capUnlimitedQuota substitutes the configured cap for the unlimited quota requested by advisor, in the desired
+// period of resources or in the current period of the cgroup if no period is desired; quota is kept unlimited
+// if no cap is configured.
+func (p *DynamicPolicy) capUnlimitedQuota(cgroupPath string, resources *common.CgroupResources) error {
+	capMilliCores := p.getQuotaReconcileConf().UnlimitedQuotaCapMilliCores
+	if capMilliCores <= 0 || resources.CpuQuota != -1 {
+		return nil
+	}
+
+	period, err := p.getDesiredCPUPeriod(cgroupPath, resources)
+	if err != nil {
+		return err
+	}
+
+	resources.CpuQuota = capMilliCores * int64(period) / 1000
+	general.Infof("unlimited cpu quota of %s is capped to %d", cgroupPath, resources.CpuQuota)
+	return nil
+}
+
+// getDesiredCPUPeriod returns the desired period of resources, or the current period of the cgroup if no period
+// is desired.
+func (p *DynamicPolicy) getDesiredCPUPeriod(cgroupPath string, resources *common.CgroupResources) (uint64, error) {
+	if resources.CpuPeriod != 0 {
+		return resources.CpuPeriod, nil
+	}
+
+	cpuStats, err := p.getCPUWithRelativePath(cgroupPath)
+	if err != nil {
+		return 0, fmt.Errorf("%w: get cpu stats failed with error: %v", ErrCgroupRead, err)
+	}
+	return cpuStats.CpuPeriod, nil
+}
+
+// scaleCPUQuotaToPeriod converts the quota in the given period into the one with the same effective cpu count
+// in the target period, and unlimited quota is kept as it is.
+func scaleCPUQuotaToPeriod(quota int64, fromPeriod, toPeriod uint64) int64 {
+	if quota <= 0 || fromPeriod == 0 || toPeriod == 0 || fromPeriod == toPeriod {
+		return quota
+	}
+	return quota * int64(toPeriod) / int64(fromPeriod)
+}
+
+// isCPUQuotaWidened returns whether the target quota allows more cpu than the current one in the same period,
+// where unlimited quota (-1) is wider than any limited one.
+func isCPUQuotaWidened(currentQuota, targetQuota int64) bool {
+	if currentQuota == -1 {
+		return false
+	}
+	return targetQuota == -1 || targetQuota > currentQuota
+}
+
+// applyCPUSetMems applies cpuset.mems given by advisor to the cgroup path, to bind memory of
+// NUMA-sensitive workloads under it to the specific NUMA nodes.
+func (p *DynamicPolicy) applyCPUSetMems(calculationInfo *advisorsvc.CalculationInfo) error {
+	mems, err := p.parseCPUSetMems(calculationInfo.CalculationResult.Values)
+	if err != nil {
+		return err
+	} else if mems == nil {
+		return nil
+	}
+
+	if err := p.checkCgroupWritesAllowed(); err != nil {
+		return err
+	}
+	if err := p.captureKnob(knobCPUSetMems, calculationInfo.CgroupPath, p.capturePriorCPUSetMems); err != nil {
+		return err
+	}
+
+	err = p.runCgroupWrite(context.Background(), calculationInfo.CgroupPath, func() error {
+		return p.applyCPUSetWithRelativePath(calculationInfo.CgroupPath, &common.CPUSetData{Mems: mems.String()})
+	})
+	if err != nil {
+		err = fmt.Errorf("%w: %v", ErrCgroupWrite, err)
+	}
+	p.recordCgroupWrite(calculationInfo.CgroupPath, err)
+	return err
+}
+
+// parseCPUSetMems parses the NUMA nodes of the control knob in the cpuset list format, e.g. "0-1,3",
+// and the nodes must exist on this machine; nil is returned if it's not given.
+func (p *DynamicPolicy) parseCPUSetMems(values map[string]string) (*machine.CPUSet, error) {
+	value, ok := values[string(advisorapi.ControlKnobKeyCPUSetMems)]
+	if !ok {
+		return nil, nil
+	}
+
+	mems, err := machine.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %s failed with error: %v", advisorapi.ControlKnobKeyCPUSetMems, value, err)
+	} else if mems.IsEmpty() {
+		return nil, fmt.Errorf("%s: %s contains no NUMA node", advisorapi.ControlKnobKeyCPUSetMems, value)
+	}
+
+	if p.machineInfo == nil || p.machineInfo.CPUTopology == nil {
+		return nil, fmt.Errorf("%s: %s can't be validated without cpu topology", advisorapi.ControlKnobKeyCPUSetMems, value)
+	}
+	numaNodes := p.machineInfo.CPUDetails.NUMANodes()
+	if !mems.IsSubsetOf(numaNodes) {
+		return nil, fmt.Errorf("%s: %s refers to nonexistent NUMA nodes %s, available ones are %s",
+			advisorapi.ControlKnobKeyCPUSetMems, value, mems.Difference(numaNodes).String(), numaNodes.String())
+	}
+	return &mems, nil
+}
+
+// checkAndApplyCPUBurst reads back the cpu burst of the cgroup path and corrects it once it
+// drifts from the desired one, since ApplyCgroupConfigs doesn't cover cpu burst.
+func (p *DynamicPolicy) checkAndApplyCPUBurst(cgroupPath string, desiredBurst *uint64) error {
+	if desiredBurst == nil {
+		return nil
+	}
+
+	cpuStats, err := p.getCPUWithRelativePath(cgroupPath)
+	if err != nil {
+		return fmt.Errorf("get cpu stats failed with error: %v", err)
+	}
+
+	if cpuStats.CpuBurst == nil {
+		general.Warningf("cpu burst is not supported for %s, skip applying it", cgroupPath)
+		return nil
+	}
+
+	if *cpuStats.CpuBurst == *desiredBurst {
+		return nil
+	}
+
+	general.Infof("cpu burst of %s drifts, current: %d, desired: %d", cgroupPath, *cpuStats.CpuBurst, *desiredBurst)
+	return p.applyCPUQuotaWithRelativePath(context.Background(), cgroupPath, &common.CPUData{CpuBurstPtr: desiredBurst})
+}
+
+func (p *DynamicPolicy) checkAndApplyIfCgroupV1(calculationInfo *advisorsvc.CalculationInfo,
+	resources *common.CgroupResources,
+) (result *ReconcileResult, err error) {
+	if common.CheckCgroup2UnifiedMode() {
+		return &ReconcileResult{}, nil
+	}
+	if !isQuotaReconcileEnabled() {
+		general.InfofV(4, "feature gate %s is disabled, skip reconciling quota to pods under %s",
+			features.QuotaReconcile, calculationInfo.CgroupPath)
+		return &ReconcileResult{}, nil
+	}
+
+	ctx, span := p.getTracer().Start(context.Background(), "checkAndApplyIfCgroupV1", trace.WithAttributes(
+		attribute.String("cgroupPath", calculationInfo.CgroupPath),
+		attribute.Int64("desiredQuota", resources.CpuQuota),
+	))
+	defer func() { endSpanWithError(span, err) }()
+
+	currentParentCgroupCPUStats, err := p.getCPUWithRelativePath(calculationInfo.CgroupPath)
+	if err != nil {
+		return &ReconcileResult{}, fmt.Errorf("%w: Get big group quota failed with error: %v", ErrCgroupRead, err)
+	}
+
+	// the current quota is compared in the desired period, in case that the period is changed by advisor
+	currentQuota := currentParentCgroupCPUStats.CpuQuota
+	if resources.CpuPeriod != 0 {
+		currentQuota = scaleCPUQuotaToPeriod(currentQuota, currentParentCgroupCPUStats.CpuPeriod, resources.CpuPeriod)
+	}
+
+	// scale down the be group quota
+	bigGroupQuota := resources.CpuQuota
+	if currentQuota >= 0 && resources.CpuQuota > currentQuota {
+		// scale up the be group quota after pods are applied, so pods are bounded by the current one in the meanwhile
+		bigGroupQuota = currentQuota
+	}
+
+	result, err = p.checkAndApplyAllPodsQuota(ctx, calculationInfo, bigGroupQuota)
+	if err != nil {
+		return result, fmt.Errorf("checkAndApplyAllPodsQuota failed with error: %w", err)
+	}
+	return result, nil
+}
+
+// podQuotaRound accumulates results of all pods under an advisor cgroup path in a round of quota reconcile.
+type podQuotaRound struct {
+	// appliedQuotaByQoSLevel accumulates the quota (in milli-cores) written to pods that are bounded by their own limits,
+	// pods set to unlimited are bounded by the big group quota and are not counted in
+	appliedQuotaByQoSLevel map[string]int64
+	skippedPodsByReason    map[string]int64
+	// livePodPaths records pod cgroups matched with live pods
+	livePodPaths map[string]bool
+	// driftedPods is the number of pods whose read-back quota differs from the last-applied one,
+	// which hints that something outside katalyst is rewriting the cgroups
+	driftedPods int64
+	// processedPods, appliedPods, unchangedPods and failedPods are summarized in the result of the round
+	processedPods int64
+	appliedPods   int64
+	unchangedPods int64
+	failedPods    int64
+	// podErrors records errors of pods keyed by their pod dirs
+	podErrors map[string]error
+	// kubeletStaticPods records uids of pods with exclusive cpus assigned by kubelet, loaded at the first lookup
+	kubeletStaticPods map[string]bool
+}
+
+// ReconcileResult is the outcome of reconciling quota of pods under an advisor cgroup path in a round,
+// which is the single source of counts reported by metrics, logs and admin endpoints.
+type ReconcileResult struct {
+	Processed int64
+	Applied   int64
+	// Skipped includes pods already at the desired quota
+	Skipped  int64
+	Failed   int64
+	Duration time.Duration
+	// PodErrors are errors of failed pods keyed by their pod dirs, and only fatal ones abort the round
+	PodErrors map[string]error
+	// SimulatedApplies are the quota writes the round would make, and they're only set by SimulateReconcile
+	SimulatedApplies []SimulatedApply
+}
+
+// result returns the outcome of the round which took the given duration.
+func (r *podQuotaRound) result(duration time.Duration) *ReconcileResult {
+	skipped := r.unchangedPods
+	for _, count := range r.skippedPodsByReason {
+		skipped += count
+	}
+	return &ReconcileResult{
+		Processed: r.processedPods,
+		Applied:   r.appliedPods,
+		Skipped:   skipped,
+		Failed:    r.failedPods,
+		Duration:  duration,
+		PodErrors: r.podErrors,
+	}
+}
+
+// logSummary logs a concise summary of the result at normal verbosity, while per-pod details are only logged at
+// higher verbosity to keep logs quiet at scale.
+func (r *ReconcileResult) logSummary(cgroupPath string) {
+	general.Infof("quota reconcile of %s: processed %d pods, applied %d, skipped %d, failed %d, took %v",
+		cgroupPath, r.Processed, r.Applied, r.Skipped, r.Failed, r.Duration)
+}
+
+// checkAndApplyAllPodsQuota applies quota to all pods under the advisor cgroup path, and the result of the round
+// is returned even if it's aborted by a fatal error.
+func (p *DynamicPolicy) checkAndApplyAllPodsQuota(ctx context.Context, calculationInfo *advisorsvc.CalculationInfo,
+	bigGroupQuota int64,
+) (result *ReconcileResult, err error) {
+	return p.checkAndApplyPodsQuotaOfQoSLevel(ctx, calculationInfo, bigGroupQuota, "")
+}
+
+// checkAndApplyPodsQuotaOfQoSLevel applies quota to pods of the qos level under the advisor cgroup path, and empty
+// qos level means all pods. Stale pod cgroups are only cleaned up and per-path metrics are only emitted in rounds
+// of all pods, since pods of other qos levels are left out of the other rounds.
+func (p *DynamicPolicy) checkAndApplyPodsQuotaOfQoSLevel(ctx context.Context, calculationInfo *advisorsvc.CalculationInfo,
+	bigGroupQuota int64, qosLevel string,
+) (result *ReconcileResult, err error) {
+	podsPathMap, podDirs, err := p.getCurrentPathAllPodsDirAndMap(calculationInfo.CgroupPath)
+	if err != nil {
+		return &ReconcileResult{}, fmt.Errorf("%w: %v", ErrPathResolve, err)
+	}
+	podDirs, priorities := p.sortPodDirsByPriority(calculationInfo.CgroupPath, podDirs, podsPathMap)
+	if qosLevel != "" {
+		podDirs = p.filterPodDirsOfQoSLevel(calculationInfo.CgroupPath, podDirs, podsPathMap, qosLevel)
+	} else {
+		// the round resumes from the pod where the last one is cut off by the budget, after higher-priority pods
+		podDirs = rotatePodDirsInPriorityTier(podDirs, priorities, p.reconcileBudget.resumePodDirs[calculationInfo.CgroupPath])
+		delete(p.reconcileBudget.resumePodDirs, calculationInfo.CgroupPath)
+	}
+
+	round := &podQuotaRound{
+		appliedQuotaByQoSLevel: make(map[string]int64),
+		skippedPodsByReason:    make(map[string]int64),
+		livePodPaths:           make(map[string]bool),
+		podErrors:              make(map[string]error),
+	}
+	defer p.emitSkippedPodsByReason(calculationInfo.CgroupPath, round.skippedPodsByReason)
+	start := time.Now()
+	defer func() {
+		result = round.result(time.Since(start))
+		result.logSummary(calculationInfo.CgroupPath)
+		if qosLevel == "" {
+			_ = p.emitter.StoreFloat64(util.MetricNameQuotaReconcileDuration, result.Duration.Seconds(), metrics.MetricTypeNameRaw,
+				metrics.ConvertMapToTags(map[string]string{
+					"cgroupPath": calculationInfo.CgroupPath,
+				})...)
+		}
+	}()
+
+	// stale pod cgroups are cleaned up only if all pod dirs are walked through
+	interrupted := false
+	for i, podDir := range podDirs {
+		// the breaker or the cap has already logged and emitted the event, just stop touching the remaining pods
+		if p.checkCgroupWritesAllowed() != nil {
+			interrupted = true
+			break
+		}
+
+		round.processedPods++
+		err := p.checkAndApplyPodQuota(ctx, calculationInfo.CgroupPath, podDir, podsPathMap, bigGroupQuota, round)
+		if err != nil {
+			round.failedPods++
+			round.podErrors[podDir] = err
+			return nil, err
+		}
+
+		if i+1 < len(podDirs) && p.isReconcileBudgetExceeded() {
+			general.Warningf("reconcile budget is exceeded, %d pods under %s remain and are reconciled from %s in the next round",
+				len(podDirs)-i-1, calculationInfo.CgroupPath, podDirs[i+1])
+			if qosLevel == "" {
+				p.reconcileBudget.resumeCgroupPath = calculationInfo.CgroupPath
+				p.reconcileBudget.setResumePodDir(calculationInfo.CgroupPath, podDirs[i+1])
+			}
+			interrupted = true
+			break
+		}
+	}
+
+	if !interrupted && qosLevel == "" {
+		p.cleanupStalePodQuotas(ctx, calculationInfo.CgroupPath, round.livePodPaths)
+		p.getPodFailureBackoff().prune(calculationInfo.CgroupPath, round.livePodPaths)
+		p.getCPUThrottleTracker().prune(calculationInfo.CgroupPath, round.livePodPaths)
+		p.getQuotaStarvationTracker().prune(calculationInfo.CgroupPath, round.livePodPaths)
+	}
+
+	// only rounds in which all pods are up to date are cached, since skipped or failed pods may need
+	// to be reconciled later without any change of the calculation info
+	if interrupted || round.driftedPods > 0 || round.failedPods > 0 || len(round.skippedPodsByReason) > 0 {
+		p.getReconcileCache().invalidate(calculationInfo.CgroupPath)
+	}
+
+	if p.fullAuditRound && round.appliedPods > 0 {
+		general.Infof("full audit of %s corrected quota of %d pods, %d of which drifted from the last-applied quota",
+			calculationInfo.CgroupPath, round.appliedPods, round.driftedPods)
+	}
+
+	if qosLevel != "" {
+		return nil, nil
+	}
+
+	p.emitAppliedQuotaByQoSLevel(calculationInfo.CgroupPath, round.appliedQuotaByQoSLevel)
+	_ = p.emitter.StoreInt64(util.MetricNameQuotaReconcileDriftedPods, round.driftedPods, metrics.MetricTypeNameRaw,
+		metrics.ConvertMapToTags(map[string]string{
+			"cgroupPath": calculationInfo.CgroupPath,
+		})...)
+	_ = p.emitter.StoreInt64(util.MetricNameQuotaReconcileBackoffPods,
+		p.getPodFailureBackoff().backingOffPods(calculationInfo.CgroupPath, p.getClock().Now()), metrics.MetricTypeNameRaw,
+		metrics.ConvertMapToTags(map[string]string{
+			"cgroupPath": calculationInfo.CgroupPath,
+		})...)
+	return nil, nil
+}
+
+// checkAndApplyPodQuota applies quota to the pod of the pod dir and its containers, pods that fail to be applied
+// are skipped with errors logged, and the returned error aborts the current round.
+func (p *DynamicPolicy) checkAndApplyPodQuota(ctx context.Context, cgroupPath, podDir string, podsPathMap map[string]*v1.Pod,
+	bigGroupQuota int64, round *podQuotaRound,
+) (err error) {
+	ctx, span := p.getTracer().Start(ctx, "checkAndApplyPodQuota", trace.WithAttributes(attribute.String("podDir", podDir)))
+	defer func() { endSpanWithError(span, err) }()
+
+	pod, podRelativePath, err := p.getPodAndRelativePath(cgroupPath, podDir, podsPathMap)
+	if err != nil {
+		general.Warningf("getPodAndRelativePath error for pod dir %s: %v", podDir, err)
+		reason := podSkipReasonNoContainers
+		switch {
+		case errors.Is(err, ErrPodNotFound):
+			reason = podSkipReasonNotFound
+		case errors.Is(err, ErrPathEscape):
+			reason = podSkipReasonPathEscape
+		}
+		round.skippedPodsByReason[reason]++
+		span.SetAttributes(attribute.String("skipReason", reason))
+		return nil
+	}
+	round.livePodPaths[podRelativePath] = true
+	span.SetAttributes(attribute.String("pod", pod.Name), attribute.String("podRelativePath", podRelativePath))
+	// quota is derived from resources allocated by kubelet instead of the desired ones of in-place resizes
+	pod = p.getAllocatedPod(pod)
+
+	// applying quota to terminating pods is wasteful and races with the teardown of their cgroups
+	if pod.DeletionTimestamp != nil {
+		general.InfofV(4, "pod %s is terminating, skip applying its quota", pod.Name)
+		round.skippedPodsByReason[podSkipReasonTerminating]++
+		span.SetAttributes(attribute.String("skipReason", podSkipReasonTerminating))
+		return nil
+	}
+
+	// pods failing in consecutive rounds are retried after backoff, to avoid retrying and logging in every round;
+	// the backoff is reset once the pod is reconciled without failures
+	backoff := p.getPodFailureBackoff()
+	now := p.getClock().Now()
+	if backingOff, failures := backoff.isBackingOff(podRelativePath, now); backingOff {
+		general.InfofV(4, "pod %s failed in %d consecutive rounds and is backing off, skip applying its quota", pod.Name, failures)
+		round.skippedPodsByReason[podSkipReasonBackoff]++
+		span.SetAttributes(attribute.String("skipReason", podSkipReasonBackoff))
+		return nil
+	}
+	failedPods := round.failedPods
+	defer func() {
+		if err != nil || round.failedPods > failedPods {
+			conf := p.getQuotaReconcileConf()
+			backoff.recordFailure(podRelativePath, now, conf.PodFailureBackoffBase, conf.PodFailureBackoffMax)
+			return
+		}
+		backoff.recordSuccess(podRelativePath)
+	}()
+
+	if !p.isPodSelectedForQuotaReconcile(pod) {
+		general.InfofV(4, "pod %s doesn't match the pod label selector, skip applying its quota", pod.Name)
+		round.skippedPodsByReason[podSkipReasonLabelMismatch]++
+		span.SetAttributes(attribute.String("skipReason", podSkipReasonLabelMismatch))
+		return nil
+	}
+
+	if pod.Annotations[cpuconsts.PodAnnotationAdvisorDisabledKey] == "true" {
+		general.InfofV(4, "pod %s is opted out of quota reconcile by annotation %s, skip applying its quota",
+			pod.Name, cpuconsts.PodAnnotationAdvisorDisabledKey)
+		round.skippedPodsByReason[podSkipReasonOptedOut]++
+		span.SetAttributes(attribute.String("skipReason", podSkipReasonOptedOut))
+		return nil
+	}
+
+	// kubelet writes cgroups of pods with exclusive cpus of its static cpu manager policy,
+	// and fighting with it over their quota would only leave them flapping
+	if p.isPodOwnedByKubeletStaticPolicy(pod, round) {
+		general.InfofV(4, "pod %s has exclusive cpus of kubelet static cpu manager policy, skip applying its quota", pod.Name)
+		round.skippedPodsByReason[podSkipReasonKubeletStatic]++
+		span.SetAttributes(attribute.String("skipReason", podSkipReasonKubeletStatic))
+		return nil
+	}
+
+	if p.isPodInQuotaGracePeriod(pod) {
+		general.InfofV(4, "pod %s is in quota grace period, skip applying its quota", pod.Name)
+		round.skippedPodsByReason[podSkipReasonGracePeriod]++
+		span.SetAttributes(attribute.String("skipReason", podSkipReasonGracePeriod))
+		return nil
+	}
+
+	// the pod limit is always summed from its containers, since pod-level resources (Spec.Resources)
+	// are not available in the k8s.io/api version this module is pinned to
+	podLimit, ok := getPodCPULimit(pod)
+	limitSource := "cpu limits of containers"
+	if p.annotationQuotaFallback {
+		podLimit, ok = getPodAnnotationQuota(pod)
+		limitSource = fmt.Sprintf("annotation %s", cpuconsts.PodAnnotationQuotaKey)
+	}
+	if !ok {
+		general.Warningf("no cpu limit for pod %s from %s", pod.Name, limitSource)
+		round.skippedPodsByReason[podSkipReasonNoCPULimit]++
+		span.SetAttributes(attribute.String("skipReason", podSkipReasonNoCPULimit))
+		return nil
+	}
+	if usageLimit, ok := p.getPodUsagePercentileLimit(pod, podLimit); ok {
+		podLimit = usageLimit
+	}
+
+	podCpu, err := p.getCPUWithRelativePath(podRelativePath)
+	if err != nil {
+		return fmt.Errorf("%w: GetCPUWithRelativePath %s failed with error: %v", ErrCgroupRead, podRelativePath, err)
+	}
+
+	// the quota is derived in the period of the qos level of the pod if any, and the period is written along with it
+	podPeriod := podCpu.CpuPeriod
+	qosLevelPeriod, hasQoSLevelPeriod := p.getQoSLevelCPUPeriod(pod)
+	if hasQoSLevelPeriod {
+		podPeriod = qosLevelPeriod
+	}
+	// the quota is written to the pod cgroup as a whole rather than split across NUMA nodes, since cfs quota isn't
+	// NUMA-scoped and the pod may run on any cpu of its cpuset within it
+	podRealQuota := podLimit * int64(podPeriod) / 1000
+	// the pod quota should hold the floors of all its containers, otherwise they are capped by the pod
+	podFloorQuota := p.getPodQuotaFloor(pod, podPeriod)
+	if podRealQuota < podFloorQuota {
+		podRealQuota = podFloorQuota
+		p.emitQuotaApplyOutcome(quotaApplyOutcomeClamped)
+	}
+	podAtFloor := podFloorQuota > 0 && podRealQuota == podFloorQuota
+	podRealQuota = p.roundCPUQuota(podRealQuota)
+	// the current quota and the big group quota are compared in the period of the pod, so that effective cores
+	// are preserved when it differs from the period of the current cgroup or the one of advisor
+	podCurrentQuota := scaleCPUQuotaToPeriod(podCpu.CpuQuota, podCpu.CpuPeriod, podPeriod)
+	podPeriodChanged := podPeriod != podCpu.CpuPeriod
+	podBigGroupQuota := bigGroupQuota
+	if hasQoSLevelPeriod {
+		groupCPU, err := p.getCPUWithRelativePath(cgroupPath)
+		if err != nil {
+			return fmt.Errorf("%w: GetCPUWithRelativePath %s failed with error: %v", ErrCgroupRead, cgroupPath, err)
+		}
+		podBigGroupQuota = scaleCPUQuotaToPeriod(bigGroupQuota, groupCPU.CpuPeriod, podPeriod)
+	}
+	throttleRatio, throttleOK := p.checkPodThrottle(pod, podRelativePath)
+	p.checkPodQuotaStarvation(pod, podRelativePath, podAtFloor, throttleRatio, throttleOK)
+	if lastAppliedQuota, ok := p.getPodQuotaTracker().get(podRelativePath); ok && lastAppliedQuota != podCurrentQuota {
+		if p.isPodQuotaSettling(podRelativePath) {
+			// the read-back right after the write may be transient, so the last-applied quota is taken as the
+			// current one instead, and the drift check is deferred to the next round rather than waited for
+			general.InfofV(4, "quota of pod %s is read back as %d before it settles, defer the drift check from "+
+				"the last-applied %d to the next round", pod.Name, podCurrentQuota, lastAppliedQuota)
+			podCurrentQuota = lastAppliedQuota
+		} else {
+			general.Warningf("quota of pod %s drifts from the last-applied %d to %d", pod.Name, lastAppliedQuota, podCurrentQuota)
+			round.driftedPods++
+			span.SetAttributes(attribute.Int64("lastAppliedQuota", lastAppliedQuota))
+		}
+	}
+	span.SetAttributes(attribute.Int64("computedQuota", podRealQuota), attribute.Int64("currentQuota", podCurrentQuota))
+
+	// the desired quota is tracked before applying it, so that the lag covers rounds in which it fails to converge
+	if podRealQuota <= podBigGroupQuota {
+		p.observePodQuotaConvergence(podRelativePath, podRealQuota, podPeriod, podRealQuota == podCurrentQuota && !podPeriodChanged)
+	} else {
+		p.observePodQuotaConvergence(podRelativePath, -1, podCpu.CpuPeriod, podCpu.CpuQuota == -1)
+	}
+
+	if podRealQuota <= podBigGroupQuota {
+		if podRealQuota == podCurrentQuota && !podPeriodChanged {
+			// containers of an unchanged pod are left untouched, except that they are read back in full audit rounds
+			if p.fullAuditRound {
+				err = p.applyAllContainersQuota(ctx, pod, true, 1)
+				if err != nil {
+					general.Errorf("applyAllContainersQuota for pod %v failed with error: %v", pod.Name, err)
+					span.RecordError(err)
+					round.failedPods++
+					round.podErrors[podDir] = err
+					return nil
+				}
+			}
+
+			p.emitQuotaApplyOutcome(quotaApplyOutcomeSkippedIdempotent)
+			round.unchangedPods++
+			p.getPodQuotaTracker().record(podRelativePath, podRealQuota)
+			p.convergePodQuota(pod, podRelativePath)
+			p.logQuotaDecision(pod, podRelativePath, podRealQuota, podCurrentQuota, podRealQuota)
+			p.accumulateAppliedQuotaByQoSLevel(round.appliedQuotaByQoSLevel, pod, podRealQuota, podPeriod)
+			return nil
+		}
+
+		// containers follow the ramped quota of the pod, so that none of them exceeds the pod while it's ramping
+		podAppliedQuota := p.rampPodCPUQuota(pod, podCpu, podRealQuota, podPeriod)
+		applyContainers := func() bool {
+			err := p.applyAllContainersQuota(ctx, pod, true, getContainerQuotaRatio(podAppliedQuota, podRealQuota))
+			if err != nil {
+				general.Errorf("applyAllContainersQuota for pod %v failed with error: %v", pod.Name, err)
+				span.RecordError(err)
+				round.failedPods++
+				round.podErrors[podDir] = err
+				return false
+			}
+			return true
+		}
+
+		// cgroup v1 rejects a container quota beyond the one of its pod, so a widening pod is written before
+		// its containers and a shrinking one after them, with both quotas compared in the period of the pod
+		podWidened := isCPUQuotaWidened(podCurrentQuota, podAppliedQuota)
+		if !podWidened && !applyContainers() {
+			return nil
+		}
+
+		podData := &common.CPUData{CpuQuota: podAppliedQuota}
+		if podPeriodChanged {
+			podData.CpuPeriod = podPeriod
+		}
+		err = p.applyCPUQuotaWithRelativePath(ctx, podRelativePath, podData)
+		if err != nil {
+			return fmt.Errorf("ApplyCPUWithRelativePath %s to realQuota %v  failed with error: %w", podRelativePath, podRealQuota, err)
+		}
+		p.getPodQuotaTracker().record(podRelativePath, podData.CpuQuota)
+		p.getPodQuotaTracker().markWritten(podRelativePath, p.getClock().Now())
+		if podWidened && !applyContainers() {
+			return nil
+		}
+		// the quota may be ramped toward the desired one, and it converges only once the desired one is reached
+		if podData.CpuQuota == podRealQuota {
+			p.convergePodQuota(pod, podRelativePath)
+		}
+		round.appliedPods++
+		p.logQuotaDecision(pod, podRelativePath, podRealQuota, podCurrentQuota, podData.CpuQuota)
+		p.notifyQuotaChange(pod, podRelativePath, podCurrentQuota, podData.CpuQuota, podPeriod, quotaChangeReasonPodLimit)
+		p.accumulateAppliedQuotaByQoSLevel(round.appliedQuotaByQoSLevel, pod, podData.CpuQuota, podPeriod)
+		span.SetAttributes(attribute.Int64("appliedQuota", podData.CpuQuota))
+	} else {
+		err = p.applyAllContainersQuota(ctx, pod, false, 1)
+		if err != nil {
+			general.Errorf("applyAllContainersQuota for pod %v failed with error: %v", pod.Name, err)
+			span.RecordError(err)
+			round.failedPods++
+			round.podErrors[podDir] = err
+			return nil
+		}
+		podData := &common.CPUData{CpuQuota: p.rampPodCPUQuota(pod, podCpu, -1, podCpu.CpuPeriod)}
+		err = p.applyCPUQuotaWithRelativePath(ctx, podRelativePath, podData)
+		if err != nil {
+			return fmt.Errorf("ApplyCPUWithRelativePath %s to %d failed with error: %w", podRelativePath, podData.CpuQuota, err)
+		}
+		p.getPodQuotaTracker().record(podRelativePath, podData.CpuQuota)
+		p.getPodQuotaTracker().markWritten(podRelativePath, p.getClock().Now())
+		if podData.CpuQuota == -1 {
+			p.convergePodQuota(pod, podRelativePath)
+		}
+		round.appliedPods++
+		p.logQuotaDecision(pod, podRelativePath, podRealQuota, podCurrentQuota, podData.CpuQuota)
+		p.notifyQuotaChange(pod, podRelativePath, podCurrentQuota, podData.CpuQuota, podPeriod, quotaChangeReasonExceedsGroupQuota)
+		span.SetAttributes(attribute.Int64("appliedQuota", podData.CpuQuota))
+	}
+	return nil
+}
+
+// logQuotaDecision writes the quota decision of the pod to the decision log if it's enabled,
+// and failures are only logged since the decision log is merely for offline analysis.
+func (p *DynamicPolicy) logQuotaDecision(pod *v1.Pod, podRelativePath string, computedQuota, currentQuota, appliedQuota int64) {
+	if p.quotaDecisionLogger == nil {
+		return
+	}
+
+	err := p.quotaDecisionLogger.log(&quotaDecision{
+		Timestamp:     time.Now(),
+		PodUID:        string(pod.UID),
+		Path:          podRelativePath,
+		ComputedQuota: computedQuota,
+		AppliedQuota:  appliedQuota,
+		Delta:         appliedQuota - currentQuota,
+	})
+	if err != nil {
+		general.Warningf("log quota decision of pod %s failed with error: %v", pod.Name, err)
+	}
+}
+
+// notifyQuotaChange posts the quota change of the pod to the webhook if it's enabled and the change is significant,
+// i.e. it's from or to unlimited, or it's no less than the threshold; unchanged quotas are never posted.
+func (p *DynamicPolicy) notifyQuotaChange(pod *v1.Pod, podRelativePath string, oldQuota, newQuota int64, period uint64, reason string) {
+	if p.quotaWebhookSink == nil || oldQuota == newQuota {
+		return
+	}
+
+	if oldQuota > 0 && newQuota > 0 && period > 0 {
+		delta := newQuota - oldQuota
+		if delta < 0 {
+			delta = -delta
+		}
+		if delta*1000/int64(period) < p.getQuotaReconcileConf().WebhookChangeThresholdMilliCores {
+			return
+		}
+	}
+
+	if !p.quotaWebhookSink.notify(&quotaChangeEvent{
+		Timestamp: time.Now(),
+		Namespace: pod.Namespace,
+		PodName:   pod.Name,
+		PodUID:    string(pod.UID),
+		Path:      podRelativePath,
+		OldQuota:  oldQuota,
+		NewQuota:  newQuota,
+		Reason:    reason,
+	}) {
+		general.Warningf("quota webhook queue is full, drop the quota change event of pod %s", pod.Name)
+	}
+}
+
+// isPodSelectedForQuotaReconcile returns whether the pod matches the pod label selector of quota reconcile,
+// pods not selected are still regarded as live ones, so their cgroups are not cleaned up as stale ones.
+func (p *DynamicPolicy) isPodSelectedForQuotaReconcile(pod *v1.Pod) bool {
+	selector := p.getQuotaReconcileConf().PodLabelSelector
+	return selector == nil || selector.Empty() || selector.Matches(labels.Set(pod.Labels))
+}
+
+// cleanupStalePodQuotas prunes records of pod cgroups that have matched no live pod for several rounds,
+// and resets their quota to unlimited before the lingering cgroups are removed if it's configured.
+func (p *DynamicPolicy) cleanupStalePodQuotas(ctx context.Context, cgroupPath string, livePodPaths map[string]bool) {
+	tracker := p.getPodQuotaTracker()
+	for _, podRelativePath := range tracker.stalePaths(cgroupPath, livePodPaths) {
+		if p.getQuotaReconcileConf().ResetStalePodQuota &&
+			general.IsPathExists(p.getAbsCgroupPath(common.DefaultSelectedSubsys, podRelativePath)) {
+			if err := p.applyCPUQuotaWithRelativePath(ctx, podRelativePath, &common.CPUData{CpuQuota: -1}); err != nil {
+				general.Warningf("reset quota of stale pod cgroup %s failed with error: %v, retry in the next round", podRelativePath, err)
+				continue
+			}
+		}
+
+		general.InfofV(4, "prune quota record of stale pod cgroup %s", podRelativePath)
+		tracker.remove(podRelativePath)
+		p.getPodQuotaConvergenceTracker().remove(podRelativePath)
+	}
+}
+
+// isPodInQuotaGracePeriod returns true if the pod is created within the grace period,
+// newly-started pods are left unthrottled to speed up their initialization.
+func (p *DynamicPolicy) isPodInQuotaGracePeriod(pod *v1.Pod) bool {
+	gracePeriod := p.getQuotaReconcileConf().NewPodQuotaGracePeriod
+	if gracePeriod <= 0 || pod.CreationTimestamp.IsZero() {
+		return false
+	}
+	return time.Since(pod.CreationTimestamp.Time) < gracePeriod
+}
+
+// getPodQoSLevelTag returns the qos level of the pod to tag its metrics with, by which the cardinality of metrics is
+// bounded unlike by pod names, and it's unknown if the qos level can't be told.
+func (p *DynamicPolicy) getPodQoSLevelTag(pod *v1.Pod) string {
+	if p.qosConfig == nil {
+		return podQoSLevelUnknown
+	}
+	qosLevel, err := p.qosConfig.GetQoSLevelForPod(pod)
+	if err != nil {
+		return podQoSLevelUnknown
+	}
+	return qosLevel
+}
+
+// accumulateAppliedQuotaByQoSLevel adds the quota written to the pod cgroup in the given period, i.e. after it's
+// ramped or raised to the floors of containers, to the qos level of the pod in milli-cores.
+func (p *DynamicPolicy) accumulateAppliedQuotaByQoSLevel(appliedQuotaByQoSLevel map[string]int64, pod *v1.Pod, podQuota int64, podPeriod uint64) {
+	if p.qosConfig == nil || podPeriod == 0 {
+		return
+	}
+	qosLevel, err := p.qosConfig.GetQoSLevelForPod(pod)
+	if err != nil {
+		general.Warningf("get qos level for pod %s failed with error: %v", pod.Name, err)
+		return
+	}
+	appliedQuotaByQoSLevel[qosLevel] += podQuota * 1000 / int64(podPeriod)
+}
+
+// endSpanWithError ends the span and marks it as failed if err is not nil.
+func endSpanWithError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// emitSkippedPodsByReason emits the number of pods skipped in this round under the given cgroup path by reason.
+func (p *DynamicPolicy) emitSkippedPodsByReason(cgroupPath string, skippedPodsByReason map[string]int64) {
+	for reason, count := range skippedPodsByReason {
+		_ = p.emitter.StoreInt64(util.MetricNameQuotaReconcileSkippedPods, count, metrics.MetricTypeNameCount,
+			metrics.ConvertMapToTags(map[string]string{
+				"cgroupPath": cgroupPath,
+				"reason":     reason,
+			})...)
+	}
+}
+
+// emitAppliedQuotaByQoSLevel emits the applied quota of each qos level under the given cgroup path;
+// all qos levels are emitted (zero if no pod is counted) to overwrite values left by previous rounds.
+func (p *DynamicPolicy) emitAppliedQuotaByQoSLevel(cgroupPath string, appliedQuotaByQoSLevel map[string]int64) {
+	for _, qosLevel := range []string{
+		consts.PodAnnotationQoSLevelSharedCores,
+		consts.PodAnnotationQoSLevelDedicatedCores,
+		consts.PodAnnotationQoSLevelReclaimedCores,
+		consts.PodAnnotationQoSLevelSystemCores,
+	} {
+		_ = p.emitter.StoreInt64(util.MetricNameAppliedCPUQuotaMilliCores, appliedQuotaByQoSLevel[qosLevel],
+			metrics.MetricTypeNameRaw, metrics.ConvertMapToTags(map[string]string{
+				"cgroupPath": cgroupPath,
+				"qosLevel":   qosLevel,
+			})...)
+	}
+}
+
+// getAllDirs returns directories (relative to parentPath) that may be pod cgroup directories;
+// if the search depth is more than one, only pod-like directories are returned,
+// and other directories are descended into until the depth is reached.
+func (p *DynamicPolicy) getAllDirs(parentPath string) ([]string, error) {
+	depth := p.getQuotaReconcileConf().PodDirSearchDepth
+	if depth > maxPodDirSearchDepth {
+		general.Warningf("pod dir search depth %d exceeds the limit, use %d instead", depth, maxPodDirSearchDepth)
+		depth = maxPodDirSearchDepth
+	}
+
+	if depth > 1 {
+		return p.getAllPodDirs(parentPath, "", depth, make(map[string]bool))
+	}
+
+	entries, err := os.ReadDir(parentPath)
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := make([]string, 0)
+
+	for _, entry := range entries {
+		if isDirEntry(parentPath, entry) {
+			dirs = append(dirs, entry.Name())
+		}
+	}
+
+	return dirs, nil
+}
+
+// isDirEntry returns whether the entry under dir is a directory, symlinks (e.g. in some systemd layouts) are
+// resolved to their targets, and broken or self-referential ones are not regarded as directories.
+func isDirEntry(dir string, entry os.DirEntry) bool {
+	if entry.Type()&os.ModeSymlink == 0 {
+		return entry.IsDir()
+	}
+
+	info, err := os.Stat(filepath.Join(dir, entry.Name()))
+	if err != nil {
+		general.Warningf("resolve symlink %s failed with error: %v", filepath.Join(dir, entry.Name()), err)
+		return false
+	}
+	return info.IsDir()
+}
+
+// getAllPodDirs returns pod cgroup directories under parentPath/relativeDir within the given depth;
+// pod directories are not descended into, since their sub-directories are container cgroups,
+// and visited records resolved directories already returned or descended into, so that symlinks to them are skipped.
+func (p *DynamicPolicy) getAllPodDirs(parentPath, relativeDir string, depth int, visited map[string]bool) ([]string, error) {
+	currentDir := filepath.Join(parentPath, relativeDir)
+	if isVisitedDir(currentDir, visited) {
+		return nil, nil
+	}
+
+	entries, err := os.ReadDir(currentDir)
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := make([]string, 0)
+
+	for _, entry := range entries {
+		if !isDirEntry(currentDir, entry) {
+			continue
+		}
+
+		dir := filepath.Join(relativeDir, entry.Name())
+		if strings.HasPrefix(entry.Name(), common.PodCgroupPathPrefix) {
+			if !isVisitedDir(filepath.Join(parentPath, dir), visited) {
+				dirs = append(dirs, dir)
+			}
+			continue
+		}
+
+		if depth > 1 {
+			subDirs, err := p.getAllPodDirs(parentPath, dir, depth-1, visited)
+			if err != nil {
+				general.Warningf("get pod dirs under %s failed with error: %v", filepath.Join(parentPath, dir), err)
+				continue
+			}
+			dirs = append(dirs, subDirs...)
+		}
+	}
+
+	return dirs, nil
+}
+
+// isVisitedDir returns whether the resolved dir is already visited, and marks it as visited otherwise.
+func isVisitedDir(dir string, visited map[string]bool) bool {
+	resolvedDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return false
+	}
+
+	if visited[resolvedDir] {
+		general.InfofV(4, "%s resolved to %s is already visited, skip it", dir, resolvedDir)
+		return true
+	}
+	visited[resolvedDir] = true
+	return false
+}
+
+func (p *DynamicPolicy) getAllPodsPathMap() (map[string]*v1.Pod, error) {
+	pods, err := p.metaServer.GetPodList(context.Background(), native.PodIsActive)
+	if err != nil {
+		return nil, fmt.Errorf("GetPodList failed with error: %v", err)
+	}
+
+	podAbsPathMap := make(map[string]*v1.Pod)
+	livePodUIDs := make(map[string]bool)
+
+	for _, pod := range pods {
+		if pod == nil {
+			continue
+		}
+		livePodUIDs[string(pod.UID)] = true
+		podAbsPath, err := p.getPodAbsCgroupPath(common.DefaultSelectedSubsys, string(pod.UID))
+		if err != nil {
+			general.Errorf("get pod %s absolute path failed with error: %v", pod.Name, err)
+			continue
+		}
+		podAbsPathMap[podAbsPath] = pod
+	}
+
+	p.getContainerPathCache().prune(livePodUIDs)
+	p.podPathMap = podAbsPathMap
+	return podAbsPathMap, nil
+}
+
+// getAbsCgroupPath returns the absolute cgroup path of the relative one, which is under the cgroup root override if set,
+// and in the hierarchy serving the subsystem in hybrid mode.
+func (p *DynamicPolicy) getAbsCgroupPath(subsys, relativePath string) string {
+	if p.cgroupRootOverride == "" {
+		return common.GetAbsCgroupPath(subsys, relativePath)
+	}
+
+	root, _ := common.GetSubsysCgroupRoot(p.cgroupRootOverride, subsys)
+	return filepath.Join(root, relativePath)
+}
+
+// isSubsysOnCgroupV2 returns whether the subsystem is served by a cgroup v2 hierarchy, either in unified mode
+// or by the unified hierarchy in hybrid mode, and it's detected under the cgroup root override if set.
+func (p *DynamicPolicy) isSubsysOnCgroupV2(subsys string) bool {
+	_, v2 := common.GetSubsysCgroupRoot(p.getCgroupMountPoint(), subsys)
+	return v2
+}
+
+// getPodAbsCgroupPath returns the absolute cgroup path of the pod under any kubernetes cgroup root that exists,
+// which is under the cgroup root override if set.
+func (p *DynamicPolicy) getPodAbsCgroupPath(subsys, podUID string) (string, error) {
+	if p.cgroupRootOverride == "" {
+		return common.GetPodAbsCgroupPath(subsys, podUID)
+	}
+
+	podDir := common.PodCgroupPathPrefix + podUID
+	for _, rootPath := range common.GetKubernetesCgroupRootPaths() {
+		podAbsPath := p.getAbsCgroupPath(subsys, filepath.Join(rootPath, podDir))
+		if general.IsPathExists(podAbsPath) {
+			return podAbsPath, nil
+		}
+	}
+	return "", fmt.Errorf("failed to find absolute path of %s under cgroup root %s", podDir, p.cgroupRootOverride)
+}
+
+// getContainerRelativeCgroupPath returns the relative cgroup path of the container, and it's looked up with the
+// default layout of kubernetes under the cgroup root override if set.
+func (p *DynamicPolicy) getContainerRelativeCgroupPath(podUID, containerID string) (string, error) {
+	if p.cgroupRootOverride == "" {
+		return common.GetContainerRelativeCgroupPath(podUID, containerID)
+	}
+
+	containerDir := filepath.Join(common.PodCgroupPathPrefix+podUID, containerID)
+	for _, rootPath := range common.GetKubernetesCgroupRootPaths() {
+		relativePath := filepath.Join(rootPath, containerDir)
+		if general.IsPathExists(p.getAbsCgroupPath(common.DefaultSelectedSubsys, relativePath)) {
+			return relativePath, nil
+		}
+	}
+	return "", fmt.Errorf("failed to find relative path of %s under cgroup root %s", containerDir, p.cgroupRootOverride)
+}
+
+func (p *DynamicPolicy) getPodAndRelativePath(currentCgroupPath string, podDir string, podsPathMap map[string]*v1.Pod) (*v1.Pod, string, error) {
+	podRelativePath, err := joinPathWithinRoot(currentCgroupPath, podDir)
+	if err != nil {
+		return nil, "", err
+	}
+	podAbsPath := p.getAbsCgroupPath(common.DefaultSelectedSubsys, podRelativePath)
+	pod, ok := podsPathMap[podAbsPath]
+	if !ok || pod == nil {
+		return nil, "", fmt.Errorf("%w: can not get pod with abs path: %s", ErrPodNotFound, podAbsPath)
+	}
+
+	if len(pod.Spec.Containers) == 0 {
+		return nil, "", fmt.Errorf("%w: %s", ErrPodHasNoContainers, pod.Name)
+	}
+
+	return pod, podRelativePath, nil
+}
+
+// joinPathWithinRoot joins the sub path to the root, and rejects the joined path if it
+// is not strictly under the root after being cleaned, e.g. sub paths with "..".
+func joinPathWithinRoot(root, subPath string) (string, error) {
+	cleanedRoot := filepath.Clean(root)
+	joinedPath := filepath.Join(cleanedRoot, subPath)
+
+	rootPrefix := cleanedRoot
+	if !strings.HasSuffix(rootPrefix, string(filepath.Separator)) {
+		rootPrefix += string(filepath.Separator)
+	}
+	if !strings.HasPrefix(joinedPath, rootPrefix) {
+		return "", fmt.Errorf("%w: %s joined with %s", ErrPathEscape, root, subPath)
+	}
+	return joinedPath, nil
+}
+
+func (p *DynamicPolicy) getCurrentPathAllPodsDirAndMap(currentCgroupPath string) (map[string]*v1.Pod, []string, error) {
+	podsPathMap, err := p.getAllPodsPathMap()
+	if err != nil {
+		return nil, nil, fmt.Errorf("getAllPodsPathMap failed with error: %v", err)
+	}
+	absPath := p.getAbsCgroupPath(common.DefaultSelectedSubsys, currentCgroupPath)
+	podDirs, err := p.getAllDirs(absPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("getAllPodsPath failed with error: %v", err)
+	}
+
+	// pods are processed in the order of their dirs, which is sorted so that rounds and logs are reproducible
+	// and pods given the remaining quota first are always the same ones
+	sort.Strings(podDirs)
+	return podsPathMap, podDirs, nil
+}
+
+// getAllContainersRelativePathMap returns the containers of the pod keyed by their relative cgroup paths,
+// including app containers, restartable init containers, and ephemeral containers if it's configured.
+func (p *DynamicPolicy) getAllContainersRelativePathMap(pod *v1.Pod) map[string]*v1.Container {
+	containerPathMap := make(map[string]*v1.Container)
+
+	for _, container := range pod.Spec.Containers {
+		// Create a copy of the container to avoid the loop variable capture issue
+		containerCopy := container
+
+		containerID, err := native.GetContainerID(pod, container.Name)
+		if err != nil {
+			general.Errorf("get container %s container id failed with error: %v", container.Name, err)
+			continue
+		}
+		p.addContainerRelativePath(containerPathMap, pod, &containerCopy, containerID)
+	}
+
+	for _, container := range pod.Spec.InitContainers {
+		containerCopy := container
+
+		status := findContainerStatus(pod.Status.InitContainerStatuses, container.Name)
+		if status == nil || !isRestartableInitContainer(pod, status) {
+			continue
+		}
+		p.addContainerRelativePath(containerPathMap, pod, &containerCopy, native.TrimContainerIDPrefix(status.ContainerID))
+	}
+
+	if p.getQuotaReconcileConf().IncludeEphemeralContainers {
+		for _, ephemeralContainer := range pod.Spec.EphemeralContainers {
+			container := v1.Container(ephemeralContainer.EphemeralContainerCommon)
+
+			status := findContainerStatus(pod.Status.EphemeralContainerStatuses, container.Name)
+			if status == nil || status.ContainerID == "" {
+				general.Errorf("get ephemeral container %s container id failed: not found in container statuses", container.Name)
+				continue
+			}
+			p.addContainerRelativePath(containerPathMap, pod, &container, native.TrimContainerIDPrefix(status.ContainerID))
+		}
+	}
+
+	return containerPathMap
+}
+
+// addContainerRelativePath adds the container keyed by its relative cgroup path, and the path is resolved
+// afresh if it's not cached or the container is restarted with a new container id.
+func (p *DynamicPolicy) addContainerRelativePath(containerPathMap map[string]*v1.Container, pod *v1.Pod,
+	container *v1.Container, containerID string,
+) {
+	cache := p.getContainerPathCache()
+	containerRelativeCgroupPath, ok := cache.get(string(pod.UID), container.Name, containerID)
+	if !ok {
+		var err error
+		containerRelativeCgroupPath, err = p.getContainerRelativeCgroupPath(string(pod.UID), containerID)
+		if err != nil {
+			general.Errorf("get container %s relative cgroup path failed with error: %v", container.Name, err)
+			return
+		}
+		cache.set(string(pod.UID), container.Name, containerID, containerRelativeCgroupPath)
+	}
+
+	containerPathMap[containerRelativeCgroupPath] = container
+}
+
+// isRestartableInitContainer returns whether the init container is a restartable one, i.e. a sidecar.
+// Container.RestartPolicy is unavailable in the vendored api, so it's told by status instead: regular init
+// containers must complete before app containers start, and only restartable ones keep running after that.
+func isRestartableInitContainer(pod *v1.Pod, initContainerStatus *v1.ContainerStatus) bool {
+	if initContainerStatus.State.Running == nil || initContainerStatus.ContainerID == "" {
+		return false
+	}
+
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Running != nil || status.State.Terminated != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func findContainerStatus(statuses []v1.ContainerStatus, containerName string) *v1.ContainerStatus {
+	for i := range statuses {
+		if statuses[i].Name == containerName {
+			return &statuses[i]
+		}
+	}
+	return nil
+}
+
+// isContainerCgroupPathPending returns whether the cgroup of the container is not created yet under the existing
+// pod cgroup, which is common during the container startup, so that it's skipped until the next round instead of
+// failing the write deep in the kernel. Cgroups of pods gone as a whole are still left to fail the write.
+func (p *DynamicPolicy) isContainerCgroupPathPending(relativePath string) bool {
+	absCgroupPath := p.getAbsCgroupPath(common.CgroupSubsysCPU, relativePath)
+	podAbsCgroupPath := filepath.Dir(absCgroupPath)
+	return strings.HasPrefix(filepath.Base(podAbsCgroupPath), common.PodCgroupPathPrefix) &&
+		!general.IsPathExists(absCgroupPath) && general.IsPathExists(podAbsCgroupPath)
+}
+
+// applyAllContainersQuota applies quotas of all containers in the pod, which are their limits if setToLimit, or
+// unlimited otherwise. Quotas set to limits are scaled by quotaRatio to follow the ramped quota of the pod.
+func (p *DynamicPolicy) applyAllContainersQuota(ctx context.Context, pod *v1.Pod, setToLimit bool, quotaRatio float64) error {
+	allContainersRelativePathMap := make(map[string]*v1.Container)
+	for relativePath, container := range p.getAllContainersRelativePathMap(pod) {
+		if !p.isContainerCgroupPathPending(relativePath) {
+			allContainersRelativePathMap[relativePath] = container
+			continue
+		}
+		general.InfofV(4, "cgroup path %s of container %s/%s doesn't exist yet, skip applying quota", relativePath, pod.Name, container.Name)
+		_ = p.emitter.StoreInt64(util.MetricNameCgroupPathNotFound, 1, metrics.MetricTypeNameRaw,
+			metrics.ConvertMapToTags(map[string]string{
+				"qosLevel": p.getPodQoSLevelTag(pod),
+			})...)
+		p.emitQuotaApplyOutcome(quotaApplyOutcomeSkippedPathMissing)
+	}
+
+	var weightedLimits map[string]int64
+	if setToLimit && p.getQuotaReconcileConf().UsageWeightedContainerQuota {
+		weightedLimits = p.getUsageWeightedContainerLimits(pod, allContainersRelativePathMap)
+	}
+	qosLevelPeriod, hasQoSLevelPeriod := p.getQoSLevelCPUPeriod(pod)
+
+	for relativePath, container := range allContainersRelativePathMap {
+		limit := container.Resources.Limits.Cpu().MilliValue() // Value() will lose precision of data
+		if weightedLimit, ok := weightedLimits[relativePath]; ok {
+			limit = weightedLimit
+		}
+		containerCpu, err := p.getCPUWithRelativePath(relativePath)
+		if err != nil {
+			return fmt.Errorf("GetCPUWithRelativePath %s failed with error: %v", relativePath, err)
+		}
+		period := containerCpu.CpuPeriod
+		if hasQoSLevelPeriod {
+			period = qosLevelPeriod
+		}
+		if setToLimit && p.getContainerCPURequest(pod, container) <= 0 {
+			conf := p.getQuotaReconcileConf()
+			switch conf.ZeroRequestContainerPolicy {
+			case quotareconcile.ZeroRequestContainerPolicySkip:
+				general.InfofV(4, "container %s/%s has no cpu request, skip applying its quota", pod.Name, container.Name)
+				continue
+			case quotareconcile.ZeroRequestContainerPolicyUnlimited:
+				// sub cgroups are unlimited first as in the unlimited branch below, even if the container already is
+				if err := p.applyAllSubCgroupQuotaToUnLimit(ctx, relativePath); err != nil {
+					return fmt.Errorf("applyAllSubCgroupQuotaToUnLimit %s failed with error: %v", relativePath, err)
+				}
+				if containerCpu.CpuQuota == -1 {
+					p.emitQuotaApplyOutcome(quotaApplyOutcomeSkippedIdempotent)
+					continue
+				}
+				general.InfofV(4, "container %s/%s has no cpu request, apply its quota to unlimited", pod.Name, container.Name)
+				if err := p.applyCPUQuotaWithRelativePath(ctx, relativePath, &common.CPUData{CpuQuota: -1}); err != nil {
+					return fmt.Errorf("ApplyCPUWithRelativePath %s to -1 failed with error: %v", relativePath, err)
+				}
+				continue
+			case quotareconcile.ZeroRequestContainerPolicyDefault:
+				// the default quota never exceeds the cpu limit of the container if it has one
+				limit = conf.ZeroRequestContainerDefaultQuotaMilliCores
+				if containerLimit := container.Resources.Limits.Cpu().MilliValue(); containerLimit > 0 {
+					limit = general.MinInt64(limit, containerLimit)
+				}
+			}
+		}
+		realQuota := limit * int64(period) / 1000
+		if setToLimit {
+			if exclusiveCores := getExclusiveCPUCores(pod, container); exclusiveCores > 0 {
+				// the quota matches the exclusive cores exactly, so that it never limits the container below its cpuset
+				realQuota = exclusiveCores * int64(period)
+			} else if floorQuota := p.getContainerQuotaFloor(pod, container, period); realQuota < floorQuota {
+				general.InfofV(4, "quota %d of container %s/%s is clamped to floor %d", realQuota, pod.Name, container.Name, floorQuota)
+				_ = p.emitter.StoreInt64(util.MetricNameContainerQuotaFloorClamped, 1, metrics.MetricTypeNameCount,
+					metrics.ConvertMapToTags(map[string]string{
+						"qosLevel": p.getPodQoSLevelTag(pod),
+					})...)
+				realQuota = floorQuota
+				p.emitQuotaApplyOutcome(quotaApplyOutcomeClamped)
+			}
+			realQuota = p.roundCPUQuota(realQuota)
+			if quotaRatio > 0 && quotaRatio != 1 {
+				realQuota = int64(float64(realQuota) * quotaRatio)
+			}
+			if realQuota == containerCpu.CpuQuota && period == containerCpu.CpuPeriod {
+				p.emitQuotaApplyOutcome(quotaApplyOutcomeSkippedIdempotent)
+				continue
+			}
+			data := &common.CPUData{CpuQuota: realQuota}
+			if period != containerCpu.CpuPeriod {
+				data.CpuPeriod = period
+			}
+			err := p.applyCPUQuotaWithRelativePath(ctx, relativePath, data)
+			if err != nil {
+				return fmt.Errorf("ApplyCPUWithRelativePath %s to %v failed with error: %v", relativePath, realQuota, err)
+			}
+			if p.fullAuditRound {
+				general.Infof("full audit corrected quota of container %s/%s from %d to %d",
+					pod.Name, container.Name, containerCpu.CpuQuota, realQuota)
+			}
+		} else {
+			err := p.applyAllSubCgroupQuotaToUnLimit(ctx, relativePath)
+			if err != nil {
+				return fmt.Errorf("applyAllSubCgroupQuotaToUnLimit %s failed with error: %v", relativePath, err)
+			}
+			err = p.applyCPUQuotaWithRelativePath(ctx, relativePath, &common.CPUData{CpuQuota: -1})
+			if err != nil {
+				return fmt.Errorf("ApplyCPUWithRelativePath %s to -1 failed with error: %v", relativePath, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// getUsageWeightedContainerLimits returns the limits (in milli-cores) of containers keyed by their relative cgroup
+// paths, which sum up to the limits of all the containers: each container is given its floor, and the rest is
+// distributed in proportion to cpu usage of the containers. Containers with exclusive cores are left out and keep
+// their own limits. Each container keeps at least its usage plus the safety margin, clamped to its own limit, so that
+// containers whose share is squeezed by busy neighbours still have headroom for spikes. nil is returned if there are
+// fewer than two containers left, any of them has no cpu limit, or usage of any of them is unavailable, and then
+// containers are applied with their own limits instead.
+func (p *DynamicPolicy) getUsageWeightedContainerLimits(pod *v1.Pod, containerPathMap map[string]*v1.Container) map[string]int64 {
+	if len(containerPathMap) < 2 || p.metaServer == nil || p.metaServer.MetricsFetcher == nil {
+		return nil
+	}
+
+	var totalLimit, totalFloor int64
+	var totalUsage float64
+	floors := make(map[string]int64, len(containerPathMap))
+	usages := make(map[string]float64, len(containerPathMap))
+	ownLimits := make(map[string]int64, len(containerPathMap))
+	for relativePath, container := range containerPathMap {
+		if getExclusiveCPUCores(pod, container) > 0 {
+			// containers with exclusive cores keep their limits, and the rest are distributed among the others
+			continue
+		}
+
+		limit := container.Resources.Limits.Cpu().MilliValue()
+		if limit <= 0 {
+			return nil
+		}
+
+		usage, err := p.metaServer.GetContainerMetric(string(pod.UID), container.Name, coreconsts.MetricCPUUsageContainer)
+		if err != nil || usage.Value < 0 {
+			general.InfofV(4, "cpu usage of container %s/%s is unavailable, distribute quota by limits: %v", pod.Name, container.Name, err)
+			return nil
+		}
+
+		// floor with a period of 1000 is in milli-cores
+		floors[relativePath] = p.getContainerQuotaFloor(pod, container, 1000)
+		usages[relativePath] = usage.Value
+		ownLimits[relativePath] = limit
+		totalLimit += limit
+		totalFloor += floors[relativePath]
+		totalUsage += usage.Value
+	}
+
+	if len(usages) < 2 || totalUsage <= 0 {
+		return nil
+	}
+
+	rest := general.MaxInt64(totalLimit-totalFloor, 0)
+	marginPercent := p.getQuotaReconcileConf().UsageQuotaSafetyMarginPercent
+	limits := make(map[string]int64, len(usages))
+	for relativePath := range usages {
+		limits[relativePath] = floors[relativePath] + int64(float64(rest)*usages[relativePath]/totalUsage)
+		if marginPercent <= 0 {
+			continue
+		}
+
+		// usage is in cores
+		headroom := int64(math.Round(usages[relativePath] * 1000 * (100 + marginPercent) / 100))
+		if headroom = general.MinInt64(headroom, ownLimits[relativePath]); headroom > limits[relativePath] {
+			general.InfofV(4, "usage-weighted quota %d of container %s is raised to %d with safety margin %v%%",
+				limits[relativePath], relativePath, headroom, marginPercent)
+			limits[relativePath] = headroom
+		}
+	}
+	general.InfofV(4, "distribute quota of pod %s among containers by usage: %v", pod.Name, limits)
+	return limits
+}
+
+// getExclusiveCPUCores returns the number of cores exclusively allocated to the container via cpuset, i.e. the
+// integer cpu request of a container in a guaranteed pod, and zero means the container has no exclusive cores.
+func getExclusiveCPUCores(pod *v1.Pod, container *v1.Container) int64 {
+	if v1qos.GetPodQOS(pod) != v1.PodQOSGuaranteed {
+		return 0
+	}
+
+	request := container.Resources.Requests.Cpu()
+	if request.MilliValue() <= 0 || request.MilliValue()%1000 != 0 {
+		return 0
+	}
+	return request.MilliValue() / 1000
+}
+
+// getContainerQuotaFloor returns the minimum quota with the given cfs period that can be applied to the container,
+// it protects the container from being starved by aggressive down-sizing; zero means no floor. The floor never
+// exceeds the cpu limit of the container if it has one, so that the container is never given more than its limit.
+func (p *DynamicPolicy) getContainerQuotaFloor(pod *v1.Pod, container *v1.Container, period uint64) int64 {
+	conf := p.getQuotaReconcileConf()
+	floorMilliCores := conf.ContainerQuotaFloorMilliCores
+	if conf.ContainerQuotaFloorRequestRatio > 0 {
+		if requestFloor := int64(float64(p.getContainerCPURequest(pod, container)) * conf.ContainerQuotaFloorRequestRatio); requestFloor > floorMilliCores {
+			floorMilliCores = requestFloor
+		}
+	}
+	if limit := container.Resources.Limits.Cpu().MilliValue(); limit > 0 {
+		floorMilliCores = general.MinInt64(floorMilliCores, limit)
+	}
+	return floorMilliCores * int64(period) / 1000
+}
+
+// getContainerCPURequest returns the cpu request (in milli-cores) of the container, and a container without an
+// explicit one takes the default request of the container LimitRange in the namespace of the pod, or its default
+// limit if it has no default request, as the LimitRanger admission would have defaulted it to; zero means the
+// container has no cpu request at all.
+func (p *DynamicPolicy) getContainerCPURequest(pod *v1.Pod, container *v1.Container) int64 {
+	if request, ok := container.Resources.Requests[v1.ResourceCPU]; ok {
+		return request.MilliValue()
+	}
+	if p.metaServer == nil || p.metaServer.MetaAgent == nil || p.metaServer.LimitRangeFetcher == nil {
+		return 0
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), limitRangeFetchTimeout)
+	defer cancel()
+	limitRanges, err := p.metaServer.GetLimitRanges(ctx, pod.Namespace)
+	if err != nil {
+		general.Warningf("get limit ranges of namespace %s failed with error: %v, the cpu request of container %s/%s is regarded as zero",
+			pod.Namespace, err, pod.Name, container.Name)
+		return 0
+	}
+
+	for _, limitRange := range limitRanges {
+		for _, item := range limitRange.Spec.Limits {
+			if item.Type != v1.LimitTypeContainer {
+				continue
+			}
+			if request, ok := item.DefaultRequest[v1.ResourceCPU]; ok {
+				return request.MilliValue()
+			}
+			if limit, ok := item.Default[v1.ResourceCPU]; ok {
+				return limit.MilliValue()
+			}
+		}
+	}
+	return 0
+}
+
+// roundCPUQuota rounds the positive quota to whole ticks by the rounding policy, and unlimited quota is kept as is.
+func (p *DynamicPolicy) roundCPUQuota(quota int64) int64 {
+	if quota <= 0 {
+		return quota
+	}
+
+	var rounded int64
+	switch p.getQuotaReconcileConf().QuotaRoundingPolicy {
+	case quotareconcile.QuotaRoundingPolicyUp:
+		rounded = (quota + cpuQuotaTickUs - 1) / cpuQuotaTickUs * cpuQuotaTickUs
+	case quotareconcile.QuotaRoundingPolicyNearest:
+		rounded = (quota + cpuQuotaTickUs/2) / cpuQuotaTickUs * cpuQuotaTickUs
+		// quota is never rounded down to zero, which would mean no quota at all
+		if rounded == 0 {
+			rounded = cpuQuotaTickUs
+		}
+	default:
+		return quota
+	}
+
+	if rounded != quota {
+		general.InfofV(5, "round quota %d to %d", quota, rounded)
+	}
+	return rounded
+}
+
+// getQoSLevelCPUPeriod returns the cfs period (in microseconds) configured for the qos level of the pod,
+// and false is returned if no period is configured for it, in which case the period of its cgroup is kept.
+func (p *DynamicPolicy) getQoSLevelCPUPeriod(pod *v1.Pod) (uint64, bool) {
+	periods := p.getQuotaReconcileConf().QoSLevelCPUPeriods
+	if len(periods) == 0 {
+		return 0, false
+	}
+
+	qosLevel, err := p.qosConfig.GetQoSLevelForPod(pod)
+	if err != nil {
+		general.Warningf("get qos level for pod %s failed with error: %v", pod.Name, err)
+		return 0, false
+	}
+	period, ok := periods[qosLevel]
+	if !ok {
+		return 0, false
+	}
+	return uint64(period.Microseconds()), true
+}
+
+// getPodQuotaFloor returns the sum of quota floors of all app containers and restartable init containers in the pod.
+func (p *DynamicPolicy) getPodQuotaFloor(pod *v1.Pod, period uint64) int64 {
+	var floorQuota int64
+	for i := range pod.Spec.Containers {
+		floorQuota += p.getContainerQuotaFloor(pod, &pod.Spec.Containers[i], period)
+	}
+	for i := range pod.Spec.InitContainers {
+		if isRestartableInitContainerOfPod(pod, &pod.Spec.InitContainers[i]) {
+			floorQuota += p.getContainerQuotaFloor(pod, &pod.Spec.InitContainers[i], period)
+		}
+	}
+	return floorQuota
+}
+
+// getPodCPULimit returns the cpu limit (in milli-cores) of the pod, and false if no container has a cpu limit.
+// Restartable init containers run alongside app containers, so their limits are summed with the ones of app
+// containers as kubernetes does, while regular init containers run one by one before app containers, so only
+// the max of their limits bounds the pod. The pod overhead is added on top if any.
+func getPodCPULimit(pod *v1.Pod) (int64, bool) {
+	var sumMilliCores, maxInitMilliCores int64
+	found := false
+	for i := range pod.Spec.Containers {
+		if limit, ok := pod.Spec.Containers[i].Resources.Limits[v1.ResourceCPU]; ok {
+			sumMilliCores += limit.MilliValue()
+			found = true
+		}
+	}
+
+	for i := range pod.Spec.InitContainers {
+		container := &pod.Spec.InitContainers[i]
+		limit, ok := container.Resources.Limits[v1.ResourceCPU]
+		if !ok {
+			continue
+		}
+
+		found = true
+		if isRestartableInitContainerOfPod(pod, container) {
+			sumMilliCores += limit.MilliValue()
+		} else if limit.MilliValue() > maxInitMilliCores {
+			maxInitMilliCores = limit.MilliValue()
+		}
+	}
+
+	if !found {
+		return 0, false
+	}
+	if maxInitMilliCores > sumMilliCores {
+		sumMilliCores = maxInitMilliCores
+	}
+	if overhead, ok := pod.Spec.Overhead[v1.ResourceCPU]; ok {
+		sumMilliCores += overhead.MilliValue()
+	}
+	return sumMilliCores, true
+}
+
+// isRestartableInitContainerOfPod returns whether the init container of the pod is a restartable one by its status.
+func isRestartableInitContainerOfPod(pod *v1.Pod, initContainer *v1.Container) bool {
+	status := findContainerStatus(pod.Status.InitContainerStatuses, initContainer.Name)
+	return status != nil && isRestartableInitContainer(pod, status)
+}
+
+// applyCPUQuotaWithRelativePath applies cpu data to the given relative cgroup path,
+// and the write is skipped if the cgroup write breaker is open in the current round.
+// If quota ramp is enabled, the quota is moved toward the target by at most a step in a round, and increases are
+// limited by the max increase step as well; data.CpuQuota is updated to the quota actually applied.
+func (p *DynamicPolicy) applyCPUQuotaWithRelativePath(ctx context.Context, relativePath string, data *common.CPUData) (err error) {
+	_, span := p.getTracer().Start(ctx, "applyCPUQuotaWithRelativePath", trace.WithAttributes(
+		attribute.String("relativePath", relativePath),
+		attribute.Int64("quota", data.CpuQuota),
+	))
+	defer func() { endSpanWithError(span, err) }()
+
+	if err = p.checkCgroupWritesAllowed(); err != nil {
+		return err
+	}
+
+	if p.simulation != nil {
+		return p.simulation.recordWithRelativePath(relativePath, data, p.getCPUWithRelativePath)
+	}
+
+	if err = p.captureKnob(knobCPU, relativePath, p.capturePriorCPU); err != nil {
+		return err
+	}
+	p.captureCPUStats(relativePath)
+	p.cpuCgroupWrites++
+	err = p.runCgroupWrite(ctx, relativePath, func() error {
+		return p.applyCPUWithRelativePath(relativePath, data)
+	})
+	if err != nil {
+		err = fmt.Errorf("%w: %v", ErrCgroupWrite, err)
+		p.emitQuotaApplyOutcome(quotaApplyOutcomeFailed)
+	} else {
+		p.emitQuotaApplyOutcome(quotaApplyOutcomeAppliedChanged)
+	}
+	p.recordCgroupWrite(relativePath, err)
+	return err
+}
+
+// emitQuotaApplyOutcome counts an outcome of quota applies, so that write amplification and failure rates can be told.
+func (p *DynamicPolicy) emitQuotaApplyOutcome(outcome string) {
+	_ = p.emitter.StoreInt64(util.MetricNameQuotaApplyOutcome, 1, metrics.MetricTypeNameCount,
+		metrics.ConvertMapToTags(map[string]string{
+			"outcome": outcome,
+		})...)
+}
+
+// emitCPUCgroupWrites emits the number of cpu cgroup writes actually issued in the round, which tells the write
+// amplification apart from the logical applies of quota_apply_outcome, so that idempotency savings are measurable.
+func (p *DynamicPolicy) emitCPUCgroupWrites() {
+	_ = p.emitter.StoreInt64(util.MetricNameCPUCgroupWrites, p.cpuCgroupWrites, metrics.MetricTypeNameRaw)
+}
+
+// beginReconcileTransaction starts recording prior cpu stats of cgroups changed in this reconcile.
+func (p *DynamicPolicy) beginReconcileTransaction() {
+	p.reconcileTransaction = newReconcileTransaction()
+}
+
+// endReconcileTransaction keeps the transaction of this reconcile for undoing if it changed any cgroup,
+// so that a reconcile changing nothing won't make the last effective one impossible to undo.
+func (p *DynamicPolicy) endReconcileTransaction() {
+	if !p.reconcileTransaction.isEmpty() {
+		p.lastReconcileTransaction = p.reconcileTransaction
+	}
+	p.reconcileTransaction = nil
+}
+
+// captureCPUStats records the prior cpu stats of the cgroup in the in-progress reconcile transaction,
+// and a failure merely makes the cgroup unable to be restored, so it doesn't stop the write.
+func (p *DynamicPolicy) captureCPUStats(relativePath string) {
+	if p.reconcileTransaction == nil {
+		return
+	}
+
+	if err := p.reconcileTransaction.capture(relativePath, p.getCPUWithRelativePath); err != nil {
+		general.Warningf("capture prior cpu stats of %s failed with error: %v, it can't be undone", relativePath, err)
+	}
+}
+
+// UndoLastCPUQuotaReconcile restores the prior cpu quota, period and burst of cgroups, e.g. pods, containers and
+// their sub cgroups, changed in the last reconcile that changed any, it's meant for safe experimentation, and the
+// restored values only last until the next reconcile. Other knobs written in the reconcile, i.e. cpuset.mems,
+// memory limits, swap, pids, freeze, uclamp and oom_score_adj, aren't recorded and are left as is.
+// It waits for the in-progress reconcile, if any, by taking the same reconcile lock as applyCPUAdvisorPlan.
+func (p *DynamicPolicy) UndoLastCPUQuotaReconcile() error {
+	p.Lock()
+	defer p.Unlock()
+
+	transaction := p.lastReconcileTransaction
+	if transaction == nil {
+		return fmt.Errorf("no reconcile to undo")
+	}
+	p.lastReconcileTransaction = nil
+	// pods restored by the undo are reconciled again in the next round even if advisor pushes the same infos
+	p.reconcileCache = nil
+
+	// cgroups are restored in the reverse order of changes, so that quotas of parents and children stay consistent
+	var errList []error
+	for i := len(transaction.priorStats) - 1; i >= 0; i-- {
+		prior := transaction.priorStats[i]
+		err := p.runCgroupWrite(context.Background(), prior.relativePath, func() error {
+			return p.applyCPUWithRelativePath(prior.relativePath, &common.CPUData{
+				CpuQuota:    prior.stats.CpuQuota,
+				CpuPeriod:   prior.stats.CpuPeriod,
+				CpuBurstPtr: prior.stats.CpuBurst,
+			})
+		})
+		if err != nil {
+			errList = append(errList, fmt.Errorf("%w: restore cpu stats of %s failed with error: %v", ErrCgroupWrite, prior.relativePath, err))
+			continue
+		}
+		general.Infof("restore cpu stats of %s to quota %d, period %d", prior.relativePath, prior.stats.CpuQuota, prior.stats.CpuPeriod)
+	}
+	return utilerrors.NewAggregate(errList)
+}
+
+// runCgroupWrite runs the cgroup write within the cgroup write timeout. The write can't be cancelled
+// once it's issued, so it's abandoned in the background if it times out, and the caller proceeds.
+// Writes to the same path are serialized, so that an abandoned write never lands after a newer one.
+func (p *DynamicPolicy) runCgroupWrite(ctx context.Context, relativePath string, write func() error) error {
+	serializer := p.getCgroupWriteSerializer()
+	timeout := p.getQuotaReconcileConf().CgroupWriteTimeout
+	if timeout <= 0 {
+		return serializer.run(relativePath, write)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// the channel is buffered so that an abandoned write can still finish without blocking
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- serializer.run(relativePath, write)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		general.Errorf("cgroup write of %s is abandoned after %v: %v", relativePath, timeout, ctx.Err())
+		_ = p.emitter.StoreInt64(util.MetricNameCgroupWriteTimeout, 1, metrics.MetricTypeNameCount,
+			metrics.ConvertMapToTags(map[string]string{
+				"cgroupPath": relativePath,
+			})...)
+		return fmt.Errorf("cgroup write of %s is abandoned: %w", relativePath, ctx.Err())
+	}
+}
+
+// checkCgroupWritesAllowed returns the reason if cgroup writes are stopped in this round by the breaker or the cap.
+func (p *DynamicPolicy) checkCgroupWritesAllowed() error {
+	if p.cgroupWriteBreaker.isOpen() {
+		return errCgroupWriteBreakerOpen
+	}
+	if p.cgroupWriteCap.isReached() {
+		return errCgroupWriteCapReached
+	}
+	return nil
+}
+
+// recordCgroupWrite records the result of a cgroup write to the breaker and the cap,
+// and emits the breaker once it's open and the cap once it's reached.
+func (p *DynamicPolicy) recordCgroupWrite(relativePath string, err error) {
+	if p.cgroupWriteBreaker.record(err) {
+		general.Errorf("cgroup writes failed %d times consecutively (last path: %s, error: %v), skip the remaining writes in this round",
+			p.cgroupWriteBreaker.threshold, relativePath, err)
+		_ = p.emitter.StoreInt64(util.MetricNameCgroupWriteBreakerOpen, 1, metrics.MetricTypeNameCount)
+	}
+	if p.cgroupWriteCap.record() {
+		general.Errorf("cgroup writes reach the cap %d of a round (last path: %s), the advisor plan may be "+
+			"unexpected, skip the remaining writes in this round and leave them for the next round", p.cgroupWriteCap.limit, relativePath)
+		_ = p.emitter.StoreInt64(util.MetricNameCgroupWriteCapReached, 1, metrics.MetricTypeNameCount)
+	}
+}
+
+// rampPodCPUQuota returns the quota to be applied to the pod in this round for the target quota in the target
+// period, which moves toward the target by the ramp step and the max increase step if any is configured. Quota is
+// only ramped at the pod level, and quotas of its containers are derived from the ramped one by the ratio given by
+// getContainerQuotaRatio, so that the pod and its containers stay consistent while the pod is ramping.
+func (p *DynamicPolicy) rampPodCPUQuota(pod *v1.Pod, podCpu *common.CPUStats, targetQuota int64, targetPeriod uint64) int64 {
+	conf := p.getQuotaReconcileConf()
+	if conf.QuotaRampStepMilliCores <= 0 && conf.QuotaMaxIncreaseStepMilliCores <= 0 {
+		return targetQuota
+	}
+
+	rampedQuota := p.getRampedCPUQuota(podCpu, targetQuota, targetPeriod)
+	if rampedQuota != targetQuota {
+		general.InfofV(4, "ramp quota of pod %s to %d toward target %d", pod.Name, rampedQuota, targetQuota)
+		p.emitQuotaApplyOutcome(quotaApplyOutcomeClamped)
+	}
+	return rampedQuota
+}
+
+// getContainerQuotaRatio returns the ratio by which quotas of containers are scaled to follow the ramped quota of
+// their pod, and one means containers are applied with their own quotas, e.g. once the pod reaches its target.
+func getContainerQuotaRatio(podAppliedQuota, podTargetQuota int64) float64 {
+	if podAppliedQuota <= 0 || podTargetQuota <= 0 || podAppliedQuota == podTargetQuota {
+		return 1
+	}
+	return float64(podAppliedQuota) / float64(podTargetQuota)
+}
+
+// getRampedCPUQuota returns the quota to be applied to the cgroup with the current cpu stats in this round for the
+// target quota, which is in the target period if it's not zero, or in the current period of the cgroup otherwise.
+func (p *DynamicPolicy) getRampedCPUQuota(cpuStats *common.CPUStats, targetQuota int64, targetPeriod uint64) int64 {
+	period := cpuStats.CpuPeriod
+	if targetPeriod != 0 {
+		period = targetPeriod
+	}
+	currentQuota := scaleCPUQuotaToPeriod(cpuStats.CpuQuota, cpuStats.CpuPeriod, period)
+
+	conf := p.getQuotaReconcileConf()
+	step := conf.QuotaRampStepMilliCores * int64(period) / 1000
+	var unlimitedQuota int64
+	if p.machineInfo != nil && p.machineInfo.CPUTopology != nil {
+		unlimitedQuota = int64(p.machineInfo.NumCPUs) * int64(period)
+	}
+	rampedQuota := rampCPUQuota(currentQuota, targetQuota, step, unlimitedQuota, conf.QuotaRampDecreaseOnly)
+	maxIncrease := conf.QuotaMaxIncreaseStepMilliCores * int64(period) / 1000
+	return limitCPUQuotaIncrease(currentQuota, rampedQuota, maxIncrease, unlimitedQuota)
+}
+
+// limitCPUQuotaIncrease limits the increase from the current quota to the target quota to the max increase, and
+// the target is returned as is if it's a decrease or within the max increase. Unlimited target quota (-1) is
+// regarded as the unlimited quota given, and it's applied directly if the unlimited quota is unknown.
+func limitCPUQuotaIncrease(currentQuota, targetQuota, maxIncrease, unlimitedQuota int64) int64 {
+	if maxIncrease <= 0 || currentQuota < 0 {
+		return targetQuota
+	}
+
+	target := targetQuota
+	if targetQuota < 0 {
+		if unlimitedQuota <= 0 {
+			return targetQuota
+		}
+		target = unlimitedQuota
+	}
+	if target > currentQuota+maxIncrease {
+		return currentQuota + maxIncrease
+	}
+	return targetQuota
+}
+
+// rampCPUQuota moves the current quota toward the target quota by at most the step, and the target
+// is returned as is once it's within the step. Unlimited quota (-1) is regarded as the unlimited quota
+// given, i.e. the quota of all cpus, and it's applied directly if the unlimited quota is unknown.
+func rampCPUQuota(currentQuota, targetQuota, step, unlimitedQuota int64, decreaseOnly bool) int64 {
+	if step <= 0 || currentQuota == targetQuota {
+		return targetQuota
+	}
+	if unlimitedQuota <= 0 && (currentQuota < 0 || targetQuota < 0) {
+		return targetQuota
+	}
+
+	normalize := func(quota int64) int64 {
+		if quota < 0 {
+			return unlimitedQuota
+		}
+		return quota
+	}
+	current, target := normalize(currentQuota), normalize(targetQuota)
+	switch {
+	case target < current-step:
+		return current - step
+	case target > current+step && !decreaseOnly:
+		return current + step
+	}
+	return targetQuota
+}
+
+// checkAndApplySubCgroupPath applies unlimited quota to the sub cgroup of the relative path if it's limited, and
+// the write goes through applyCPUQuotaWithRelativePath, so that it's guarded and undone as the other quota writes.
+func (p *DynamicPolicy) checkAndApplySubCgroupPath(ctx context.Context, relativePath string, d os.DirEntry, err error) error {
+	if err != nil {
+		return err
+	}
+	if !d.IsDir() {
+		return nil
+	}
+
+	subCPU, err := p.getCPUWithRelativePath(relativePath)
+	if err != nil {
+		return fmt.Errorf("GetCPUWithRelativePath %s failed with error: %v", relativePath, err)
+	}
+
+	if subCPU.CpuQuota < 0 {
+		return nil
+	}
+
+	err = p.applyCPUQuotaWithRelativePath(ctx, relativePath, &common.CPUData{CpuQuota: -1})
+	if err != nil {
+		general.Errorf("ApplyCPUWithRelativePath %s to -1 failed with error: %v", relativePath, err)
+		return fmt.Errorf("ApplyCPUWithRelativePath %s to -1 failed with error: %w", relativePath, err)
+	}
+
+	return nil
+}
+
+func (p *DynamicPolicy) applyAllSubCgroupQuotaToUnLimit(ctx context.Context, containerRelativePath string) error {
+	containerAbsPath := p.getAbsCgroupPath(common.DefaultSelectedSubsys, containerRelativePath)
+
+	return filepath.WalkDir(containerAbsPath, func(path string, d fs.DirEntry, err error) error {
+		if path == containerAbsPath {
+			return nil
+		}
+		subPath, relErr := filepath.Rel(containerAbsPath, path)
+		if relErr != nil {
+			return relErr
 		}
-		return p.checkAndApplySubCgroupPath(path, d, err)
+		return p.checkAndApplySubCgroupPath(ctx, filepath.Join(containerRelativePath, subPath), d, err)
 	})
 }
 
@@ -17,27 +17,71 @@ limitations under the License.
 package dynamicpolicy
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/bytedance/mockey"
 	"github.com/smartystreets/goconvey/convey"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	v1 "k8s.io/api/core/v1"
 	resource2 "k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	utilfeature "k8s.io/apiserver/pkg/util/feature"
+	"k8s.io/client-go/tools/events"
+	featuregatetesting "k8s.io/component-base/featuregate/testing"
+	testingclock "k8s.io/utils/clock/testing"
 
+	configv1alpha1 "github.com/kubewharf/katalyst-api/pkg/apis/config/v1alpha1"
+	nodev1alpha1 "github.com/kubewharf/katalyst-api/pkg/apis/node/v1alpha1"
+	"github.com/kubewharf/katalyst-api/pkg/consts"
+	evictionpluginapi "github.com/kubewharf/katalyst-api/pkg/protocol/evictionplugin/v1alpha1"
 	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/advisorsvc"
+	cpuconsts "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/consts"
 	advisorapi "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/cpuadvisor"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/cpueviction/strategy"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/validator"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/util"
+	"github.com/kubewharf/katalyst-core/pkg/client/control"
+	dynamicconfig "github.com/kubewharf/katalyst-core/pkg/config/agent/dynamic"
+	"github.com/kubewharf/katalyst-core/pkg/config/agent/dynamic/crd"
+	"github.com/kubewharf/katalyst-core/pkg/config/agent/dynamic/quotareconcile"
+	"github.com/kubewharf/katalyst-core/pkg/config/generic"
+	coreconsts "github.com/kubewharf/katalyst-core/pkg/consts"
+	"github.com/kubewharf/katalyst-core/pkg/features"
 	"github.com/kubewharf/katalyst-core/pkg/metaserver"
 	"github.com/kubewharf/katalyst-core/pkg/metaserver/agent"
+	"github.com/kubewharf/katalyst-core/pkg/metaserver/agent/metric"
 	"github.com/kubewharf/katalyst-core/pkg/metaserver/agent/pod"
+	"github.com/kubewharf/katalyst-core/pkg/metrics"
+	metricspool "github.com/kubewharf/katalyst-core/pkg/metrics/metrics-pool"
+	katalystutil "github.com/kubewharf/katalyst-core/pkg/util"
 	"github.com/kubewharf/katalyst-core/pkg/util/cgroup/common"
 	cgroupmgr "github.com/kubewharf/katalyst-core/pkg/util/cgroup/manager"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+	"github.com/kubewharf/katalyst-core/pkg/util/machine"
+	utilmetric "github.com/kubewharf/katalyst-core/pkg/util/metric"
 	"github.com/kubewharf/katalyst-core/pkg/util/native"
+	"github.com/kubewharf/katalyst-core/pkg/util/process"
 )
 
 var advisorTestMutex = &sync.Mutex{}
@@ -90,13 +134,7 @@ func TestDynamicPolicy_checkAndApplyIfCgroupV1(t *testing.T) {
 		},
 	}
 
-	p := &DynamicPolicy{
-		metaServer: &metaserver.MetaServer{
-			MetaAgent: &agent.MetaAgent{
-				PodFetcher: &pod.PodFetcherStub{},
-			},
-		},
-	}
+	p := newTestDynamicPolicy()
 
 	advisorTestMutex.Lock()
 	defer advisorTestMutex.Unlock()
@@ -106,9 +144,9 @@ func TestDynamicPolicy_checkAndApplyIfCgroupV1(t *testing.T) {
 		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(mockBG, nil).Build()
 		mockey.Mock((*DynamicPolicy).getCurrentPathAllPodsDirAndMap).IncludeCurrentGoRoutine().Return(mockPodPathMap, []string{"advisor-test-pod-1"}, nil).Build()
 		mockey.Mock((*DynamicPolicy).getPodAndRelativePath).IncludeCurrentGoRoutine().Return(mockPod, "test_relative_path", nil).Build()
-		mockey.Mock((*DynamicPolicy).checkAndApplyAllPodsQuota).IncludeCurrentGoRoutine().Return(nil).Build()
+		mockey.Mock((*DynamicPolicy).checkAndApplyAllPodsQuota).IncludeCurrentGoRoutine().Return(nil, nil).Build()
 
-		err := p.checkAndApplyIfCgroupV1(mockCal, resources)
+		_, err := p.checkAndApplyIfCgroupV1(mockCal, resources)
 		convey.So(err, convey.ShouldBeNil)
 	})
 
@@ -117,10 +155,192 @@ func TestDynamicPolicy_checkAndApplyIfCgroupV1(t *testing.T) {
 		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(mockBG2, nil).Build()
 		mockey.Mock((*DynamicPolicy).getCurrentPathAllPodsDirAndMap).IncludeCurrentGoRoutine().Return(mockPodPathMap, []string{"advisor-test-pod-1"}, nil).Build()
 		mockey.Mock((*DynamicPolicy).getPodAndRelativePath).IncludeCurrentGoRoutine().Return(mockPod, "test_relative_path", nil).Build()
-		mockey.Mock((*DynamicPolicy).checkAndApplyAllPodsQuota).IncludeCurrentGoRoutine().Return(nil).Build()
+		mockey.Mock((*DynamicPolicy).checkAndApplyAllPodsQuota).IncludeCurrentGoRoutine().Return(
+			&ReconcileResult{Processed: 1, Applied: 1}, nil).Build()
+
+		result, err := p.checkAndApplyIfCgroupV1(mockCal, resources)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(result, convey.ShouldResemble, &ReconcileResult{Processed: 1, Applied: 1})
+	})
+}
+
+func TestDynamicPolicy_cpuPeriodChange(t *testing.T) {
+	t.Parallel()
+
+	p := &DynamicPolicy{
+		emitter: metrics.DummyMetrics{},
+	}
+
+	newResponse := func(resources *common.CgroupResources) *advisorapi.ListAndWatchResponse {
+		resourcesBytes, _ := json.Marshal(resources)
+		return &advisorapi.ListAndWatchResponse{
+			ExtraEntries: []*advisorsvc.CalculationInfo{
+				{
+					CgroupPath: "test_cgroup_path",
+					CalculationResult: &advisorsvc.CalculationResult{
+						Values: map[string]string{
+							string(advisorapi.ControlKnobKeyCgroupConfig): string(resourcesBytes),
+						},
+					},
+				},
+			},
+		}
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test a period-only change is applied with the effective cpu count preserved", t, func() {
+		mockey.Mock(general.IsPathExists).IncludeCurrentGoRoutine().Return(true).Build()
+		mockey.Mock(common.CheckCgroup2UnifiedMode).IncludeCurrentGoRoutine().Return(true).Build()
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(
+			&common.CPUStats{CpuQuota: 200000, CpuPeriod: 100000}, nil).Build()
+		var applied []common.CgroupResources
+		mockey.Mock(common.ApplyCgroupConfigs).IncludeCurrentGoRoutine().To(func(_ string, resources *common.CgroupResources) error {
+			applied = append(applied, *resources)
+			return nil
+		}).Build()
+
+		// the period is switched to 50ms without a quota
+		err := p.applyCgroupConfigs(newResponse(&common.CgroupResources{CpuPeriod: 50000}))
+		convey.So(err, convey.ShouldBeNil)
+		// the period is switched to 50ms along with a quota
+		err = p.applyCgroupConfigs(newResponse(&common.CgroupResources{CpuQuota: 150000, CpuPeriod: 50000}))
+		convey.So(err, convey.ShouldBeNil)
+		// the period is unchanged
+		err = p.applyCgroupConfigs(newResponse(&common.CgroupResources{CpuPeriod: 100000}))
+		convey.So(err, convey.ShouldBeNil)
+
+		convey.So(len(applied), convey.ShouldEqual, 3)
+		convey.So(applied[0].CpuQuota, convey.ShouldEqual, 100000)
+		convey.So(applied[0].CpuPeriod, convey.ShouldEqual, 50000)
+		convey.So(applied[1].CpuQuota, convey.ShouldEqual, 150000)
+		convey.So(applied[1].CpuPeriod, convey.ShouldEqual, 50000)
+		convey.So(applied[2].CpuQuota, convey.ShouldEqual, 0)
+		convey.So(applied[2].CpuPeriod, convey.ShouldEqual, 100000)
+	})
+
+	mockey.PatchConvey("test the current quota is compared in the new period", t, func() {
+		mockey.Mock(common.CheckCgroup2UnifiedMode).IncludeCurrentGoRoutine().Return(false).Build()
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(
+			&common.CPUStats{CpuQuota: 200000, CpuPeriod: 100000}, nil).Build()
+		var bigGroupQuotas []int64
+		mockey.Mock((*DynamicPolicy).checkAndApplyAllPodsQuota).IncludeCurrentGoRoutine().To(
+			func(_ *DynamicPolicy, _ context.Context, _ *advisorsvc.CalculationInfo, bigGroupQuota int64) (*ReconcileResult, error) {
+				bigGroupQuotas = append(bigGroupQuotas, bigGroupQuota)
+				return &ReconcileResult{}, nil
+			}).Build()
 
-		err := p.checkAndApplyIfCgroupV1(mockCal, resources)
+		// 3 cores in the 50ms period is a scale-up from the current 2 cores, so pods are bounded by the current ones
+		_, err := p.checkAndApplyIfCgroupV1(&advisorsvc.CalculationInfo{CgroupPath: "test_cgroup_path"},
+			&common.CgroupResources{CpuQuota: 150000, CpuPeriod: 50000})
+		convey.So(err, convey.ShouldBeNil)
+		// 1 core in the 50ms period is a scale-down
+		_, err = p.checkAndApplyIfCgroupV1(&advisorsvc.CalculationInfo{CgroupPath: "test_cgroup_path"},
+			&common.CgroupResources{CpuQuota: 50000, CpuPeriod: 50000})
 		convey.So(err, convey.ShouldBeNil)
+		convey.So(bigGroupQuotas, convey.ShouldResemble, []int64{100000, 50000})
+	})
+}
+
+func Test_scaleCPUQuotaToPeriod(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		quota      int64
+		fromPeriod uint64
+		toPeriod   uint64
+		want       int64
+	}{
+		{name: "shorter period", quota: 200000, fromPeriod: 100000, toPeriod: 50000, want: 100000},
+		{name: "longer period", quota: 50000, fromPeriod: 50000, toPeriod: 100000, want: 100000},
+		{name: "same period", quota: 200000, fromPeriod: 100000, toPeriod: 100000, want: 200000},
+		{name: "unlimited quota", quota: -1, fromPeriod: 100000, toPeriod: 50000, want: -1},
+		{name: "unknown period", quota: 200000, fromPeriod: 0, toPeriod: 50000, want: 200000},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.want, scaleCPUQuotaToPeriod(tt.quota, tt.fromPeriod, tt.toPeriod))
+		})
+	}
+}
+
+func TestDynamicPolicy_convertCPUCoresToQuota(t *testing.T) {
+	t.Parallel()
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	assert.NoError(t, err)
+	p := &DynamicPolicy{
+		emitter:     metrics.DummyMetrics{},
+		machineInfo: &machine.KatalystMachineInfo{CPUTopology: cpuTopology},
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test cpu cores are converted into quota", t, func() {
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(&common.CPUStats{CpuPeriod: 50000}, nil).Build()
+
+		// fractional cores in the desired period
+		resources := &common.CgroupResources{CpuQuota: 100000, CpuPeriod: 100000}
+		convey.So(p.convertCPUCoresToQuota("test_cgroup_path", "2.5", resources), convey.ShouldBeNil)
+		convey.So(resources.CpuQuota, convey.ShouldEqual, 250000)
+
+		// fractional cores in the current period of the cgroup
+		resources = &common.CgroupResources{}
+		convey.So(p.convertCPUCoresToQuota("test_cgroup_path", "0.25", resources), convey.ShouldBeNil)
+		convey.So(resources.CpuQuota, convey.ShouldEqual, 12500)
+
+		// all cores of the node are allowed
+		resources = &common.CgroupResources{CpuPeriod: 100000}
+		convey.So(p.convertCPUCoresToQuota("test_cgroup_path", "16", resources), convey.ShouldBeNil)
+		convey.So(resources.CpuQuota, convey.ShouldEqual, 1600000)
+
+		for _, invalid := range []string{"16.5", "0", "-1", "NaN", "+Inf", "two"} {
+			resources = &common.CgroupResources{CpuQuota: 100000, CpuPeriod: 100000}
+			convey.So(p.convertCPUCoresToQuota("test_cgroup_path", invalid, resources), convey.ShouldNotBeNil)
+			convey.So(resources.CpuQuota, convey.ShouldEqual, 100000)
+		}
+
+		// cores can't be validated without cpu topology
+		convey.So((&DynamicPolicy{}).convertCPUCoresToQuota("test_cgroup_path", "1", &common.CgroupResources{}), convey.ShouldNotBeNil)
+	})
+}
+
+func TestDynamicPolicy_capUnlimitedQuota(t *testing.T) {
+	t.Parallel()
+
+	p := newTestDynamicPolicy(withTestQuotaReconcileConf(&quotareconcile.QuotaReconcileConfiguration{
+		UnlimitedQuotaCapMilliCores: 8000,
+	}))
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test unlimited quota is capped", t, func() {
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(&common.CPUStats{CpuPeriod: 50000}, nil).Build()
+
+		// the cap in the desired period
+		resources := &common.CgroupResources{CpuQuota: -1, CpuPeriod: 100000}
+		convey.So(p.capUnlimitedQuota("test_cgroup_path", resources), convey.ShouldBeNil)
+		convey.So(resources.CpuQuota, convey.ShouldEqual, 800000)
+
+		// the cap in the current period of the cgroup
+		resources = &common.CgroupResources{CpuQuota: -1}
+		convey.So(p.capUnlimitedQuota("test_cgroup_path", resources), convey.ShouldBeNil)
+		convey.So(resources.CpuQuota, convey.ShouldEqual, 400000)
+
+		// limited or unset quota is kept as it is
+		for _, quota := range []int64{0, 200000} {
+			resources = &common.CgroupResources{CpuQuota: quota, CpuPeriod: 100000}
+			convey.So(p.capUnlimitedQuota("test_cgroup_path", resources), convey.ShouldBeNil)
+			convey.So(resources.CpuQuota, convey.ShouldEqual, quota)
+		}
+
+		// unlimited quota is kept without the cap by default
+		resources = &common.CgroupResources{CpuQuota: -1, CpuPeriod: 100000}
+		convey.So(newTestDynamicPolicy().capUnlimitedQuota("test_cgroup_path", resources), convey.ShouldBeNil)
+		convey.So(resources.CpuQuota, convey.ShouldEqual, -1)
 	})
 }
 
@@ -145,6 +365,61 @@ func TestDynamicPolicy_getAllDirs(t *testing.T) {
 		assert.NoError(t, err)
 		assert.ElementsMatch(t, dirs, []string{"foo", "bar"})
 	})
+
+	t.Run("nested pod dirs", func(t *testing.T) {
+		t.Parallel()
+
+		root := t.TempDir()
+		for _, dir := range []string{
+			"pod-uid-1/container-1",
+			"besteffort/pod-uid-2/container-2",
+			"besteffort/pod-uid-3",
+			"besteffort/sub-slice/pod-uid-4",
+		} {
+			assert.NoError(t, os.MkdirAll(filepath.Join(root, dir), 0o755))
+		}
+		assert.NoError(t, os.WriteFile(filepath.Join(root, "besteffort", "cpu.cfs_quota_us"), []byte("-1"), 0o644))
+
+		nestedPolicy := &DynamicPolicy{
+			quotaReconcileConf: &quotareconcile.QuotaReconcileConfiguration{PodDirSearchDepth: 2},
+		}
+		dirs, err := nestedPolicy.getAllDirs(root)
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, dirs, []string{"pod-uid-1", "besteffort/pod-uid-2", "besteffort/pod-uid-3"})
+
+		// the depth is capped by maxPodDirSearchDepth
+		nestedPolicy.quotaReconcileConf.PodDirSearchDepth = 100
+		dirs, err = nestedPolicy.getAllDirs(root)
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, dirs, []string{"pod-uid-1", "besteffort/pod-uid-2", "besteffort/pod-uid-3", "besteffort/sub-slice/pod-uid-4"})
+	})
+
+	t.Run("symlinked dirs", func(t *testing.T) {
+		t.Parallel()
+
+		root := t.TempDir()
+		target := t.TempDir()
+		assert.NoError(t, os.MkdirAll(filepath.Join(root, "pod-uid-1"), 0o755))
+		assert.NoError(t, os.MkdirAll(filepath.Join(target, "pod-uid-2"), 0o755))
+		assert.NoError(t, os.Symlink(filepath.Join(target, "pod-uid-2"), filepath.Join(root, "pod-uid-2")))
+		assert.NoError(t, os.Symlink(target, filepath.Join(root, "besteffort")))
+		// symlinks to the root itself and broken ones are never followed infinitely
+		assert.NoError(t, os.Symlink(root, filepath.Join(root, "loop")))
+		assert.NoError(t, os.Symlink(filepath.Join(root, "self"), filepath.Join(root, "self")))
+		assert.NoError(t, os.Symlink(filepath.Join(root, "not-exist"), filepath.Join(root, "broken")))
+
+		dirs, err := policy.getAllDirs(root)
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, dirs, []string{"pod-uid-1", "pod-uid-2", "besteffort", "loop"})
+
+		nestedPolicy := &DynamicPolicy{
+			quotaReconcileConf: &quotareconcile.QuotaReconcileConfiguration{PodDirSearchDepth: maxPodDirSearchDepth},
+		}
+		dirs, err = nestedPolicy.getAllDirs(root)
+		assert.NoError(t, err)
+		// the pod dir linked twice is only returned once
+		assert.ElementsMatch(t, dirs, []string{"pod-uid-1", "besteffort/pod-uid-2"})
+	})
 }
 
 type mockDirEntry struct {
@@ -183,13 +458,7 @@ func TestDynamic_getCurrentPathAllPodsDirAndMap(t *testing.T) {
 		mockey.Mock((*DynamicPolicy).getAllPodsPathMap).IncludeCurrentGoRoutine().Return(mockPodPathMap, nil).Build()
 		mockey.Mock((*DynamicPolicy).getAllDirs).IncludeCurrentGoRoutine().Return([]string{"advisor-test-pod-1"}, nil).Build()
 
-		p := &DynamicPolicy{
-			metaServer: &metaserver.MetaServer{
-				MetaAgent: &agent.MetaAgent{
-					PodFetcher: &pod.PodFetcherStub{},
-				},
-			},
-		}
+		p := newTestDynamicPolicy()
 
 		resultMap, dirs, err := p.getCurrentPathAllPodsDirAndMap("test_group_path")
 		convey.So(err, convey.ShouldBeNil)
@@ -223,17 +492,39 @@ func TestDynamicPolicy_getPodAndRelativePath(t *testing.T) {
 		},
 	}
 
-	p := &DynamicPolicy{
-		metaServer: &metaserver.MetaServer{
-			MetaAgent: &agent.MetaAgent{
-				PodFetcher: &pod.PodFetcherStub{},
-			},
-		},
-	}
+	p := newTestDynamicPolicy()
 
 	mockey.PatchConvey("test getPodAndRelativePath", t, func() {
 		_, _, err := p.getPodAndRelativePath(currentPath, dirs, podPathMap)
 		convey.So(err, convey.ShouldBeNil)
+
+		_, _, err = p.getPodAndRelativePath(currentPath, "unknown-dir", podPathMap)
+		convey.So(errors.Is(err, ErrPodNotFound), convey.ShouldBeTrue)
+
+		emptyPodPathMap := map[string]*v1.Pod{
+			common.GetAbsCgroupPath(common.DefaultSelectedSubsys, filepath.Join(currentPath, dirs)): {},
+		}
+		_, _, err = p.getPodAndRelativePath(currentPath, dirs, emptyPodPathMap)
+		convey.So(errors.Is(err, ErrPodHasNoContainers), convey.ShouldBeTrue)
+	})
+
+	mockey.PatchConvey("test getPodAndRelativePath rejects paths escaping the cgroup root", t, func() {
+		escapedPodPathMap := map[string]*v1.Pod{
+			common.GetAbsCgroupPath(common.DefaultSelectedSubsys, "other-dir"): podPathMap[common.GetAbsCgroupPath(
+				common.DefaultSelectedSubsys, filepath.Join(currentPath, dirs))],
+		}
+		for _, podDir := range []string{"../other-dir", "..", ".", "test-dir/../../other-dir"} {
+			_, _, err := p.getPodAndRelativePath(currentPath, podDir, escapedPodPathMap)
+			convey.So(errors.Is(err, ErrPathEscape), convey.ShouldBeTrue)
+		}
+
+		podRelativePath, err := joinPathWithinRoot("/kubepods/besteffort", "pod-dir/../pod-dir")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(podRelativePath, convey.ShouldEqual, "/kubepods/besteffort/pod-dir")
+
+		podRelativePath, err = joinPathWithinRoot("/", "pod-dir")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(podRelativePath, convey.ShouldEqual, "/pod-dir")
 	})
 }
 
@@ -257,13 +548,7 @@ func TestDynamicPolicy_getAllPodsPathMap(t *testing.T) {
 		},
 	}
 
-	p := &DynamicPolicy{
-		metaServer: &metaserver.MetaServer{
-			MetaAgent: &agent.MetaAgent{
-				PodFetcher: &pod.PodFetcherStub{},
-			},
-		},
-	}
+	p := newTestDynamicPolicy()
 
 	advisorTestMutex.Lock()
 	defer advisorTestMutex.Unlock()
@@ -297,13 +582,7 @@ func TestDynamicPolicy_getAllContainersRelativePathMap(t *testing.T) {
 		},
 	}
 
-	p := &DynamicPolicy{
-		metaServer: &metaserver.MetaServer{
-			MetaAgent: &agent.MetaAgent{
-				PodFetcher: &pod.PodFetcherStub{},
-			},
-		},
-	}
+	p := newTestDynamicPolicy()
 
 	advisorTestMutex.Lock()
 	defer advisorTestMutex.Unlock()
@@ -316,18 +595,239 @@ func TestDynamicPolicy_getAllContainersRelativePathMap(t *testing.T) {
 		convey.So(len(testMap), convey.ShouldEqual, 1)
 		convey.So(testMap["test-container-relative-path"].Name, convey.ShouldEqual, mockPod.Spec.Containers[0].Name)
 	})
+
+	mockey.PatchConvey("test restartable init containers and ephemeral containers", t, func() {
+		mockey.Mock(common.GetContainerRelativeCgroupPath).IncludeCurrentGoRoutine().To(func(_ string, containerID string) (string, error) {
+			return "path-" + containerID, nil
+		}).Build()
+
+		running := v1.ContainerState{Running: &v1.ContainerStateRunning{}}
+		terminated := v1.ContainerState{Terminated: &v1.ContainerStateTerminated{}}
+		testPod := &v1.Pod{
+			Spec: v1.PodSpec{
+				InitContainers: []v1.Container{{Name: "init"}, {Name: "sidecar"}},
+				Containers:     []v1.Container{{Name: "app"}},
+				EphemeralContainers: []v1.EphemeralContainer{
+					{EphemeralContainerCommon: v1.EphemeralContainerCommon{Name: "debugger"}},
+				},
+			},
+			Status: v1.PodStatus{
+				InitContainerStatuses: []v1.ContainerStatus{
+					{Name: "init", ContainerID: "containerd://init-id", State: terminated},
+					{Name: "sidecar", ContainerID: "containerd://sidecar-id", State: running},
+				},
+				ContainerStatuses: []v1.ContainerStatus{
+					{Name: "app", ContainerID: "containerd://app-id", State: running},
+				},
+				EphemeralContainerStatuses: []v1.ContainerStatus{
+					{Name: "debugger", ContainerID: "containerd://debugger-id", State: running},
+				},
+			},
+		}
+
+		containerNames := func(containerPathMap map[string]*v1.Container) map[string]string {
+			names := make(map[string]string, len(containerPathMap))
+			for path, container := range containerPathMap {
+				names[path] = container.Name
+			}
+			return names
+		}
+
+		// the running init container is a restartable one since app containers have started
+		convey.So(containerNames(p.getAllContainersRelativePathMap(testPod)), convey.ShouldResemble, map[string]string{
+			"path-app-id":     "app",
+			"path-sidecar-id": "sidecar",
+		})
+
+		ephemeralPolicy := &DynamicPolicy{
+			quotaReconcileConf: &quotareconcile.QuotaReconcileConfiguration{IncludeEphemeralContainers: true},
+		}
+		convey.So(containerNames(ephemeralPolicy.getAllContainersRelativePathMap(testPod)), convey.ShouldResemble, map[string]string{
+			"path-app-id":      "app",
+			"path-sidecar-id":  "sidecar",
+			"path-debugger-id": "debugger",
+		})
+
+		// a running init container before app containers start is a regular one
+		initializingPod := testPod.DeepCopy()
+		initializingPod.Status.ContainerStatuses = []v1.ContainerStatus{
+			{Name: "app", State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{}}},
+		}
+		convey.So(containerNames(p.getAllContainersRelativePathMap(initializingPod)), convey.ShouldBeEmpty)
+	})
 }
 
-func TestDynamicPolicy_checkAllPodsQuota(t *testing.T) {
+func TestDynamicPolicy_startQoSLevelReconcilers(t *testing.T) {
 	t.Parallel()
 
-	p := &DynamicPolicy{
-		metaServer: &metaserver.MetaServer{
-			MetaAgent: &agent.MetaAgent{
-				PodFetcher: &pod.PodFetcherStub{},
+	p := newTestDynamicPolicy()
+	p.qosLevelReconcileIntervals = map[string]time.Duration{
+		consts.PodAnnotationQoSLevelDedicatedCores: 10 * time.Millisecond,
+		consts.PodAnnotationQoSLevelSharedCores:    50 * time.Millisecond,
+		consts.PodAnnotationQoSLevelReclaimedCores: 0,
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test pods of qos levels with shorter intervals are reconciled more often", t, func() {
+		var mu sync.Mutex
+		reconciles := make(map[string]int)
+		// reconcilers run in their own goroutines, so the mock is not limited to the current one
+		mockey.Mock((*DynamicPolicy).reconcileQoSLevel).To(func(_ *DynamicPolicy, qosLevel string) {
+			mu.Lock()
+			defer mu.Unlock()
+			reconciles[qosLevel]++
+		}).Build()
+
+		stopCh := make(chan struct{})
+		p.startQoSLevelReconcilers(stopCh)
+		time.Sleep(500 * time.Millisecond)
+		close(stopCh)
+
+		mu.Lock()
+		defer mu.Unlock()
+		convey.So(reconciles[consts.PodAnnotationQoSLevelSharedCores], convey.ShouldBeGreaterThan, 0)
+		convey.So(reconciles[consts.PodAnnotationQoSLevelDedicatedCores], convey.ShouldBeGreaterThan,
+			2*reconciles[consts.PodAnnotationQoSLevelSharedCores])
+		convey.So(reconciles, convey.ShouldNotContainKey, consts.PodAnnotationQoSLevelReclaimedCores)
+	})
+}
+
+func TestDynamicPolicy_filterPodDirsOfQoSLevel(t *testing.T) {
+	t.Parallel()
+
+	cgroupRoot := t.TempDir()
+	newPod := func(uid, qosLevel string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        uid,
+				Annotations: map[string]string{consts.PodAnnotationQoSLevelKey: qosLevel},
 			},
+			Spec: v1.PodSpec{Containers: []v1.Container{{Name: "app"}}},
+		}
+	}
+	dedicatedPod := newPod("dedicated", consts.PodAnnotationQoSLevelDedicatedCores)
+	sharedPod := newPod("shared", consts.PodAnnotationQoSLevelSharedCores)
+	p := newTestDynamicPolicy()
+	p.cgroupRootOverride = cgroupRoot
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test only pod dirs of pods of the qos level are kept", t, func() {
+		mockey.Mock(common.CheckCgroup2UnifiedMode).IncludeCurrentGoRoutine().Return(false).Build()
+
+		podAbsPath := func(podDir string) string {
+			return p.getAbsCgroupPath(common.DefaultSelectedSubsys, filepath.Join(common.CgroupFsRootPathBurstable, podDir))
+		}
+		podsPathMap := map[string]*v1.Pod{
+			podAbsPath("poddedicated"): dedicatedPod,
+			podAbsPath("podshared"):    sharedPod,
+		}
+		podDirs := []string{"poddedicated", "podshared", "podunknown"}
+
+		convey.So(p.filterPodDirsOfQoSLevel(common.CgroupFsRootPathBurstable, podDirs, podsPathMap,
+			consts.PodAnnotationQoSLevelDedicatedCores), convey.ShouldResemble, []string{"poddedicated"})
+		convey.So(p.filterPodDirsOfQoSLevel(common.CgroupFsRootPathBurstable, podDirs, podsPathMap,
+			consts.PodAnnotationQoSLevelReclaimedCores), convey.ShouldBeEmpty)
+	})
+}
+
+func TestDynamicPolicy_GetResolvedPodPaths(t *testing.T) {
+	t.Parallel()
+
+	cgroupRoot := t.TempDir()
+	newPod := func(name, uid string) *v1.Pod {
+		assert.NoError(t, os.MkdirAll(filepath.Join(cgroupRoot, common.DefaultSelectedSubsys,
+			common.CgroupFsRootPathBurstable, "pod"+uid), 0o755))
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "test-ns", Name: name, UID: types.UID(uid)},
+			Status:     v1.PodStatus{Phase: v1.PodRunning},
+		}
+	}
+	p := newTestDynamicPolicy(withTestPods(newPod("pod-b", "uid-b"), newPod("pod-a", "uid-a")))
+	p.cgroupRootOverride = cgroupRoot
+	assert.Empty(t, p.GetResolvedPodPaths())
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test the dump matches the resolved pod path map", t, func() {
+		mockey.Mock(common.CheckCgroup2UnifiedMode).IncludeCurrentGoRoutine().Return(false).Build()
+
+		podsPathMap, err := p.getAllPodsPathMap()
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(podsPathMap, convey.ShouldHaveLength, 2)
+
+		resolvedPodPaths := p.GetResolvedPodPaths()
+		convey.So(resolvedPodPaths, convey.ShouldHaveLength, len(podsPathMap))
+		for _, resolvedPodPath := range resolvedPodPaths {
+			pod, ok := podsPathMap[resolvedPodPath.AbsCgroupPath]
+			convey.So(ok, convey.ShouldBeTrue)
+			convey.So(resolvedPodPath.PodUID, convey.ShouldEqual, string(pod.UID))
+			convey.So(resolvedPodPath.PodNamespace, convey.ShouldEqual, pod.Namespace)
+			convey.So(resolvedPodPath.PodName, convey.ShouldEqual, pod.Name)
+		}
+		convey.So(resolvedPodPaths[0].PodName, convey.ShouldEqual, "pod-a")
+
+		data, err := p.DumpResolvedPodPaths()
+		convey.So(err, convey.ShouldBeNil)
+		var dumped []ResolvedPodPath
+		convey.So(json.Unmarshal(data, &dumped), convey.ShouldBeNil)
+		convey.So(dumped, convey.ShouldResemble, resolvedPodPaths)
+
+		// the returned data is a copy
+		resolvedPodPaths[0].PodName = "changed"
+		convey.So(p.GetResolvedPodPaths()[0].PodName, convey.ShouldEqual, "pod-a")
+		convey.So(podsPathMap[resolvedPodPaths[0].AbsCgroupPath].Name, convey.ShouldEqual, "pod-a")
+	})
+}
+
+func TestDynamicPolicy_getAllContainersRelativePathMap_containerRestart(t *testing.T) {
+	t.Parallel()
+
+	testPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{UID: "pod-uid"},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{{Name: "app"}},
 		},
 	}
+	p := &DynamicPolicy{}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test container path is re-resolved after container restart", t, func() {
+		containerID := "container-id-1"
+		mockey.Mock(native.GetContainerID).IncludeCurrentGoRoutine().To(func(_ *v1.Pod, _ string) (string, error) {
+			return containerID, nil
+		}).Build()
+
+		resolved := 0
+		mockey.Mock(common.GetContainerRelativeCgroupPath).IncludeCurrentGoRoutine().To(func(_ string, id string) (string, error) {
+			resolved++
+			return "path-" + id, nil
+		}).Build()
+
+		convey.So(p.getAllContainersRelativePathMap(testPod), convey.ShouldContainKey, "path-container-id-1")
+		convey.So(p.getAllContainersRelativePathMap(testPod), convey.ShouldContainKey, "path-container-id-1")
+		convey.So(resolved, convey.ShouldEqual, 1)
+
+		// the container is restarted with a new container id
+		containerID = "container-id-2"
+		testMap := p.getAllContainersRelativePathMap(testPod)
+		convey.So(resolved, convey.ShouldEqual, 2)
+		convey.So(len(testMap), convey.ShouldEqual, 1)
+		convey.So(testMap["path-container-id-2"].Name, convey.ShouldEqual, "app")
+
+		// records of pods no longer live are pruned
+		p.getContainerPathCache().prune(map[string]bool{})
+		p.getAllContainersRelativePathMap(testPod)
+		convey.So(resolved, convey.ShouldEqual, 3)
+	})
+}
+
+func TestDynamicPolicy_checkAllPodsQuota(t *testing.T) {
+	t.Parallel()
+
+	p := newTestDynamicPolicy()
 
 	mockPod := &v1.Pod{
 		Spec: v1.PodSpec{
@@ -397,26 +897,27 @@ func TestDynamicPolicy_checkAllPodsQuota(t *testing.T) {
 		mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().Return(nil).Build()
 		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(mockBG, nil).Build()
 
-		err := p.checkAndApplyAllPodsQuota(mockCal, mockBG.CpuQuota)
+		_, err := p.checkAndApplyAllPodsQuota(context.TODO(), mockCal, mockBG.CpuQuota)
 		convey.So(err, convey.ShouldBeNil)
 
-		err = p.checkAndApplyAllPodsQuota(mockCal, mockBG2.CpuQuota)
+		_, err = p.checkAndApplyAllPodsQuota(context.TODO(), mockCal, mockBG2.CpuQuota)
 		convey.So(err, convey.ShouldBeNil)
 
-		err = p.checkAndApplyAllPodsQuota(mockCal, mockBG3.CpuQuota)
+		_, err = p.checkAndApplyAllPodsQuota(context.TODO(), mockCal, mockBG3.CpuQuota)
 		convey.So(err, convey.ShouldBeNil)
 	})
 
 	mockey.PatchConvey("test checkAndApplyAllPodsQuota", t, func() {
 		mockey.Mock((*DynamicPolicy).getCurrentPathAllPodsDirAndMap).IncludeCurrentGoRoutine().Return(nil, nil, mockErr).Build()
-		err := p.checkAndApplyAllPodsQuota(mockCal, mockBG.CpuQuota)
+		_, err := p.checkAndApplyAllPodsQuota(context.TODO(), mockCal, mockBG.CpuQuota)
 		convey.So(err, convey.ShouldNotBeNil)
+		convey.So(errors.Is(err, ErrPathResolve), convey.ShouldBeTrue)
 	})
 
 	mockey.PatchConvey("test checkAndApplyAllPodsQuota", t, func() {
 		mockey.Mock((*DynamicPolicy).getCurrentPathAllPodsDirAndMap).IncludeCurrentGoRoutine().Return(mockPodPathMap, mockPodDirs, nil).Build()
 		mockey.Mock((*DynamicPolicy).getPodAndRelativePath).IncludeCurrentGoRoutine().Return(mockPod, "test_relative_path", mockErr).Build()
-		err := p.checkAndApplyAllPodsQuota(mockCal, mockBG.CpuQuota)
+		_, err := p.checkAndApplyAllPodsQuota(context.TODO(), mockCal, mockBG.CpuQuota)
 		convey.So(err, convey.ShouldBeNil)
 	})
 
@@ -424,8 +925,9 @@ func TestDynamicPolicy_checkAllPodsQuota(t *testing.T) {
 		mockey.Mock((*DynamicPolicy).getCurrentPathAllPodsDirAndMap).IncludeCurrentGoRoutine().Return(mockPodPathMap, mockPodDirs, nil).Build()
 		mockey.Mock((*DynamicPolicy).getPodAndRelativePath).IncludeCurrentGoRoutine().Return(mockPod, "test_relative_path", nil).Build()
 		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(mockBG, mockErr).Build()
-		err := p.checkAndApplyAllPodsQuota(mockCal, mockBG.CpuQuota)
+		_, err := p.checkAndApplyAllPodsQuota(context.TODO(), mockCal, mockBG.CpuQuota)
 		convey.So(err, convey.ShouldNotBeNil)
+		convey.So(errors.Is(err, ErrCgroupRead), convey.ShouldBeTrue)
 	})
 
 	mockey.PatchConvey("test checkAndApplyAllPodsQuota", t, func() {
@@ -435,13 +937,13 @@ func TestDynamicPolicy_checkAllPodsQuota(t *testing.T) {
 		mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().Return(nil).Build()
 		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(mockBG, nil).Build()
 
-		err := p.checkAndApplyAllPodsQuota(mockCal, mockBG.CpuQuota)
+		_, err := p.checkAndApplyAllPodsQuota(context.TODO(), mockCal, mockBG.CpuQuota)
 		convey.So(err, convey.ShouldBeNil)
 
-		err = p.checkAndApplyAllPodsQuota(mockCal, mockBG2.CpuQuota)
+		_, err = p.checkAndApplyAllPodsQuota(context.TODO(), mockCal, mockBG2.CpuQuota)
 		convey.So(err, convey.ShouldBeNil)
 
-		err = p.checkAndApplyAllPodsQuota(mockCal, mockBG3.CpuQuota)
+		_, err = p.checkAndApplyAllPodsQuota(context.TODO(), mockCal, mockBG3.CpuQuota)
 		convey.So(err, convey.ShouldBeNil)
 	})
 
@@ -452,124 +954,5037 @@ func TestDynamicPolicy_checkAllPodsQuota(t *testing.T) {
 		mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().Return(mockErr).Build()
 		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(mockBG, nil).Build()
 
-		err := p.checkAndApplyAllPodsQuota(mockCal, mockBG.CpuQuota)
+		_, err := p.checkAndApplyAllPodsQuota(context.TODO(), mockCal, mockBG.CpuQuota)
 		convey.So(err, convey.ShouldNotBeNil)
 
-		err = p.checkAndApplyAllPodsQuota(mockCal, mockBG2.CpuQuota)
+		_, err = p.checkAndApplyAllPodsQuota(context.TODO(), mockCal, mockBG2.CpuQuota)
 		convey.So(err, convey.ShouldNotBeNil)
 
-		err = p.checkAndApplyAllPodsQuota(mockCal, mockBG3.CpuQuota)
+		_, err = p.checkAndApplyAllPodsQuota(context.TODO(), mockCal, mockBG3.CpuQuota)
 		convey.So(err, convey.ShouldNotBeNil)
+		convey.So(errors.Is(err, ErrCgroupWrite), convey.ShouldBeTrue)
 	})
 }
 
-func TestDynamicPolicy_applyAllContainersQuota(t *testing.T) {
+func TestDynamicPolicy_appliedQuotaByQoSLevel(t *testing.T) {
 	t.Parallel()
 
-	p := &DynamicPolicy{
-		metaServer: &metaserver.MetaServer{
-			MetaAgent: &agent.MetaAgent{
-				PodFetcher: &pod.PodFetcherStub{},
-			},
-		},
-	}
+	p := newTestDynamicPolicy()
 
-	containerPathMap := map[string]*v1.Container{
-		"container1": {
-			Name: "container1",
-			Resources: v1.ResourceRequirements{
-				Requests: v1.ResourceList{
-					v1.ResourceCPU: resource2.MustParse("1"),
-				},
-			},
-		},
-		"container2": {
-			Name: "container2",
-			Resources: v1.ResourceRequirements{
-				Requests: v1.ResourceList{
-					v1.ResourceCPU: resource2.MustParse("2"),
+	newPod := func(name, qosLevel, cpuLimit string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+				Annotations: map[string]string{
+					consts.PodAnnotationQoSLevelKey: qosLevel,
 				},
 			},
-		},
-	}
-
-	pod := &v1.Pod{
-		Spec: v1.PodSpec{
-			Containers: []v1.Container{
-				{
-					Name: "container1",
-					Resources: v1.ResourceRequirements{
-						Requests: v1.ResourceList{
-							v1.ResourceCPU: resource2.MustParse("1"),
-						},
-					},
-				},
-				{
-					Name: "container2",
-					Resources: v1.ResourceRequirements{
-						Requests: v1.ResourceList{
-							v1.ResourceCPU: resource2.MustParse("2"),
+			Spec: v1.PodSpec{
+				Containers: []v1.Container{
+					{
+						Name: "test-container",
+						Resources: v1.ResourceRequirements{
+							Limits: v1.ResourceList{
+								v1.ResourceCPU: resource2.MustParse(cpuLimit),
+							},
 						},
 					},
 				},
 			},
-		},
+		}
 	}
 
-	mockCPU := &common.CPUStats{
+	podDirMap := map[string]*v1.Pod{
+		"shared-pod-1-dir":    newPod("shared-pod-1", consts.PodAnnotationQoSLevelSharedCores, "2"),
+		"shared-pod-2-dir":    newPod("shared-pod-2", consts.PodAnnotationQoSLevelSharedCores, "1"),
+		"reclaimed-pod-1-dir": newPod("reclaimed-pod-1", consts.PodAnnotationQoSLevelReclaimedCores, "4"),
+		"reclaimed-pod-2-dir": newPod("reclaimed-pod-2", consts.PodAnnotationQoSLevelReclaimedCores, "500m"),
+	}
+	mockPodDirs := []string{"shared-pod-1-dir", "shared-pod-2-dir", "reclaimed-pod-1-dir", "reclaimed-pod-2-dir"}
+
+	mockCal := &advisorsvc.CalculationInfo{
+		CgroupPath: "test_cgroup_path",
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test applied quota by qos level", t, func() {
+		var emitted map[string]int64
+		mockey.Mock((*DynamicPolicy).getCurrentPathAllPodsDirAndMap).IncludeCurrentGoRoutine().Return(map[string]*v1.Pod{}, mockPodDirs, nil).Build()
+		mockey.Mock((*DynamicPolicy).getPodAndRelativePath).IncludeCurrentGoRoutine().To(
+			func(_ *DynamicPolicy, _ string, podDir string, _ map[string]*v1.Pod) (*v1.Pod, string, error) {
+				return podDirMap[podDir], podDir, nil
+			}).Build()
+		mockey.Mock((*DynamicPolicy).applyAllContainersQuota).IncludeCurrentGoRoutine().Return(nil).Build()
+		mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().Return(nil).Build()
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(&common.CPUStats{CpuQuota: -1, CpuPeriod: 100000}, nil).Build()
+		mockey.Mock((*DynamicPolicy).emitAppliedQuotaByQoSLevel).IncludeCurrentGoRoutine().To(
+			func(_ *DynamicPolicy, _ string, appliedQuotaByQoSLevel map[string]int64) {
+				emitted = appliedQuotaByQoSLevel
+			}).Build()
+
+		// all pods are bounded by their own limits
+		_, err := p.checkAndApplyAllPodsQuota(context.TODO(), mockCal, 1000000)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(emitted[consts.PodAnnotationQoSLevelSharedCores], convey.ShouldEqual, 3000)
+		convey.So(emitted[consts.PodAnnotationQoSLevelReclaimedCores], convey.ShouldEqual, 4500)
+		convey.So(emitted[consts.PodAnnotationQoSLevelDedicatedCores], convey.ShouldEqual, 0)
+
+		// pods whose limits exceed the big group quota are set to unlimited and not counted
+		_, err = p.checkAndApplyAllPodsQuota(context.TODO(), mockCal, 150000)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(emitted[consts.PodAnnotationQoSLevelSharedCores], convey.ShouldEqual, 1000)
+		convey.So(emitted[consts.PodAnnotationQoSLevelReclaimedCores], convey.ShouldEqual, 500)
+	})
+
+	mockey.PatchConvey("test applied quota of ramped pods by qos level", t, func() {
+		rampedPolicy := newTestDynamicPolicy(withTestQuotaReconcileConf(&quotareconcile.QuotaReconcileConfiguration{
+			QuotaMaxIncreaseStepMilliCores: 500,
+		}))
+
+		var emitted map[string]int64
+		mockey.Mock((*DynamicPolicy).getCurrentPathAllPodsDirAndMap).IncludeCurrentGoRoutine().Return(map[string]*v1.Pod{}, []string{"shared-pod-1-dir"}, nil).Build()
+		mockey.Mock((*DynamicPolicy).getPodAndRelativePath).IncludeCurrentGoRoutine().Return(podDirMap["shared-pod-1-dir"], "shared-pod-1-dir", nil).Build()
+		mockey.Mock((*DynamicPolicy).applyAllContainersQuota).IncludeCurrentGoRoutine().Return(nil).Build()
+		mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().Return(nil).Build()
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(&common.CPUStats{CpuQuota: 100000, CpuPeriod: 100000}, nil).Build()
+		mockey.Mock((*DynamicPolicy).emitAppliedQuotaByQoSLevel).IncludeCurrentGoRoutine().To(
+			func(_ *DynamicPolicy, _ string, appliedQuotaByQoSLevel map[string]int64) {
+				emitted = appliedQuotaByQoSLevel
+			}).Build()
+
+		// the pod limited to 2 cores is ramped from 1 core by at most 500m, and the written quota is counted
+		_, err := rampedPolicy.checkAndApplyAllPodsQuota(context.TODO(), mockCal, 1000000)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(emitted[consts.PodAnnotationQoSLevelSharedCores], convey.ShouldEqual, 1500)
+
+		// pods are not counted without the qos config, rather than panicking
+		rampedPolicy.qosConfig = nil
+		_, err = rampedPolicy.checkAndApplyAllPodsQuota(context.TODO(), mockCal, 1000000)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(emitted[consts.PodAnnotationQoSLevelSharedCores], convey.ShouldEqual, 0)
+	})
+}
+
+func TestDynamicPolicy_newPodQuotaGracePeriod(t *testing.T) {
+	t.Parallel()
+
+	p := &DynamicPolicy{
+		emitter:   metrics.DummyMetrics{},
+		qosConfig: generic.NewQoSConfiguration(),
+		quotaReconcileConf: &quotareconcile.QuotaReconcileConfiguration{
+			NewPodQuotaGracePeriod: time.Minute,
+		},
+	}
+
+	testPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test-pod",
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{
+				{
+					Name: "test-container",
+					Resources: v1.ResourceRequirements{
+						Limits: v1.ResourceList{
+							v1.ResourceCPU: resource2.MustParse("1"),
+						},
+					},
+				},
+			},
+		},
+	}
+
+	mockCal := &advisorsvc.CalculationInfo{
+		CgroupPath: "test_cgroup_path",
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test quota of new pod is deferred until grace period passes", t, func() {
+		mockey.Mock((*DynamicPolicy).getCurrentPathAllPodsDirAndMap).IncludeCurrentGoRoutine().Return(map[string]*v1.Pod{}, []string{"test-pod-dir"}, nil).Build()
+		mockey.Mock((*DynamicPolicy).getPodAndRelativePath).IncludeCurrentGoRoutine().Return(testPod, "test-pod-dir", nil).Build()
+		mockey.Mock((*DynamicPolicy).applyAllContainersQuota).IncludeCurrentGoRoutine().Return(nil).Build()
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(&common.CPUStats{CpuQuota: -1, CpuPeriod: 100000}, nil).Build()
+		apply := mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().Return(nil).Build()
+
+		// freshly-created pod is left untouched
+		testPod.CreationTimestamp = metav1.NewTime(time.Now())
+		_, err := p.checkAndApplyAllPodsQuota(context.TODO(), mockCal, 1000000)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(apply.Times(), convey.ShouldEqual, 0)
+
+		// quota is applied normally once past the grace window
+		testPod.CreationTimestamp = metav1.NewTime(time.Now().Add(-2 * time.Minute))
+		_, err = p.checkAndApplyAllPodsQuota(context.TODO(), mockCal, 1000000)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(apply.Times(), convey.ShouldEqual, 1)
+	})
+}
+
+func TestDynamicPolicy_refreshQuotaReconcileConf_fromCRD(t *testing.T) {
+	t.Parallel()
+
+	dynamicConf := dynamicconfig.NewDynamicAgentConfiguration()
+	// the dynamic config manager rebuilds the configuration from the crd on every update
+	applyCRD := func(annotations map[string]string) {
+		c := dynamicconfig.NewConfiguration()
+		c.ApplyConfiguration(&crd.DynamicConfigCRD{
+			AdminQoSConfiguration: &configv1alpha1.AdminQoSConfiguration{
+				ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+			},
+		})
+		dynamicConf.SetDynamicConfiguration(c)
+	}
+	p := &DynamicPolicy{
+		emitter:       metrics.DummyMetrics{},
+		dynamicConfig: dynamicConf,
+	}
+
+	applyCRD(map[string]string{
+		quotareconcile.AnnotationKeyPrefix + "max-cgroup-writes-per-round":   "100",
+		quotareconcile.AnnotationKeyPrefix + "new-pod-grace-period":          "30s",
+		quotareconcile.AnnotationKeyPrefix + "zero-request-container-policy": quotareconcile.ZeroRequestContainerPolicySkip,
+		// knobs with invalid values or unknown names are ignored
+		quotareconcile.AnnotationKeyPrefix + "usage-weighted-container-quota": "maybe",
+		quotareconcile.AnnotationKeyPrefix + "unknown-knob":                   "1",
+		"unrelated-annotation": "2",
+	})
+	p.refreshQuotaReconcileConf()
+	conf := p.getQuotaReconcileConf()
+	assert.Equal(t, 100, conf.MaxCgroupWritesPerRound)
+	assert.Equal(t, 30*time.Second, conf.NewPodQuotaGracePeriod)
+	assert.Equal(t, quotareconcile.ZeroRequestContainerPolicySkip, conf.ZeroRequestContainerPolicy)
+	assert.False(t, conf.UsageWeightedContainerQuota)
+
+	// an update of the crd not changing any knob keeps the configuration in use
+	applyCRD(map[string]string{
+		quotareconcile.AnnotationKeyPrefix + "max-cgroup-writes-per-round":   "100",
+		quotareconcile.AnnotationKeyPrefix + "new-pod-grace-period":          "30s",
+		quotareconcile.AnnotationKeyPrefix + "zero-request-container-policy": quotareconcile.ZeroRequestContainerPolicySkip,
+	})
+	p.refreshQuotaReconcileConf()
+	assert.Same(t, conf, p.getQuotaReconcileConf())
+
+	applyCRD(map[string]string{
+		quotareconcile.AnnotationKeyPrefix + "max-cgroup-writes-per-round": "50",
+	})
+	p.refreshQuotaReconcileConf()
+	assert.NotSame(t, conf, p.getQuotaReconcileConf())
+	assert.Equal(t, 50, p.getQuotaReconcileConf().MaxCgroupWritesPerRound)
+}
+
+func TestDynamicPolicy_refreshQuotaReconcileConf(t *testing.T) {
+	t.Parallel()
+
+	dynamicConf := dynamicconfig.NewDynamicAgentConfiguration()
+	setQuotaReconcileConf := func(conf *quotareconcile.QuotaReconcileConfiguration) {
+		c := dynamicconfig.NewConfiguration()
+		c.QuotaReconcileConfiguration = conf
+		dynamicConf.SetDynamicConfiguration(c)
+	}
+	setQuotaReconcileConf(&quotareconcile.QuotaReconcileConfiguration{
+		NewPodQuotaGracePeriod: time.Hour,
+	})
+
+	p := &DynamicPolicy{
+		emitter:       metrics.DummyMetrics{},
+		qosConfig:     generic.NewQoSConfiguration(),
+		dynamicConfig: dynamicConf,
+	}
+
+	testPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "test-pod",
+			CreationTimestamp: metav1.NewTime(time.Now()),
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{
+				{
+					Name: "test-container",
+					Resources: v1.ResourceRequirements{
+						Limits: v1.ResourceList{
+							v1.ResourceCPU: resource2.MustParse("1"),
+						},
+					},
+				},
+			},
+		},
+	}
+
+	mockCal := &advisorsvc.CalculationInfo{
+		CgroupPath: "test_cgroup_path",
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test quota reconcile configuration is reloaded in the next round", t, func() {
+		mockey.Mock((*DynamicPolicy).getCurrentPathAllPodsDirAndMap).IncludeCurrentGoRoutine().Return(map[string]*v1.Pod{}, []string{"test-pod-dir"}, nil).Build()
+		mockey.Mock((*DynamicPolicy).getPodAndRelativePath).IncludeCurrentGoRoutine().Return(testPod, "test-pod-dir", nil).Build()
+		mockey.Mock((*DynamicPolicy).applyAllContainersQuota).IncludeCurrentGoRoutine().Return(nil).Build()
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(&common.CPUStats{CpuQuota: -1, CpuPeriod: 100000}, nil).Build()
+		apply := mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().Return(nil).Build()
+
+		err := p.applyCgroupConfigs(&advisorapi.ListAndWatchResponse{})
+		convey.So(err, convey.ShouldBeNil)
+		_, err = p.checkAndApplyAllPodsQuota(context.TODO(), mockCal, 1000000)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(apply.Times(), convey.ShouldEqual, 0)
+
+		// grace period is disabled at runtime
+		setQuotaReconcileConf(&quotareconcile.QuotaReconcileConfiguration{})
+		err = p.applyCgroupConfigs(&advisorapi.ListAndWatchResponse{})
+		convey.So(err, convey.ShouldBeNil)
+		_, err = p.checkAndApplyAllPodsQuota(context.TODO(), mockCal, 1000000)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(apply.Times(), convey.ShouldEqual, 1)
+
+		// invalid configuration is rejected and the last valid one is kept
+		setQuotaReconcileConf(&quotareconcile.QuotaReconcileConfiguration{
+			NewPodQuotaGracePeriod: -time.Hour,
+		})
+		err = p.applyCgroupConfigs(&advisorapi.ListAndWatchResponse{})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(p.getQuotaReconcileConf().NewPodQuotaGracePeriod, convey.ShouldEqual, 0)
+	})
+}
+
+func TestDynamicPolicy_skippedPodsByReason(t *testing.T) {
+	t.Parallel()
+
+	p := &DynamicPolicy{
+		emitter:   metrics.DummyMetrics{},
+		qosConfig: generic.NewQoSConfiguration(),
+		quotaReconcileConf: &quotareconcile.QuotaReconcileConfiguration{
+			NewPodQuotaGracePeriod: time.Minute,
+		},
+	}
+
+	newPod := func(name string, creationTime time.Time, withLimit bool) *v1.Pod {
+		container := v1.Container{Name: "test-container"}
+		if withLimit {
+			container.Resources.Limits = v1.ResourceList{
+				v1.ResourceCPU: resource2.MustParse("1"),
+			}
+		}
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				CreationTimestamp: metav1.NewTime(creationTime),
+			},
+			Spec: v1.PodSpec{
+				Containers: []v1.Container{container},
+			},
+		}
+	}
+
+	oldTime := time.Now().Add(-time.Hour)
+	podDirMap := map[string]*v1.Pod{
+		"new-pod-dir":      newPod("new-pod", time.Now(), true),
+		"no-limit-pod-dir": newPod("no-limit-pod", oldTime, false),
+		"normal-pod-dir":   newPod("normal-pod", oldTime, true),
+	}
+	mockPodDirs := []string{"missing-pod-1-dir", "missing-pod-2-dir", "new-pod-dir", "no-limit-pod-dir", "normal-pod-dir"}
+
+	mockCal := &advisorsvc.CalculationInfo{
+		CgroupPath: "test_cgroup_path",
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test skipped pods are counted by reason", t, func() {
+		mockey.Mock((*DynamicPolicy).getCurrentPathAllPodsDirAndMap).IncludeCurrentGoRoutine().Return(map[string]*v1.Pod{}, mockPodDirs, nil).Build()
+		mockey.Mock((*DynamicPolicy).getPodAndRelativePath).IncludeCurrentGoRoutine().To(
+			func(_ *DynamicPolicy, _ string, podDir string, _ map[string]*v1.Pod) (*v1.Pod, string, error) {
+				pod, ok := podDirMap[podDir]
+				if !ok {
+					return nil, "", fmt.Errorf("%w: %s", ErrPodNotFound, podDir)
+				}
+				return pod, podDir, nil
+			}).Build()
+		mockey.Mock((*DynamicPolicy).applyAllContainersQuota).IncludeCurrentGoRoutine().Return(nil).Build()
+		mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().Return(nil).Build()
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(&common.CPUStats{CpuQuota: -1, CpuPeriod: 100000}, nil).Build()
+
+		skipped := make(map[string]int64)
+		mockey.Mock(metrics.DummyMetrics.StoreInt64).IncludeCurrentGoRoutine().To(
+			func(_ metrics.DummyMetrics, key string, val int64, _ metrics.MetricTypeName, tags ...metrics.MetricTag) error {
+				if key != util.MetricNameQuotaReconcileSkippedPods {
+					return nil
+				}
+				for _, tag := range tags {
+					if tag.Key == "reason" {
+						skipped[tag.Val] += val
+					}
+				}
+				return nil
+			}).Build()
+
+		_, err := p.checkAndApplyAllPodsQuota(context.TODO(), mockCal, 1000000)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(skipped, convey.ShouldResemble, map[string]int64{
+			podSkipReasonNotFound:    2,
+			podSkipReasonGracePeriod: 1,
+			podSkipReasonNoCPULimit:  1,
+		})
+	})
+}
+
+func TestDynamicPolicy_cleanupStalePodQuotas(t *testing.T) {
+	t.Parallel()
+
+	p := &DynamicPolicy{
+		emitter:   metrics.DummyMetrics{},
+		qosConfig: generic.NewQoSConfiguration(),
+		quotaReconcileConf: &quotareconcile.QuotaReconcileConfiguration{
+			ResetStalePodQuota: true,
+		},
+	}
+
+	livePod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "live-pod",
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{
+				{
+					Name: "test-container",
+					Resources: v1.ResourceRequirements{
+						Limits: v1.ResourceList{
+							v1.ResourceCPU: resource2.MustParse("1"),
+						},
+					},
+				},
+			},
+		},
+	}
+
+	stalePath := filepath.Join("test_cgroup_path", "stale-pod-dir")
+	livePath := filepath.Join("test_cgroup_path", "live-pod-dir")
+	otherPath := filepath.Join("other_cgroup_path", "other-pod-dir")
+	p.getPodQuotaTracker().record(stalePath, 100000)
+	p.getPodQuotaTracker().record(otherPath, 100000)
+
+	mockCal := &advisorsvc.CalculationInfo{
+		CgroupPath: "test_cgroup_path",
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test quota record of stale pod dir is pruned", t, func() {
+		mockTestPodDirs([]string{"stale-pod-dir", "live-pod-dir"}, map[string]*v1.Pod{"live-pod-dir": livePod})
+		mockey.Mock((*DynamicPolicy).applyAllContainersQuota).IncludeCurrentGoRoutine().Return(nil).Build()
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(&common.CPUStats{CpuQuota: -1, CpuPeriod: 100000}, nil).Build()
+		mockey.Mock(general.IsPathExists).IncludeCurrentGoRoutine().Return(true).Build()
+		var resetPaths []string
+		mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(relativePath string, data *common.CPUData) error {
+			if relativePath == stalePath && data.CpuQuota == -1 {
+				resetPaths = append(resetPaths, relativePath)
+			}
+			return nil
+		}).Build()
+
+		// the stale record is kept in case the pod is missing transiently
+		for i := 0; i < stalePodQuotaToleranceRounds-1; i++ {
+			_, err := p.checkAndApplyAllPodsQuota(context.TODO(), mockCal, 1000000)
+			convey.So(err, convey.ShouldBeNil)
+			_, ok := p.getPodQuotaTracker().get(stalePath)
+			convey.So(ok, convey.ShouldBeTrue)
+		}
+		convey.So(resetPaths, convey.ShouldBeEmpty)
+
+		_, err := p.checkAndApplyAllPodsQuota(context.TODO(), mockCal, 1000000)
+		convey.So(err, convey.ShouldBeNil)
+		_, ok := p.getPodQuotaTracker().get(stalePath)
+		convey.So(ok, convey.ShouldBeFalse)
+		convey.So(resetPaths, convey.ShouldResemble, []string{stalePath})
+
+		// records of live pods and pods under other cgroup paths are kept
+		quota, ok := p.getPodQuotaTracker().get(livePath)
+		convey.So(ok, convey.ShouldBeTrue)
+		convey.So(quota, convey.ShouldEqual, 100000)
+		_, ok = p.getPodQuotaTracker().get(otherPath)
+		convey.So(ok, convey.ShouldBeTrue)
+
+		// pruning is idempotent
+		_, err = p.checkAndApplyAllPodsQuota(context.TODO(), mockCal, 1000000)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(resetPaths, convey.ShouldHaveLength, 1)
+	})
+}
+
+func TestDynamicPolicy_reconcileBudget(t *testing.T) {
+	t.Parallel()
+
+	fakeClock := testingclock.NewFakeClock(time.Now())
+	p := newTestDynamicPolicy(withTestClock(fakeClock), withTestQuotaReconcileConf(&quotareconcile.QuotaReconcileConfiguration{
+		ReconcileBudget: 3 * time.Second,
+	}))
+	var podDirs []string
+	for i := 0; i < 10; i++ {
+		podDirs = append(podDirs, fmt.Sprintf("pod-dir-%d", i))
+	}
+	calculationInfo := &advisorsvc.CalculationInfo{CgroupPath: "test_cgroup_path"}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test rounds are cut off by the budget and resumed in a round-robin manner", t, func() {
+		mockey.Mock((*DynamicPolicy).getCurrentPathAllPodsDirAndMap).IncludeCurrentGoRoutine().To(
+			func(_ *DynamicPolicy, _ string) (map[string]*v1.Pod, []string, error) {
+				return map[string]*v1.Pod{}, append([]string{}, podDirs...), nil
+			}).Build()
+		var reconciled []string
+		// each pod takes a second to reconcile
+		mockey.Mock((*DynamicPolicy).checkAndApplyPodQuota).IncludeCurrentGoRoutine().To(
+			func(_ *DynamicPolicy, _ context.Context, _, podDir string, _ map[string]*v1.Pod, _ int64, _ *podQuotaRound) error {
+				reconciled = append(reconciled, podDir)
+				fakeClock.Step(time.Second)
+				return nil
+			}).Build()
+
+		for _, expected := range [][]string{
+			{"pod-dir-0", "pod-dir-1", "pod-dir-2"},
+			{"pod-dir-3", "pod-dir-4", "pod-dir-5"},
+			{"pod-dir-6", "pod-dir-7", "pod-dir-8"},
+			{"pod-dir-9", "pod-dir-0", "pod-dir-1"},
+		} {
+			reconciled = nil
+			p.startReconcileBudget()
+			result, err := p.checkAndApplyAllPodsQuota(context.TODO(), calculationInfo, 1000000)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(result.Processed, convey.ShouldEqual, 3)
+			convey.So(reconciled, convey.ShouldResemble, expected)
+			convey.So(p.reconcileBudget.resumeCgroupPath, convey.ShouldEqual, calculationInfo.CgroupPath)
+		}
+
+		// all pods are reconciled in a round without the budget
+		reconciled = nil
+		p.quotaReconcileConf = &quotareconcile.QuotaReconcileConfiguration{}
+		p.startReconcileBudget()
+		_, err := p.checkAndApplyAllPodsQuota(context.TODO(), calculationInfo, 1000000)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(reconciled, convey.ShouldHaveLength, len(podDirs))
+		convey.So(reconciled[0], convey.ShouldEqual, "pod-dir-2")
+		convey.So(p.reconcileBudget.resumePodDirs, convey.ShouldNotContainKey, calculationInfo.CgroupPath)
+	})
+
+	calculationInfos := []*advisorsvc.CalculationInfo{{CgroupPath: "a"}, {CgroupPath: "b"}, {CgroupPath: "c"}}
+	assert.Equal(t, []*advisorsvc.CalculationInfo{calculationInfos[1], calculationInfos[2], calculationInfos[0]},
+		rotateCalculationInfos(calculationInfos, "b"))
+	assert.Equal(t, calculationInfos, rotateCalculationInfos(calculationInfos, "unknown"))
+	// the round resumes from the next pod if the pod to resume from is gone
+	assert.Equal(t, []string{"c", "d", "a"}, rotatePodDirs([]string{"a", "c", "d"}, "b"))
+}
+
+func TestDynamicPolicy_reconcileBudget_priority(t *testing.T) {
+	t.Parallel()
+
+	fakeClock := testingclock.NewFakeClock(time.Now())
+	p := newTestDynamicPolicy(withTestClock(fakeClock), withTestQuotaReconcileConf(&quotareconcile.QuotaReconcileConfiguration{
+		ReconcileBudget: 4 * time.Second,
+	}))
+	podDirs := []string{"pod-dir-a", "pod-dir-b", "pod-dir-c", "pod-dir-d", "pod-dir-e", "pod-dir-f"}
+	priorities := map[string]int32{"pod-dir-b": 100, "pod-dir-d": 1000, "pod-dir-f": 1000}
+	calculationInfo := &advisorsvc.CalculationInfo{CgroupPath: "test_cgroup_path"}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test higher-priority pods are reconciled first within the budget", t, func() {
+		mockey.Mock((*DynamicPolicy).getCurrentPathAllPodsDirAndMap).IncludeCurrentGoRoutine().To(
+			func(_ *DynamicPolicy, _ string) (map[string]*v1.Pod, []string, error) {
+				return map[string]*v1.Pod{}, append([]string{}, podDirs...), nil
+			}).Build()
+		mockey.Mock((*DynamicPolicy).getPodAndRelativePath).IncludeCurrentGoRoutine().To(
+			func(_ *DynamicPolicy, cgroupPath string, podDir string, _ map[string]*v1.Pod) (*v1.Pod, string, error) {
+				pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: podDir}}
+				if priority, ok := priorities[podDir]; ok {
+					pod.Spec.Priority = &priority
+				}
+				return pod, filepath.Join(cgroupPath, podDir), nil
+			}).Build()
+		var reconciled []string
+		// each pod takes a second to reconcile
+		mockey.Mock((*DynamicPolicy).checkAndApplyPodQuota).IncludeCurrentGoRoutine().To(
+			func(_ *DynamicPolicy, _ context.Context, _, podDir string, _ map[string]*v1.Pod, _ int64, _ *podQuotaRound) error {
+				reconciled = append(reconciled, podDir)
+				fakeClock.Step(time.Second)
+				return nil
+			}).Build()
+
+		// higher-priority pods are reconciled in every round, and the rest are resumed in a round-robin manner
+		for _, expected := range [][]string{
+			{"pod-dir-d", "pod-dir-f", "pod-dir-b", "pod-dir-a"},
+			{"pod-dir-d", "pod-dir-f", "pod-dir-b", "pod-dir-c"},
+			{"pod-dir-d", "pod-dir-f", "pod-dir-b", "pod-dir-e"},
+			{"pod-dir-d", "pod-dir-f", "pod-dir-b", "pod-dir-a"},
+		} {
+			reconciled = nil
+			p.startReconcileBudget()
+			result, err := p.checkAndApplyAllPodsQuota(context.TODO(), calculationInfo, 1000000)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(result.Processed, convey.ShouldEqual, 4)
+			convey.So(reconciled, convey.ShouldResemble, expected)
+		}
+	})
+
+	// the round resumes from the next pod of the tier if the pod to resume from is gone
+	assert.Equal(t, []string{"d", "f", "e", "a", "c"},
+		rotatePodDirsInPriorityTier([]string{"d", "f", "a", "c", "e"}, map[string]int32{"d": 1, "f": 1}, "d0"))
+}
+
+func TestDynamicPolicy_publishAppliedQuotas(t *testing.T) {
+	t.Parallel()
+
+	metricsFetcher := metric.NewFakeMetricsFetcher(metrics.DummyMetrics{})
+	p := newTestDynamicPolicy(withTestMetricsFetcher(metricsFetcher))
+	p.getPodQuotaTracker().record("/kubepods/offline/poduid-1", 200000)
+	p.getPodQuotaTracker().record("/kubepods/offline/poduid-2", -1)
+
+	p.publishAppliedQuotas()
+	metricsFetcher.Run(context.TODO())
+	data, err := metricsFetcher.GetCgroupMetric("/kubepods/offline/poduid-1", coreconsts.MetricCPUQuotaAppliedCgroup)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(200000), data.Value)
+	assert.NotNil(t, data.Time)
+	data, err = metricsFetcher.GetCgroupMetric("/kubepods/offline/poduid-2", coreconsts.MetricCPUQuotaAppliedCgroup)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(-1), data.Value)
+
+	// the store receives quotas of the latest reconcile
+	p.getPodQuotaTracker().record("/kubepods/offline/poduid-1", 150000)
+	p.publishAppliedQuotas()
+	metricsFetcher.Run(context.TODO())
+	data, err = metricsFetcher.GetCgroupMetric("/kubepods/offline/poduid-1", coreconsts.MetricCPUQuotaAppliedCgroup)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(150000), data.Value)
+}
+
+func TestDynamicPolicy_GetTrackedPodQuotas(t *testing.T) {
+	t.Parallel()
+
+	p := &DynamicPolicy{
+		emitter:   metrics.DummyMetrics{},
+		qosConfig: generic.NewQoSConfiguration(),
+	}
+	assert.Empty(t, p.GetTrackedPodQuotas())
+
+	newPod := func(name, cpuLimit string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+			},
+			Spec: v1.PodSpec{
+				Containers: []v1.Container{
+					{
+						Name: "test-container",
+						Resources: v1.ResourceRequirements{
+							Limits: v1.ResourceList{
+								v1.ResourceCPU: resource2.MustParse(cpuLimit),
+							},
+						},
+					},
+				},
+			},
+		}
+	}
+	pods := map[string]*v1.Pod{
+		"bounded-pod-dir":   newPod("bounded-pod", "1"),
+		"unlimited-pod-dir": newPod("unlimited-pod", "20"),
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test tracked pods reflect the last reconcile", t, func() {
+		mockTestPodDirs([]string{"bounded-pod-dir", "unlimited-pod-dir", "unknown-pod-dir"}, pods)
+		mockey.Mock((*DynamicPolicy).applyAllContainersQuota).IncludeCurrentGoRoutine().Return(nil).Build()
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(&common.CPUStats{CpuQuota: -1, CpuPeriod: 100000}, nil).Build()
+		mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().Return(nil).Build()
+
+		startTime := time.Now()
+		_, err := p.checkAndApplyAllPodsQuota(context.TODO(), &advisorsvc.CalculationInfo{CgroupPath: "test_cgroup_path"}, 1000000)
+		convey.So(err, convey.ShouldBeNil)
+
+		trackedPodQuotas := p.GetTrackedPodQuotas()
+		convey.So(trackedPodQuotas, convey.ShouldHaveLength, 2)
+		convey.So(trackedPodQuotas[0].PodRelativePath, convey.ShouldEqual, filepath.Join("test_cgroup_path", "bounded-pod-dir"))
+		convey.So(trackedPodQuotas[0].Quota, convey.ShouldEqual, 100000)
+		convey.So(trackedPodQuotas[1].PodRelativePath, convey.ShouldEqual, filepath.Join("test_cgroup_path", "unlimited-pod-dir"))
+		convey.So(trackedPodQuotas[1].Quota, convey.ShouldEqual, -1)
+		for _, trackedPodQuota := range trackedPodQuotas {
+			convey.So(trackedPodQuota.LastReconcileTime.Before(startTime), convey.ShouldBeFalse)
+		}
+
+		// the returned data is a copy
+		trackedPodQuotas[0].Quota = 0
+		quota, ok := p.getPodQuotaTracker().get(filepath.Join("test_cgroup_path", "bounded-pod-dir"))
+		convey.So(ok, convey.ShouldBeTrue)
+		convey.So(quota, convey.ShouldEqual, 100000)
+	})
+}
+
+func TestDynamicPolicy_quotaDriftDetection(t *testing.T) {
+	t.Parallel()
+
+	p := &DynamicPolicy{
+		emitter:   metrics.DummyMetrics{},
+		qosConfig: generic.NewQoSConfiguration(),
+	}
+
+	testPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test-pod",
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{
+				{
+					Name: "test-container",
+					Resources: v1.ResourceRequirements{
+						Limits: v1.ResourceList{
+							v1.ResourceCPU: resource2.MustParse("1"),
+						},
+					},
+				},
+			},
+		},
+	}
+	mockCal := &advisorsvc.CalculationInfo{
+		CgroupPath: "test_cgroup_path",
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test pods with drifted quota are counted", t, func() {
+		mockTestPodDirs([]string{"test-pod-dir"}, map[string]*v1.Pod{"test-pod-dir": testPod})
+		mockey.Mock((*DynamicPolicy).applyAllContainersQuota).IncludeCurrentGoRoutine().Return(nil).Build()
+		currentQuota := int64(-1)
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(_ string) (*common.CPUStats, error) {
+			return &common.CPUStats{CpuQuota: currentQuota, CpuPeriod: 100000}, nil
+		}).Build()
+		mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(_ string, data *common.CPUData) error {
+			currentQuota = data.CpuQuota
+			return nil
+		}).Build()
+		var driftedPods []int64
+		mockey.Mock(metrics.DummyMetrics.StoreInt64).IncludeCurrentGoRoutine().To(
+			func(_ metrics.DummyMetrics, key string, val int64, _ metrics.MetricTypeName, _ ...metrics.MetricTag) error {
+				if key == util.MetricNameQuotaReconcileDriftedPods {
+					driftedPods = append(driftedPods, val)
+				}
+				return nil
+			}).Build()
+
+		// no drift before any quota is applied
+		_, err := p.checkAndApplyAllPodsQuota(context.TODO(), mockCal, 1000000)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(currentQuota, convey.ShouldEqual, 100000)
+
+		// the quota is rewritten outside katalyst
+		currentQuota = 300000
+		_, err = p.checkAndApplyAllPodsQuota(context.TODO(), mockCal, 1000000)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(currentQuota, convey.ShouldEqual, 100000)
+
+		_, err = p.checkAndApplyAllPodsQuota(context.TODO(), mockCal, 1000000)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(driftedPods, convey.ShouldResemble, []int64{0, 1, 0})
+	})
+}
+
+func TestDynamicPolicy_podLabelSelector(t *testing.T) {
+	t.Parallel()
+
+	selector, err := labels.Parse("canary=true")
+	assert.NoError(t, err)
+	p := &DynamicPolicy{
+		emitter:   metrics.DummyMetrics{},
+		qosConfig: generic.NewQoSConfiguration(),
+		quotaReconcileConf: &quotareconcile.QuotaReconcileConfiguration{
+			PodLabelSelector: selector,
+		},
+	}
+
+	newPod := func(name string, podLabels map[string]string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   name,
+				Labels: podLabels,
+			},
+			Spec: v1.PodSpec{
+				Containers: []v1.Container{
+					{
+						Name: "test-container",
+						Resources: v1.ResourceRequirements{
+							Limits: v1.ResourceList{
+								v1.ResourceCPU: resource2.MustParse("1"),
+							},
+						},
+					},
+				},
+			},
+		}
+	}
+	pods := map[string]*v1.Pod{
+		"canary-pod-dir":     newPod("canary-pod", map[string]string{"canary": "true"}),
+		"non-canary-pod-dir": newPod("non-canary-pod", map[string]string{"canary": "false"}),
+		"unlabeled-pod-dir":  newPod("unlabeled-pod", nil),
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test only pods matching the label selector are processed", t, func() {
+		mockTestPodDirs([]string{"canary-pod-dir", "non-canary-pod-dir", "unlabeled-pod-dir"}, pods)
+		var processedPods []string
+		mockey.Mock((*DynamicPolicy).applyAllContainersQuota).IncludeCurrentGoRoutine().To(
+			func(_ *DynamicPolicy, _ context.Context, pod *v1.Pod, _ bool, _ float64) error {
+				processedPods = append(processedPods, pod.Name)
+				return nil
+			}).Build()
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(&common.CPUStats{CpuQuota: -1, CpuPeriod: 100000}, nil).Build()
+		mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().Return(nil).Build()
+		skipped := make(map[string]int64)
+		mockey.Mock(metrics.DummyMetrics.StoreInt64).IncludeCurrentGoRoutine().To(
+			func(_ metrics.DummyMetrics, key string, val int64, _ metrics.MetricTypeName, tags ...metrics.MetricTag) error {
+				if key != util.MetricNameQuotaReconcileSkippedPods {
+					return nil
+				}
+				for _, tag := range tags {
+					if tag.Key == "reason" {
+						skipped[tag.Val] += val
+					}
+				}
+				return nil
+			}).Build()
+
+		_, err := p.checkAndApplyAllPodsQuota(context.TODO(), &advisorsvc.CalculationInfo{CgroupPath: "test_cgroup_path"}, 1000000)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(processedPods, convey.ShouldResemble, []string{"canary-pod"})
+		convey.So(skipped, convey.ShouldResemble, map[string]int64{podSkipReasonLabelMismatch: 2})
+
+		// all pods are processed with an empty selector
+		processedPods = nil
+		p.quotaReconcileConf.PodLabelSelector = labels.Everything()
+		_, err = p.checkAndApplyAllPodsQuota(context.TODO(), &advisorsvc.CalculationInfo{CgroupPath: "test_cgroup_path"}, 1000000)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(processedPods, convey.ShouldHaveLength, 3)
+	})
+}
+
+func TestDynamicPolicy_podAdvisorDisabledAnnotation(t *testing.T) {
+	t.Parallel()
+
+	p := &DynamicPolicy{
+		emitter:   metrics.DummyMetrics{},
+		qosConfig: generic.NewQoSConfiguration(),
+	}
+
+	testPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test-pod",
+			Annotations: map[string]string{
+				cpuconsts.PodAnnotationAdvisorDisabledKey: "true",
+			},
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{
+				{
+					Name: "test-container",
+					Resources: v1.ResourceRequirements{
+						Limits: v1.ResourceList{
+							v1.ResourceCPU: resource2.MustParse("1"),
+						},
+					},
+				},
+			},
+		},
+	}
+	mockCal := &advisorsvc.CalculationInfo{
+		CgroupPath: "test_cgroup_path",
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test pods opted out by annotation are skipped", t, func() {
+		mockTestPodDirs([]string{"test-pod-dir"}, map[string]*v1.Pod{"test-pod-dir": testPod})
+		applyContainers := mockey.Mock((*DynamicPolicy).applyAllContainersQuota).IncludeCurrentGoRoutine().Return(nil).Build()
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(&common.CPUStats{CpuQuota: -1, CpuPeriod: 100000}, nil).Build()
+		apply := mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().Return(nil).Build()
+		skipped := make(map[string]int64)
+		mockey.Mock(metrics.DummyMetrics.StoreInt64).IncludeCurrentGoRoutine().To(
+			func(_ metrics.DummyMetrics, key string, val int64, _ metrics.MetricTypeName, tags ...metrics.MetricTag) error {
+				if key != util.MetricNameQuotaReconcileSkippedPods {
+					return nil
+				}
+				for _, tag := range tags {
+					if tag.Key == "reason" {
+						skipped[tag.Val] += val
+					}
+				}
+				return nil
+			}).Build()
+
+		_, err := p.checkAndApplyAllPodsQuota(context.TODO(), mockCal, 1000000)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(applyContainers.Times(), convey.ShouldEqual, 0)
+		convey.So(apply.Times(), convey.ShouldEqual, 0)
+		convey.So(skipped, convey.ShouldResemble, map[string]int64{podSkipReasonOptedOut: 1})
+
+		// the pod is reconciled again once the annotation is removed
+		delete(testPod.Annotations, cpuconsts.PodAnnotationAdvisorDisabledKey)
+		_, err = p.checkAndApplyAllPodsQuota(context.TODO(), mockCal, 1000000)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(applyContainers.Times(), convey.ShouldEqual, 1)
+		convey.So(apply.Times(), convey.ShouldEqual, 1)
+	})
+}
+
+func TestDynamicPolicy_terminatingPod(t *testing.T) {
+	t.Parallel()
+
+	p := &DynamicPolicy{
+		emitter:   metrics.DummyMetrics{},
+		qosConfig: generic.NewQoSConfiguration(),
+	}
+
+	testPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "test-pod",
+			DeletionTimestamp: &metav1.Time{Time: time.Now()},
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{
+				{
+					Name: "test-container",
+					Resources: v1.ResourceRequirements{
+						Limits: v1.ResourceList{
+							v1.ResourceCPU: resource2.MustParse("1"),
+						},
+					},
+				},
+			},
+		},
+	}
+	mockCal := &advisorsvc.CalculationInfo{
+		CgroupPath: "test_cgroup_path",
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test terminating pods are skipped", t, func() {
+		mockTestPodDirs([]string{"test-pod-dir"}, map[string]*v1.Pod{"test-pod-dir": testPod})
+		applyContainers := mockey.Mock((*DynamicPolicy).applyAllContainersQuota).IncludeCurrentGoRoutine().Return(nil).Build()
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(&common.CPUStats{CpuQuota: -1, CpuPeriod: 100000}, nil).Build()
+		apply := mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().Return(nil).Build()
+		skipped := make(map[string]int64)
+		mockey.Mock(metrics.DummyMetrics.StoreInt64).IncludeCurrentGoRoutine().To(
+			func(_ metrics.DummyMetrics, key string, val int64, _ metrics.MetricTypeName, tags ...metrics.MetricTag) error {
+				if key != util.MetricNameQuotaReconcileSkippedPods {
+					return nil
+				}
+				for _, tag := range tags {
+					if tag.Key == "reason" {
+						skipped[tag.Val] += val
+					}
+				}
+				return nil
+			}).Build()
+
+		_, err := p.checkAndApplyAllPodsQuota(context.TODO(), mockCal, 1000000)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(applyContainers.Times(), convey.ShouldEqual, 0)
+		convey.So(apply.Times(), convey.ShouldEqual, 0)
+		convey.So(skipped, convey.ShouldResemble, map[string]int64{podSkipReasonTerminating: 1})
+
+		// the same pod without the deletion timestamp is reconciled
+		testPod.DeletionTimestamp = nil
+		_, err = p.checkAndApplyAllPodsQuota(context.TODO(), mockCal, 1000000)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(applyContainers.Times(), convey.ShouldEqual, 1)
+		convey.So(apply.Times(), convey.ShouldEqual, 1)
+	})
+}
+
+func TestDynamicPolicy_reconcileResizedPods(t *testing.T) {
+	t.Parallel()
+
+	p := newTestDynamicPolicy()
+	groupPath := "/kubepods/offline"
+	podPath := groupPath + "/poduid-1"
+	p.lastCgroupConfigs = []*advisorsvc.CalculationInfo{{CgroupPath: groupPath}}
+	p.getReconcileCache().record(groupPath, &reconcileCacheEntry{})
+	cgroupState := map[string]*common.CPUStats{
+		groupPath:              {CpuQuota: 1000000, CpuPeriod: 100000},
+		podPath:                {CpuQuota: 100000, CpuPeriod: 100000},
+		podPath + "/uid-1-app": {CpuQuota: 100000, CpuPeriod: 100000},
+	}
+	// the limit of the pod is raised from 1 to 2 cores in place
+	pod := newScenarioPod("uid-1", scenarioContainer{name: "app", cpuLimit: "2"})
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test quota of pods resized in place is derived from their new resources", t, func() {
+		mockey.Mock(common.CheckCgroup2UnifiedMode).IncludeCurrentGoRoutine().Return(false).Build()
+		mockey.Mock((*DynamicPolicy).getAllPodsPathMap).IncludeCurrentGoRoutine().Return(map[string]*v1.Pod{
+			p.getAbsCgroupPath(common.DefaultSelectedSubsys, podPath): pod,
+		}, nil).Build()
+		mockey.Mock((*DynamicPolicy).getContainerRelativeCgroupPath).IncludeCurrentGoRoutine().To(
+			func(_ *DynamicPolicy, _, containerID string) (string, error) {
+				return filepath.Join(podPath, containerID), nil
+			}).Build()
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(path string) (*common.CPUStats, error) {
+			statsCopy := *cgroupState[path]
+			return &statsCopy, nil
+		}).Build()
+		apply := mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(path string, data *common.CPUData) error {
+			cgroupState[path].CpuQuota = data.CpuQuota
+			return nil
+		}).Build()
+
+		// nothing is reconciled until the pod is resized
+		p.reconcileResizedPods()
+		convey.So(apply.Times(), convey.ShouldEqual, 0)
+
+		p.markPodResized("uid-1")
+		convey.So(p.reconcileCache, convey.ShouldBeNil)
+		p.reconcileResizedPods()
+		convey.So(cgroupState[podPath].CpuQuota, convey.ShouldEqual, 200000)
+		convey.So(cgroupState[podPath+"/uid-1-app"].CpuQuota, convey.ShouldEqual, 200000)
+		convey.So(apply.Times(), convey.ShouldEqual, 2)
+
+		// the resized pod is only reconciled once
+		p.reconcileResizedPods()
+		convey.So(apply.Times(), convey.ShouldEqual, 2)
+	})
+}
+
+func TestDynamicPolicy_inplaceResizeAllocatedResources(t *testing.T) {
+	t.Parallel()
+
+	p := newTestDynamicPolicy()
+	groupPath := "/kubepods/offline"
+	podPath := groupPath + "/poduid-1"
+	scenario := reconcileScenario{
+		cgroupPath: groupPath,
+		resources:  &common.CgroupResources{CpuQuota: 1000000, CpuPeriod: 100000},
+		pods:       []*v1.Pod{newScenarioPod("uid-1", scenarioContainer{name: "app", cpuLimit: "1"})},
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test quota is derived from the allocated resources", t, func() {
+		quotas, err := runReconcileScenario(p, scenario)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(quotas[podPath], convey.ShouldEqual, 100000)
+		convey.So(quotas[podPath+"/uid-1-app"], convey.ShouldEqual, 100000)
+	})
+
+	// the limit of the pod is raised from 1 to 2 cores in place, which isn't allocated by kubelet yet
+	resizingPod := newScenarioPod("uid-1", scenarioContainer{name: "app", cpuLimit: "2"})
+	resizingPod.Annotations = map[string]string{consts.PodAnnotationInplaceUpdateResizingKey: "true"}
+	scenario.pods = []*v1.Pod{resizingPod}
+	mockey.PatchConvey("test quota of pods resizing in place is derived from the allocated resources", t, func() {
+		quotas, err := runReconcileScenario(p, scenario)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(quotas[podPath], convey.ShouldEqual, 100000)
+		convey.So(quotas[podPath+"/uid-1-app"], convey.ShouldEqual, 100000)
+		// the spec of the pod isn't touched
+		limit := resizingPod.Spec.Containers[0].Resources.Limits[v1.ResourceCPU]
+		convey.So(limit.MilliValue(), convey.ShouldEqual, 2000)
+	})
+
+	// kubelet allocates the resize
+	p.markPodResized("uid-1")
+	mockey.PatchConvey("test quota of pods is derived from the new resources once the resize is allocated", t, func() {
+		quotas, err := runReconcileScenario(p, scenario)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(quotas[podPath], convey.ShouldEqual, 200000)
+		convey.So(quotas[podPath+"/uid-1-app"], convey.ShouldEqual, 200000)
+	})
+}
+
+func TestDynamicPolicy_quotaReadBackSettleDelay(t *testing.T) {
+	t.Parallel()
+
+	start := time.Now()
+	fakeClock := testingclock.NewFakeClock(start)
+	p := newTestDynamicPolicy(withTestClock(fakeClock), withTestQuotaReconcileConf(&quotareconcile.QuotaReconcileConfiguration{
+		QuotaReadBackSettleDelay: 100 * time.Millisecond,
+	}))
+	scenario := reconcileScenario{
+		cgroupPath: "/kubepods/offline",
+		resources:  &common.CgroupResources{CpuQuota: 1000000, CpuPeriod: 100000},
+		pods:       []*v1.Pod{newScenarioPod("uid-1", scenarioContainer{name: "app", cpuLimit: "1"})},
+	}
+	podPath := "/kubepods/offline/poduid-1"
+	state := map[string]*common.CPUStats{}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test the drift check of pods written just now is deferred to the next round", t, func() {
+		mockReconcileScenario(p, scenario, state)
+		var driftedPods []int64
+		mockey.Mock(metrics.DummyMetrics.StoreInt64).IncludeCurrentGoRoutine().To(
+			func(_ metrics.DummyMetrics, key string, val int64, _ metrics.MetricTypeName, _ ...metrics.MetricTag) error {
+				if key == util.MetricNameQuotaReconcileDriftedPods {
+					driftedPods = append(driftedPods, val)
+				}
+				return nil
+			}).Build()
+		reconcile := func() {
+			_, err := p.checkAndApplyIfCgroupV1(&advisorsvc.CalculationInfo{CgroupPath: scenario.cgroupPath}, scenario.resources)
+			convey.So(err, convey.ShouldBeNil)
+		}
+
+		reconcile()
+		convey.So(state[podPath].CpuQuota, convey.ShouldEqual, 100000)
+
+		// a transient read-back right after the write is neither counted as drift nor rewritten, and nothing waits
+		state[podPath].CpuQuota = 50000
+		reconcile()
+		convey.So(state[podPath].CpuQuota, convey.ShouldEqual, 50000)
+		convey.So(fakeClock.Since(start), convey.ShouldEqual, 0)
+
+		// the read-back is checked once the settle delay passes
+		fakeClock.Step(time.Second)
+		reconcile()
+		convey.So(state[podPath].CpuQuota, convey.ShouldEqual, 100000)
+		convey.So(driftedPods, convey.ShouldResemble, []int64{0, 0, 1})
+	})
+}
+
+func TestDynamicPolicy_podQuotaUsagePercentile(t *testing.T) {
+	t.Parallel()
+
+	metricsFetcher := metric.NewFakeMetricsFetcher(metrics.DummyMetrics{}).(*metric.FakeMetricsFetcher)
+	testPod := newScenarioPod("uid-1", scenarioContainer{name: "app", cpuLimit: "4"})
+	p := newTestDynamicPolicy(withTestMetricsFetcher(metricsFetcher), withTestPods(testPod),
+		withTestQuotaReconcileConf(&quotareconcile.QuotaReconcileConfiguration{
+			PodQuotaUsagePercentile:              95,
+			PodQuotaUsagePercentileMarginPercent: 10,
+			PodQuotaUsageHistorySamples:          20,
+		}))
+	podPath := "/kubepods/offline/poduid-1"
+	scenario := reconcileScenario{
+		cgroupPath: "/kubepods/offline",
+		resources:  &common.CgroupResources{CpuQuota: 1000000, CpuPeriod: 100000},
+		pods:       []*v1.Pod{testPod},
+	}
+	// usage of the pod is spread from 0.1 to 2.0 cores, with spikes in the top samples
+	for i := 1; i <= 18; i++ {
+		p.getPodUsageHistory().add("uid-1", float64(i)/10, 20)
+	}
+	// samples of pods gone are pruned by sampling
+	p.getPodUsageHistory().add("uid-gone", 1, 20)
+
+	now := time.Now()
+	metricsFetcher.SetContainerMetric("uid-1", "app", coreconsts.MetricCPUUsageContainer, utilmetric.MetricData{Value: 1.9, Time: &now})
+	p.samplePodUsages()
+	_, samples := p.getPodUsageHistory().percentile("uid-gone", 95)
+	assert.Zero(t, samples)
+	assert.Equal(t, uint64(1), p.getPodUsageEpoch())
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test pod quota is sized by limit until the history is filled", t, func() {
+		quotas, err := runReconcileScenario(p, scenario)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(quotas[podPath], convey.ShouldEqual, 400000)
+	})
+	metricsFetcher.SetContainerMetric("uid-1", "app", coreconsts.MetricCPUUsageContainer, utilmetric.MetricData{Value: 2, Time: &now})
+	p.samplePodUsages()
+	mockey.PatchConvey("test pod quota is sized to p95 usage with the margin", t, func() {
+		quotas, err := runReconcileScenario(p, scenario)
+		convey.So(err, convey.ShouldBeNil)
+		// p95 of 20 samples is the 19th one of 1.9 cores, and 10% margin makes it 2.09 cores
+		convey.So(quotas[podPath], convey.ShouldEqual, 209000)
+	})
+}
+
+func TestDynamicPolicy_reconcileMetricsExport(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+	exported, err := metrics.NewOpenTelemetryPrometheusMetricsEmitter(generic.NewMetricsConfiguration(), reconcileMetricsPath, mux)
+	assert.NoError(t, err)
+	exporter := newReconcileMetricsExporter(metrics.DummyMetrics{}, exported)
+	p := newTestDynamicPolicy(withTestEmitter(exporter), withTestQuotaReconcileConf(&quotareconcile.QuotaReconcileConfiguration{
+		EnableMetricsExport: true,
+	}))
+	p.reconcileMetricsExporter = exporter
+	p.refreshReconcileMetricsExport()
+	noLimitPod := newScenarioPod("uid-2", scenarioContainer{name: "app", cpuLimit: "1"})
+	noLimitPod.Spec.Containers[0].Resources.Limits = nil
+	scenario := reconcileScenario{
+		cgroupPath: "/kubepods/offline",
+		resources:  &common.CgroupResources{CpuQuota: 1000000, CpuPeriod: 100000},
+		pods:       []*v1.Pod{newScenarioPod("uid-1", scenarioContainer{name: "app", cpuLimit: "1"}), noLimitPod},
+	}
+
+	scrape := func() (int, string) {
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, string(reconcileMetricsPath), nil))
+		return recorder.Code, recorder.Body.String()
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test reconcile metrics fed to the emitter are scraped from the exported path", t, func() {
+		_, err := runReconcileScenario(p, scenario)
+		convey.So(err, convey.ShouldBeNil)
+		// metrics other than reconcile ones aren't exported
+		_ = p.emitter.StoreInt64(util.MetricNameHeartBeat, 1, metrics.MetricTypeNameRaw)
+
+		code, body := scrape()
+		convey.So(code, convey.ShouldEqual, http.StatusOK)
+		convey.So(body, convey.ShouldContainSubstring, util.MetricNameQuotaReconcileDuration)
+		convey.So(body, convey.ShouldContainSubstring, util.MetricNameQuotaApplyOutcome)
+		convey.So(body, convey.ShouldContainSubstring, util.MetricNameQuotaReconcileSkippedPods)
+		convey.So(body, convey.ShouldContainSubstring, util.MetricNameQuotaReconcileDriftedPods)
+		convey.So(body, convey.ShouldNotContainSubstring, util.MetricNameHeartBeat)
+
+		// reconcile metrics aren't exported once it's disabled
+		p.quotaReconcileConf.EnableMetricsExport = false
+		p.refreshReconcileMetricsExport()
+		_ = p.emitter.StoreInt64(util.MetricNameCgroupWriteTimeout, 1, metrics.MetricTypeNameCount)
+		_, body = scrape()
+		convey.So(body, convey.ShouldNotContainSubstring, util.MetricNameCgroupWriteTimeout)
+	})
+}
+
+func TestNewReconcileMetricsEmitter(t *testing.T) {
+	t.Parallel()
+
+	scrapeCode := func(mux *http.ServeMux) int {
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, string(reconcileMetricsPath), nil))
+		return recorder.Code
+	}
+
+	// the path isn't registered and the emitter isn't wrapped by default
+	mux := http.NewServeMux()
+	emitterPool, err := metricspool.NewOpenTelemetryPrometheusMetricsEmitterPool(generic.NewMetricsConfiguration(), mux)
+	assert.NoError(t, err)
+	emitter, exporter, err := newReconcileMetricsEmitter(emitterPool, metrics.DummyMetrics{}, false)
+	assert.NoError(t, err)
+	assert.Nil(t, exporter)
+	assert.Equal(t, metrics.DummyMetrics{}, emitter)
+	assert.Equal(t, http.StatusNotFound, scrapeCode(mux))
+
+	// the path is registered once the export is enabled
+	mux = http.NewServeMux()
+	emitterPool, err = metricspool.NewOpenTelemetryPrometheusMetricsEmitterPool(generic.NewMetricsConfiguration(), mux)
+	assert.NoError(t, err)
+	emitter, exporter, err = newReconcileMetricsEmitter(emitterPool, metrics.DummyMetrics{}, true)
+	assert.NoError(t, err)
+	assert.NotNil(t, exporter)
+	assert.Equal(t, exporter, emitter)
+	assert.Equal(t, http.StatusOK, scrapeCode(mux))
+}
+
+func TestDynamicPolicy_checkPodThrottle(t *testing.T) {
+	t.Parallel()
+
+	cgroupRoot := t.TempDir()
+	podRelativePath := filepath.Join(common.CgroupFsRootPathBurstable, "podtest-pod-uid")
+	podAbsPath := filepath.Join(cgroupRoot, common.CgroupSubsysCPU, podRelativePath)
+	assert.NoError(t, os.MkdirAll(podAbsPath, 0o755))
+	writeCPUStat := func(nrPeriods, nrThrottled, throttledTime int) {
+		content := fmt.Sprintf("nr_periods %d\nnr_throttled %d\nthrottled_time %d\n", nrPeriods, nrThrottled, throttledTime)
+		assert.NoError(t, os.WriteFile(filepath.Join(podAbsPath, common.CgroupCPUStatFile), []byte(content), 0o644))
+	}
+
+	recorder := events.NewFakeRecorder(10)
+	p := newTestDynamicPolicy(withTestEventRecorder(recorder), withTestQuotaReconcileConf(&quotareconcile.QuotaReconcileConfiguration{
+		ThrottleAlertRatio: 0.5,
+	}))
+	p.cgroupRootOverride = cgroupRoot
+	testPod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-pod", UID: "test-pod-uid"}}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test an event is recorded for excessive throttling", t, func() {
+		mockey.Mock(common.CheckCgroup2UnifiedMode).IncludeCurrentGoRoutine().Return(false).Build()
+		var throttleRatio float64
+		var throttleTags []metrics.MetricTag
+		mockey.Mock(metrics.DummyMetrics.StoreFloat64).IncludeCurrentGoRoutine().To(
+			func(_ metrics.DummyMetrics, key string, val float64, _ metrics.MetricTypeName, tags ...metrics.MetricTag) error {
+				if key == util.MetricNamePodCPUThrottleRatio {
+					throttleRatio = val
+					throttleTags = tags
+				}
+				return nil
+			}).Build()
+
+		// the first sample can't tell the throttle ratio
+		writeCPUStat(100, 10, 1000000000)
+		p.checkPodThrottle(testPod, podRelativePath)
+		convey.So(recorder.Events, convey.ShouldBeEmpty)
+
+		// throttling below the alert ratio is only emitted
+		writeCPUStat(200, 30, 2000000000)
+		p.checkPodThrottle(testPod, podRelativePath)
+		convey.So(throttleRatio, convey.ShouldEqual, 0.2)
+		convey.So(throttleTags, convey.ShouldResemble, metrics.ConvertMapToTags(map[string]string{"qosLevel": consts.PodAnnotationQoSLevelSharedCores}))
+		convey.So(recorder.Events, convey.ShouldBeEmpty)
+
+		// throttling beyond the alert ratio fires a warning event
+		writeCPUStat(300, 120, 7000000000)
+		p.checkPodThrottle(testPod, podRelativePath)
+		convey.So(throttleRatio, convey.ShouldEqual, 0.9)
+		convey.So(recorder.Events, convey.ShouldHaveLength, 1)
+		event := <-recorder.Events
+		convey.So(event, convey.ShouldStartWith, v1.EventTypeWarning+" "+eventReasonCPUQuotaThrottled)
+		convey.So(event, convey.ShouldContainSubstring, "90.0% of periods (5.00s throttled)")
+
+		// counters reset by a recreated cgroup are not alerted
+		writeCPUStat(10, 9, 100)
+		p.checkPodThrottle(testPod, podRelativePath)
+		convey.So(recorder.Events, convey.ShouldBeEmpty)
+	})
+}
+
+func Test_readCPUThrottleStats(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, common.CgroupCPUStatFile),
+		[]byte("usage_usec 100\nnr_periods 10\nnr_throttled 2\nthrottled_usec 300\n"), 0o644))
+	stats, err := readCPUThrottleStats(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, &cpuThrottleStats{nrPeriods: 10, nrThrottled: 2, throttledTime: 300000}, stats)
+
+	_, err = readCPUThrottleStats(filepath.Join(dir, "not-exist"))
+	assert.Error(t, err)
+}
+
+func TestDynamicPolicy_checkPodQuotaStarvation(t *testing.T) {
+	t.Parallel()
+
+	p := newTestDynamicPolicy(withTestQuotaReconcileConf(&quotareconcile.QuotaReconcileConfiguration{
+		QuotaStarvationEvictionRounds: 3,
+		QuotaStarvationThrottleRatio:  0.5,
+	}))
+	p.quotaStarvationEviction = strategy.NewCPUQuotaStarvationEviction()
+	podRelativePath := filepath.Join(common.CgroupFsRootPathBurstable, "podtest-pod-uid")
+	testPod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "test-ns", Name: "test-pod", UID: "test-pod-uid"}}
+	getEvictPods := func(activePods ...*v1.Pod) []*evictionpluginapi.EvictPod {
+		resp, err := p.quotaStarvationEviction.GetEvictPods(context.TODO(), &evictionpluginapi.GetEvictPodsRequest{
+			ActivePods: activePods,
+		})
+		assert.NoError(t, err)
+		return resp.EvictPods
+	}
+
+	// rounds in which the pod is not at the floor, not throttled heavily, or throttling can't be told don't count
+	p.checkPodQuotaStarvation(testPod, podRelativePath, true, 0.9, true)
+	p.checkPodQuotaStarvation(testPod, podRelativePath, true, 0.9, true)
+	p.checkPodQuotaStarvation(testPod, podRelativePath, false, 0.9, true)
+	p.checkPodQuotaStarvation(testPod, podRelativePath, true, 0.9, true)
+	p.checkPodQuotaStarvation(testPod, podRelativePath, true, 0.3, true)
+	p.checkPodQuotaStarvation(testPod, podRelativePath, true, 0, false)
+	assert.Empty(t, getEvictPods(testPod))
+
+	// the pod is signaled once the starvation persists for the configured rounds
+	p.checkPodQuotaStarvation(testPod, podRelativePath, true, 0.9, true)
+	p.checkPodQuotaStarvation(testPod, podRelativePath, true, 0.9, true)
+	assert.Empty(t, getEvictPods(testPod))
+	p.checkPodQuotaStarvation(testPod, podRelativePath, true, 0.9, true)
+	evictPods := getEvictPods(testPod)
+	assert.Len(t, evictPods, 1)
+	assert.Equal(t, testPod, evictPods[0].Pod)
+	assert.Contains(t, evictPods[0].Reason, "held at the floor for 3 reconcile rounds")
+
+	// the signal is cleared once the pod recovers
+	p.checkPodQuotaStarvation(testPod, podRelativePath, true, 0.1, true)
+	assert.Empty(t, getEvictPods(testPod))
+
+	// signals of pods no longer active are dropped
+	for i := 0; i < 3; i++ {
+		p.checkPodQuotaStarvation(testPod, podRelativePath, true, 0.9, true)
+	}
+	assert.Empty(t, getEvictPods())
+	assert.Empty(t, getEvictPods(testPod))
+}
+
+func TestDynamicPolicy_podFailureBackoff(t *testing.T) {
+	t.Parallel()
+
+	fakeClock := testingclock.NewFakeClock(time.Now())
+	p := newTestDynamicPolicy(withTestClock(fakeClock), withTestQuotaReconcileConf(&quotareconcile.QuotaReconcileConfiguration{
+		PodFailureBackoffBase: 10 * time.Second,
+		PodFailureBackoffMax:  30 * time.Second,
+	}))
+
+	testPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test-pod",
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{
+				{
+					Name: "test-container",
+					Resources: v1.ResourceRequirements{
+						Limits: v1.ResourceList{
+							v1.ResourceCPU: resource2.MustParse("1"),
+						},
+					},
+				},
+			},
+		},
+	}
+	mockCal := &advisorsvc.CalculationInfo{
+		CgroupPath: "test_cgroup_path",
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test failing pods are backed off", t, func() {
+		mockTestPodDirs([]string{"test-pod-dir"}, map[string]*v1.Pod{"test-pod-dir": testPod})
+		applyErr := fmt.Errorf("permission denied")
+		applyContainers := mockey.Mock((*DynamicPolicy).applyAllContainersQuota).IncludeCurrentGoRoutine().To(
+			func(_ *DynamicPolicy, _ context.Context, _ *v1.Pod, _ bool, _ float64) error {
+				return applyErr
+			}).Build()
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(&common.CPUStats{CpuQuota: -1, CpuPeriod: 100000}, nil).Build()
+		mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().Return(nil).Build()
+		var backoffPods int64
+		skipped := make(map[string]int64)
+		mockey.Mock(metrics.DummyMetrics.StoreInt64).IncludeCurrentGoRoutine().To(
+			func(_ metrics.DummyMetrics, key string, val int64, _ metrics.MetricTypeName, tags ...metrics.MetricTag) error {
+				switch key {
+				case util.MetricNameQuotaReconcileBackoffPods:
+					backoffPods = val
+				case util.MetricNameQuotaReconcileSkippedPods:
+					for _, tag := range tags {
+						if tag.Key == "reason" {
+							skipped[tag.Val] += val
+						}
+					}
+				}
+				return nil
+			}).Build()
+
+		reconcile := func() {
+			_, err := p.checkAndApplyAllPodsQuota(context.TODO(), mockCal, 1000000)
+			convey.So(err, convey.ShouldBeNil)
+		}
+
+		// the first failure backs the pod off for the base
+		reconcile()
+		convey.So(applyContainers.Times(), convey.ShouldEqual, 1)
+		convey.So(backoffPods, convey.ShouldEqual, 1)
+		fakeClock.Step(5 * time.Second)
+		reconcile()
+		convey.So(applyContainers.Times(), convey.ShouldEqual, 1)
+		convey.So(skipped[podSkipReasonBackoff], convey.ShouldEqual, 1)
+
+		// the backoff is doubled with each consecutive failure
+		fakeClock.Step(5 * time.Second)
+		reconcile()
+		convey.So(applyContainers.Times(), convey.ShouldEqual, 2)
+		fakeClock.Step(19 * time.Second)
+		reconcile()
+		convey.So(applyContainers.Times(), convey.ShouldEqual, 2)
+		convey.So(skipped[podSkipReasonBackoff], convey.ShouldEqual, 2)
+
+		// and it's no more than the max
+		fakeClock.Step(time.Second)
+		reconcile()
+		convey.So(applyContainers.Times(), convey.ShouldEqual, 3)
+		fakeClock.Step(29 * time.Second)
+		reconcile()
+		convey.So(applyContainers.Times(), convey.ShouldEqual, 3)
+		convey.So(skipped[podSkipReasonBackoff], convey.ShouldEqual, 3)
+
+		// the backoff is reset once the pod is applied
+		applyErr = nil
+		fakeClock.Step(time.Second)
+		reconcile()
+		convey.So(applyContainers.Times(), convey.ShouldEqual, 4)
+		convey.So(backoffPods, convey.ShouldEqual, 0)
+		reconcile()
+		convey.So(applyContainers.Times(), convey.ShouldEqual, 5)
+		convey.So(skipped[podSkipReasonBackoff], convey.ShouldEqual, 3)
+	})
+}
+
+func TestDynamicPolicy_applyAllContainersQuota(t *testing.T) {
+	t.Parallel()
+
+	p := newTestDynamicPolicy()
+
+	containerPathMap := map[string]*v1.Container{
+		"container1": {
+			Name: "container1",
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{
+					v1.ResourceCPU: resource2.MustParse("1"),
+				},
+			},
+		},
+		"container2": {
+			Name: "container2",
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{
+					v1.ResourceCPU: resource2.MustParse("2"),
+				},
+			},
+		},
+	}
+
+	pod := &v1.Pod{
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{
+				{
+					Name: "container1",
+					Resources: v1.ResourceRequirements{
+						Requests: v1.ResourceList{
+							v1.ResourceCPU: resource2.MustParse("1"),
+						},
+					},
+				},
+				{
+					Name: "container2",
+					Resources: v1.ResourceRequirements{
+						Requests: v1.ResourceList{
+							v1.ResourceCPU: resource2.MustParse("2"),
+						},
+					},
+				},
+			},
+		},
+	}
+
+	mockCPU := &common.CPUStats{
 		CpuQuota:  1,
 		CpuPeriod: 1000,
 	}
 
 	advisorTestMutex.Lock()
 	defer advisorTestMutex.Unlock()
-	mockey.PatchConvey("test checkAllContainersQuota", t, func() {
-		mockey.Mock((*DynamicPolicy).getAllContainersRelativePathMap).IncludeCurrentGoRoutine().Return(containerPathMap).Build()
-		mockey.Mock((*DynamicPolicy).applyAllSubCgroupQuotaToUnLimit).IncludeCurrentGoRoutine().Return(nil).Build()
-		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(mockCPU, nil).Build()
-		apply := mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().Return(nil).Build()
+	mockey.PatchConvey("test checkAllContainersQuota", t, func() {
+		mockey.Mock((*DynamicPolicy).getAllContainersRelativePathMap).IncludeCurrentGoRoutine().Return(containerPathMap).Build()
+		mockey.Mock((*DynamicPolicy).applyAllSubCgroupQuotaToUnLimit).IncludeCurrentGoRoutine().Return(nil).Build()
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(mockCPU, nil).Build()
+		apply := mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().Return(nil).Build()
+
+		err := p.applyAllContainersQuota(context.TODO(), pod, true, 1)
+
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(apply.Times(), convey.ShouldEqual, 2)
+
+		err = p.applyAllContainersQuota(context.TODO(), pod, false, 1)
+		convey.So(err, convey.ShouldBeNil)
+	})
+}
+
+func TestDynamicPolicy_applyAllContainersQuota_pendingPath(t *testing.T) {
+	t.Parallel()
+
+	cgroupRoot := t.TempDir()
+	podRelativePath := filepath.Join(common.CgroupFsRootPathBurstable, "podtest-pod-uid")
+	startedPath := filepath.Join(podRelativePath, "started-container-id")
+	pendingPath := filepath.Join(podRelativePath, "pending-container-id")
+	assert.NoError(t, os.MkdirAll(filepath.Join(cgroupRoot, common.CgroupSubsysCPU, startedPath), 0o755))
+
+	limitedContainer := func(name string) *v1.Container {
+		return &v1.Container{
+			Name: name,
+			Resources: v1.ResourceRequirements{
+				Limits: v1.ResourceList{v1.ResourceCPU: resource2.MustParse("1")},
+			},
+		}
+	}
+	testPod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-pod", UID: "test-pod-uid"}}
+	p := newTestDynamicPolicy()
+	p.cgroupRootOverride = cgroupRoot
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test containers whose cgroups are not created yet are skipped", t, func() {
+		mockey.Mock(common.CheckCgroup2UnifiedMode).IncludeCurrentGoRoutine().Return(false).Build()
+		mockey.Mock((*DynamicPolicy).getAllContainersRelativePathMap).IncludeCurrentGoRoutine().Return(map[string]*v1.Container{
+			startedPath: limitedContainer("started"),
+			pendingPath: limitedContainer("pending"),
+		}).Build()
+		cgroupManager := &fakeCgroupManager{cpuStats: &common.CPUStats{CpuQuota: -1, CpuPeriod: 100000}}
+		mockey.Mock(cgroupmgr.GetManagerForHierarchy).IncludeCurrentGoRoutine().Return(cgroupManager).Build()
+		var notFound [][]metrics.MetricTag
+		mockey.Mock(metrics.DummyMetrics.StoreInt64).IncludeCurrentGoRoutine().To(
+			func(_ metrics.DummyMetrics, key string, _ int64, _ metrics.MetricTypeName, tags ...metrics.MetricTag) error {
+				if key == util.MetricNameCgroupPathNotFound {
+					notFound = append(notFound, tags)
+				}
+				return nil
+			}).Build()
+
+		convey.So(p.applyAllContainersQuota(context.TODO(), testPod, true, 1), convey.ShouldBeNil)
+		// cpu quota is written under the cgroup root override
+		convey.So(cgroupManager.appliedCPU, convey.ShouldResemble,
+			map[string]int64{filepath.Join(cgroupRoot, common.CgroupSubsysCPU, startedPath): 100000})
+		// the missing path is counted by the qos level of the pod rather than tagged by the pod itself
+		convey.So(notFound, convey.ShouldResemble, [][]metrics.MetricTag{
+			metrics.ConvertMapToTags(map[string]string{"qosLevel": consts.PodAnnotationQoSLevelSharedCores}),
+		})
+		convey.So(p.isContainerCgroupPathPending(pendingPath), convey.ShouldBeTrue)
+
+		// cgroups of pods gone as a whole are left to fail the write
+		convey.So(p.isContainerCgroupPathPending(filepath.Join(common.CgroupFsRootPathBurstable, "podgone", "id")), convey.ShouldBeFalse)
+	})
+}
+
+func TestDynamicPolicy_roundCPUQuota(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		policy string
+		quota  int64
+		want   int64
+	}{
+		{name: "empty policy keeps the exact quota", policy: "", quota: 123400, want: 123400},
+		{name: "none keeps the exact quota", policy: quotareconcile.QuotaRoundingPolicyNone, quota: 123400, want: 123400},
+		{name: "up rounds to the next tick", policy: quotareconcile.QuotaRoundingPolicyUp, quota: 123400, want: 124000},
+		{name: "up keeps whole ticks", policy: quotareconcile.QuotaRoundingPolicyUp, quota: 123000, want: 123000},
+		{name: "nearest rounds down below half a tick", policy: quotareconcile.QuotaRoundingPolicyNearest, quota: 123400, want: 123000},
+		{name: "nearest rounds up from half a tick", policy: quotareconcile.QuotaRoundingPolicyNearest, quota: 123500, want: 124000},
+		{name: "nearest never rounds to zero", policy: quotareconcile.QuotaRoundingPolicyNearest, quota: 300, want: 1000},
+		{name: "unlimited quota is kept", policy: quotareconcile.QuotaRoundingPolicyUp, quota: -1, want: -1},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			p := newTestDynamicPolicy(withTestQuotaReconcileConf(&quotareconcile.QuotaReconcileConfiguration{
+				QuotaRoundingPolicy: tt.policy,
+			}))
+			assert.Equal(t, tt.want, p.roundCPUQuota(tt.quota))
+		})
+	}
+
+	p := newTestDynamicPolicy(withTestQuotaReconcileConf(&quotareconcile.QuotaReconcileConfiguration{
+		QuotaRoundingPolicy: quotareconcile.QuotaRoundingPolicyUp,
+	}))
+	testPod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-pod"}}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test container quota is rounded before it's applied", t, func() {
+		mockey.Mock((*DynamicPolicy).getAllContainersRelativePathMap).IncludeCurrentGoRoutine().Return(map[string]*v1.Container{
+			"test-path": {
+				Name: "test-container",
+				Resources: v1.ResourceRequirements{
+					Limits: v1.ResourceList{v1.ResourceCPU: resource2.MustParse("1234m")},
+				},
+			},
+		}).Build()
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(&common.CPUStats{CpuQuota: -1, CpuPeriod: 100000}, nil).Build()
+		var applied []int64
+		mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(_ string, data *common.CPUData) error {
+			applied = append(applied, data.CpuQuota)
+			return nil
+		}).Build()
+
+		convey.So(p.applyAllContainersQuota(context.TODO(), testPod, true, 1), convey.ShouldBeNil)
+		convey.So(applied, convey.ShouldResemble, []int64{124000})
+	})
+}
+
+func TestDynamicPolicy_applyAllContainersQuota_usageWeighted(t *testing.T) {
+	t.Parallel()
+
+	limitedContainer := func(name string) *v1.Container {
+		return &v1.Container{
+			Name: name,
+			Resources: v1.ResourceRequirements{
+				Limits: v1.ResourceList{
+					v1.ResourceCPU: resource2.MustParse("2"),
+				},
+			},
+		}
+	}
+	containerPathMap := map[string]*v1.Container{
+		"busy-path": limitedContainer("busy"),
+		"idle-path": limitedContainer("idle"),
+	}
+	testPod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-pod", UID: "pod-uid"}}
+
+	metricsFetcher := metric.NewFakeMetricsFetcher(metrics.DummyMetrics{}).(*metric.FakeMetricsFetcher)
+	now := time.Now()
+	metricsFetcher.SetContainerMetric("pod-uid", "busy", coreconsts.MetricCPUUsageContainer, utilmetric.MetricData{Value: 3, Time: &now})
+
+	p := &DynamicPolicy{
+		metaServer: &metaserver.MetaServer{
+			MetaAgent: &agent.MetaAgent{
+				MetricsFetcher: metricsFetcher,
+			},
+		},
+		emitter: metrics.DummyMetrics{},
+		quotaReconcileConf: &quotareconcile.QuotaReconcileConfiguration{
+			UsageWeightedContainerQuota:   true,
+			ContainerQuotaFloorMilliCores: 200,
+		},
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test pod quota is distributed among containers by usage", t, func() {
+		applied := make(map[string]int64)
+		mockey.Mock((*DynamicPolicy).getAllContainersRelativePathMap).IncludeCurrentGoRoutine().Return(containerPathMap).Build()
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(&common.CPUStats{CpuPeriod: 100000}, nil).Build()
+		mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(relativePath string, data *common.CPUData) error {
+			applied[relativePath] = data.CpuQuota
+			return nil
+		}).Build()
+
+		// usage of the idle container is unavailable, so containers are applied with their own limits
+		convey.So(p.applyAllContainersQuota(context.TODO(), testPod, true, 1), convey.ShouldBeNil)
+		convey.So(applied, convey.ShouldResemble, map[string]int64{"busy-path": 200000, "idle-path": 200000})
+
+		// floors of 200m are held, and the rest 3600m is distributed by usage of 3:1
+		metricsFetcher.SetContainerMetric("pod-uid", "idle", coreconsts.MetricCPUUsageContainer, utilmetric.MetricData{Value: 1, Time: &now})
+		convey.So(p.applyAllContainersQuota(context.TODO(), testPod, true, 1), convey.ShouldBeNil)
+		convey.So(applied, convey.ShouldResemble, map[string]int64{"busy-path": 290000, "idle-path": 110000})
+	})
+}
+
+func TestDynamicPolicy_applyAllContainersQuota_zeroRequestContainer(t *testing.T) {
+	t.Parallel()
+
+	containerPathMap := map[string]*v1.Container{
+		"requested-path": {
+			Name: "requested",
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{v1.ResourceCPU: resource2.MustParse("1")},
+				Limits:   v1.ResourceList{v1.ResourceCPU: resource2.MustParse("2")},
+			},
+		},
+		"unrequested-path": {
+			Name: "unrequested",
+			Resources: v1.ResourceRequirements{
+				Limits: v1.ResourceList{v1.ResourceCPU: resource2.MustParse("2")},
+			},
+		},
+	}
+	testPod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-pod", UID: "pod-uid"}}
+	p := &DynamicPolicy{
+		emitter: metrics.DummyMetrics{},
+	}
+
+	tests := []struct {
+		name    string
+		conf    *quotareconcile.QuotaReconcileConfiguration
+		applied map[string]int64
+		// subCgroupsUnlimited are containers whose sub cgroups are applied to unlimited
+		subCgroupsUnlimited []string
+	}{
+		{
+			name:    "zero-request containers are sized by their limits by default",
+			conf:    &quotareconcile.QuotaReconcileConfiguration{},
+			applied: map[string]int64{"requested-path": 200000, "unrequested-path": 200000},
+		},
+		{
+			name:    "zero-request containers are sized by their limits with the limit policy",
+			conf:    &quotareconcile.QuotaReconcileConfiguration{ZeroRequestContainerPolicy: quotareconcile.ZeroRequestContainerPolicyLimit},
+			applied: map[string]int64{"requested-path": 200000, "unrequested-path": 200000},
+		},
+		{
+			name:    "zero-request containers are left as is with the skip policy",
+			conf:    &quotareconcile.QuotaReconcileConfiguration{ZeroRequestContainerPolicy: quotareconcile.ZeroRequestContainerPolicySkip},
+			applied: map[string]int64{"requested-path": 200000},
+		},
+		{
+			name:                "zero-request containers are applied to unlimited with the unlimited policy",
+			conf:                &quotareconcile.QuotaReconcileConfiguration{ZeroRequestContainerPolicy: quotareconcile.ZeroRequestContainerPolicyUnlimited},
+			applied:             map[string]int64{"requested-path": 200000, "unrequested-path": -1},
+			subCgroupsUnlimited: []string{"unrequested-path"},
+		},
+		{
+			name: "zero-request containers are applied with the default quota with the default policy",
+			conf: &quotareconcile.QuotaReconcileConfiguration{
+				ZeroRequestContainerPolicy:                 quotareconcile.ZeroRequestContainerPolicyDefault,
+				ZeroRequestContainerDefaultQuotaMilliCores: 500,
+			},
+			applied: map[string]int64{"requested-path": 200000, "unrequested-path": 50000},
+		},
+		{
+			name: "the default quota of zero-request containers is clamped to their limits",
+			conf: &quotareconcile.QuotaReconcileConfiguration{
+				ZeroRequestContainerPolicy:                 quotareconcile.ZeroRequestContainerPolicyDefault,
+				ZeroRequestContainerDefaultQuotaMilliCores: 3000,
+			},
+			applied: map[string]int64{"requested-path": 200000, "unrequested-path": 200000},
+		},
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test quota of zero-request containers", t, func() {
+		applied := make(map[string]int64)
+		mockey.Mock((*DynamicPolicy).getAllContainersRelativePathMap).IncludeCurrentGoRoutine().Return(containerPathMap).Build()
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(&common.CPUStats{CpuQuota: 10000, CpuPeriod: 100000}, nil).Build()
+		mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(relativePath string, data *common.CPUData) error {
+			applied[relativePath] = data.CpuQuota
+			return nil
+		}).Build()
+		var subCgroupsUnlimited []string
+		mockey.Mock((*DynamicPolicy).applyAllSubCgroupQuotaToUnLimit).IncludeCurrentGoRoutine().To(
+			func(_ *DynamicPolicy, _ context.Context, relativePath string) error {
+				subCgroupsUnlimited = append(subCgroupsUnlimited, relativePath)
+				return nil
+			}).Build()
+
+		for _, tt := range tests {
+			convey.Convey(tt.name, func() {
+				for path := range applied {
+					delete(applied, path)
+				}
+				subCgroupsUnlimited = nil
+				p.quotaReconcileConf = tt.conf
+				convey.So(tt.conf.Validate(), convey.ShouldBeNil)
+				convey.So(p.applyAllContainersQuota(context.TODO(), testPod, true, 1), convey.ShouldBeNil)
+				convey.So(applied, convey.ShouldResemble, tt.applied)
+				convey.So(subCgroupsUnlimited, convey.ShouldResemble, tt.subCgroupsUnlimited)
+			})
+		}
+	})
+}
+
+func TestDynamicPolicy_applyAllContainersQuota_usageSafetyMargin(t *testing.T) {
+	t.Parallel()
+
+	limitedContainer := func(name string) *v1.Container {
+		return &v1.Container{
+			Name: name,
+			Resources: v1.ResourceRequirements{
+				Limits: v1.ResourceList{
+					v1.ResourceCPU: resource2.MustParse("1"),
+				},
+			},
+		}
+	}
+	containerPathMap := map[string]*v1.Container{
+		"busy-path": limitedContainer("busy"),
+		"calm-path": limitedContainer("calm"),
+	}
+	testPod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-pod", UID: "pod-uid"}}
+
+	metricsFetcher := metric.NewFakeMetricsFetcher(metrics.DummyMetrics{}).(*metric.FakeMetricsFetcher)
+	now := time.Now()
+	metricsFetcher.SetContainerMetric("pod-uid", "busy", coreconsts.MetricCPUUsageContainer, utilmetric.MetricData{Value: 1, Time: &now})
+	metricsFetcher.SetContainerMetric("pod-uid", "calm", coreconsts.MetricCPUUsageContainer, utilmetric.MetricData{Value: 0.5, Time: &now})
+	p := newTestDynamicPolicy(withTestMetricsFetcher(metricsFetcher), withTestQuotaReconcileConf(&quotareconcile.QuotaReconcileConfiguration{
+		UsageWeightedContainerQuota:   true,
+		UsageQuotaSafetyMarginPercent: 50,
+	}))
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test usage-weighted quota keeps the safety margin above usage", t, func() {
+		applied := make(map[string]int64)
+		mockey.Mock((*DynamicPolicy).getAllContainersRelativePathMap).IncludeCurrentGoRoutine().Return(containerPathMap).Build()
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(&common.CPUStats{CpuPeriod: 100000}, nil).Build()
+		mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(relativePath string, data *common.CPUData) error {
+			applied[relativePath] = data.CpuQuota
+			return nil
+		}).Build()
+
+		// the 2000m is distributed by usage of 2:1 into 1333m and 666m, the calm container is raised to its usage
+		// of 500m plus 50%, and the margin of the busy container is clamped to its limit of 1000m below its share
+		convey.So(p.applyAllContainersQuota(context.TODO(), testPod, true, 1), convey.ShouldBeNil)
+		convey.So(applied, convey.ShouldResemble, map[string]int64{"busy-path": 133300, "calm-path": 75000})
+	})
+}
+
+func TestDynamicPolicy_applyAllContainersQuota_exclusiveCores(t *testing.T) {
+	t.Parallel()
+
+	guaranteedContainer := func(name, cpu string) v1.Container {
+		resources := v1.ResourceList{
+			v1.ResourceCPU:    resource2.MustParse(cpu),
+			v1.ResourceMemory: resource2.MustParse("1Gi"),
+		}
+		return v1.Container{
+			Name:      name,
+			Resources: v1.ResourceRequirements{Requests: resources, Limits: resources},
+		}
+	}
+	testPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pod"},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{
+				guaranteedContainer("exclusive", "2"),
+				guaranteedContainer("fractional", "1500m"),
+			},
+		},
+	}
+	assert.Equal(t, int64(2), getExclusiveCPUCores(testPod, &testPod.Spec.Containers[0]))
+	assert.Equal(t, int64(0), getExclusiveCPUCores(testPod, &testPod.Spec.Containers[1]))
+
+	p := newTestDynamicPolicy(withTestQuotaReconcileConf(&quotareconcile.QuotaReconcileConfiguration{
+		ContainerQuotaFloorMilliCores: 3000,
+	}))
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test quota of containers with exclusive cores matches the core count", t, func() {
+		mockey.Mock((*DynamicPolicy).getAllContainersRelativePathMap).IncludeCurrentGoRoutine().Return(map[string]*v1.Container{
+			"exclusive-path":  &testPod.Spec.Containers[0],
+			"fractional-path": &testPod.Spec.Containers[1],
+		}).Build()
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(&common.CPUStats{CpuQuota: -1, CpuPeriod: 100000}, nil).Build()
+		applied := make(map[string]int64)
+		mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(relativePath string, data *common.CPUData) error {
+			applied[relativePath] = data.CpuQuota
+			return nil
+		}).Build()
+
+		convey.So(p.applyAllContainersQuota(context.TODO(), testPod, true, 1), convey.ShouldBeNil)
+		// the floor is applied to the container with fractional cores only
+		convey.So(applied, convey.ShouldResemble, map[string]int64{
+			"exclusive-path":  200000,
+			"fractional-path": 300000,
+		})
+	})
+}
+
+func Test_getPodCPULimit(t *testing.T) {
+	t.Parallel()
+
+	running := v1.ContainerState{Running: &v1.ContainerStateRunning{}}
+	terminated := v1.ContainerState{Terminated: &v1.ContainerStateTerminated{}}
+	withCPULimit := func(name, cpu string) v1.Container {
+		return v1.Container{
+			Name: name,
+			Resources: v1.ResourceRequirements{
+				Limits: v1.ResourceList{v1.ResourceCPU: resource2.MustParse(cpu)},
+			},
+		}
+	}
+	newPod := func(initContainers []v1.Container, overhead v1.ResourceList) *v1.Pod {
+		return &v1.Pod{
+			Spec: v1.PodSpec{
+				InitContainers: initContainers,
+				Containers:     []v1.Container{withCPULimit("app", "2")},
+				Overhead:       overhead,
+			},
+			Status: v1.PodStatus{
+				InitContainerStatuses: []v1.ContainerStatus{
+					{Name: "init", ContainerID: "containerd://init-id", State: terminated},
+					{Name: "sidecar", ContainerID: "containerd://sidecar-id", State: running},
+				},
+				ContainerStatuses: []v1.ContainerStatus{
+					{Name: "app", ContainerID: "containerd://app-id", State: running},
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		name       string
+		pod        *v1.Pod
+		wantLimit  int64
+		wantExists bool
+	}{
+		{
+			name:       "app containers only",
+			pod:        newPod(nil, nil),
+			wantLimit:  2000,
+			wantExists: true,
+		},
+		{
+			name:       "restartable init container is summed with app containers",
+			pod:        newPod([]v1.Container{withCPULimit("sidecar", "500m")}, nil),
+			wantLimit:  2500,
+			wantExists: true,
+		},
+		{
+			name:       "regular init container larger than the others bounds the pod",
+			pod:        newPod([]v1.Container{withCPULimit("init", "4"), withCPULimit("sidecar", "500m")}, nil),
+			wantLimit:  4000,
+			wantExists: true,
+		},
+		{
+			name:       "regular init container smaller than the others",
+			pod:        newPod([]v1.Container{withCPULimit("init", "1"), withCPULimit("sidecar", "500m")}, nil),
+			wantLimit:  2500,
+			wantExists: true,
+		},
+		{
+			name:       "pod overhead",
+			pod:        newPod([]v1.Container{withCPULimit("sidecar", "500m")}, v1.ResourceList{v1.ResourceCPU: resource2.MustParse("100m")}),
+			wantLimit:  2600,
+			wantExists: true,
+		},
+		{
+			name:       "no cpu limit",
+			pod:        &v1.Pod{Spec: v1.PodSpec{Containers: []v1.Container{{Name: "app"}}}},
+			wantExists: false,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			limit, exists := getPodCPULimit(tt.pod)
+			assert.Equal(t, tt.wantExists, exists)
+			assert.Equal(t, tt.wantLimit, limit)
+		})
+	}
+}
+
+func TestDynamicPolicy_containerQuotaFloor(t *testing.T) {
+	t.Parallel()
+
+	p := &DynamicPolicy{
+		emitter:   metrics.DummyMetrics{},
+		qosConfig: generic.NewQoSConfiguration(),
+		quotaReconcileConf: &quotareconcile.QuotaReconcileConfiguration{
+			ContainerQuotaFloorMilliCores:   500,
+			ContainerQuotaFloorRequestRatio: 0.5,
+			UsageWeightedContainerQuota:     true,
+		},
+	}
+
+	container := v1.Container{
+		Name: "test-container",
+		Resources: v1.ResourceRequirements{
+			Requests: v1.ResourceList{
+				v1.ResourceCPU: resource2.MustParse("4"),
+			},
+			Limits: v1.ResourceList{
+				v1.ResourceCPU: resource2.MustParse("3"),
+			},
+		},
+	}
+	testPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test-pod",
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{container},
+		},
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test container quota is clamped to the floor", t, func() {
+		mockey.Mock((*DynamicPolicy).getAllContainersRelativePathMap).IncludeCurrentGoRoutine().Return(
+			map[string]*v1.Container{"test-container-path": &container}).Build()
+		// the usage-weighted limit of the container is cut down far below its cpu limit
+		mockey.Mock((*DynamicPolicy).getUsageWeightedContainerLimits).IncludeCurrentGoRoutine().Return(
+			map[string]int64{"test-container-path": 100}).Build()
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(&common.CPUStats{CpuQuota: -1, CpuPeriod: 100000}, nil).Build()
+		appliedQuota := make(map[string]int64)
+		mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(relativePath string, data *common.CPUData) error {
+			appliedQuota[relativePath] = data.CpuQuota
+			return nil
+		}).Build()
+		clampedTimes := 0
+		var clampedTags []metrics.MetricTag
+		mockey.Mock(metrics.DummyMetrics.StoreInt64).IncludeCurrentGoRoutine().To(
+			func(_ metrics.DummyMetrics, key string, _ int64, _ metrics.MetricTypeName, tags ...metrics.MetricTag) error {
+				if key == util.MetricNameContainerQuotaFloorClamped {
+					clampedTimes++
+					clampedTags = tags
+				}
+				return nil
+			}).Build()
+
+		// the floor is 50% of the 4-core request, which is larger than the absolute floor and below the limit
+		err := p.applyAllContainersQuota(context.TODO(), testPod, true, 1)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(appliedQuota["test-container-path"], convey.ShouldEqual, 200000)
+		convey.So(clampedTimes, convey.ShouldEqual, 1)
+		// the clamp metric is tagged by the qos level instead of the pod
+		convey.So(clampedTags, convey.ShouldResemble, metrics.ConvertMapToTags(map[string]string{"qosLevel": consts.PodAnnotationQoSLevelSharedCores}))
+
+		// the floor never exceeds the cpu limit of the container
+		limitedContainer := container.DeepCopy()
+		limitedContainer.Resources.Limits[v1.ResourceCPU] = resource2.MustParse("1")
+		convey.So(p.getContainerQuotaFloor(testPod, limitedContainer, 100000), convey.ShouldEqual, 100000)
+
+		// the pod quota holds the floors of its containers, even if its usage-based limit is below them
+		mockey.Mock((*DynamicPolicy).getPodUsagePercentileLimit).IncludeCurrentGoRoutine().Return(int64(100), true).Build()
+		mockey.Mock((*DynamicPolicy).getCurrentPathAllPodsDirAndMap).IncludeCurrentGoRoutine().Return(map[string]*v1.Pod{}, []string{"test-pod-dir"}, nil).Build()
+		mockey.Mock((*DynamicPolicy).getPodAndRelativePath).IncludeCurrentGoRoutine().Return(testPod, "test-pod-dir", nil).Build()
+		_, err = p.checkAndApplyAllPodsQuota(context.TODO(), &advisorsvc.CalculationInfo{CgroupPath: "test_cgroup_path"}, 1000000)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(appliedQuota["test-pod-dir"], convey.ShouldEqual, 200000)
+	})
+}
+
+func TestDynamicPolicy_containerQuotaFloor_limitRangeDefault(t *testing.T) {
+	t.Parallel()
+
+	limitRange := &v1.LimitRange{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-limit-range", Namespace: "test-namespace"},
+		Spec: v1.LimitRangeSpec{
+			Limits: []v1.LimitRangeItem{
+				{
+					Type:           v1.LimitTypePod,
+					DefaultRequest: v1.ResourceList{v1.ResourceCPU: resource2.MustParse("8")},
+				},
+				{
+					Type:           v1.LimitTypeContainer,
+					DefaultRequest: v1.ResourceList{v1.ResourceCPU: resource2.MustParse("4")},
+				},
+			},
+		},
+	}
+	defaultLimitRange := &v1.LimitRange{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-limit-range", Namespace: "default-limit-namespace"},
+		Spec: v1.LimitRangeSpec{
+			Limits: []v1.LimitRangeItem{
+				{
+					Type:    v1.LimitTypeContainer,
+					Default: v1.ResourceList{v1.ResourceCPU: resource2.MustParse("3")},
+				},
+			},
+		},
+	}
+	p := newTestDynamicPolicy(withTestLimitRanges(limitRange, defaultLimitRange), withTestQuotaReconcileConf(&quotareconcile.QuotaReconcileConfiguration{
+		ContainerQuotaFloorRequestRatio: 0.5,
+		UsageWeightedContainerQuota:     true,
+	}))
+
+	container := v1.Container{
+		Name: "test-container",
+		Resources: v1.ResourceRequirements{
+			Limits: v1.ResourceList{
+				v1.ResourceCPU: resource2.MustParse("3"),
+			},
+		},
+	}
+	newPod := func(namespace string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: namespace},
+			Spec:       v1.PodSpec{Containers: []v1.Container{container}},
+		}
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test the container without a cpu request takes the default of the limit range", t, func() {
+		mockey.Mock((*DynamicPolicy).getAllContainersRelativePathMap).IncludeCurrentGoRoutine().Return(
+			map[string]*v1.Container{"test-container-path": &container}).Build()
+		mockey.Mock((*DynamicPolicy).getUsageWeightedContainerLimits).IncludeCurrentGoRoutine().Return(
+			map[string]int64{"test-container-path": 1000}).Build()
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(&common.CPUStats{CpuQuota: -1, CpuPeriod: 100000}, nil).Build()
+		appliedQuota := make(map[string]int64)
+		mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(relativePath string, data *common.CPUData) error {
+			appliedQuota[relativePath] = data.CpuQuota
+			return nil
+		}).Build()
+
+		// the floor is 50% of the 4-core default request of containers, which is larger than the weighted limit
+		err := p.applyAllContainersQuota(context.TODO(), newPod("test-namespace"), true, 1)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(appliedQuota["test-container-path"], convey.ShouldEqual, 200000)
+
+		// the request is zero in namespaces without limit ranges
+		err = p.applyAllContainersQuota(context.TODO(), newPod("other-namespace"), true, 1)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(appliedQuota["test-container-path"], convey.ShouldEqual, 100000)
+
+		// the default limit is taken as the request if the limit range has no default request
+		err = p.applyAllContainersQuota(context.TODO(), newPod("default-limit-namespace"), true, 1)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(appliedQuota["test-container-path"], convey.ShouldEqual, 150000)
+	})
+}
+
+func TestDynamicPolicy_allocateByCPUAdvisorCoalescing(t *testing.T) {
+	t.Parallel()
+
+	p := &DynamicPolicy{
+		emitter: metrics.DummyMetrics{},
+	}
+
+	resp1 := &advisorapi.ListAndWatchResponse{AllowSharedCoresOverlapReclaimedCores: true}
+	resp2 := &advisorapi.ListAndWatchResponse{}
+	resp3 := &advisorapi.ListAndWatchResponse{}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test plans pushed during a slow reconcile are coalesced", t, func() {
+		var appliedPlans []*advisorapi.ListAndWatchResponse
+		mockey.Mock((*DynamicPolicy).applyCPUAdvisorPlan).IncludeCurrentGoRoutine().To(func(p *DynamicPolicy, plan *cpuAdvisorPlan) error {
+			appliedPlans = append(appliedPlans, plan.resp)
+			if len(appliedPlans) == 1 {
+				// pushes during the in-progress run return immediately without being applied
+				convey.So(p.allocateByCPUAdvisor(nil, resp2, nil), convey.ShouldBeNil)
+				convey.So(p.allocateByCPUAdvisor(nil, resp3, nil), convey.ShouldBeNil)
+				convey.So(appliedPlans, convey.ShouldHaveLength, 1)
+				return fmt.Errorf("test error")
+			}
+			return nil
+		}).Build()
+		var coalesced int64
+		mockey.Mock(metrics.DummyMetrics.StoreInt64).IncludeCurrentGoRoutine().To(
+			func(_ metrics.DummyMetrics, key string, val int64, _ metrics.MetricTypeName, _ ...metrics.MetricTag) error {
+				if key == util.MetricNameAdvisorPlanCoalesced {
+					coalesced += val
+				}
+				return nil
+			}).Build()
+
+		// the error of the in-progress plan is returned to its caller
+		err := p.allocateByCPUAdvisor(nil, resp1, nil)
+		convey.So(err, convey.ShouldNotBeNil)
+
+		// only the latest plan runs next
+		convey.So(appliedPlans, convey.ShouldHaveLength, 2)
+		convey.So(appliedPlans[0], convey.ShouldPointTo, resp1)
+		convey.So(appliedPlans[1], convey.ShouldPointTo, resp3)
+		convey.So(coalesced, convey.ShouldEqual, 1)
+
+		// the runner is idle again after the run completes
+		err = p.allocateByCPUAdvisor(nil, resp2, nil)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(appliedPlans, convey.ShouldHaveLength, 3)
+		convey.So(appliedPlans[2], convey.ShouldPointTo, resp2)
+	})
+}
+
+func TestDynamicPolicy_reconcileTriggersNeverOverlap(t *testing.T) {
+	t.Parallel()
+
+	p := newTestDynamicPolicy()
+	p.advisorValidator = &validator.CPUAdvisorValidator{}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test reconciles of all triggers are serialized", t, func() {
+		var running, maxRunning, reconciles int64
+		reconcile := func() {
+			atomic.AddInt64(&reconciles, 1)
+			current := atomic.AddInt64(&running, 1)
+			defer atomic.AddInt64(&running, -1)
+			for {
+				observed := atomic.LoadInt64(&maxRunning)
+				if current <= observed || atomic.CompareAndSwapInt64(&maxRunning, observed, current) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		// triggers run in other goroutines, so the mocks are not limited to the current goroutine
+		mockey.Mock((*validator.CPUAdvisorValidator).ValidateRequest).Return(nil).Build()
+		mockey.Mock((*validator.CPUAdvisorValidator).Validate).Return(nil).Build()
+		mockey.Mock((*DynamicPolicy).generateBlockCPUSet).To(func(p *DynamicPolicy, _ *advisorapi.ListAndWatchResponse) (advisorapi.BlockCPUSet, error) {
+			reconcile()
+			// leave a change to undo, which is restored within the reconcile lock as well
+			p.lastReconcileTransaction = &reconcileTransaction{
+				priorStats: []cgroupPriorCPUStats{{relativePath: "test_cgroup_path"}},
+			}
+			return nil, fmt.Errorf("test error")
+		}).Build()
+		mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).To(func(_ string, _ *common.CPUData) error {
+			reconcile()
+			return nil
+		}).Build()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(3)
+			// ListAndWatch of cpu-advisor
+			go func() {
+				defer wg.Done()
+				_ = p.allocateByCPUAdvisor(nil, &advisorapi.ListAndWatchResponse{}, nil)
+			}()
+			// GetAdvice of cpu-advisor
+			go func() {
+				defer wg.Done()
+				_ = p.allocateByCPUAdvisor(&advisorapi.GetAdviceRequest{}, &advisorapi.ListAndWatchResponse{}, nil)
+			}()
+			go func() {
+				defer wg.Done()
+				_ = p.UndoLastCPUQuotaReconcile()
+			}()
+		}
+		wg.Wait()
+
+		convey.So(atomic.LoadInt64(&reconciles), convey.ShouldBeGreaterThan, 1)
+		convey.So(atomic.LoadInt64(&maxRunning), convey.ShouldEqual, 1)
+	})
+}
+
+func Test_advisorPlanRunner_minInterval(t *testing.T) {
+	t.Parallel()
+
+	minInterval := 200 * time.Millisecond
+	r := &advisorPlanRunner{minInterval: minInterval}
+	applied := make(chan *cpuAdvisorPlan, 10)
+	apply := func(plan *cpuAdvisorPlan) error {
+		applied <- plan
+		return nil
+	}
+
+	plans := []*cpuAdvisorPlan{{}, {}, {}, {}}
+	startTime := time.Now()
+	superseded, err := r.run(plans[0], apply)
+	assert.NoError(t, err)
+	assert.False(t, superseded)
+	assert.Len(t, applied, 1)
+	assert.Same(t, plans[0], <-applied)
+
+	// plans pushed within the interval are coalesced into a single deferred run
+	for i, plan := range plans[1:] {
+		superseded, err = r.run(plan, apply)
+		assert.NoError(t, err)
+		assert.Equal(t, i > 0, superseded)
+	}
+	assert.Len(t, applied, 0)
+
+	select {
+	case plan := <-applied:
+		assert.Same(t, plans[3], plan)
+		assert.GreaterOrEqual(t, time.Since(startTime), minInterval)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the deferred plan is not applied")
+	}
+
+	select {
+	case <-applied:
+		t.Fatalf("the superseded plans are applied")
+	case <-time.After(2 * minInterval):
+	}
+}
+
+func TestDynamicPolicy_quotaApplyOutcome(t *testing.T) {
+	t.Parallel()
+
+	p := &DynamicPolicy{
+		emitter: metrics.DummyMetrics{},
+	}
+
+	testPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test-pod",
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{
+				{
+					Name: "test-container",
+					Resources: v1.ResourceRequirements{
+						Limits: v1.ResourceList{
+							v1.ResourceCPU: resource2.MustParse("1"),
+						},
+					},
+				},
+			},
+		},
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test each outcome of quota applies is counted", t, func() {
+		mockey.Mock((*DynamicPolicy).getAllContainersRelativePathMap).IncludeCurrentGoRoutine().Return(
+			map[string]*v1.Container{"test-container-path": &testPod.Spec.Containers[0]}).Build()
+		currentQuota := int64(-1)
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(_ string) (*common.CPUStats, error) {
+			return &common.CPUStats{CpuQuota: currentQuota, CpuPeriod: 100000}, nil
+		}).Build()
+		var applyErr error
+		mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(_ string, data *common.CPUData) error {
+			if applyErr == nil {
+				currentQuota = data.CpuQuota
+			}
+			return applyErr
+		}).Build()
+		outcomes := map[string]int64{}
+		mockey.Mock(metrics.DummyMetrics.StoreInt64).IncludeCurrentGoRoutine().To(
+			func(_ metrics.DummyMetrics, key string, val int64, _ metrics.MetricTypeName, tags ...metrics.MetricTag) error {
+				if key == util.MetricNameQuotaApplyOutcome {
+					for _, tag := range tags {
+						if tag.Key == "outcome" {
+							outcomes[tag.Val] += val
+						}
+					}
+				}
+				return nil
+			}).Build()
+
+		// the quota is changed
+		err := p.applyAllContainersQuota(context.TODO(), testPod, true, 1)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(outcomes, convey.ShouldResemble, map[string]int64{quotaApplyOutcomeAppliedChanged: 1})
+
+		// the quota is already the desired one
+		err = p.applyAllContainersQuota(context.TODO(), testPod, true, 1)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(outcomes[quotaApplyOutcomeSkippedIdempotent], convey.ShouldEqual, 1)
+
+		// the quota is clamped to the floor
+		p.quotaReconcileConf = &quotareconcile.QuotaReconcileConfiguration{ContainerQuotaFloorMilliCores: 2000}
+		err = p.applyAllContainersQuota(context.TODO(), testPod, true, 1)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(outcomes[quotaApplyOutcomeClamped], convey.ShouldEqual, 1)
+		convey.So(outcomes[quotaApplyOutcomeAppliedChanged], convey.ShouldEqual, 2)
+		convey.So(currentQuota, convey.ShouldEqual, 200000)
+
+		// the write fails
+		p.quotaReconcileConf = nil
+		applyErr = fmt.Errorf("test error")
+		err = p.applyAllContainersQuota(context.TODO(), testPod, true, 1)
+		convey.So(err, convey.ShouldNotBeNil)
+		convey.So(outcomes, convey.ShouldResemble, map[string]int64{
+			quotaApplyOutcomeAppliedChanged:    2,
+			quotaApplyOutcomeSkippedIdempotent: 1,
+			quotaApplyOutcomeClamped:           1,
+			quotaApplyOutcomeFailed:            1,
+		})
+	})
+}
+
+func TestDynamicPolicy_emitCPUCgroupWrites(t *testing.T) {
+	t.Parallel()
+
+	p := newTestDynamicPolicy()
+	groupPath := "/kubepods/offline"
+	podPath := groupPath + "/poduid-1"
+	pod := newScenarioPod("uid-1",
+		scenarioContainer{name: "app", cpuLimit: "1"},
+		scenarioContainer{name: "logger", cpuLimit: "500m"})
+	resourcesBytes, _ := json.Marshal(&common.CgroupResources{CpuQuota: 1000000, CpuPeriod: 100000})
+	resp := &advisorapi.ListAndWatchResponse{
+		ExtraEntries: []*advisorsvc.CalculationInfo{{
+			CgroupPath: groupPath,
+			CalculationResult: &advisorsvc.CalculationResult{
+				Values: map[string]string{
+					string(advisorapi.ControlKnobKeyCgroupConfig): string(resourcesBytes),
+				},
+			},
+		}},
+	}
+	cgroupState := map[string]*common.CPUStats{
+		groupPath:                 {CpuQuota: -1, CpuPeriod: 100000},
+		podPath:                   {CpuQuota: -1, CpuPeriod: 100000},
+		podPath + "/uid-1-app":    {CpuQuota: 300000, CpuPeriod: 100000},
+		podPath + "/uid-1-logger": {CpuQuota: 50000, CpuPeriod: 100000},
+	}
+
+	var cgroupWrites []int64
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test cpu cgroup writes issued in each round are counted apart from idempotent applies", t, func() {
+		mockey.Mock(general.IsPathExists).IncludeCurrentGoRoutine().Return(true).Build()
+		mockey.Mock(common.CheckCgroup2UnifiedMode).IncludeCurrentGoRoutine().Return(false).Build()
+		mockey.Mock((*DynamicPolicy).getAllPodsPathMap).IncludeCurrentGoRoutine().Return(map[string]*v1.Pod{
+			p.getAbsCgroupPath(common.DefaultSelectedSubsys, podPath): pod,
+		}, nil).Build()
+		mockey.Mock((*DynamicPolicy).getAllDirs).IncludeCurrentGoRoutine().Return([]string{"poduid-1"}, nil).Build()
+		mockey.Mock((*DynamicPolicy).getContainerRelativeCgroupPath).IncludeCurrentGoRoutine().To(
+			func(_ *DynamicPolicy, _, containerID string) (string, error) {
+				return filepath.Join(podPath, containerID), nil
+			}).Build()
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(path string) (*common.CPUStats, error) {
+			statsCopy := *cgroupState[path]
+			return &statsCopy, nil
+		}).Build()
+		apply := mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(path string, data *common.CPUData) error {
+			cgroupState[path].CpuQuota = data.CpuQuota
+			return nil
+		}).Build()
+		mockey.Mock(common.ApplyCgroupConfigs).IncludeCurrentGoRoutine().Return(nil).Build()
+		mockey.Mock(metrics.DummyMetrics.StoreInt64).IncludeCurrentGoRoutine().To(
+			func(_ metrics.DummyMetrics, key string, val int64, _ metrics.MetricTypeName, _ ...metrics.MetricTag) error {
+				if key == util.MetricNameCPUCgroupWrites {
+					cgroupWrites = append(cgroupWrites, val)
+				}
+				return nil
+			}).Build()
+
+		// the logger is already at its limit, so only the app and the pod are written in the first round
+		convey.So(p.applyCgroupConfigs(resp), convey.ShouldBeNil)
+		convey.So(apply.Times(), convey.ShouldEqual, 2)
+		// nothing is written once all of them are at the desired quota
+		convey.So(p.applyCgroupConfigs(resp), convey.ShouldBeNil)
+		convey.So(apply.Times(), convey.ShouldEqual, 2)
+	})
+
+	assert.Equal(t, []int64{2, 0}, cgroupWrites)
+}
+
+func TestDynamicPolicy_podQuotaConvergenceLag(t *testing.T) {
+	t.Parallel()
+
+	fakeClock := testingclock.NewFakeClock(time.Now())
+	p := newTestDynamicPolicy(withTestClock(fakeClock), withTestQuotaReconcileConf(&quotareconcile.QuotaReconcileConfiguration{
+		QuotaRampStepMilliCores: 500,
+	}))
+	groupPath := "/kubepods/offline"
+	podPath := groupPath + "/poduid-1"
+	pod := newScenarioPod("uid-1", scenarioContainer{name: "app", cpuLimit: "1"})
+	calculationInfo := &advisorsvc.CalculationInfo{CgroupPath: groupPath}
+	resources := &common.CgroupResources{CpuQuota: 1000000, CpuPeriod: 100000}
+	cgroupState := map[string]*common.CPUStats{
+		groupPath:              {CpuQuota: -1, CpuPeriod: 100000},
+		podPath:                {CpuQuota: 20000, CpuPeriod: 100000},
+		podPath + "/uid-1-app": {CpuQuota: 20000, CpuPeriod: 100000},
+	}
+
+	var lagBuckets []string
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test the lag is told from when the desired quota appears to when the pod converges", t, func() {
+		mockey.Mock(common.CheckCgroup2UnifiedMode).IncludeCurrentGoRoutine().Return(false).Build()
+		mockey.Mock((*DynamicPolicy).getAllPodsPathMap).IncludeCurrentGoRoutine().Return(map[string]*v1.Pod{
+			p.getAbsCgroupPath(common.DefaultSelectedSubsys, podPath): pod,
+		}, nil).Build()
+		mockey.Mock((*DynamicPolicy).getAllDirs).IncludeCurrentGoRoutine().Return([]string{"poduid-1"}, nil).Build()
+		mockey.Mock((*DynamicPolicy).getContainerRelativeCgroupPath).IncludeCurrentGoRoutine().To(
+			func(_ *DynamicPolicy, _, containerID string) (string, error) {
+				return filepath.Join(podPath, containerID), nil
+			}).Build()
+		mockey.Mock((*DynamicPolicy).applyAllSubCgroupQuotaToUnLimit).IncludeCurrentGoRoutine().Return(nil).Build()
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(path string) (*common.CPUStats, error) {
+			statsCopy := *cgroupState[path]
+			return &statsCopy, nil
+		}).Build()
+		mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(path string, data *common.CPUData) error {
+			cgroupState[path].CpuQuota = data.CpuQuota
+			return nil
+		}).Build()
+		mockey.Mock(metrics.DummyMetrics.StoreInt64).IncludeCurrentGoRoutine().To(
+			func(_ metrics.DummyMetrics, key string, _ int64, _ metrics.MetricTypeName, tags ...metrics.MetricTag) error {
+				if key != util.MetricNamePodQuotaConvergenceLag {
+					return nil
+				}
+				for _, tag := range tags {
+					if tag.Key == "bucket" {
+						lagBuckets = append(lagBuckets, tag.Val)
+					}
+				}
+				return nil
+			}).Build()
+
+		// the pod is ramped halfway toward the desired quota in the cycle of the plan change
+		_, err := p.checkAndApplyIfCgroupV1(calculationInfo, resources)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(cgroupState[podPath].CpuQuota, convey.ShouldEqual, 70000)
+		convey.So(lagBuckets, convey.ShouldBeEmpty)
+
+		// and it converges in the next cycle
+		fakeClock.Step(8 * time.Second)
+		_, err = p.checkAndApplyIfCgroupV1(calculationInfo, resources)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(cgroupState[podPath].CpuQuota, convey.ShouldEqual, 100000)
+		convey.So(lagBuckets, convey.ShouldResemble, []string{"10s"})
+
+		// the converged pod is counted only once
+		fakeClock.Step(8 * time.Second)
+		_, err = p.checkAndApplyIfCgroupV1(calculationInfo, resources)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(lagBuckets, convey.ShouldResemble, []string{"10s"})
+	})
+}
+
+func Test_rampCPUQuota(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name           string
+		currentQuota   int64
+		targetQuota    int64
+		unlimitedQuota int64
+		decreaseOnly   bool
+		want           int64
+	}{
+		{name: "decrease by a step", currentQuota: 800000, targetQuota: 100000, want: 600000},
+		{name: "decrease within a step", currentQuota: 250000, targetQuota: 100000, want: 100000},
+		{name: "increase by a step", currentQuota: 100000, targetQuota: 800000, want: 300000},
+		{name: "increase directly if decrease only", currentQuota: 100000, targetQuota: 800000, decreaseOnly: true, want: 800000},
+		{name: "decrease from unlimited", currentQuota: -1, targetQuota: 100000, unlimitedQuota: 400000, want: 200000},
+		{name: "increase to unlimited by a step", currentQuota: 100000, targetQuota: -1, unlimitedQuota: 400000, want: 300000},
+		{name: "increase to unlimited within a step", currentQuota: 300000, targetQuota: -1, unlimitedQuota: 400000, want: -1},
+		{name: "unknown unlimited quota", currentQuota: -1, targetQuota: 100000, want: 100000},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := rampCPUQuota(tt.currentQuota, tt.targetQuota, 200000, tt.unlimitedQuota, tt.decreaseOnly)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestDynamicPolicy_quotaRamp(t *testing.T) {
+	t.Parallel()
+
+	p := newTestDynamicPolicy(withTestQuotaReconcileConf(&quotareconcile.QuotaReconcileConfiguration{
+		QuotaRampStepMilliCores: 2000,
+	}))
+	pod := newScenarioPod("uid-1", scenarioContainer{name: "app", cpuLimit: "500m"}, scenarioContainer{name: "sidecar", cpuLimit: "500m"})
+	scenario := reconcileScenario{
+		cgroupPath: "/kubepods/offline",
+		resources:  &common.CgroupResources{CpuQuota: 1000000, CpuPeriod: 100000},
+		pods:       []*v1.Pod{pod},
+	}
+	groupPath := scenario.cgroupPath
+	podPath := scenario.podRelativePath(pod)
+	appPath, sidecarPath := filepath.Join(podPath, "uid-1-app"), filepath.Join(podPath, "uid-1-sidecar")
+	state := map[string]*common.CPUStats{
+		groupPath:   {CpuQuota: 1600000, CpuPeriod: 100000},
+		podPath:     {CpuQuota: 800000, CpuPeriod: 100000},
+		appPath:     {CpuQuota: 400000, CpuPeriod: 100000},
+		sidecarPath: {CpuQuota: 400000, CpuPeriod: 100000},
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test a large quota decrease of a pod converges over multiple cycles", t, func() {
+		mockReconcileScenario(p, scenario, state)
+
+		var podQuotas, appQuotas []int64
+		for i := 0; i < 10 && state[podPath].CpuQuota != 100000; i++ {
+			_, err := p.checkAndApplyIfCgroupV1(&advisorsvc.CalculationInfo{CgroupPath: groupPath}, scenario.resources)
+			convey.So(err, convey.ShouldBeNil)
+			podQuotas = append(podQuotas, state[podPath].CpuQuota)
+			appQuotas = append(appQuotas, state[appPath].CpuQuota)
+			// containers follow the ramped pod in proportion, so that they never exceed it
+			convey.So(state[sidecarPath].CpuQuota, convey.ShouldEqual, state[appPath].CpuQuota)
+		}
+		convey.So(podQuotas, convey.ShouldResemble, []int64{600000, 400000, 200000, 100000})
+		convey.So(appQuotas, convey.ShouldResemble, []int64{300000, 200000, 100000, 50000})
+		// the group quota isn't ramped
+		convey.So(state[groupPath].CpuQuota, convey.ShouldEqual, 1000000)
+
+		// increases are applied directly if only decreases are ramped
+		p.quotaReconcileConf.QuotaRampDecreaseOnly = true
+		pod.Spec.Containers[0].Resources.Limits[v1.ResourceCPU] = resource2.MustParse("4")
+		pod.Spec.Containers[1].Resources.Limits[v1.ResourceCPU] = resource2.MustParse("4")
+		_, err := p.checkAndApplyIfCgroupV1(&advisorsvc.CalculationInfo{CgroupPath: groupPath}, scenario.resources)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(state[podPath].CpuQuota, convey.ShouldEqual, 800000)
+		convey.So(state[appPath].CpuQuota, convey.ShouldEqual, 400000)
+	})
+}
+
+func Test_limitCPUQuotaIncrease(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name           string
+		currentQuota   int64
+		targetQuota    int64
+		unlimitedQuota int64
+		want           int64
+	}{
+		{name: "increase by the max increase", currentQuota: 100000, targetQuota: 800000, want: 300000},
+		{name: "increase within the max increase", currentQuota: 100000, targetQuota: 250000, want: 250000},
+		{name: "decrease directly", currentQuota: 800000, targetQuota: 100000, want: 100000},
+		{name: "decrease from unlimited directly", currentQuota: -1, targetQuota: 100000, unlimitedQuota: 400000, want: 100000},
+		{name: "increase to unlimited by the max increase", currentQuota: 100000, targetQuota: -1, unlimitedQuota: 400000, want: 300000},
+		{name: "increase to unlimited within the max increase", currentQuota: 300000, targetQuota: -1, unlimitedQuota: 400000, want: -1},
+		{name: "unknown unlimited quota", currentQuota: 100000, targetQuota: -1, want: -1},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := limitCPUQuotaIncrease(tt.currentQuota, tt.targetQuota, 200000, tt.unlimitedQuota)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestDynamicPolicy_quotaMaxIncreaseStep(t *testing.T) {
+	t.Parallel()
+
+	p := newTestDynamicPolicy(withTestQuotaReconcileConf(&quotareconcile.QuotaReconcileConfiguration{
+		QuotaMaxIncreaseStepMilliCores: 3000,
+	}))
+	pod := newScenarioPod("uid-1", scenarioContainer{name: "app", cpuLimit: "4"}, scenarioContainer{name: "sidecar", cpuLimit: "4"})
+	scenario := reconcileScenario{
+		cgroupPath: "/kubepods/offline",
+		resources:  &common.CgroupResources{CpuQuota: 1000000, CpuPeriod: 100000},
+		pods:       []*v1.Pod{pod},
+	}
+	groupPath := scenario.cgroupPath
+	podPath := scenario.podRelativePath(pod)
+	appPath, sidecarPath := filepath.Join(podPath, "uid-1-app"), filepath.Join(podPath, "uid-1-sidecar")
+	state := map[string]*common.CPUStats{
+		groupPath:   {CpuQuota: 1000000, CpuPeriod: 100000},
+		podPath:     {CpuQuota: 100000, CpuPeriod: 100000},
+		appPath:     {CpuQuota: 50000, CpuPeriod: 100000},
+		sidecarPath: {CpuQuota: 50000, CpuPeriod: 100000},
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test a large quota increase of a pod is stepped over multiple cycles", t, func() {
+		mockReconcileScenario(p, scenario, state)
+
+		var podQuotas, appQuotas []int64
+		for i := 0; i < 10 && state[podPath].CpuQuota != 800000; i++ {
+			_, err := p.checkAndApplyIfCgroupV1(&advisorsvc.CalculationInfo{CgroupPath: groupPath}, scenario.resources)
+			convey.So(err, convey.ShouldBeNil)
+			podQuotas = append(podQuotas, state[podPath].CpuQuota)
+			appQuotas = append(appQuotas, state[appPath].CpuQuota)
+			// containers never exceed the stepped pod, which cgroup v1 would reject
+			convey.So(state[appPath].CpuQuota+state[sidecarPath].CpuQuota, convey.ShouldBeLessThanOrEqualTo, state[podPath].CpuQuota)
+		}
+		convey.So(podQuotas, convey.ShouldResemble, []int64{400000, 700000, 800000})
+		convey.So(appQuotas, convey.ShouldResemble, []int64{200000, 350000, 400000})
+
+		// decreases are applied directly
+		pod.Spec.Containers[0].Resources.Limits[v1.ResourceCPU] = resource2.MustParse("500m")
+		pod.Spec.Containers[1].Resources.Limits[v1.ResourceCPU] = resource2.MustParse("500m")
+		_, err := p.checkAndApplyIfCgroupV1(&advisorsvc.CalculationInfo{CgroupPath: groupPath}, scenario.resources)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(state[podPath].CpuQuota, convey.ShouldEqual, 100000)
+		convey.So(state[appPath].CpuQuota, convey.ShouldEqual, 50000)
+
+		// and the smaller one of the ramp step and the max increase step takes effect
+		p.quotaReconcileConf.QuotaRampStepMilliCores = 2000
+		pod.Spec.Containers[0].Resources.Limits[v1.ResourceCPU] = resource2.MustParse("4")
+		pod.Spec.Containers[1].Resources.Limits[v1.ResourceCPU] = resource2.MustParse("4")
+		_, err = p.checkAndApplyIfCgroupV1(&advisorsvc.CalculationInfo{CgroupPath: groupPath}, scenario.resources)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(state[podPath].CpuQuota, convey.ShouldEqual, 300000)
+	})
+}
+
+func TestDynamicPolicy_quotaDecisionLog(t *testing.T) {
+	t.Parallel()
+
+	decisionLogFile := filepath.Join(t.TempDir(), "decisions.log")
+	p := &DynamicPolicy{
+		emitter:   metrics.DummyMetrics{},
+		qosConfig: generic.NewQoSConfiguration(),
+		quotaReconcileConf: &quotareconcile.QuotaReconcileConfiguration{
+			DecisionLogFile:      decisionLogFile,
+			DecisionLogMaxSizeMB: 1,
+		},
+	}
+
+	testPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test-pod",
+			UID:  "test-pod-uid",
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{
+				{
+					Name: "test-container",
+					Resources: v1.ResourceRequirements{
+						Limits: v1.ResourceList{
+							v1.ResourceCPU: resource2.MustParse("1"),
+						},
+					},
+				},
+			},
+		},
+	}
+	mockCal := &advisorsvc.CalculationInfo{
+		CgroupPath: "test_cgroup_path",
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test quota decisions are written as JSON lines", t, func() {
+		mockTestPodDirs([]string{"test-pod-dir"}, map[string]*v1.Pod{"test-pod-dir": testPod})
+		mockey.Mock((*DynamicPolicy).applyAllContainersQuota).IncludeCurrentGoRoutine().Return(nil).Build()
+		currentQuota := int64(-1)
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(_ string) (*common.CPUStats, error) {
+			return &common.CPUStats{CpuQuota: currentQuota, CpuPeriod: 100000}, nil
+		}).Build()
+		mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(_ string, data *common.CPUData) error {
+			currentQuota = data.CpuQuota
+			return nil
+		}).Build()
+
+		p.refreshQuotaDecisionLogger()
+		convey.So(p.quotaDecisionLogger, convey.ShouldNotBeNil)
+
+		_, err := p.checkAndApplyAllPodsQuota(context.TODO(), mockCal, 1000000)
+		convey.So(err, convey.ShouldBeNil)
+		currentQuota = 300000
+		_, err = p.checkAndApplyAllPodsQuota(context.TODO(), mockCal, 1000000)
+		convey.So(err, convey.ShouldBeNil)
+		// the pod limit exceeds the big group quota, so the pod quota is relaxed to unlimited
+		_, err = p.checkAndApplyAllPodsQuota(context.TODO(), mockCal, 10000)
+		convey.So(err, convey.ShouldBeNil)
+		p.closeQuotaDecisionLogger()
+
+		content, err := os.ReadFile(decisionLogFile)
+		convey.So(err, convey.ShouldBeNil)
+		lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+		convey.So(len(lines), convey.ShouldEqual, 3)
+
+		var decisions []quotaDecision
+		for _, line := range lines {
+			decision := quotaDecision{}
+			convey.So(json.Unmarshal([]byte(line), &decision), convey.ShouldBeNil)
+			convey.So(decision.Timestamp.IsZero(), convey.ShouldBeFalse)
+			// reset the timestamp so that the rest fields can be compared
+			decision.Timestamp = time.Time{}
+			decisions = append(decisions, decision)
+
+			fields := map[string]interface{}{}
+			convey.So(json.Unmarshal([]byte(line), &fields), convey.ShouldBeNil)
+			for _, field := range []string{"timestamp", "podUID", "path", "computedQuota", "appliedQuota", "delta"} {
+				convey.So(fields, convey.ShouldContainKey, field)
+			}
+		}
+		podPath := filepath.Join("test_cgroup_path", "test-pod-dir")
+		convey.So(decisions, convey.ShouldResemble, []quotaDecision{
+			{PodUID: "test-pod-uid", Path: podPath, ComputedQuota: 100000, AppliedQuota: 100000, Delta: 100001},
+			{PodUID: "test-pod-uid", Path: podPath, ComputedQuota: 100000, AppliedQuota: 100000, Delta: -200000},
+			{PodUID: "test-pod-uid", Path: podPath, ComputedQuota: 100000, AppliedQuota: -1, Delta: -100001},
+		})
+	})
+}
+
+func TestDynamicPolicy_refreshQuotaDecisionLogger(t *testing.T) {
+	t.Parallel()
+
+	p := &DynamicPolicy{}
+	p.refreshQuotaDecisionLogger()
+	assert.Nil(t, p.quotaDecisionLogger)
+
+	decisionLogFile := filepath.Join(t.TempDir(), "decisions.log")
+	p.quotaReconcileConf = &quotareconcile.QuotaReconcileConfiguration{
+		DecisionLogFile:       decisionLogFile,
+		DecisionLogMaxSizeMB:  1,
+		DecisionLogMaxBackups: 1,
+	}
+	p.refreshQuotaDecisionLogger()
+	logger := p.quotaDecisionLogger
+	assert.NotNil(t, logger)
+
+	// the logger is kept if its settings are unchanged
+	p.refreshQuotaDecisionLogger()
+	assert.Equal(t, logger, p.quotaDecisionLogger)
+
+	p.quotaReconcileConf = &quotareconcile.QuotaReconcileConfiguration{
+		DecisionLogFile:       decisionLogFile,
+		DecisionLogMaxSizeMB:  2,
+		DecisionLogMaxBackups: 1,
+	}
+	p.refreshQuotaDecisionLogger()
+	assert.NotNil(t, p.quotaDecisionLogger)
+	assert.NotEqual(t, logger, p.quotaDecisionLogger)
+
+	p.quotaReconcileConf = &quotareconcile.QuotaReconcileConfiguration{}
+	p.refreshQuotaDecisionLogger()
+	assert.Nil(t, p.quotaDecisionLogger)
+}
+
+func TestDynamicPolicy_quotaWebhook(t *testing.T) {
+	t.Parallel()
+
+	payloads := make(chan []byte, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		payloads <- body
+	}))
+	defer server.Close()
+
+	p := &DynamicPolicy{
+		emitter:   metrics.DummyMetrics{},
+		qosConfig: generic.NewQoSConfiguration(),
+		quotaReconcileConf: &quotareconcile.QuotaReconcileConfiguration{
+			WebhookURL:                       server.URL,
+			WebhookChangeThresholdMilliCores: 100,
+			WebhookTimeout:                   time.Second,
+		},
+	}
+
+	testPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "test-namespace",
+			Name:      "test-pod",
+			UID:       "test-pod-uid",
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{
+				{
+					Name: "test-container",
+					Resources: v1.ResourceRequirements{
+						Limits: v1.ResourceList{
+							v1.ResourceCPU: resource2.MustParse("1"),
+						},
+					},
+				},
+			},
+		},
+	}
+	mockCal := &advisorsvc.CalculationInfo{
+		CgroupPath: "test_cgroup_path",
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test significant quota changes are posted to the webhook", t, func() {
+		mockTestPodDirs([]string{"test-pod-dir"}, map[string]*v1.Pod{"test-pod-dir": testPod})
+		mockey.Mock((*DynamicPolicy).applyAllContainersQuota).IncludeCurrentGoRoutine().Return(nil).Build()
+		currentQuota := int64(-1)
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(_ string) (*common.CPUStats, error) {
+			return &common.CPUStats{CpuQuota: currentQuota, CpuPeriod: 100000}, nil
+		}).Build()
+		mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(_ string, data *common.CPUData) error {
+			currentQuota = data.CpuQuota
+			return nil
+		}).Build()
+
+		p.refreshQuotaWebhookSink()
+		convey.So(p.quotaWebhookSink, convey.ShouldNotBeNil)
+		defer p.stopQuotaWebhookSink()
+
+		// the change from unlimited is posted
+		_, err := p.checkAndApplyAllPodsQuota(context.TODO(), mockCal, 1000000)
+		convey.So(err, convey.ShouldBeNil)
+		// the change of 50 milli-cores is below the threshold
+		currentQuota = 105000
+		_, err = p.checkAndApplyAllPodsQuota(context.TODO(), mockCal, 1000000)
+		convey.So(err, convey.ShouldBeNil)
+		// the change of 2 cores is posted
+		currentQuota = 300000
+		_, err = p.checkAndApplyAllPodsQuota(context.TODO(), mockCal, 1000000)
+		convey.So(err, convey.ShouldBeNil)
+		// the change to unlimited is posted since the pod limit exceeds the big group quota
+		_, err = p.checkAndApplyAllPodsQuota(context.TODO(), mockCal, 10000)
+		convey.So(err, convey.ShouldBeNil)
+
+		var events []quotaChangeEvent
+		for i := 0; i < 3; i++ {
+			var payload []byte
+			select {
+			case payload = <-payloads:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for the quota change event %d", i)
+			}
+
+			fields := map[string]interface{}{}
+			convey.So(json.Unmarshal(payload, &fields), convey.ShouldBeNil)
+			for _, field := range []string{"timestamp", "namespace", "podName", "podUID", "path", "oldQuota", "newQuota", "reason"} {
+				convey.So(fields, convey.ShouldContainKey, field)
+			}
+
+			event := quotaChangeEvent{}
+			convey.So(json.Unmarshal(payload, &event), convey.ShouldBeNil)
+			convey.So(event.Timestamp.IsZero(), convey.ShouldBeFalse)
+			// reset the timestamp so that the rest fields can be compared
+			event.Timestamp = time.Time{}
+			events = append(events, event)
+		}
+		podPath := filepath.Join("test_cgroup_path", "test-pod-dir")
+		newEvent := func(oldQuota, newQuota int64, reason string) quotaChangeEvent {
+			return quotaChangeEvent{
+				Namespace: "test-namespace",
+				PodName:   "test-pod",
+				PodUID:    "test-pod-uid",
+				Path:      podPath,
+				OldQuota:  oldQuota,
+				NewQuota:  newQuota,
+				Reason:    reason,
+			}
+		}
+		convey.So(events, convey.ShouldResemble, []quotaChangeEvent{
+			newEvent(-1, 100000, quotaChangeReasonPodLimit),
+			newEvent(300000, 100000, quotaChangeReasonPodLimit),
+			newEvent(100000, -1, quotaChangeReasonExceedsGroupQuota),
+		})
+	})
+}
+
+func TestQuotaWebhookSink_breaker(t *testing.T) {
+	t.Parallel()
+
+	var lock sync.Mutex
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		requests++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	getRequests := func() int {
+		lock.Lock()
+		defer lock.Unlock()
+		return requests
+	}
+
+	s := newQuotaWebhookSink(server.URL, time.Second, 1)
+	defer s.stop()
+
+	// each failed event is retried once, and events are dropped once the breaker opens
+	for i := 0; i < quotaWebhookBreakerThreshold+2; i++ {
+		assert.True(t, s.notify(&quotaChangeEvent{PodName: fmt.Sprintf("test-pod-%d", i)}))
+	}
+	assert.Eventually(t, func() bool {
+		return len(s.events) == 0 && getRequests() == quotaWebhookBreakerThreshold*2
+	}, 10*time.Second, 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, quotaWebhookBreakerThreshold*2, getRequests())
+}
+
+func TestDynamicPolicy_fullAuditRound(t *testing.T) {
+	t.Parallel()
+
+	p := &DynamicPolicy{
+		emitter:   metrics.DummyMetrics{},
+		qosConfig: generic.NewQoSConfiguration(),
+		quotaReconcileConf: &quotareconcile.QuotaReconcileConfiguration{
+			FullAuditRoundInterval: 3,
+		},
+	}
+
+	groupPath := "/test_cgroup_path"
+	podPath := filepath.Join(groupPath, "test-pod-dir")
+	containerPath := filepath.Join(podPath, "test-container")
+	testPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test-pod",
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{
+				{
+					Name: "test-container",
+					Resources: v1.ResourceRequirements{
+						Limits: v1.ResourceList{
+							v1.ResourceCPU: resource2.MustParse("1"),
+						},
+					},
+				},
+			},
+		},
+	}
+
+	resourcesBytes, _ := json.Marshal(&common.CgroupResources{CpuQuota: 1000000, CpuPeriod: 100000})
+	newResponse := func() *advisorapi.ListAndWatchResponse {
+		return &advisorapi.ListAndWatchResponse{
+			ExtraEntries: []*advisorsvc.CalculationInfo{
+				{
+					CgroupPath: groupPath,
+					CalculationResult: &advisorsvc.CalculationResult{
+						Values: map[string]string{
+							string(advisorapi.ControlKnobKeyCgroupConfig): string(resourcesBytes),
+						},
+					},
+				},
+			},
+		}
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test all pods are read back in full audit rounds even if unchanged", t, func() {
+		mockey.Mock(general.IsPathExists).IncludeCurrentGoRoutine().Return(true).Build()
+		mockey.Mock(common.CheckCgroup2UnifiedMode).IncludeCurrentGoRoutine().Return(false).Build()
+		mockey.Mock((*DynamicPolicy).getAllDirs).IncludeCurrentGoRoutine().Return([]string{"test-pod-dir"}, nil).Build()
+		mockey.Mock((*DynamicPolicy).getCurrentPathAllPodsDirAndMap).IncludeCurrentGoRoutine().Return(
+			map[string]*v1.Pod{}, []string{"test-pod-dir"}, nil).Build()
+		mockey.Mock((*DynamicPolicy).getPodAndRelativePath).IncludeCurrentGoRoutine().Return(testPod, podPath, nil).Build()
+		mockey.Mock((*DynamicPolicy).getAllContainersRelativePathMap).IncludeCurrentGoRoutine().Return(
+			map[string]*v1.Container{containerPath: &testPod.Spec.Containers[0]}).Build()
+		cgroupQuotas := map[string]int64{groupPath: 1000000}
+		readBacks := make(map[string]int)
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(path string) (*common.CPUStats, error) {
+			readBacks[path]++
+			quota, ok := cgroupQuotas[path]
+			if !ok {
+				quota = -1
+			}
+			return &common.CPUStats{CpuQuota: quota, CpuPeriod: 100000}, nil
+		}).Build()
+		mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(path string, data *common.CPUData) error {
+			cgroupQuotas[path] = data.CpuQuota
+			return nil
+		}).Build()
+		apply := mockey.Mock(common.ApplyCgroupConfigs).IncludeCurrentGoRoutine().Return(nil).Build()
+
+		// the first round is a full audit one
+		err := p.applyCgroupConfigs(newResponse())
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(apply.Times(), convey.ShouldEqual, 1)
+		convey.So(cgroupQuotas[podPath], convey.ShouldEqual, 100000)
+		convey.So(cgroupQuotas[containerPath], convey.ShouldEqual, 100000)
+
+		// the identical calculation info is fast-pathed in the following rounds, even if the container drifts
+		cgroupQuotas[containerPath] = 50000
+		for i := 0; i < 2; i++ {
+			err = p.applyCgroupConfigs(newResponse())
+			convey.So(err, convey.ShouldBeNil)
+		}
+		convey.So(apply.Times(), convey.ShouldEqual, 1)
+		convey.So(cgroupQuotas[containerPath], convey.ShouldEqual, 50000)
+
+		// the unchanged pod and its containers are read back and corrected in the next full audit round
+		podReadBacks, containerReadBacks := readBacks[podPath], readBacks[containerPath]
+		err = p.applyCgroupConfigs(newResponse())
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(apply.Times(), convey.ShouldEqual, 2)
+		convey.So(readBacks[podPath], convey.ShouldBeGreaterThan, podReadBacks)
+		convey.So(readBacks[containerPath], convey.ShouldBeGreaterThan, containerReadBacks)
+		convey.So(cgroupQuotas[containerPath], convey.ShouldEqual, 100000)
+	})
+}
+
+func TestDynamicPolicy_applyPoolQuota(t *testing.T) {
+	t.Parallel()
+
+	p := &DynamicPolicy{
+		emitter:   metrics.DummyMetrics{},
+		qosConfig: generic.NewQoSConfiguration(),
+		quotaReconcileConf: &quotareconcile.QuotaReconcileConfiguration{
+			PoolCgroupPathPrefixes: []string{"/kubepods/share-"},
+		},
+	}
+
+	groupPath := "/test_cgroup_path"
+	poolPath := "/kubepods/share-pool-1"
+	testPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test-pod",
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{
+				{
+					Name: "test-container",
+					Resources: v1.ResourceRequirements{
+						Limits: v1.ResourceList{
+							v1.ResourceCPU: resource2.MustParse("1"),
+						},
+					},
+				},
+			},
+		},
+	}
+
+	newCalculationInfo := func(cgroupPath string, resources *common.CgroupResources) *advisorsvc.CalculationInfo {
+		resourcesBytes, _ := json.Marshal(resources)
+		return &advisorsvc.CalculationInfo{
+			CgroupPath: cgroupPath,
+			CalculationResult: &advisorsvc.CalculationResult{
+				Values: map[string]string{
+					string(advisorapi.ControlKnobKeyCgroupConfig): string(resourcesBytes),
+				},
+			},
+		}
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test quota of pools is applied to pools and the rest is reconciled to pods", t, func() {
+		mockey.Mock(general.IsPathExists).IncludeCurrentGoRoutine().Return(true).Build()
+		mockey.Mock(common.CheckCgroup2UnifiedMode).IncludeCurrentGoRoutine().Return(false).Build()
+		mockey.Mock((*DynamicPolicy).getAllDirs).IncludeCurrentGoRoutine().Return([]string{"test-pod-dir"}, nil).Build()
+		var reconciledPaths []string
+		mockey.Mock((*DynamicPolicy).getCurrentPathAllPodsDirAndMap).IncludeCurrentGoRoutine().To(
+			func(_ *DynamicPolicy, cgroupPath string) (map[string]*v1.Pod, []string, error) {
+				reconciledPaths = append(reconciledPaths, cgroupPath)
+				return map[string]*v1.Pod{}, []string{"test-pod-dir"}, nil
+			}).Build()
+		mockey.Mock((*DynamicPolicy).getPodAndRelativePath).IncludeCurrentGoRoutine().To(
+			func(_ *DynamicPolicy, cgroupPath string, podDir string, _ map[string]*v1.Pod) (*v1.Pod, string, error) {
+				return testPod, filepath.Join(cgroupPath, podDir), nil
+			}).Build()
+		mockey.Mock((*DynamicPolicy).applyAllContainersQuota).IncludeCurrentGoRoutine().Return(nil).Build()
+		cgroupQuotas := map[string]int64{groupPath: 1000000, poolPath: -1}
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(path string) (*common.CPUStats, error) {
+			quota, ok := cgroupQuotas[path]
+			if !ok {
+				quota = -1
+			}
+			return &common.CPUStats{CpuQuota: quota, CpuPeriod: 100000}, nil
+		}).Build()
+		mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(path string, data *common.CPUData) error {
+			cgroupQuotas[path] = data.CpuQuota
+			return nil
+		}).Build()
+		appliedResources := make(map[string]*common.CgroupResources)
+		mockey.Mock(common.ApplyCgroupConfigs).IncludeCurrentGoRoutine().To(func(path string, resources *common.CgroupResources) error {
+			appliedResources[path] = resources
+			return nil
+		}).Build()
+
+		err := p.applyCgroupConfigs(&advisorapi.ListAndWatchResponse{
+			ExtraEntries: []*advisorsvc.CalculationInfo{
+				newCalculationInfo(poolPath, &common.CgroupResources{CpuQuota: 400000, CpuPeriod: 100000}),
+				newCalculationInfo(groupPath, &common.CgroupResources{CpuQuota: 1000000, CpuPeriod: 100000}),
+			},
+		})
+		convey.So(err, convey.ShouldBeNil)
+
+		// the pool quota is applied to the pool itself, and pods under it are not reconciled
+		convey.So(cgroupQuotas[poolPath], convey.ShouldEqual, 400000)
+		convey.So(reconciledPaths, convey.ShouldResemble, []string{groupPath})
+		convey.So(appliedResources[poolPath].CpuQuota, convey.ShouldEqual, 0)
+		convey.So(appliedResources[poolPath].CpuPeriod, convey.ShouldEqual, 0)
+
+		// the pod quota is reconciled under the rest cgroup path
+		convey.So(cgroupQuotas[filepath.Join(groupPath, "test-pod-dir")], convey.ShouldEqual, 100000)
+		convey.So(appliedResources[groupPath].CpuQuota, convey.ShouldEqual, 1000000)
+	})
+}
+
+func TestDynamicPolicy_checkAndApplyIfCgroupV1_featureGate(t *testing.T) {
+	t.Parallel()
+
+	groupPath := "/kubepods/offline"
+	scenario := reconcileScenario{
+		cgroupPath: groupPath,
+		resources:  &common.CgroupResources{CpuQuota: 1000000, CpuPeriod: 100000},
+		pods:       []*v1.Pod{newScenarioPod("uid-1", scenarioContainer{name: "app", cpuLimit: "2"})},
+		cgroupState: map[string]*common.CPUStats{
+			groupPath + "/poduid-1":           {CpuQuota: -1, CpuPeriod: 100000},
+			groupPath + "/poduid-1/uid-1-app": {CpuQuota: -1, CpuPeriod: 100000},
+		},
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	// the feature gate is global, and it's only toggled while holding the advisor test mutex
+	defer featuregatetesting.SetFeatureGateDuringTest(t, utilfeature.DefaultFeatureGate, features.QuotaReconcile, false)()
+	mockey.PatchConvey("test quota reconcile is skipped when the feature gate is disabled", t, func() {
+		// no cgroup is even read, so the resulting state is the initial one
+		quotas, err := runReconcileScenario(newTestDynamicPolicy(), scenario)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(quotas, convey.ShouldResemble, map[string]int64{
+			groupPath + "/poduid-1":           -1,
+			groupPath + "/poduid-1/uid-1-app": -1,
+		})
+	})
+}
+
+func TestDynamicPolicy_checkAndApplyIfCgroupV1_kubeletStaticPolicy(t *testing.T) {
+	t.Parallel()
+
+	stateFile := filepath.Join(t.TempDir(), "cpu_manager_state")
+	err := os.WriteFile(stateFile, []byte(`{"policyName":"static","defaultCpuSet":"0-1,4-7",`+
+		`"entries":{"uid-1":{"app":"2-3"}},"checksum":1}`), 0o644)
+	if err != nil {
+		t.Fatalf("write kubelet cpu manager checkpoint failed: %v", err)
+	}
+
+	groupPath := "/kubepods/offline"
+	scenario := reconcileScenario{
+		cgroupPath: groupPath,
+		resources:  &common.CgroupResources{CpuQuota: 1000000, CpuPeriod: 100000},
+		pods: []*v1.Pod{
+			newScenarioPod("uid-1", scenarioContainer{name: "app", cpuLimit: "2"}),
+			newScenarioPod("uid-2", scenarioContainer{name: "app", cpuLimit: "2"}),
+		},
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test pods with exclusive cpus of kubelet static policy are skipped", t, func() {
+		conf := quotareconcile.NewQuotaReconcileConfiguration()
+		conf.KubeletCPUManagerStateFile = stateFile
+		quotas, err := runReconcileScenario(newTestDynamicPolicy(withTestQuotaReconcileConf(conf)), scenario)
+		convey.So(err, convey.ShouldBeNil)
+		// cgroups of the pod owned by kubelet are never touched
+		_, ok := quotas[groupPath+"/poduid-1"]
+		convey.So(ok, convey.ShouldBeFalse)
+		_, ok = quotas[groupPath+"/poduid-1/uid-1-app"]
+		convey.So(ok, convey.ShouldBeFalse)
+		convey.So(quotas[groupPath+"/poduid-2"], convey.ShouldEqual, 200000)
+		convey.So(quotas[groupPath+"/poduid-2/uid-2-app"], convey.ShouldEqual, 200000)
+	})
+
+	mockey.PatchConvey("test pods are reconciled when kubelet cpu manager isn't static", t, func() {
+		conf := quotareconcile.NewQuotaReconcileConfiguration()
+		conf.KubeletCPUManagerStateFile = filepath.Join(t.TempDir(), "cpu_manager_state")
+		quotas, err := runReconcileScenario(newTestDynamicPolicy(withTestQuotaReconcileConf(conf)), scenario)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(quotas[groupPath+"/poduid-1"], convey.ShouldEqual, 200000)
+		convey.So(quotas[groupPath+"/poduid-2"], convey.ShouldEqual, 200000)
+	})
+}
+
+// fakeCNRControl records cnrs whose status is patched.
+type fakeCNRControl struct {
+	control.DummyCNRControl
+	patched []*nodev1alpha1.CustomNodeResource
+}
+
+func (f *fakeCNRControl) PatchCNRStatus(_ context.Context, _ string, _, newCNR *nodev1alpha1.CustomNodeResource) (*nodev1alpha1.CustomNodeResource, error) {
+	f.patched = append(f.patched, newCNR)
+	return newCNR, nil
+}
+
+func TestDynamicPolicy_reportReconcileStatus(t *testing.T) {
+	t.Parallel()
+
+	fakeClock := testingclock.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	conf := quotareconcile.NewQuotaReconcileConfiguration()
+	conf.StatusReportInterval = time.Minute
+	cnr := &nodev1alpha1.CustomNodeResource{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}
+	cnrControl := &fakeCNRControl{}
+	p := newTestDynamicPolicy(withTestQuotaReconcileConf(conf), withTestClock(fakeClock), withTestCNR(cnr))
+	p.cnrControl = cnrControl
+
+	p.beginReconcileStatus()
+	p.recordReconcileStatus(&ReconcileResult{
+		Processed: 3,
+		Failed:    2,
+		PodErrors: map[string]error{
+			"poduid-1": errors.New("write cpu.cfs_quota_us failed"),
+			"poduid-2": errors.New("write cpu.cfs_quota_us failed"),
+		},
+	})
+	p.recordReconcileStatus(&ReconcileResult{
+		Processed: 2,
+		Failed:    1,
+		PodErrors: map[string]error{"poduid-3": errors.New("pod not found")},
+	})
+	p.reportReconcileStatus(nil)
+	p.reconcileStatusReportWG.Wait()
+
+	assert.Len(t, cnrControl.patched, 1)
+	_, condition := katalystutil.GetCNRCondition(&cnrControl.patched[0].Status, cnrConditionTypeQuotaReconcileHealthy)
+	assert.NotNil(t, condition)
+	assert.Equal(t, v1.ConditionFalse, condition.Status)
+	assert.Equal(t, reconcileStatusReasonFailed, condition.Reason)
+	assert.Equal(t, `lastReconcileTime=2024-01-01T00:00:00Z, processed=5, failed=3, topError="write cpu.cfs_quota_us failed"`,
+		condition.Message)
+	assert.Equal(t, fakeClock.Now(), condition.LastHeartbeatTime.Time)
+	// the cnr held by the fetcher is left untouched
+	assert.Empty(t, cnr.Status.Conditions)
+
+	// writes are rate-limited within the interval
+	fakeClock.Step(30 * time.Second)
+	p.beginReconcileStatus()
+	p.recordReconcileStatus(&ReconcileResult{Processed: 5})
+	p.reportReconcileStatus(nil)
+	p.reconcileStatusReportWG.Wait()
+	assert.Len(t, cnrControl.patched, 1)
+
+	fakeClock.Step(30 * time.Second)
+	p.beginReconcileStatus()
+	p.recordReconcileStatus(&ReconcileResult{Processed: 5})
+	p.reportReconcileStatus(nil)
+	p.reconcileStatusReportWG.Wait()
+	assert.Len(t, cnrControl.patched, 2)
+	_, condition = katalystutil.GetCNRCondition(&cnrControl.patched[1].Status, cnrConditionTypeQuotaReconcileHealthy)
+	assert.NotNil(t, condition)
+	assert.Equal(t, v1.ConditionTrue, condition.Status)
+	assert.Equal(t, reconcileStatusReasonSucceeded, condition.Reason)
+	assert.Equal(t, `lastReconcileTime=2024-01-01T00:01:00Z, processed=5, failed=0, topError=""`, condition.Message)
+}
+
+func TestDynamicPolicy_checkAndApplyIfCgroupV1_golden(t *testing.T) {
+	t.Parallel()
+
+	groupPath := "/kubepods/offline"
+	scenarios := []struct {
+		name     string
+		scenario reconcileScenario
+	}{
+		{
+			name: "single pod is bounded by its limit",
+			scenario: reconcileScenario{
+				cgroupPath: groupPath,
+				resources:  &common.CgroupResources{CpuQuota: 1000000, CpuPeriod: 100000},
+				pods:       []*v1.Pod{newScenarioPod("uid-1", scenarioContainer{name: "app", cpuLimit: "2"})},
+				expectedQuotas: map[string]int64{
+					groupPath:                         -1,
+					groupPath + "/poduid-1":           200000,
+					groupPath + "/poduid-1/uid-1-app": 200000,
+				},
+			},
+		},
+		{
+			name: "containers of a multi-container pod are bounded by their own limits",
+			scenario: reconcileScenario{
+				cgroupPath: groupPath,
+				resources:  &common.CgroupResources{CpuQuota: 1000000, CpuPeriod: 100000},
+				pods: []*v1.Pod{newScenarioPod("uid-2",
+					scenarioContainer{name: "app", cpuLimit: "1"},
+					scenarioContainer{name: "logger", cpuLimit: "500m"})},
+				cgroupState: map[string]*common.CPUStats{
+					groupPath + "/poduid-2/uid-2-app": {CpuQuota: 300000, CpuPeriod: 100000},
+				},
+				expectedQuotas: map[string]int64{
+					groupPath:                            -1,
+					groupPath + "/poduid-2":              150000,
+					groupPath + "/poduid-2/uid-2-app":    100000,
+					groupPath + "/poduid-2/uid-2-logger": 50000,
+				},
+			},
+		},
+		{
+			name: "pod quota includes the cpu overhead of the sandbox",
+			scenario: reconcileScenario{
+				cgroupPath: groupPath,
+				resources:  &common.CgroupResources{CpuQuota: 1000000, CpuPeriod: 100000},
+				pods: []*v1.Pod{withScenarioCPUOverhead(newScenarioPod("uid-4",
+					scenarioContainer{name: "app", cpuLimit: "1"}), "250m")},
+				expectedQuotas: map[string]int64{
+					groupPath:                         -1,
+					groupPath + "/poduid-4":           125000,
+					groupPath + "/poduid-4/uid-4-app": 100000,
+				},
+			},
+		},
+		{
+			name: "pod exceeding the group quota is bounded by the group instead",
+			scenario: reconcileScenario{
+				cgroupPath: groupPath,
+				resources:  &common.CgroupResources{CpuQuota: 100000, CpuPeriod: 100000},
+				pods:       []*v1.Pod{newScenarioPod("uid-3", scenarioContainer{name: "app", cpuLimit: "2"})},
+				cgroupState: map[string]*common.CPUStats{
+					groupPath:                         {CpuQuota: 100000, CpuPeriod: 100000},
+					groupPath + "/poduid-3":           {CpuQuota: 200000, CpuPeriod: 100000},
+					groupPath + "/poduid-3/uid-3-app": {CpuQuota: 200000, CpuPeriod: 100000},
+				},
+				expectedQuotas: map[string]int64{
+					groupPath:                         100000,
+					groupPath + "/poduid-3":           -1,
+					groupPath + "/poduid-3/uid-3-app": -1,
+				},
+			},
+		},
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	for _, s := range scenarios {
+		mockey.PatchConvey(s.name, t, func() {
+			quotas, err := runReconcileScenario(newTestDynamicPolicy(), s.scenario)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(quotas, convey.ShouldResemble, s.scenario.expectedQuotas)
+		})
+	}
+}
+
+func TestDynamicPolicy_qosLevelCPUPeriods(t *testing.T) {
+	t.Parallel()
+
+	groupPath := "/kubepods/offline"
+	newPod := func(uid, qosLevel, cpuLimit string) *v1.Pod {
+		pod := newScenarioPod(uid, scenarioContainer{name: "app", cpuLimit: cpuLimit})
+		pod.Annotations = map[string]string{consts.PodAnnotationQoSLevelKey: qosLevel}
+		return pod
+	}
+	newPolicy := func() *DynamicPolicy {
+		return newTestDynamicPolicy(withTestQuotaReconcileConf(&quotareconcile.QuotaReconcileConfiguration{
+			QoSLevelCPUPeriods: map[string]time.Duration{
+				consts.PodAnnotationQoSLevelSharedCores:    50 * time.Millisecond,
+				consts.PodAnnotationQoSLevelReclaimedCores: 200 * time.Millisecond,
+			},
+		}))
+	}
+	scenario := reconcileScenario{
+		cgroupPath: groupPath,
+		resources:  &common.CgroupResources{CpuQuota: 1000000, CpuPeriod: 100000},
+		pods: []*v1.Pod{
+			newPod("uid-1", consts.PodAnnotationQoSLevelSharedCores, "1"),
+			newPod("uid-2", consts.PodAnnotationQoSLevelReclaimedCores, "2"),
+		},
+		cgroupState: map[string]*common.CPUStats{
+			groupPath: {CpuQuota: 1000000, CpuPeriod: 100000},
+		},
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test quota is derived in the period of the qos level and written along with it", t, func() {
+		state, err := runReconcileScenarioStats(newPolicy(), scenario)
+		convey.So(err, convey.ShouldBeNil)
+		// effective cores are preserved in both periods, while the group keeps the period of advisor
+		convey.So(state, convey.ShouldResemble, map[string]*common.CPUStats{
+			groupPath:                         {CpuQuota: 1000000, CpuPeriod: 100000},
+			groupPath + "/poduid-1":           {CpuQuota: 50000, CpuPeriod: 50000},
+			groupPath + "/poduid-1/uid-1-app": {CpuQuota: 50000, CpuPeriod: 50000},
+			groupPath + "/poduid-2":           {CpuQuota: 400000, CpuPeriod: 200000},
+			groupPath + "/poduid-2/uid-2-app": {CpuQuota: 400000, CpuPeriod: 200000},
+		})
+	})
+
+	mockey.PatchConvey("test widening pods are written before their containers and shrinking ones after them", t, func() {
+		strictScenario := scenario
+		strictScenario.strictHierarchy = true
+		strictScenario.pods = []*v1.Pod{
+			newPod("uid-1", consts.PodAnnotationQoSLevelSharedCores, "2"),
+			newPod("uid-2", consts.PodAnnotationQoSLevelReclaimedCores, "2"),
+		}
+		// the pod of 1 core is widened to 2 cores, and the pod of 4 cores is shrunk to 2 cores
+		strictScenario.cgroupState = map[string]*common.CPUStats{
+			groupPath:                         {CpuQuota: 1000000, CpuPeriod: 100000},
+			groupPath + "/poduid-1":           {CpuQuota: 100000, CpuPeriod: 100000},
+			groupPath + "/poduid-1/uid-1-app": {CpuQuota: 100000, CpuPeriod: 100000},
+			groupPath + "/poduid-2":           {CpuQuota: 400000, CpuPeriod: 100000},
+			groupPath + "/poduid-2/uid-2-app": {CpuQuota: 400000, CpuPeriod: 100000},
+		}
+		state, err := runReconcileScenarioStats(newPolicy(), strictScenario)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(state, convey.ShouldResemble, map[string]*common.CPUStats{
+			groupPath:                         {CpuQuota: 1000000, CpuPeriod: 100000},
+			groupPath + "/poduid-1":           {CpuQuota: 100000, CpuPeriod: 50000},
+			groupPath + "/poduid-1/uid-1-app": {CpuQuota: 100000, CpuPeriod: 50000},
+			groupPath + "/poduid-2":           {CpuQuota: 400000, CpuPeriod: 200000},
+			groupPath + "/poduid-2/uid-2-app": {CpuQuota: 400000, CpuPeriod: 200000},
+		})
+	})
+}
+
+func TestDynamicPolicy_auditOrphanedQuotas(t *testing.T) {
+	t.Parallel()
+
+	p := newTestDynamicPolicy(withTestQuotaReconcileConf(&quotareconcile.QuotaReconcileConfiguration{
+		AuditOrphanedQuota: true,
+	}))
+	podPath := "/kubepods/offline/poduid-1"
+	stalePodPath := "/kubepods/offline/poduid-2"
+	orphanedPath := "/system.slice/sshd.service"
+	p.getPodQuotaTracker().record(podPath, 100000)
+	p.getPodQuotaTracker().record(stalePodPath, 100000)
+	p.getPodQuotaTracker().record(orphanedPath, 200000)
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test quota of cgroups matching no pod, pool or kubepods root is reset", t, func() {
+		mockey.Mock(general.IsPathExists).IncludeCurrentGoRoutine().Return(true).Build()
+		mockey.Mock((*DynamicPolicy).getAllPodsPathMap).IncludeCurrentGoRoutine().Return(map[string]*v1.Pod{
+			p.getAbsCgroupPath(common.DefaultSelectedSubsys, podPath): newScenarioPod("uid-1"),
+		}, nil).Build()
+		applied := map[string]int64{}
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(
+			&common.CPUStats{CpuQuota: 200000, CpuPeriod: 100000}, nil).Build()
+		mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(path string, data *common.CPUData) error {
+			applied[path] = data.CpuQuota
+			return nil
+		}).Build()
+
+		p.auditOrphanedQuotas(context.TODO())
+		convey.So(applied, convey.ShouldResemble, map[string]int64{orphanedPath: -1})
+		// the pod cgroup with no live pod is left to the pruning of stale pod quotas
+		_, ok := p.getPodQuotaTracker().get(stalePodPath)
+		convey.So(ok, convey.ShouldBeTrue)
+		_, ok = p.getPodQuotaTracker().get(orphanedPath)
+		convey.So(ok, convey.ShouldBeFalse)
+
+		// nothing is audited unless it's enabled
+		p.getPodQuotaTracker().record(orphanedPath, 200000)
+		p.quotaReconcileConf.AuditOrphanedQuota = false
+		p.auditOrphanedQuotas(context.TODO())
+		_, ok = p.getPodQuotaTracker().get(orphanedPath)
+		convey.So(ok, convey.ShouldBeTrue)
+	})
+}
+
+func TestDynamicPolicy_applyKubepodsRootQuota(t *testing.T) {
+	t.Parallel()
+
+	p := newTestDynamicPolicy()
+	newCalculationInfo := func(cgroupPath string, resources *common.CgroupResources) *advisorsvc.CalculationInfo {
+		resourcesBytes, _ := json.Marshal(resources)
+		return &advisorsvc.CalculationInfo{
+			CgroupPath: cgroupPath,
+			CalculationResult: &advisorsvc.CalculationResult{
+				Values: map[string]string{
+					string(advisorapi.ControlKnobKeyCgroupConfig): string(resourcesBytes),
+				},
+			},
+		}
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test the reservation of the kubepods root is applied to the root itself", t, func() {
+		mockey.Mock(general.IsPathExists).IncludeCurrentGoRoutine().Return(true).Build()
+		mockey.Mock(common.CheckCgroup2UnifiedMode).IncludeCurrentGoRoutine().Return(false).Build()
+		reconcilePods := mockey.Mock((*DynamicPolicy).getCurrentPathAllPodsDirAndMap).IncludeCurrentGoRoutine().Return(
+			map[string]*v1.Pod{}, []string{}, nil).Build()
+		cgroupQuotas := map[string]int64{}
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(&common.CPUStats{CpuQuota: -1, CpuPeriod: 100000}, nil).Build()
+		mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(path string, data *common.CPUData) error {
+			cgroupQuotas[path] = data.CpuQuota
+			return nil
+		}).Build()
+		appliedResources := make(map[string]*common.CgroupResources)
+		mockey.Mock(common.ApplyCgroupConfigs).IncludeCurrentGoRoutine().To(func(path string, resources *common.CgroupResources) error {
+			appliedResources[path] = resources
+			return nil
+		}).Build()
+
+		// 2 of 16 cores are reserved for system daemons out of all pods
+		err := p.applyCgroupConfigs(&advisorapi.ListAndWatchResponse{
+			ExtraEntries: []*advisorsvc.CalculationInfo{
+				newCalculationInfo(common.CgroupFsRootPath, &common.CgroupResources{CpuQuota: 1400000, CpuPeriod: 100000}),
+			},
+		})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(cgroupQuotas, convey.ShouldResemble, map[string]int64{common.CgroupFsRootPath: 1400000})
+		convey.So(reconcilePods.Times(), convey.ShouldEqual, 0)
+		convey.So(appliedResources[common.CgroupFsRootPath].CpuQuota, convey.ShouldEqual, 0)
+
+		// cgroup paths under the root are still reconciled to their pods
+		_, err = p.checkAndApplyIfCgroupV1(&advisorsvc.CalculationInfo{CgroupPath: common.CgroupFsRootPathBurstable},
+			&common.CgroupResources{CpuQuota: 1000000, CpuPeriod: 100000})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(reconcilePods.Times(), convey.ShouldEqual, 1)
+	})
+
+	for cgroupPath, expected := range map[string]bool{
+		"/kubepods":                      true,
+		"kubepods/":                      true,
+		common.SystemdRootPath:           true,
+		common.CgroupFsRootPathBurstable: false,
+		"/kubepods-offline":              false,
+	} {
+		assert.Equal(t, expected, isKubepodsRootCgroupPath(cgroupPath), cgroupPath)
+	}
+}
+
+func TestDynamicPolicy_podProcessingOrder(t *testing.T) {
+	t.Parallel()
+
+	p := &DynamicPolicy{
+		emitter:   metrics.DummyMetrics{},
+		qosConfig: generic.NewQoSConfiguration(),
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test pods are processed in a stable order regardless of the order of their dirs", t, func() {
+		mockey.Mock((*DynamicPolicy).getAllPodsPathMap).IncludeCurrentGoRoutine().Return(map[string]*v1.Pod{}, nil).Build()
+		var podDirs []string
+		mockey.Mock((*DynamicPolicy).getAllDirs).IncludeCurrentGoRoutine().To(func(_ *DynamicPolicy, _ string) ([]string, error) {
+			return append([]string(nil), podDirs...), nil
+		}).Build()
+		var processedPodDirs []string
+		mockey.Mock((*DynamicPolicy).getPodAndRelativePath).IncludeCurrentGoRoutine().To(
+			func(_ *DynamicPolicy, _ string, podDir string, _ map[string]*v1.Pod) (*v1.Pod, string, error) {
+				processedPodDirs = append(processedPodDirs, podDir)
+				return nil, "", ErrPodNotFound
+			}).Build()
+
+		var orders [][]string
+		for _, dirs := range [][]string{
+			{"pod-uid-3", "pod-uid-1", "besteffort/pod-uid-2"},
+			{"pod-uid-1", "besteffort/pod-uid-2", "pod-uid-3"},
+			{"besteffort/pod-uid-2", "pod-uid-3", "pod-uid-1"},
+		} {
+			podDirs = dirs
+			processedPodDirs = nil
+			_, err := p.checkAndApplyAllPodsQuota(context.TODO(), &advisorsvc.CalculationInfo{CgroupPath: "test_cgroup_path"}, 1000000)
+			convey.So(err, convey.ShouldBeNil)
+			orders = append(orders, processedPodDirs)
+		}
+		for _, order := range orders {
+			convey.So(order, convey.ShouldResemble, []string{"besteffort/pod-uid-2", "pod-uid-1", "pod-uid-3"})
+		}
+	})
+}
+
+func TestDynamicPolicy_checkAdvisorPlanStaleness(t *testing.T) {
+	t.Parallel()
+
+	fakeClock := testingclock.NewFakeClock(time.Now())
+	p := &DynamicPolicy{
+		emitter: metrics.DummyMetrics{},
+		clock:   fakeClock,
+		quotaReconcileConf: &quotareconcile.QuotaReconcileConfiguration{
+			AdvisorPlanStalenessThreshold: time.Minute,
+		},
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test the staleness of the last advisor plan is emitted and warned", t, func() {
+		var stalenesses []float64
+		mockey.Mock(metrics.DummyMetrics.StoreFloat64).IncludeCurrentGoRoutine().To(
+			func(_ metrics.DummyMetrics, key string, val float64, _ metrics.MetricTypeName, _ ...metrics.MetricTag) error {
+				if key == util.MetricNameAdvisorPlanStaleness {
+					stalenesses = append(stalenesses, val)
+				}
+				return nil
+			}).Build()
+		var warnings []string
+		mockey.Mock(general.Warningf).IncludeCurrentGoRoutine().To(func(message string, params ...interface{}) {
+			warnings = append(warnings, fmt.Sprintf(message, params...))
+		}).Build()
+
+		// nothing is emitted before any plan is applied
+		p.checkAdvisorPlanStaleness()
+		convey.So(stalenesses, convey.ShouldBeEmpty)
+
+		p.lastAdvisorPlanTime = fakeClock.Now()
+		fakeClock.Step(30 * time.Second)
+		p.checkAdvisorPlanStaleness()
+		convey.So(stalenesses, convey.ShouldResemble, []float64{30})
+		convey.So(warnings, convey.ShouldBeEmpty)
+
+		fakeClock.Step(time.Minute)
+		p.checkAdvisorPlanStaleness()
+		convey.So(stalenesses, convey.ShouldResemble, []float64{30, 90})
+		convey.So(warnings, convey.ShouldHaveLength, 1)
+		convey.So(warnings[0], convey.ShouldContainSubstring, "exceeds the staleness threshold 1m0s")
+	})
+}
+
+func TestDynamicPolicy_applyStaticPlanFile(t *testing.T) {
+	t.Parallel()
+
+	staticPlanFile := filepath.Join(t.TempDir(), "plan.json")
+	p := newTestDynamicPolicy()
+	p.staticPlanFile = staticPlanFile
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test static plan in the file is applied", t, func() {
+		var appliedResps []*advisorapi.ListAndWatchResponse
+		mockey.Mock((*DynamicPolicy).applyCgroupConfigs).IncludeCurrentGoRoutine().To(func(_ *DynamicPolicy, resp *advisorapi.ListAndWatchResponse) error {
+			appliedResps = append(appliedResps, resp)
+			return nil
+		}).Build()
+
+		// a missing file is not applied
+		p.applyStaticPlanFile()
+		convey.So(appliedResps, convey.ShouldBeEmpty)
+
+		convey.So(os.WriteFile(staticPlanFile, []byte(`[{"cgroup_path": "/kubepods/besteffort"}]`), 0o644), convey.ShouldBeNil)
+		p.applyStaticPlanFile()
+		convey.So(appliedResps, convey.ShouldBeEmpty)
+
+		convey.So(os.WriteFile(staticPlanFile, []byte(`[{"cgroup_path": "/kubepods/besteffort", `+
+			`"calculation_result": {"values": {"cgroup_config": "{\"cpu_quota\": 200000, \"cpu_period\": 100000}"}}}]`), 0o644), convey.ShouldBeNil)
+		p.applyStaticPlanFile()
+		convey.So(appliedResps, convey.ShouldHaveLength, 1)
+		convey.So(appliedResps[0].ExtraEntries, convey.ShouldHaveLength, 1)
+		convey.So(appliedResps[0].ExtraEntries[0].CgroupPath, convey.ShouldEqual, "/kubepods/besteffort")
+		convey.So(appliedResps[0].ExtraEntries[0].CalculationResult.Values, convey.ShouldResemble, map[string]string{
+			string(advisorapi.ControlKnobKeyCgroupConfig): `{"cpu_quota": 200000, "cpu_period": 100000}`,
+		})
+		convey.So(p.lastAdvisorPlanTime.IsZero(), convey.ShouldBeFalse)
+	})
+}
+
+func TestDynamicPolicy_logAdvisorPayload(t *testing.T) {
+	t.Parallel()
+
+	calculationInfo := &advisorsvc.CalculationInfo{
+		CgroupPath: "test_cgroup_path",
+		CalculationResult: &advisorsvc.CalculationResult{
+			Values: map[string]string{
+				string(advisorapi.ControlKnobKeyCgroupConfig): `{"cpu_quota":1000}`,
+				"secret": "sensitive",
+			},
+		},
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test raw advisor payload is logged only if enabled", t, func() {
+		var logged []string
+		mockey.Mock(general.Infof).IncludeCurrentGoRoutine().To(func(format string, args ...interface{}) {
+			if strings.HasPrefix(format, "raw advisor payload") {
+				logged = append(logged, fmt.Sprintf(format, args...))
+			}
+		}).Build()
+
+		newTestDynamicPolicy().logAdvisorPayload(calculationInfo)
+		convey.So(logged, convey.ShouldBeEmpty)
+
+		newTestDynamicPolicy(withTestQuotaReconcileConf(&quotareconcile.QuotaReconcileConfiguration{
+			AdvisorPayloadLogEnabled:      true,
+			AdvisorPayloadLogRedactedKeys: []string{"secret"},
+		})).logAdvisorPayload(calculationInfo)
+		convey.So(logged, convey.ShouldResemble, []string{
+			`raw advisor payload of test_cgroup_path: {"cgroup_config":"{\"cpu_quota\":1000}","secret":"<redacted>"}`,
+		})
+
+		newTestDynamicPolicy(withTestQuotaReconcileConf(&quotareconcile.QuotaReconcileConfiguration{
+			AdvisorPayloadLogEnabled:  true,
+			AdvisorPayloadLogMaxBytes: 10,
+		})).logAdvisorPayload(calculationInfo)
+		convey.So(len(logged), convey.ShouldEqual, 2)
+		convey.So(logged[1], convey.ShouldStartWith, `raw advisor payload of test_cgroup_path (truncated from`)
+		convey.So(logged[1], convey.ShouldEndWith, `: {"cgroup_c...`)
+	})
+}
+
+func TestDynamicPolicy_quotaReconcileSummary(t *testing.T) {
+	t.Parallel()
+
+	p := &DynamicPolicy{
+		emitter:   metrics.DummyMetrics{},
+		qosConfig: generic.NewQoSConfiguration(),
+	}
+
+	groupPath := "test_cgroup_path"
+	newPod := func(name string, annotations map[string]string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Annotations: annotations,
+			},
+			Spec: v1.PodSpec{
+				Containers: []v1.Container{
+					{
+						Name: "test-container",
+						Resources: v1.ResourceRequirements{
+							Limits: v1.ResourceList{
+								v1.ResourceCPU: resource2.MustParse("1"),
+							},
+						},
+					},
+				},
+			},
+		}
+	}
+	pods := map[string]*v1.Pod{
+		"applied":   newPod("test-pod-applied", nil),
+		"unchanged": newPod("test-pod-unchanged", nil),
+		"opted-out": newPod("test-pod-opted-out", map[string]string{cpuconsts.PodAnnotationAdvisorDisabledKey: "true"}),
+		"failed":    newPod("test-pod-failed", nil),
+	}
+	podDirs := []string{"applied", "unchanged", "opted-out", "failed"}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test a summary is logged per round with counts of pods", t, func() {
+		mockTestPodDirs(podDirs, pods)
+		mockey.Mock((*DynamicPolicy).applyAllContainersQuota).IncludeCurrentGoRoutine().To(
+			func(_ *DynamicPolicy, _ context.Context, pod *v1.Pod, _ bool, _ float64) error {
+				if pod.Name == "test-pod-failed" {
+					return fmt.Errorf("test error")
+				}
+				return nil
+			}).Build()
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(path string) (*common.CPUStats, error) {
+			if path == filepath.Join(groupPath, "unchanged") {
+				return &common.CPUStats{CpuQuota: 100000, CpuPeriod: 100000}, nil
+			}
+			return &common.CPUStats{CpuQuota: -1, CpuPeriod: 100000}, nil
+		}).Build()
+		mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().Return(nil).Build()
+		var summaries []string
+		mockey.Mock(general.Infof).IncludeCurrentGoRoutine().To(func(message string, params ...interface{}) {
+			if strings.HasPrefix(message, "quota reconcile of") {
+				summaries = append(summaries, fmt.Sprintf(message, params...))
+			}
+		}).Build()
+
+		result, err := p.checkAndApplyAllPodsQuota(context.TODO(), &advisorsvc.CalculationInfo{CgroupPath: groupPath}, 1000000)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(result.Processed, convey.ShouldEqual, 4)
+		convey.So(result.Applied, convey.ShouldEqual, 1)
+		convey.So(result.Skipped, convey.ShouldEqual, 2)
+		convey.So(result.Failed, convey.ShouldEqual, 1)
+		convey.So(result.Duration, convey.ShouldBeGreaterThan, 0)
+		convey.So(result.PodErrors, convey.ShouldHaveLength, 1)
+		convey.So(result.PodErrors["failed"], convey.ShouldBeError, "test error")
+		convey.So(summaries, convey.ShouldHaveLength, 1)
+		convey.So(summaries[0], convey.ShouldStartWith,
+			"quota reconcile of test_cgroup_path: processed 4 pods, applied 1, skipped 2, failed 1, took ")
+	})
+}
+
+func TestDynamicPolicy_checkAndApplyCPUBurst(t *testing.T) {
+	t.Parallel()
+
+	p := &DynamicPolicy{
+		emitter: metrics.DummyMetrics{},
+	}
+
+	currentBurst := uint64(0)
+	desiredBurst := uint64(50000)
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test cpu burst drifts from desired", t, func() {
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(&common.CPUStats{CpuBurst: &currentBurst}, nil).Build()
+		var appliedData *common.CPUData
+		apply := mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(_ string, data *common.CPUData) error {
+			appliedData = data
+			return nil
+		}).Build()
+
+		err := p.checkAndApplyCPUBurst("test_cgroup_path", &desiredBurst)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(apply.Times(), convey.ShouldEqual, 1)
+		convey.So(*appliedData.CpuBurstPtr, convey.ShouldEqual, desiredBurst)
+	})
+
+	mockey.PatchConvey("test cpu burst equals to desired", t, func() {
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(&common.CPUStats{CpuBurst: &desiredBurst}, nil).Build()
+		apply := mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().Return(nil).Build()
+
+		err := p.checkAndApplyCPUBurst("test_cgroup_path", &desiredBurst)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(apply.Times(), convey.ShouldEqual, 0)
+	})
+
+	mockey.PatchConvey("test cpu burst not supported", t, func() {
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(&common.CPUStats{}, nil).Build()
+		apply := mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().Return(nil).Build()
+
+		err := p.checkAndApplyCPUBurst("test_cgroup_path", &desiredBurst)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(apply.Times(), convey.ShouldEqual, 0)
+	})
+}
+
+func Test_parseCPUUclamp(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		value   string
+		want    float64
+		wantErr bool
+	}{
+		{name: "min percentage", value: "0", want: 0},
+		{name: "fractional percentage", value: "12.5", want: 12.5},
+		{name: "max percentage", value: "100", want: 100},
+		{name: "negative percentage", value: "-1", wantErr: true},
+		{name: "percentage over 100", value: "100.01", wantErr: true},
+		{name: "nan", value: "NaN", wantErr: true},
+		{name: "malformed", value: "max", wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := parseCPUUclamp(map[string]string{
+				string(advisorapi.ControlKnobKeyCPUUclampMin): tt.value,
+			}, advisorapi.ControlKnobKeyCPUUclampMin)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, *got)
+		})
+	}
+
+	got, err := parseCPUUclamp(map[string]string{}, advisorapi.ControlKnobKeyCPUUclampMax)
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+}
+
+func TestDynamicPolicy_applyCPUUclamp(t *testing.T) {
+	t.Parallel()
+
+	p := &DynamicPolicy{
+		emitter: metrics.DummyMetrics{},
+	}
+
+	newCalculationInfo := func(values map[string]string) *advisorsvc.CalculationInfo {
+		return &advisorsvc.CalculationInfo{
+			CgroupPath: "test_cgroup_path",
+			CalculationResult: &advisorsvc.CalculationResult{
+				Values: values,
+			},
+		}
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test apply cpu uclamp in cgroup v2", t, func() {
+		mockey.Mock(common.CheckCgroup2UnifiedMode).IncludeCurrentGoRoutine().Return(true).Build()
+		mockey.Mock(general.IsPathExists).IncludeCurrentGoRoutine().Return(true).Build()
+		var applied *common.CPUData
+		apply := mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(_ string, data *common.CPUData) error {
+			applied = data
+			return nil
+		}).Build()
+
+		err := p.applyCPUUclamp(newCalculationInfo(map[string]string{
+			string(advisorapi.ControlKnobKeyCPUUclampMin): "20",
+			string(advisorapi.ControlKnobKeyCPUUclampMax): "80.5",
+		}))
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(*applied.CpuUclampMinPtr, convey.ShouldEqual, 20)
+		convey.So(*applied.CpuUclampMaxPtr, convey.ShouldEqual, 80.5)
+
+		// out-of-range values are rejected
+		err = p.applyCPUUclamp(newCalculationInfo(map[string]string{
+			string(advisorapi.ControlKnobKeyCPUUclampMax): "101",
+		}))
+		convey.So(err, convey.ShouldNotBeNil)
+
+		// min larger than max is rejected
+		err = p.applyCPUUclamp(newCalculationInfo(map[string]string{
+			string(advisorapi.ControlKnobKeyCPUUclampMin): "60",
+			string(advisorapi.ControlKnobKeyCPUUclampMax): "40",
+		}))
+		convey.So(err, convey.ShouldNotBeNil)
+
+		// nothing to apply without uclamp control knobs
+		err = p.applyCPUUclamp(newCalculationInfo(map[string]string{}))
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(apply.Times(), convey.ShouldEqual, 1)
+	})
+
+	mockey.PatchConvey("test apply cpu uclamp in cgroup v1", t, func() {
+		mockey.Mock(common.CheckCgroup2UnifiedMode).IncludeCurrentGoRoutine().Return(false).Build()
+		apply := mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().Return(nil).Build()
+
+		err := p.applyCPUUclamp(newCalculationInfo(map[string]string{
+			string(advisorapi.ControlKnobKeyCPUUclampMin): "20",
+		}))
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(apply.Times(), convey.ShouldEqual, 0)
+	})
+}
+
+func Test_parsePidsMax(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		value   string
+		want    int64
+		wantErr bool
+	}{
+		{name: "numeric limit", value: "1024", want: 1024},
+		{name: "max sentinel", value: "max", want: common.PidsMaxUnlimit},
+		{name: "zero limit", value: "0", wantErr: true},
+		{name: "negative limit", value: "-1", wantErr: true},
+		{name: "malformed", value: "unlimited", wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := parsePidsMax(map[string]string{
+				string(advisorapi.ControlKnobKeyPidsMax): tt.value,
+			})
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, *got)
+		})
+	}
+
+	got, err := parsePidsMax(map[string]string{})
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+}
+
+func TestDynamicPolicy_applyPidsMax(t *testing.T) {
+	t.Parallel()
+
+	p := &DynamicPolicy{
+		emitter: metrics.DummyMetrics{},
+	}
+
+	newCalculationInfo := func(values map[string]string) *advisorsvc.CalculationInfo {
+		return &advisorsvc.CalculationInfo{
+			CgroupPath: "test_cgroup_path",
+			CalculationResult: &advisorsvc.CalculationResult{
+				Values: values,
+			},
+		}
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test apply pids max", t, func() {
+		var applied []int64
+		apply := mockey.Mock(cgroupmgr.ApplyPidsWithRelativePath).IncludeCurrentGoRoutine().To(func(_ string, data *common.PidsData) error {
+			applied = append(applied, data.PidsMax)
+			return nil
+		}).Build()
+
+		err := p.applyPidsMax(newCalculationInfo(map[string]string{
+			string(advisorapi.ControlKnobKeyPidsMax): "1024",
+		}))
+		convey.So(err, convey.ShouldBeNil)
+
+		err = p.applyPidsMax(newCalculationInfo(map[string]string{
+			string(advisorapi.ControlKnobKeyPidsMax): "max",
+		}))
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(applied, convey.ShouldResemble, []int64{1024, common.PidsMaxUnlimit})
+
+		// invalid values are rejected
+		err = p.applyPidsMax(newCalculationInfo(map[string]string{
+			string(advisorapi.ControlKnobKeyPidsMax): "-1",
+		}))
+		convey.So(err, convey.ShouldNotBeNil)
+
+		// nothing to apply without the pids max control knob
+		err = p.applyPidsMax(newCalculationInfo(map[string]string{}))
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(apply.Times(), convey.ShouldEqual, 2)
+	})
+
+	mockey.PatchConvey("test apply pids max failed", t, func() {
+		mockey.Mock(cgroupmgr.ApplyPidsWithRelativePath).IncludeCurrentGoRoutine().Return(fmt.Errorf("test error")).Build()
+
+		err := p.applyPidsMax(newCalculationInfo(map[string]string{
+			string(advisorapi.ControlKnobKeyPidsMax): "1024",
+		}))
+		convey.So(errors.Is(err, ErrCgroupWrite), convey.ShouldBeTrue)
+	})
+}
+
+func TestDynamicPolicy_applySwapMax(t *testing.T) {
+	t.Parallel()
+
+	p := &DynamicPolicy{
+		emitter: metrics.DummyMetrics{},
+	}
+
+	newCalculationInfo := func(values map[string]string) *advisorsvc.CalculationInfo {
+		return &advisorsvc.CalculationInfo{
+			CgroupPath: "test_cgroup_path",
+			CalculationResult: &advisorsvc.CalculationResult{
+				Values: values,
+			},
+		}
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test apply swap max", t, func() {
+		mockey.Mock(general.IsPathExists).IncludeCurrentGoRoutine().Return(true).Build()
+		mockey.Mock(common.CheckCgroup2UnifiedMode).IncludeCurrentGoRoutine().Return(true).Build()
+		var applied []int64
+		apply := mockey.Mock(cgroupmgr.ApplyMemoryWithRelativePath).IncludeCurrentGoRoutine().To(func(_ string, data *common.MemoryData) error {
+			applied = append(applied, data.SwapMaxInBytes)
+			return nil
+		}).Build()
+
+		for _, value := range []string{"0", "1073741824", "max"} {
+			err := p.applySwapMax(newCalculationInfo(map[string]string{
+				string(advisorapi.ControlKnobKeySwapMax): value,
+			}))
+			convey.So(err, convey.ShouldBeNil)
+		}
+		convey.So(applied, convey.ShouldResemble, []int64{-1, 1073741824, math.MaxInt64})
+
+		// invalid values are rejected
+		err := p.applySwapMax(newCalculationInfo(map[string]string{
+			string(advisorapi.ControlKnobKeySwapMax): "-1",
+		}))
+		convey.So(err, convey.ShouldNotBeNil)
+
+		// nothing to apply without the swap max control knob
+		err = p.applySwapMax(newCalculationInfo(map[string]string{}))
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(apply.Times(), convey.ShouldEqual, 3)
+	})
+
+	mockey.PatchConvey("test apply swap max unsupported", t, func() {
+		mockey.Mock(common.CheckCgroup2UnifiedMode).IncludeCurrentGoRoutine().Return(false).Build()
+		apply := mockey.Mock(cgroupmgr.ApplyMemoryWithRelativePath).IncludeCurrentGoRoutine().Return(nil).Build()
+
+		err := p.applySwapMax(newCalculationInfo(map[string]string{
+			string(advisorapi.ControlKnobKeySwapMax): "max",
+		}))
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(apply.Times(), convey.ShouldEqual, 0)
+	})
+
+	mockey.PatchConvey("test apply swap max failed", t, func() {
+		mockey.Mock(general.IsPathExists).IncludeCurrentGoRoutine().Return(true).Build()
+		mockey.Mock(common.CheckCgroup2UnifiedMode).IncludeCurrentGoRoutine().Return(true).Build()
+		mockey.Mock(cgroupmgr.ApplyMemoryWithRelativePath).IncludeCurrentGoRoutine().Return(fmt.Errorf("test error")).Build()
+
+		err := p.applySwapMax(newCalculationInfo(map[string]string{
+			string(advisorapi.ControlKnobKeySwapMax): "1073741824",
+		}))
+		convey.So(errors.Is(err, ErrCgroupWrite), convey.ShouldBeTrue)
+	})
+}
+
+func TestDynamicPolicy_applySwapMaxUnchanged(t *testing.T) {
+	t.Parallel()
+
+	cgroupRoot := t.TempDir()
+	podRelativePath := filepath.Join(common.CgroupFsRootPathBurstable, "podtest-pod-uid")
+	swapMaxFile := filepath.Join(cgroupRoot, podRelativePath, "memory.swap.max")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(swapMaxFile), 0o755))
+
+	p := newTestDynamicPolicy()
+	p.cgroupRootOverride = cgroupRoot
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test swap max is only written when the kernel reads back a different value", t, func() {
+		mockey.Mock(common.CheckCgroup2UnifiedMode).IncludeCurrentGoRoutine().Return(true).Build()
+		var applied []int64
+		mockey.Mock(cgroupmgr.ApplyMemoryWithRelativePath).IncludeCurrentGoRoutine().To(func(_ string, data *common.MemoryData) error {
+			applied = append(applied, data.SwapMaxInBytes)
+			return nil
+		}).Build()
+
+		for _, c := range []struct{ current, value string }{
+			{current: "max", value: "max"},
+			{current: "0", value: "0"},
+			{current: "1073741824", value: "1073741824"},
+			{current: "max", value: "0"},
+			{current: "0", value: "max"},
+			{current: "1073741824", value: "2147483648"},
+		} {
+			assert.NoError(t, os.WriteFile(swapMaxFile, []byte(c.current+"\n"), 0o644))
+			err := p.applySwapMax(&advisorsvc.CalculationInfo{
+				CgroupPath: podRelativePath,
+				CalculationResult: &advisorsvc.CalculationResult{
+					Values: map[string]string{string(advisorapi.ControlKnobKeySwapMax): c.value},
+				},
+			})
+			convey.So(err, convey.ShouldBeNil)
+		}
+		convey.So(applied, convey.ShouldResemble, []int64{-1, math.MaxInt64, 2147483648})
+	})
+}
+
+type testNodeFetcher struct {
+	node *v1.Node
+}
+
+func (f *testNodeFetcher) Run(_ context.Context) {}
+
+func (f *testNodeFetcher) GetNode(_ context.Context) (*v1.Node, error) {
+	return f.node, nil
+}
+
+func TestDynamicPolicy_checkQuotaOvercommit(t *testing.T) {
+	t.Parallel()
+
+	testNode := &v1.Node{
+		Status: v1.NodeStatus{
+			Allocatable: v1.ResourceList{
+				v1.ResourceCPU: resource2.MustParse("4"),
+			},
+		},
+	}
+	cgroupConfig, _ := json.Marshal(&common.CgroupResources{CpuQuota: 300000, CpuPeriod: 100000})
+	unlimitedConfig, _ := json.Marshal(&common.CgroupResources{CpuQuota: -1, CpuPeriod: 100000})
+	newCalculationInfo := func(key advisorapi.CPUControlKnobName, value string) *advisorsvc.CalculationInfo {
+		return &advisorsvc.CalculationInfo{
+			CgroupPath: "test_cgroup_path",
+			CalculationResult: &advisorsvc.CalculationResult{
+				Values: map[string]string{string(key): value},
+			},
+		}
+	}
+	// quotas of 3 and 2 cores sum up above the allocatable of 4 cores
+	resp := &advisorapi.ListAndWatchResponse{
+		ExtraEntries: []*advisorsvc.CalculationInfo{
+			newCalculationInfo(advisorapi.ControlKnobKeyCgroupConfig, string(cgroupConfig)),
+			newCalculationInfo(advisorapi.ControlKnobKeyCPUCores, "2"),
+			newCalculationInfo(advisorapi.ControlKnobKeyCgroupConfig, string(unlimitedConfig)),
+		},
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test overcommit of advisor quotas is warned", t, func() {
+		var ratios []float64
+		mockey.Mock(metrics.DummyMetrics.StoreFloat64).IncludeCurrentGoRoutine().To(
+			func(_ metrics.DummyMetrics, key string, val float64, _ metrics.MetricTypeName, _ ...metrics.MetricTag) error {
+				if key == util.MetricNameAdvisorQuotaAllocatableRatio {
+					ratios = append(ratios, val)
+				}
+				return nil
+			}).Build()
+		var warnings int
+		mockey.Mock(general.Warningf).IncludeCurrentGoRoutine().To(func(message string, _ ...interface{}) {
+			if strings.HasPrefix(message, "quotas of cpu advisor sum up") {
+				warnings++
+			}
+		}).Build()
+
+		p := newTestDynamicPolicy(withTestQuotaReconcileConf(&quotareconcile.QuotaReconcileConfiguration{
+			QuotaOvercommitWarningRatio: 1.1,
+		}))
+		p.metaServer.NodeFetcher = &testNodeFetcher{node: testNode}
+		p.checkQuotaOvercommit(resp)
+		convey.So(ratios, convey.ShouldResemble, []float64{1.25})
+		convey.So(warnings, convey.ShouldEqual, 1)
+
+		// the overcommit within the threshold is not warned
+		p.quotaReconcileConf.QuotaOvercommitWarningRatio = 1.5
+		p.checkQuotaOvercommit(resp)
+		convey.So(ratios, convey.ShouldResemble, []float64{1.25, 1.25})
+		convey.So(warnings, convey.ShouldEqual, 1)
+	})
+}
+
+func TestDynamicPolicy_isReconcilePausedForMaintenance(t *testing.T) {
+	t.Parallel()
+
+	testNode := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test-node",
+		},
+	}
+	p := newTestDynamicPolicy(withTestQuotaReconcileConf(&quotareconcile.QuotaReconcileConfiguration{
+		PauseOnNodeMaintenance: true,
+	}))
+	p.metaServer.NodeFetcher = &testNodeFetcher{node: testNode}
+
+	resourcesBytes, _ := json.Marshal(&common.CgroupResources{CpuPeriod: 100000})
+	resp := &advisorapi.ListAndWatchResponse{
+		ExtraEntries: []*advisorsvc.CalculationInfo{
+			{
+				CgroupPath: "test_cgroup_path",
+				CalculationResult: &advisorsvc.CalculationResult{
+					Values: map[string]string{
+						string(advisorapi.ControlKnobKeyCgroupConfig): string(resourcesBytes),
+					},
+				},
+			},
+		},
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test reconcile is paused while the node is under maintenance", t, func() {
+		mockey.Mock(general.IsPathExists).IncludeCurrentGoRoutine().Return(true).Build()
+		mockey.Mock(common.CheckCgroup2UnifiedMode).IncludeCurrentGoRoutine().Return(true).Build()
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(&common.CPUStats{CpuQuota: -1, CpuPeriod: 100000}, nil).Build()
+		mockey.Mock((*DynamicPolicy).checkAndApplyIfCgroupV1).IncludeCurrentGoRoutine().Return(nil, nil).Build()
+		mockey.Mock((*DynamicPolicy).checkAndApplyCPUBurst).IncludeCurrentGoRoutine().Return(nil).Build()
+		apply := mockey.Mock(common.ApplyCgroupConfigs).IncludeCurrentGoRoutine().Return(nil).Build()
+		var heartbeats []int64
+		mockey.Mock(metrics.DummyMetrics.StoreInt64).IncludeCurrentGoRoutine().To(
+			func(_ metrics.DummyMetrics, key string, val int64, _ metrics.MetricTypeName, _ ...metrics.MetricTag) error {
+				if key == util.MetricNameQuotaReconcilePaused {
+					heartbeats = append(heartbeats, val)
+				}
+				return nil
+			}).Build()
+
+		err := p.applyCgroupConfigs(resp)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(apply.Times(), convey.ShouldEqual, 1)
+
+		// reconcile is paused while the node is cordoned
+		testNode.Spec.Unschedulable = true
+		err = p.applyCgroupConfigs(resp)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(apply.Times(), convey.ShouldEqual, 1)
 
-		err := p.applyAllContainersQuota(pod, true)
+		// or annotated to be under maintenance
+		testNode.Spec.Unschedulable = false
+		testNode.Annotations = map[string]string{cpuconsts.NodeAnnotationMaintenanceKey: "true"}
+		err = p.applyCgroupConfigs(resp)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(apply.Times(), convey.ShouldEqual, 1)
 
+		// and it resumes once the maintenance is cleared
+		testNode.Annotations = nil
+		err = p.applyCgroupConfigs(resp)
 		convey.So(err, convey.ShouldBeNil)
 		convey.So(apply.Times(), convey.ShouldEqual, 2)
+		convey.So(heartbeats, convey.ShouldResemble, []int64{0, 1, 1, 0})
 
-		err = p.applyAllContainersQuota(pod, false)
+		// reconciles are never paused if it's not configured
+		testNode.Spec.Unschedulable = true
+		p.quotaReconcileConf.PauseOnNodeMaintenance = false
+		err = p.applyCgroupConfigs(resp)
 		convey.So(err, convey.ShouldBeNil)
+		convey.So(apply.Times(), convey.ShouldEqual, 3)
 	})
 }
 
-func TestDynamicPolicy_checkAndApplySubCgroupPath(t *testing.T) {
+func TestDynamicPolicy_mergeCalculationInfosBySource(t *testing.T) {
+	t.Parallel()
+
+	p := newTestDynamicPolicy(withTestQuotaReconcileConf(&quotareconcile.QuotaReconcileConfiguration{
+		AdvisorSourceMergeStrategy: quotareconcile.AdvisorSourceMergeStrategyPriority,
+		AdvisorSourcePriorities:    []string{"custom-advisor", "cpu-advisor"},
+	}))
+	podPath := "/kubepods/burstable/podtest-pod-uid"
+	newCalculationInfo := func(source string, quota int64) *advisorsvc.CalculationInfo {
+		resourcesBytes, _ := json.Marshal(&common.CgroupResources{CpuQuota: quota, CpuPeriod: 100000})
+		values := map[string]string{string(advisorapi.ControlKnobKeyCgroupConfig): string(resourcesBytes)}
+		if source != "" {
+			values[string(advisorapi.ControlKnobKeyAdvisorSource)] = source
+		}
+		return &advisorsvc.CalculationInfo{
+			CgroupPath:        podPath,
+			CalculationResult: &advisorsvc.CalculationResult{Values: values},
+		}
+	}
+	// the custom advisor pushes its knobs before cpu-advisor in the plan
+	resp := &advisorapi.ListAndWatchResponse{
+		ExtraEntries: []*advisorsvc.CalculationInfo{
+			newCalculationInfo("custom-advisor", 300000),
+			newCalculationInfo("", 100000),
+		},
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test knobs of the source with the highest priority are applied", t, func() {
+		mockey.Mock(general.IsPathExists).IncludeCurrentGoRoutine().Return(true).Build()
+		mockey.Mock(common.CheckCgroup2UnifiedMode).IncludeCurrentGoRoutine().Return(true).Build()
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(&common.CPUStats{CpuQuota: -1, CpuPeriod: 100000}, nil).Build()
+		mockey.Mock((*DynamicPolicy).checkAndApplyCPUBurst).IncludeCurrentGoRoutine().Return(nil).Build()
+		var appliedQuotas []int64
+		mockey.Mock(common.ApplyCgroupConfigs).IncludeCurrentGoRoutine().To(
+			func(relativePath string, resources *common.CgroupResources) error {
+				convey.So(relativePath, convey.ShouldEqual, podPath)
+				appliedQuotas = append(appliedQuotas, resources.CpuQuota)
+				return nil
+			}).Build()
+
+		err := p.applyCgroupConfigs(resp)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(appliedQuotas, convey.ShouldResemble, []int64{300000})
+		convey.So(p.lastCgroupConfigs, convey.ShouldHaveLength, 1)
+		// the plan itself is left untouched
+		convey.So(resp.ExtraEntries, convey.ShouldHaveLength, 2)
+
+		// the last calculation info of the plan wins by the last-writer strategy
+		p.quotaReconcileConf.AdvisorSourceMergeStrategy = quotareconcile.AdvisorSourceMergeStrategyLastWriter
+		err = p.applyCgroupConfigs(resp)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(appliedQuotas, convey.ShouldResemble, []int64{300000, 100000})
+	})
+}
+
+func TestDynamicPolicy_isNodeReadyForReconcile(t *testing.T) {
 	t.Parallel()
 
+	testNode := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test-node",
+		},
+		Status: v1.NodeStatus{
+			Conditions: []v1.NodeCondition{
+				{Type: v1.NodeReady, Status: v1.ConditionFalse},
+			},
+		},
+	}
 	p := &DynamicPolicy{
+		emitter: metrics.DummyMetrics{},
 		metaServer: &metaserver.MetaServer{
 			MetaAgent: &agent.MetaAgent{
-				PodFetcher: &pod.PodFetcherStub{},
+				NodeFetcher: &testNodeFetcher{node: testNode},
+			},
+		},
+		quotaReconcileConf: &quotareconcile.QuotaReconcileConfiguration{
+			WaitForNodeReady: true,
+			MinNodeUptime:    time.Minute,
+		},
+	}
+
+	resourcesBytes, _ := json.Marshal(&common.CgroupResources{CpuPeriod: 100000})
+	resp := &advisorapi.ListAndWatchResponse{
+		ExtraEntries: []*advisorsvc.CalculationInfo{
+			{
+				CgroupPath: "test_cgroup_path",
+				CalculationResult: &advisorsvc.CalculationResult{
+					Values: map[string]string{
+						string(advisorapi.ControlKnobKeyCgroupConfig): string(resourcesBytes),
+					},
+				},
+			},
+		},
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test the first reconcile is deferred until the node is ready", t, func() {
+		mockey.Mock(general.IsPathExists).IncludeCurrentGoRoutine().Return(true).Build()
+		mockey.Mock(common.CheckCgroup2UnifiedMode).IncludeCurrentGoRoutine().Return(true).Build()
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(&common.CPUStats{CpuQuota: -1, CpuPeriod: 100000}, nil).Build()
+		mockey.Mock((*DynamicPolicy).checkAndApplyIfCgroupV1).IncludeCurrentGoRoutine().Return(nil, nil).Build()
+		mockey.Mock((*DynamicPolicy).checkAndApplyCPUBurst).IncludeCurrentGoRoutine().Return(nil).Build()
+		apply := mockey.Mock(common.ApplyCgroupConfigs).IncludeCurrentGoRoutine().Return(nil).Build()
+		uptime := 10 * time.Second
+		mockey.Mock(getNodeUptime).IncludeCurrentGoRoutine().To(func() (time.Duration, error) {
+			return uptime, nil
+		}).Build()
+
+		// the node has not been up for long enough
+		err := p.applyCgroupConfigs(resp)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(apply.Times(), convey.ShouldEqual, 0)
+
+		// the node is not ready yet
+		uptime = time.Hour
+		err = p.applyCgroupConfigs(resp)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(apply.Times(), convey.ShouldEqual, 0)
+
+		testNode.Status.Conditions[0].Status = v1.ConditionTrue
+		err = p.applyCgroupConfigs(resp)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(apply.Times(), convey.ShouldEqual, 1)
+
+		// later reconciles are no longer gated
+		testNode.Status.Conditions[0].Status = v1.ConditionFalse
+		err = p.applyCgroupConfigs(resp)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(apply.Times(), convey.ShouldEqual, 2)
+	})
+}
+
+func TestDynamicPolicy_applyCgroupFreeze(t *testing.T) {
+	t.Parallel()
+
+	p := &DynamicPolicy{
+		emitter: metrics.DummyMetrics{},
+	}
+
+	newCalculationInfo := func(values map[string]string) *advisorsvc.CalculationInfo {
+		return &advisorsvc.CalculationInfo{
+			CgroupPath: "test_pod_cgroup_path",
+			CalculationResult: &advisorsvc.CalculationResult{
+				Values: values,
+			},
+		}
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test freeze and thaw cgroup", t, func() {
+		var frozenStates []bool
+		apply := mockey.Mock(cgroupmgr.ApplyFreezerWithRelativePath).IncludeCurrentGoRoutine().To(func(_ string, data *common.FreezerData) error {
+			frozenStates = append(frozenStates, *data.FrozenPtr)
+			return nil
+		}).Build()
+
+		err := p.applyCgroupFreeze(newCalculationInfo(map[string]string{
+			string(advisorapi.ControlKnobKeyCgroupFreeze): "1",
+		}))
+		convey.So(err, convey.ShouldBeNil)
+
+		err = p.applyCgroupFreeze(newCalculationInfo(map[string]string{
+			string(advisorapi.ControlKnobKeyCgroupFreeze): "0",
+		}))
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(frozenStates, convey.ShouldResemble, []bool{true, false})
+
+		// invalid values are rejected
+		err = p.applyCgroupFreeze(newCalculationInfo(map[string]string{
+			string(advisorapi.ControlKnobKeyCgroupFreeze): "FROZEN",
+		}))
+		convey.So(err, convey.ShouldNotBeNil)
+
+		// nothing to apply without the cgroup freeze control knob
+		err = p.applyCgroupFreeze(newCalculationInfo(map[string]string{}))
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(apply.Times(), convey.ShouldEqual, 2)
+	})
+
+	mockey.PatchConvey("test freeze cgroup failed", t, func() {
+		mockey.Mock(cgroupmgr.ApplyFreezerWithRelativePath).IncludeCurrentGoRoutine().Return(fmt.Errorf("test error")).Build()
+
+		err := p.applyCgroupFreeze(newCalculationInfo(map[string]string{
+			string(advisorapi.ControlKnobKeyCgroupFreeze): "1",
+		}))
+		convey.So(errors.Is(err, ErrCgroupWrite), convey.ShouldBeTrue)
+	})
+}
+
+func TestDynamicPolicy_applyCgroupFreezeUnchanged(t *testing.T) {
+	t.Parallel()
+
+	cgroupRoot := t.TempDir()
+	cgroupPath := "test_pod_cgroup_path"
+	freezerStateFile := filepath.Join(cgroupRoot, common.CgroupSubsysFreezer, cgroupPath, "freezer.state")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(freezerStateFile), 0o755))
+
+	p := newTestDynamicPolicy()
+	p.cgroupRootOverride = cgroupRoot
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test freezer state is only written on a change", t, func() {
+		mockey.Mock(common.CheckCgroup2UnifiedMode).IncludeCurrentGoRoutine().Return(false).Build()
+		var frozenStates []bool
+		mockey.Mock((*DynamicPolicy).applyFreezerWithRelativePath).IncludeCurrentGoRoutine().To(func(_ *DynamicPolicy, _ string, data *common.FreezerData) error {
+			frozenStates = append(frozenStates, *data.FrozenPtr)
+			return nil
+		}).Build()
+
+		for _, c := range []struct{ current, value string }{
+			{current: "FROZEN", value: "1"},
+			{current: "THAWED", value: "0"},
+			{current: "THAWED", value: "1"},
+			{current: "FROZEN", value: "0"},
+			// a cgroup still freezing is written again
+			{current: "FREEZING", value: "1"},
+		} {
+			assert.NoError(t, os.WriteFile(freezerStateFile, []byte(c.current+"\n"), 0o644))
+			err := p.applyCgroupFreeze(&advisorsvc.CalculationInfo{
+				CgroupPath: cgroupPath,
+				CalculationResult: &advisorsvc.CalculationResult{
+					Values: map[string]string{string(advisorapi.ControlKnobKeyCgroupFreeze): c.value},
+				},
+			})
+			convey.So(err, convey.ShouldBeNil)
+		}
+		convey.So(frozenStates, convey.ShouldResemble, []bool{true, false, true})
+	})
+}
+
+func TestDynamicPolicy_applyToCgroupPids(t *testing.T) {
+	t.Parallel()
+
+	cgroupRoot := t.TempDir()
+	cgroupPath := "test_container_cgroup_path"
+	absCgroupPath := filepath.Join(cgroupRoot, common.DefaultSelectedSubsys, cgroupPath)
+	assert.NoError(t, os.MkdirAll(filepath.Join(absCgroupPath, "child"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(absCgroupPath, common.CgroupProcsFile), []byte("100\n101\n"), 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(absCgroupPath, "child", common.CgroupProcsFile), []byte("102\n"), 0o644))
+
+	p := newTestDynamicPolicy()
+	p.cgroupRootOverride = cgroupRoot
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test apply to all pids under the cgroup path", t, func() {
+		mockey.Mock(common.CheckCgroup2UnifiedMode).IncludeCurrentGoRoutine().Return(false).Build()
+
+		var applied []int
+		err := p.applyToCgroupPids(cgroupPath, func(pid int) error {
+			// the process exits in the middle of the iteration
+			if pid == 101 {
+				return fmt.Errorf("test error: %w", syscall.ESRCH)
+			}
+			applied = append(applied, pid)
+			return nil
+		})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(applied, convey.ShouldResemble, []int{100, 102})
+
+		// errors of processes still alive are returned after all processes are applied
+		applied = nil
+		err = p.applyToCgroupPids(cgroupPath, func(pid int) error {
+			applied = append(applied, pid)
+			if pid == 100 {
+				return fmt.Errorf("test error")
+			}
+			return nil
+		})
+		convey.So(err, convey.ShouldNotBeNil)
+		convey.So(applied, convey.ShouldResemble, []int{100, 101, 102})
+
+		// the cgroup itself must exist
+		err = p.applyToCgroupPids("not_exist_cgroup_path", func(int) error { return nil })
+		convey.So(errors.Is(err, ErrCgroupRead), convey.ShouldBeTrue)
+	})
+}
+
+func TestDynamicPolicy_applyOOMScoreAdj(t *testing.T) {
+	t.Parallel()
+
+	p := &DynamicPolicy{
+		emitter: metrics.DummyMetrics{},
+	}
+
+	newCalculationInfo := func(values map[string]string) *advisorsvc.CalculationInfo {
+		return &advisorsvc.CalculationInfo{
+			CgroupPath: "test_pod_cgroup_path",
+			CalculationResult: &advisorsvc.CalculationResult{
+				Values: values,
+			},
+		}
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test apply oom score adj to all processes", t, func() {
+		mockey.Mock(readCgroupProcs).IncludeCurrentGoRoutine().Return([]int{100, 101, 102}, nil).Build()
+		applied := map[int]int{}
+		setOOMScoreAdj := mockey.Mock(process.SetProcessOOMScoreAdj).IncludeCurrentGoRoutine().To(func(pid int, oomScoreAdj int) error {
+			// the process exits before its oom_score_adj is written
+			if pid == 101 {
+				return fmt.Errorf("test error: %w", os.ErrNotExist)
+			}
+			applied[pid] = oomScoreAdj
+			return nil
+		}).Build()
+
+		err := p.applyOOMScoreAdj(newCalculationInfo(map[string]string{
+			string(advisorapi.ControlKnobKeyOOMScoreAdj): "500",
+		}))
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(applied, convey.ShouldResemble, map[int]int{100: 500, 102: 500})
+
+		// nothing to apply without the oom score adj control knob
+		err = p.applyOOMScoreAdj(newCalculationInfo(map[string]string{}))
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(setOOMScoreAdj.Times(), convey.ShouldEqual, 3)
+	})
+
+	mockey.PatchConvey("test oom score adj out of range is rejected", t, func() {
+		setOOMScoreAdj := mockey.Mock(process.SetProcessOOMScoreAdj).IncludeCurrentGoRoutine().Return(nil).Build()
+
+		for _, value := range []string{"-1001", "1001", "invalid"} {
+			err := p.applyOOMScoreAdj(newCalculationInfo(map[string]string{
+				string(advisorapi.ControlKnobKeyOOMScoreAdj): value,
+			}))
+			convey.So(err, convey.ShouldNotBeNil)
+		}
+		convey.So(setOOMScoreAdj.Times(), convey.ShouldEqual, 0)
+	})
+
+	mockey.PatchConvey("test apply oom score adj failed", t, func() {
+		mockey.Mock(readCgroupProcs).IncludeCurrentGoRoutine().Return([]int{100, 101}, nil).Build()
+		mockey.Mock(process.SetProcessOOMScoreAdj).IncludeCurrentGoRoutine().To(func(pid int, _ int) error {
+			if pid == 100 {
+				return fmt.Errorf("test error")
+			}
+			return nil
+		}).Build()
+
+		err := p.applyOOMScoreAdj(newCalculationInfo(map[string]string{
+			string(advisorapi.ControlKnobKeyOOMScoreAdj): "-1000",
+		}))
+		convey.So(errors.Is(err, ErrCgroupWrite), convey.ShouldBeTrue)
+	})
+
+	mockey.PatchConvey("test oom score adj is applied through the guarded write path", t, func() {
+		mockey.Mock(readCgroupProcs).IncludeCurrentGoRoutine().Return([]int{100, 101}, nil).Build()
+		setOOMScoreAdj := mockey.Mock(process.SetProcessOOMScoreAdj).IncludeCurrentGoRoutine().Return(nil).Build()
+		calculationInfo := newCalculationInfo(map[string]string{
+			string(advisorapi.ControlKnobKeyOOMScoreAdj): "500",
+		})
+
+		// the write counts toward the cap of the round, and nothing is written once it's reached
+		capped := newTestDynamicPolicy()
+		capped.cgroupWriteCap = newCgroupWriteCap(1)
+		convey.So(capped.applyOOMScoreAdj(calculationInfo), convey.ShouldBeNil)
+		convey.So(setOOMScoreAdj.Times(), convey.ShouldEqual, 2)
+		err := capped.applyOOMScoreAdj(calculationInfo)
+		convey.So(errors.Is(err, errCgroupWriteCapReached), convey.ShouldBeTrue)
+		convey.So(setOOMScoreAdj.Times(), convey.ShouldEqual, 2)
+
+		// nothing is written once the breaker is open
+		tripped := newTestDynamicPolicy()
+		tripped.cgroupWriteBreaker = newCgroupWriteBreaker(1)
+		tripped.cgroupWriteBreaker.record(fmt.Errorf("test error"))
+		err = tripped.applyOOMScoreAdj(calculationInfo)
+		convey.So(errors.Is(err, errCgroupWriteBreakerOpen), convey.ShouldBeTrue)
+		convey.So(setOOMScoreAdj.Times(), convey.ShouldEqual, 2)
+	})
+}
+
+func TestDynamicPolicy_applyContainerMemoryLimits(t *testing.T) {
+	t.Parallel()
+
+	testPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test-pod",
+			UID:  "test-pod-uid",
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{
+				{Name: "test-container-1"},
+				{Name: "test-container-2"},
 			},
 		},
 	}
+	p := newTestDynamicPolicy(withTestPods(testPod))
+
+	newCalculationInfo := func(limits map[string]map[string]int64) *advisorsvc.CalculationInfo {
+		limitsBytes, _ := json.Marshal(limits)
+		return &advisorsvc.CalculationInfo{
+			CgroupPath: "test_cgroup_path",
+			CalculationResult: &advisorsvc.CalculationResult{
+				Values: map[string]string{
+					string(advisorapi.ControlKnobKeyContainerMemoryLimits): string(limitsBytes),
+				},
+			},
+		}
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test apply container memory limits", t, func() {
+		mockey.Mock((*DynamicPolicy).getAllContainersRelativePathMap).IncludeCurrentGoRoutine().Return(map[string]*v1.Container{
+			"test-container-path-1": &testPod.Spec.Containers[0],
+			"test-container-path-2": &testPod.Spec.Containers[1],
+		}).Build()
+		mockey.Mock(cgroupmgr.GetMetricsWithRelativePath).IncludeCurrentGoRoutine().To(
+			func(_ string, _ map[string]struct{}) (*common.CgroupMetrics, error) {
+				return &common.CgroupMetrics{Memory: &common.MemoryMetrics{RSS: 2 << 30}}, nil
+			}).Build()
+		applied := map[string]int64{}
+		mockey.Mock(cgroupmgr.ApplyMemoryWithRelativePath).IncludeCurrentGoRoutine().To(func(path string, data *common.MemoryData) error {
+			applied[path] = data.LimitInBytes
+			return nil
+		}).Build()
+
+		err := p.applyContainerMemoryLimits(context.TODO(), newCalculationInfo(map[string]map[string]int64{
+			"test-pod-uid": {"test-container-1": 4 << 30},
+		}))
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(applied, convey.ShouldResemble, map[string]int64{"test-container-path-1": 4 << 30})
+
+		// the limit below rss is rejected, and the other container is still applied
+		err = p.applyContainerMemoryLimits(context.TODO(), newCalculationInfo(map[string]map[string]int64{
+			"test-pod-uid": {"test-container-1": 1 << 30, "test-container-2": 3 << 30},
+		}))
+		convey.So(errors.Is(err, ErrMemoryLimitBelowUsage), convey.ShouldBeTrue)
+		convey.So(applied, convey.ShouldResemble, map[string]int64{
+			"test-container-path-1": 4 << 30,
+			"test-container-path-2": 3 << 30,
+		})
+
+		// limits of unknown pods are rejected
+		err = p.applyContainerMemoryLimits(context.TODO(), newCalculationInfo(map[string]map[string]int64{
+			"unknown-pod-uid": {"test-container-1": 4 << 30},
+		}))
+		convey.So(errors.Is(err, ErrPodNotFound), convey.ShouldBeTrue)
+
+		// limits are rejected rather than panicking without the meta server
+		err = (&DynamicPolicy{emitter: metrics.DummyMetrics{}}).applyContainerMemoryLimits(context.TODO(),
+			newCalculationInfo(map[string]map[string]int64{
+				"test-pod-uid": {"test-container-1": 4 << 30},
+			}))
+		convey.So(errors.Is(err, ErrPodNotFound), convey.ShouldBeTrue)
+	})
+}
+
+func TestDynamicPolicy_applyCPUSetMems(t *testing.T) {
+	t.Parallel()
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	assert.NoError(t, err)
+	p := &DynamicPolicy{
+		emitter:     metrics.DummyMetrics{},
+		machineInfo: &machine.KatalystMachineInfo{CPUTopology: cpuTopology},
+	}
+
+	newCalculationInfo := func(values map[string]string) *advisorsvc.CalculationInfo {
+		return &advisorsvc.CalculationInfo{
+			CgroupPath: "test_cgroup_path",
+			CalculationResult: &advisorsvc.CalculationResult{
+				Values: values,
+			},
+		}
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test apply cpuset mems", t, func() {
+		var applied []string
+		apply := mockey.Mock(cgroupmgr.ApplyCPUSetWithRelativePath).IncludeCurrentGoRoutine().To(func(_ string, data *common.CPUSetData) error {
+			applied = append(applied, data.Mems)
+			return nil
+		}).Build()
+
+		for _, mems := range []string{"0-1", "3,2", "0"} {
+			err := p.applyCPUSetMems(newCalculationInfo(map[string]string{
+				string(advisorapi.ControlKnobKeyCPUSetMems): mems,
+			}))
+			convey.So(err, convey.ShouldBeNil)
+		}
+		convey.So(applied, convey.ShouldResemble, []string{"0-1", "2-3", "0"})
+
+		// invalid specs and nonexistent NUMA nodes are rejected
+		for _, mems := range []string{"", "a-b", "4", "2-5"} {
+			err := p.applyCPUSetMems(newCalculationInfo(map[string]string{
+				string(advisorapi.ControlKnobKeyCPUSetMems): mems,
+			}))
+			convey.So(err, convey.ShouldNotBeNil)
+		}
+
+		// nothing to apply without the cpuset mems control knob
+		err := p.applyCPUSetMems(newCalculationInfo(map[string]string{}))
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(apply.Times(), convey.ShouldEqual, 3)
+	})
+
+	mockey.PatchConvey("test apply cpuset mems failed", t, func() {
+		mockey.Mock(cgroupmgr.ApplyCPUSetWithRelativePath).IncludeCurrentGoRoutine().Return(fmt.Errorf("test error")).Build()
+
+		err := p.applyCPUSetMems(newCalculationInfo(map[string]string{
+			string(advisorapi.ControlKnobKeyCPUSetMems): "1",
+		}))
+		convey.So(errors.Is(err, ErrCgroupWrite), convey.ShouldBeTrue)
+	})
+}
+
+func TestDynamicPolicy_checkAndApplySubCgroupPath(t *testing.T) {
+	t.Parallel()
+
+	p := newTestDynamicPolicy()
 
 	advisorTestMutex.Lock()
 	defer advisorTestMutex.Unlock()
 	mockey.PatchConvey("test checkAndApplySubCgroupPath", t, func() {
 		d1 := mockDirEntry{isDir: false}
-		err1 := p.checkAndApplySubCgroupPath("path1", d1, nil)
+		err1 := p.checkAndApplySubCgroupPath(context.TODO(), "path1", d1, nil)
 		convey.So(err1, convey.ShouldBeNil)
 
 		d2 := mockDirEntry{isDir: true}
 		subCPU2 := &common.CPUStats{CpuQuota: -1}
-		mockey.Mock(cgroupmgr.GetCPUWithAbsolutePath).IncludeCurrentGoRoutine().Return(subCPU2, nil).Build()
-		err2 := p.checkAndApplySubCgroupPath("path2", d2, nil)
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(subCPU2, nil).Build()
+		err2 := p.checkAndApplySubCgroupPath(context.TODO(), "path2", d2, nil)
 		convey.So(err2, convey.ShouldBeNil)
 	})
 
 	mockey.PatchConvey("test checkAndApplySubCgroupPath", t, func() {
 		d3 := mockDirEntry{isDir: true}
 		subCPU3 := &common.CPUStats{CpuQuota: 1000}
-		mockey.Mock(cgroupmgr.GetCPUWithAbsolutePath).IncludeCurrentGoRoutine().Return(subCPU3, nil).Build()
-		mockey.Mock(cgroupmgr.ApplyCPUWithAbsolutePath).IncludeCurrentGoRoutine().Return(nil).Build()
-		err3 := p.checkAndApplySubCgroupPath("path3", d3, nil)
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(subCPU3, nil).Build()
+		mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().Return(nil).Build()
+		err3 := p.checkAndApplySubCgroupPath(context.TODO(), "path3", d3, nil)
 		convey.So(err3, convey.ShouldBeNil)
 	})
 }
+
+func TestDynamicPolicy_applyAllSubCgroupQuotaToUnLimit_guarded(t *testing.T) {
+	t.Parallel()
+
+	cgroupRoot := t.TempDir()
+	containerPath := "/kubepods/burstable/podtest-pod-uid/test-container"
+	assert.NoError(t, os.MkdirAll(filepath.Join(cgroupRoot, common.DefaultSelectedSubsys, containerPath, "sub-1"), 0o755))
+	assert.NoError(t, os.MkdirAll(filepath.Join(cgroupRoot, common.DefaultSelectedSubsys, containerPath, "sub-2"), 0o755))
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test sub cgroups are unlimited through the guarded write path", t, func() {
+		mockey.Mock(common.CheckCgroup2UnifiedMode).IncludeCurrentGoRoutine().Return(false).Build()
+		mockey.Mock((*DynamicPolicy).getCPUWithRelativePath).IncludeCurrentGoRoutine().Return(
+			&common.CPUStats{CpuQuota: 100000, CpuPeriod: 100000}, nil).Build()
+		var applied []string
+		mockey.Mock((*DynamicPolicy).applyCPUWithRelativePath).IncludeCurrentGoRoutine().To(
+			func(_ *DynamicPolicy, relativePath string, _ *common.CPUData) error {
+				applied = append(applied, relativePath)
+				return nil
+			}).Build()
+
+		convey.Convey("the write cap of the round stops the remaining sub cgroups", func() {
+			applied = nil
+			p := newTestDynamicPolicy()
+			p.cgroupRootOverride = cgroupRoot
+			p.cgroupWriteCap = newCgroupWriteCap(1)
+			p.beginReconcileTransaction()
+
+			err := p.applyAllSubCgroupQuotaToUnLimit(context.TODO(), containerPath)
+			convey.So(errors.Is(err, errCgroupWriteCapReached), convey.ShouldBeTrue)
+			convey.So(applied, convey.ShouldResemble, []string{filepath.Join(containerPath, "sub-1")})
+			convey.So(p.cpuCgroupWrites, convey.ShouldEqual, 1)
+			// the prior quota of the written sub cgroup is recorded for the undo
+			convey.So(p.reconcileTransaction.priorStats, convey.ShouldResemble, []cgroupPriorCPUStats{{
+				relativePath: filepath.Join(containerPath, "sub-1"),
+				stats:        common.CPUStats{CpuQuota: 100000, CpuPeriod: 100000},
+			}})
+		})
+
+		convey.Convey("a tripped write breaker stops all sub cgroups", func() {
+			applied = nil
+			p := newTestDynamicPolicy()
+			p.cgroupRootOverride = cgroupRoot
+			p.cgroupWriteBreaker = newCgroupWriteBreaker(1)
+			p.cgroupWriteBreaker.record(fmt.Errorf("test error"))
+
+			err := p.applyAllSubCgroupQuotaToUnLimit(context.TODO(), containerPath)
+			convey.So(errors.Is(err, errCgroupWriteBreakerOpen), convey.ShouldBeTrue)
+			convey.So(applied, convey.ShouldBeEmpty)
+			convey.So(p.cpuCgroupWrites, convey.ShouldEqual, 0)
+		})
+	})
+}
+
+func TestDynamicPolicy_quotaReconcileTracing(t *testing.T) {
+	t.Parallel()
+
+	exporter := tracetest.NewInMemoryExporter()
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	p := &DynamicPolicy{
+		emitter:   metrics.DummyMetrics{},
+		qosConfig: generic.NewQoSConfiguration(),
+		tracer:    tracerProvider.Tracer(quotaReconcileTracerName),
+	}
+
+	livePod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "live-pod",
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{
+				{
+					Name: "test-container",
+					Resources: v1.ResourceRequirements{
+						Limits: v1.ResourceList{
+							v1.ResourceCPU: resource2.MustParse("1"),
+						},
+					},
+				},
+			},
+		},
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test spans are emitted per pod in quota reconcile", t, func() {
+		mockey.Mock(common.CheckCgroup2UnifiedMode).IncludeCurrentGoRoutine().Return(false).Build()
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(&common.CPUStats{CpuQuota: -1, CpuPeriod: 100000}, nil).Build()
+		mockTestPodDirs([]string{"stale-pod-dir", "live-pod-dir"}, map[string]*v1.Pod{"live-pod-dir": livePod})
+		mockey.Mock((*DynamicPolicy).applyAllContainersQuota).IncludeCurrentGoRoutine().Return(nil).Build()
+		mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().Return(nil).Build()
+
+		_, err := p.checkAndApplyIfCgroupV1(&advisorsvc.CalculationInfo{CgroupPath: "test_cgroup_path"},
+			&common.CgroupResources{CpuQuota: 1000000})
+		convey.So(err, convey.ShouldBeNil)
+
+		spans := make(map[string]*sdktrace.SpanSnapshot)
+		var podSpans []*sdktrace.SpanSnapshot
+		for _, span := range exporter.GetSpans() {
+			if span.Name == "checkAndApplyPodQuota" {
+				podSpans = append(podSpans, span)
+				continue
+			}
+			spans[span.Name] = span
+		}
+		convey.So(podSpans, convey.ShouldHaveLength, 2)
+
+		root, ok := spans["checkAndApplyIfCgroupV1"]
+		convey.So(ok, convey.ShouldBeTrue)
+		convey.So(root.Parent.IsValid(), convey.ShouldBeFalse)
+		convey.So(getSpanAttributes(root)["cgroupPath"].AsString(), convey.ShouldEqual, "test_cgroup_path")
+		convey.So(getSpanAttributes(root)["desiredQuota"].AsInt64(), convey.ShouldEqual, 1000000)
+
+		var livePodSpan *sdktrace.SpanSnapshot
+		for _, podSpan := range podSpans {
+			convey.So(podSpan.Parent.SpanID(), convey.ShouldEqual, root.SpanContext.SpanID())
+			attrs := getSpanAttributes(podSpan)
+			if attrs["podDir"].AsString() == "live-pod-dir" {
+				livePodSpan = podSpan
+				continue
+			}
+			convey.So(attrs["skipReason"].AsString(), convey.ShouldEqual, podSkipReasonNotFound)
+		}
+		convey.So(livePodSpan, convey.ShouldNotBeNil)
+		attrs := getSpanAttributes(livePodSpan)
+		convey.So(attrs["pod"].AsString(), convey.ShouldEqual, "live-pod")
+		convey.So(attrs["computedQuota"].AsInt64(), convey.ShouldEqual, 100000)
+		convey.So(attrs["appliedQuota"].AsInt64(), convey.ShouldEqual, 100000)
+
+		applySpan, ok := spans["applyCPUQuotaWithRelativePath"]
+		convey.So(ok, convey.ShouldBeTrue)
+		convey.So(applySpan.Parent.SpanID(), convey.ShouldEqual, livePodSpan.SpanContext.SpanID())
+		convey.So(getSpanAttributes(applySpan)["relativePath"].AsString(), convey.ShouldEqual,
+			filepath.Join("test_cgroup_path", "live-pod-dir"))
+		convey.So(applySpan.StatusCode, convey.ShouldEqual, codes.Unset)
+
+		// failures of cgroup writes are recorded in spans
+		exporter.Reset()
+		mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().Return(fmt.Errorf("test error")).Build()
+		err = p.applyCPUQuotaWithRelativePath(context.TODO(), "test_relative_path", &common.CPUData{CpuQuota: -1})
+		convey.So(errors.Is(err, ErrCgroupWrite), convey.ShouldBeTrue)
+		convey.So(exporter.GetSpans(), convey.ShouldHaveLength, 1)
+		convey.So(exporter.GetSpans()[0].StatusCode, convey.ShouldEqual, codes.Error)
+	})
+}
+
+func getSpanAttributes(span *sdktrace.SpanSnapshot) map[string]attribute.Value {
+	attrs := make(map[string]attribute.Value, len(span.Attributes))
+	for _, kv := range span.Attributes {
+		attrs[string(kv.Key)] = kv.Value
+	}
+	return attrs
+}
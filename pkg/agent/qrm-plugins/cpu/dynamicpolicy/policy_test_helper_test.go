@@ -0,0 +1,159 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/bytedance/mockey"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/events"
+	"k8s.io/utils/clock"
+	testingclock "k8s.io/utils/clock/testing"
+
+	nodev1alpha1 "github.com/kubewharf/katalyst-api/pkg/apis/node/v1alpha1"
+	"github.com/kubewharf/katalyst-core/pkg/config/agent/dynamic/quotareconcile"
+	"github.com/kubewharf/katalyst-core/pkg/config/generic"
+	"github.com/kubewharf/katalyst-core/pkg/metaserver"
+	"github.com/kubewharf/katalyst-core/pkg/metaserver/agent"
+	cnrfetcher "github.com/kubewharf/katalyst-core/pkg/metaserver/agent/cnr"
+	"github.com/kubewharf/katalyst-core/pkg/metaserver/agent/limitrange"
+	"github.com/kubewharf/katalyst-core/pkg/metaserver/agent/metric"
+	"github.com/kubewharf/katalyst-core/pkg/metaserver/agent/pod"
+	"github.com/kubewharf/katalyst-core/pkg/metaserver/agent/types"
+	"github.com/kubewharf/katalyst-core/pkg/metrics"
+)
+
+// testDynamicPolicyOption overrides a fake wired by newTestDynamicPolicy.
+type testDynamicPolicyOption func(p *DynamicPolicy)
+
+// withTestPods makes the pod fetcher of the policy return the given pods.
+func withTestPods(pods ...*v1.Pod) testDynamicPolicyOption {
+	return func(p *DynamicPolicy) {
+		p.metaServer.PodFetcher = &pod.PodFetcherStub{PodList: pods}
+	}
+}
+
+// withTestLimitRanges makes the limit range fetcher of the policy return the given limit ranges.
+func withTestLimitRanges(limitRanges ...*v1.LimitRange) testDynamicPolicyOption {
+	return func(p *DynamicPolicy) {
+		p.metaServer.LimitRangeFetcher = &limitrange.LimitRangeFetcherStub{LimitRanges: limitRanges}
+	}
+}
+
+// withTestCNR makes the cnr fetcher of the policy return the given cnr.
+func withTestCNR(cnr *nodev1alpha1.CustomNodeResource) testDynamicPolicyOption {
+	return func(p *DynamicPolicy) {
+		p.metaServer.CNRFetcher = &cnrfetcher.CNRFetcherStub{CNR: cnr}
+	}
+}
+
+// withTestMetricsFetcher makes the policy read metrics from the given fetcher.
+func withTestMetricsFetcher(metricsFetcher types.MetricsFetcher) testDynamicPolicyOption {
+	return func(p *DynamicPolicy) {
+		p.metaServer.MetricsFetcher = metricsFetcher
+	}
+}
+
+// withTestEmitter makes the policy emit metrics to the given emitter.
+func withTestEmitter(emitter metrics.MetricEmitter) testDynamicPolicyOption {
+	return func(p *DynamicPolicy) {
+		p.emitter = emitter
+	}
+}
+
+// withTestQuotaReconcileConf makes the policy reconcile quota with the given configuration.
+func withTestQuotaReconcileConf(conf *quotareconcile.QuotaReconcileConfiguration) testDynamicPolicyOption {
+	return func(p *DynamicPolicy) {
+		p.quotaReconcileConf = conf
+	}
+}
+
+// withTestClock makes the policy tell time by the given clock.
+func withTestClock(c clock.Clock) testDynamicPolicyOption {
+	return func(p *DynamicPolicy) {
+		p.clock = c
+	}
+}
+
+// withTestEventRecorder makes the policy record events with the given recorder.
+func withTestEventRecorder(recorder events.EventRecorder) testDynamicPolicyOption {
+	return func(p *DynamicPolicy) {
+		p.eventRecorder = recorder
+	}
+}
+
+// newTestDynamicPolicy returns a policy wired with fakes, i.e. an empty pod fetcher, a fake metrics fetcher,
+// a dummy emitter and the default qos configuration, which can be overridden by the given options.
+// Cgroups are accessed through package-level functions, so they are still faked by mockey in tests.
+func newTestDynamicPolicy(opts ...testDynamicPolicyOption) *DynamicPolicy {
+	p := &DynamicPolicy{
+		metaServer: &metaserver.MetaServer{
+			MetaAgent: &agent.MetaAgent{
+				PodFetcher:     &pod.PodFetcherStub{},
+				MetricsFetcher: metric.NewFakeMetricsFetcher(metrics.DummyMetrics{}),
+			},
+		},
+		emitter:   metrics.DummyMetrics{},
+		qosConfig: generic.NewQoSConfiguration(),
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// mockTestPodDirs makes the policy see the given pod dirs under any cgroup path, and resolves each of them
+// to its pod in pods, or to ErrPodNotFound if missing. It must be called inside a mockey.PatchConvey.
+func mockTestPodDirs(podDirs []string, pods map[string]*v1.Pod) {
+	mockey.Mock((*DynamicPolicy).getCurrentPathAllPodsDirAndMap).IncludeCurrentGoRoutine().Return(
+		map[string]*v1.Pod{}, podDirs, nil).Build()
+	mockey.Mock((*DynamicPolicy).getPodAndRelativePath).IncludeCurrentGoRoutine().To(
+		func(_ *DynamicPolicy, cgroupPath string, podDir string, _ map[string]*v1.Pod) (*v1.Pod, string, error) {
+			pod, ok := pods[podDir]
+			if !ok {
+				return nil, "", fmt.Errorf("%w: %s", ErrPodNotFound, podDir)
+			}
+			return pod, filepath.Join(cgroupPath, podDir), nil
+		}).Build()
+}
+
+func TestNewTestDynamicPolicy(t *testing.T) {
+	t.Parallel()
+
+	p := newTestDynamicPolicy()
+	pods, err := p.metaServer.GetPodList(nil, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, pods)
+	assert.NotNil(t, p.getQuotaReconcileConf())
+	assert.NotNil(t, p.getPodQuotaTracker())
+
+	testPod := &v1.Pod{}
+	fakeClock := testingclock.NewFakeClock(p.getClock().Now())
+	conf := &quotareconcile.QuotaReconcileConfiguration{PodDirSearchDepth: 2}
+	p = newTestDynamicPolicy(withTestPods(testPod), withTestClock(fakeClock), withTestQuotaReconcileConf(conf),
+		withTestEmitter(metrics.DummyMetrics{}), withTestMetricsFetcher(metric.NewFakeMetricsFetcher(metrics.DummyMetrics{})))
+	pods, err = p.metaServer.GetPodList(nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []*v1.Pod{testPod}, pods)
+	assert.Equal(t, fakeClock, p.getClock())
+	assert.Equal(t, conf, p.getQuotaReconcileConf())
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"context"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	advisorapi "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/cpuadvisor"
+	"github.com/kubewharf/katalyst-core/pkg/util/cgroup/common"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+)
+
+// startQoSLevelReconcilers starts a reconciler for each qos level with a reconcile interval, which reconciles
+// quota of pods of the qos level with the last applied plan until stopCh is closed.
+func (p *DynamicPolicy) startQoSLevelReconcilers(stopCh <-chan struct{}) {
+	for qosLevel, interval := range p.qosLevelReconcileIntervals {
+		if interval <= 0 {
+			continue
+		}
+
+		general.Infof("start quota reconciler of qos level %s with interval %v", qosLevel, interval)
+		qosLevel := qosLevel
+		go wait.Until(func() { p.reconcileQoSLevel(qosLevel) }, interval, stopCh)
+	}
+}
+
+// reconcileQoSLevel reconciles quota of pods of the qos level under advisor cgroup paths of the last applied plan.
+// The policy mutex is held as the reconcile lock shared with the other reconcile triggers. Cgroup configs of the
+// advisor cgroup paths are left to plans of cpu-advisor, so pods are bounded by the current quota of their groups.
+func (p *DynamicPolicy) reconcileQoSLevel(qosLevel string) {
+	p.Lock()
+	defer p.Unlock()
+
+	if len(p.lastCgroupConfigs) == 0 || common.CheckCgroup2UnifiedMode() || !isQuotaReconcileEnabled() {
+		return
+	}
+
+	startTime := time.Now()
+	p.refreshQuotaReconcileConf()
+	if p.isReconcilePausedForMaintenance(context.Background()) {
+		return
+	}
+	p.beginReconcileTransaction()
+	defer p.endReconcileTransaction()
+
+	p.cgroupWriteBreaker = newCgroupWriteBreaker(p.getQuotaReconcileConf().CgroupWriteFailureThreshold)
+	p.cgroupWriteCap = newCgroupWriteCap(p.getQuotaReconcileConf().MaxCgroupWritesPerRound)
+	p.cpuCgroupWrites = 0
+	defer p.emitCPUCgroupWrites()
+	p.startReconcileBudget()
+
+	for _, calculationInfo := range p.lastCgroupConfigs {
+		if err := p.checkCgroupWritesAllowed(); err != nil {
+			general.Warningf("%v, skip reconciling the remaining pods of qos level %s", err, qosLevel)
+			break
+		}
+
+		if _, ok := calculationInfo.CalculationResult.Values[string(advisorapi.ControlKnobKeyCgroupConfig)]; !ok {
+			if _, ok := calculationInfo.CalculationResult.Values[string(advisorapi.ControlKnobKeyCPUCores)]; !ok {
+				continue
+			}
+		}
+		if p.isPoolCgroupPath(calculationInfo.CgroupPath) || isKubepodsRootCgroupPath(calculationInfo.CgroupPath) ||
+			!general.IsPathExists(p.getAbsCgroupPath(common.DefaultSelectedSubsys, calculationInfo.CgroupPath)) {
+			continue
+		}
+
+		groupCPU, err := p.getCPUWithRelativePath(calculationInfo.CgroupPath)
+		if err != nil {
+			general.Warningf("get quota of %s failed with error: %v, skip reconciling pods of qos level %s under it",
+				calculationInfo.CgroupPath, err, qosLevel)
+			continue
+		}
+
+		_, err = p.checkAndApplyPodsQuotaOfQoSLevel(context.Background(), calculationInfo, groupCPU.CpuQuota, qosLevel)
+		if err != nil {
+			general.Warningf("reconcile pods of qos level %s under %s failed with error: %v",
+				qosLevel, calculationInfo.CgroupPath, err)
+		}
+	}
+
+	general.InfofV(4, "reconciled pods of qos level %s, took %v", qosLevel, time.Since(startTime))
+}
+
+// filterPodDirsOfQoSLevel returns pod dirs under the cgroup path whose pods are of the qos level.
+func (p *DynamicPolicy) filterPodDirsOfQoSLevel(cgroupPath string, podDirs []string, podsPathMap map[string]*v1.Pod,
+	qosLevel string,
+) []string {
+	var filtered []string
+	for _, podDir := range podDirs {
+		pod, _, err := p.getPodAndRelativePath(cgroupPath, podDir, podsPathMap)
+		if err != nil {
+			continue
+		}
+
+		podQoSLevel, err := p.qosConfig.GetQoSLevelForPod(pod)
+		if err != nil {
+			general.Warningf("get qos level for pod %s failed with error: %v", pod.Name, err)
+			continue
+		}
+		if podQoSLevel == qosLevel {
+			filtered = append(filtered, podDir)
+		}
+	}
+	return filtered
+}
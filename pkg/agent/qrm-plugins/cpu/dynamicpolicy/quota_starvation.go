@@ -0,0 +1,90 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/util"
+	"github.com/kubewharf/katalyst-core/pkg/metrics"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+)
+
+// quotaStarvationTracker counts consecutive rounds in which pods are starved, i.e. their quota is held at the
+// floor while they are still throttled heavily, keyed by the relative path of the pod cgroup.
+type quotaStarvationTracker struct {
+	rounds map[string]int
+}
+
+func newQuotaStarvationTracker() *quotaStarvationTracker {
+	return &quotaStarvationTracker{
+		rounds: make(map[string]int),
+	}
+}
+
+// observe records whether the pod is starved in this round, and returns the number of consecutive starved rounds.
+func (t *quotaStarvationTracker) observe(podRelativePath string, starved bool) int {
+	if !starved {
+		delete(t.rounds, podRelativePath)
+		return 0
+	}
+	t.rounds[podRelativePath]++
+	return t.rounds[podRelativePath]
+}
+
+// prune deletes records of pod cgroups under the parent path which are not matched with live pods in the round.
+func (t *quotaStarvationTracker) prune(parentPath string, livePaths map[string]bool) {
+	prefix := filepath.Clean(parentPath) + string(filepath.Separator)
+	for path := range t.rounds {
+		if strings.HasPrefix(path, prefix) && !livePaths[path] {
+			delete(t.rounds, path)
+		}
+	}
+}
+
+// checkPodQuotaStarvation signals the cpu eviction to evict the pod once its quota is held at the floor with the
+// throttle ratio beyond QuotaStarvationThrottleRatio for QuotaStarvationEvictionRounds consecutive rounds, and
+// the signal is cleared as soon as the pod is no longer starved. Rounds in which throttling can't be told, e.g.
+// the first one of the pod, are not counted either way.
+func (p *DynamicPolicy) checkPodQuotaStarvation(pod *v1.Pod, podRelativePath string, atFloor bool,
+	throttleRatio float64, throttleOK bool,
+) {
+	conf := p.getQuotaReconcileConf()
+	if p.quotaStarvationEviction == nil || conf.QuotaStarvationEvictionRounds <= 0 || !throttleOK {
+		return
+	}
+
+	starved := atFloor && throttleRatio > conf.QuotaStarvationThrottleRatio
+	rounds := p.getQuotaStarvationTracker().observe(podRelativePath, starved)
+	if rounds < conf.QuotaStarvationEvictionRounds {
+		p.quotaStarvationEviction.ClearStarvedPod(string(pod.UID))
+		return
+	}
+
+	general.Warningf("pod %s is starved at the quota floor for %d rounds with throttle ratio %.2f, signal eviction",
+		pod.Name, rounds, throttleRatio)
+	p.quotaStarvationEviction.SignalStarvedPod(string(pod.UID), fmt.Sprintf("cpu quota is held at the floor for %d "+
+		"reconcile rounds while %.1f%% of periods are throttled", rounds, throttleRatio*100))
+	_ = p.emitter.StoreInt64(util.MetricNameQuotaStarvedPodSignaled, 1, metrics.MetricTypeNameCount,
+		metrics.ConvertMapToTags(map[string]string{
+			"qosLevel": p.getPodQoSLevelTag(pod),
+		})...)
+}
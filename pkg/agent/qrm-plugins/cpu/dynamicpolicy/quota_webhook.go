@@ -0,0 +1,178 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+)
+
+const (
+	// quotaWebhookQueueSize is the max number of pending events, and events are dropped once it's full
+	quotaWebhookQueueSize = 128
+	// quotaWebhookBreakerThreshold is the number of consecutive events failing to be sent after which the breaker
+	// opens, and events are dropped without being sent until quotaWebhookBreakerCooldown passes
+	quotaWebhookBreakerThreshold = 5
+	quotaWebhookBreakerCooldown  = time.Minute
+	// quotaWebhookRetryInterval is the interval between retries of an event, and it's doubled per retry
+	quotaWebhookRetryInterval = 100 * time.Millisecond
+
+	quotaChangeReasonPodLimit          = "PodLimit"
+	quotaChangeReasonExceedsGroupQuota = "ExceedsGroupQuota"
+)
+
+// quotaChangeEvent is the payload posted to the quota webhook on a significant quota change of a pod,
+// and -1 means unlimited for both of the quotas.
+type quotaChangeEvent struct {
+	Timestamp time.Time `json:"timestamp"`
+	Namespace string    `json:"namespace"`
+	PodName   string    `json:"podName"`
+	PodUID    string    `json:"podUID"`
+	Path      string    `json:"path"`
+	OldQuota  int64     `json:"oldQuota"`
+	NewQuota  int64     `json:"newQuota"`
+	Reason    string    `json:"reason"`
+}
+
+// quotaWebhookSink posts quota change events to an external webhook for audit, events are sent asynchronously
+// from a bounded queue with bounded retries, and a circuit breaker stops sending for a while after consecutive
+// failures, so that a slow or unavailable webhook never blocks reconcile.
+type quotaWebhookSink struct {
+	url        string
+	timeout    time.Duration
+	maxRetries int
+	client     *http.Client
+
+	events chan *quotaChangeEvent
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+
+	// consecutiveFailures and breakerOpenUntil are only accessed by the sending goroutine
+	consecutiveFailures int
+	breakerOpenUntil    time.Time
+}
+
+func newQuotaWebhookSink(url string, timeout time.Duration, maxRetries int) *quotaWebhookSink {
+	s := &quotaWebhookSink{
+		url:        url,
+		timeout:    timeout,
+		maxRetries: maxRetries,
+		client:     &http.Client{Timeout: timeout},
+		events:     make(chan *quotaChangeEvent, quotaWebhookQueueSize),
+		stopCh:     make(chan struct{}),
+	}
+
+	s.wg.Add(1)
+	go s.run()
+	return s
+}
+
+// matches returns whether the sink is created with the given settings.
+func (s *quotaWebhookSink) matches(url string, timeout time.Duration, maxRetries int) bool {
+	return s.url == url && s.timeout == timeout && s.maxRetries == maxRetries
+}
+
+// notify enqueues the event without blocking, and it returns false if the event is dropped since the queue is full.
+func (s *quotaWebhookSink) notify(event *quotaChangeEvent) bool {
+	select {
+	case s.events <- event:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *quotaWebhookSink) run() {
+	defer s.wg.Done()
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case event := <-s.events:
+			s.handle(event)
+		}
+	}
+}
+
+func (s *quotaWebhookSink) handle(event *quotaChangeEvent) {
+	if time.Now().Before(s.breakerOpenUntil) {
+		general.InfofV(4, "quota webhook breaker is open, drop the event of pod %s", event.PodName)
+		return
+	}
+
+	err := s.sendWithRetries(event)
+	if err == nil {
+		s.consecutiveFailures = 0
+		return
+	}
+
+	general.Warningf("send the quota change event of pod %s to webhook failed with error: %v", event.PodName, err)
+	s.consecutiveFailures++
+	if s.consecutiveFailures >= quotaWebhookBreakerThreshold {
+		general.Warningf("quota webhook failed %d times in a row, stop sending events for %v",
+			s.consecutiveFailures, quotaWebhookBreakerCooldown)
+		s.breakerOpenUntil = time.Now().Add(quotaWebhookBreakerCooldown)
+		s.consecutiveFailures = 0
+	}
+}
+
+func (s *quotaWebhookSink) sendWithRetries(event *quotaChangeEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("marshal quota change event failed with error: %v", err)
+	}
+
+	interval := quotaWebhookRetryInterval
+	for attempt := 0; ; attempt++ {
+		err = s.send(body)
+		if err == nil || attempt >= s.maxRetries {
+			return err
+		}
+
+		select {
+		case <-s.stopCh:
+			return err
+		case <-time.After(interval):
+		}
+		interval *= 2
+	}
+}
+
+func (s *quotaWebhookSink) send(body []byte) error {
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// stop stops sending events, and pending ones are dropped.
+func (s *quotaWebhookSink) stop() {
+	close(s.stopCh)
+	s.wg.Wait()
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"sort"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/advisorsvc"
+)
+
+// reconcileBudget bounds the duration of a reconcile round, and records where the round is cut off,
+// so that the next round resumes from there and all pods are reconciled in a round-robin manner
+// even if a round can't cover them within the push interval of cpu-advisor on huge nodes.
+type reconcileBudget struct {
+	// deadline is the end of the budget of the in-progress round, and zero means no budget
+	deadline time.Time
+	// resumeCgroupPath is the advisor cgroup path from which the next round resumes
+	resumeCgroupPath string
+	// resumePodDirs are the pod dirs from which the next round resumes, keyed by advisor cgroup paths
+	resumePodDirs map[string]string
+}
+
+// setResumePodDir records the pod dir from which the next round resumes under the cgroup path.
+func (b *reconcileBudget) setResumePodDir(cgroupPath, podDir string) {
+	if b.resumePodDirs == nil {
+		b.resumePodDirs = make(map[string]string)
+	}
+	b.resumePodDirs[cgroupPath] = podDir
+}
+
+// startReconcileBudget starts the budget of a reconcile round if it's configured.
+func (p *DynamicPolicy) startReconcileBudget() {
+	p.reconcileBudget.deadline = time.Time{}
+	if budget := p.getQuotaReconcileConf().ReconcileBudget; budget > 0 {
+		p.reconcileBudget.deadline = p.getClock().Now().Add(budget)
+	}
+}
+
+// isReconcileBudgetExceeded returns true if the budget of the in-progress round is used up.
+func (p *DynamicPolicy) isReconcileBudgetExceeded() bool {
+	deadline := p.reconcileBudget.deadline
+	return !deadline.IsZero() && !p.getClock().Now().Before(deadline)
+}
+
+// rotateCalculationInfos returns the calculation infos starting from the one of the cgroup path,
+// and they are returned as they are if the cgroup path is not found.
+func rotateCalculationInfos(calculationInfos []*advisorsvc.CalculationInfo, cgroupPath string) []*advisorsvc.CalculationInfo {
+	for i, calculationInfo := range calculationInfos {
+		if calculationInfo.CgroupPath == cgroupPath {
+			return append(append([]*advisorsvc.CalculationInfo{}, calculationInfos[i:]...), calculationInfos[:i]...)
+		}
+	}
+	return calculationInfos
+}
+
+// rotatePodDirs returns the sorted pod dirs starting from the first one not less than the given pod dir,
+// so that the round resumes from the next pod even if the given one is gone in the meanwhile.
+func rotatePodDirs(podDirs []string, podDir string) []string {
+	i := sort.SearchStrings(podDirs, podDir)
+	if podDir == "" || i == 0 || i == len(podDirs) {
+		return podDirs
+	}
+	return append(append([]string{}, podDirs[i:]...), podDirs[:i]...)
+}
+
+// sortPodDirsByPriority sorts pod dirs by priorities of their pods in descending order, so that higher-priority pods
+// are reconciled first and always converge even if the budget of a round is exhausted. Pods of the same priority
+// are kept in the order of their dirs, and pods with no priority or not resolved are regarded as priority zero.
+// The priorities keyed by pod dirs are returned along with the sorted pod dirs.
+func (p *DynamicPolicy) sortPodDirsByPriority(cgroupPath string, podDirs []string, podsPathMap map[string]*v1.Pod,
+) ([]string, map[string]int32) {
+	priorities := make(map[string]int32, len(podDirs))
+	for _, podDir := range podDirs {
+		pod, _, err := p.getPodAndRelativePath(cgroupPath, podDir, podsPathMap)
+		if err == nil && pod.Spec.Priority != nil {
+			priorities[podDir] = *pod.Spec.Priority
+		}
+	}
+
+	sorted := append([]string{}, podDirs...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return priorities[sorted[i]] > priorities[sorted[j]]
+	})
+	return sorted, priorities
+}
+
+// rotatePodDirsInPriorityTier rotates pod dirs sorted by sortPodDirsByPriority within the tier of pods of the same
+// priority as the given pod dir, so that higher-priority pods are still reconciled first while pods of the tier
+// cut off by the budget are reconciled in a round-robin manner; a gone pod dir is regarded as priority zero.
+func rotatePodDirsInPriorityTier(podDirs []string, priorities map[string]int32, podDir string) []string {
+	if podDir == "" {
+		return podDirs
+	}
+
+	priority := priorities[podDir]
+	start := sort.Search(len(podDirs), func(i int) bool { return priorities[podDirs[i]] <= priority })
+	end := sort.Search(len(podDirs), func(i int) bool { return priorities[podDirs[i]] < priority })
+	rotated := append([]string{}, podDirs[:start]...)
+	rotated = append(rotated, rotatePodDirs(podDirs[start:end], podDir)...)
+	return append(rotated, podDirs[end:]...)
+}
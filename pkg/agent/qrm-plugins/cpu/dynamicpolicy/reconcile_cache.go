@@ -0,0 +1,117 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/advisorsvc"
+	"github.com/kubewharf/katalyst-core/pkg/config/agent/dynamic/quotareconcile"
+)
+
+// reconcileCacheEntry is the state of a cgroup path after its last full reconcile, and a later reconcile
+// of an identical calculation info is fast-pathed as long as the state is unchanged.
+type reconcileCacheEntry struct {
+	infoHash      uint64
+	podDirsHash   uint64
+	podQuotasHash uint64
+	podUsageEpoch uint64
+	cpuQuota      int64
+	cpuPeriod     uint64
+	confHash      uint64
+}
+
+// reconcileCache records last-applied calculation infos of cgroup paths, so that identical infos pushed
+// repeatedly by advisor don't walk through all pods under the paths again.
+type reconcileCache struct {
+	entries map[string]*reconcileCacheEntry
+	// invalidated records cgroup paths with drift detected in the in-progress reconcile, which must not be cached
+	invalidated map[string]bool
+}
+
+func newReconcileCache() *reconcileCache {
+	return &reconcileCache{
+		entries:     make(map[string]*reconcileCacheEntry),
+		invalidated: make(map[string]bool),
+	}
+}
+
+// matches returns whether the cached entry of the cgroup path is identical to the given one.
+func (c *reconcileCache) matches(cgroupPath string, entry *reconcileCacheEntry) bool {
+	cached, ok := c.entries[cgroupPath]
+	return ok && *cached == *entry
+}
+
+// record caches the entry of the cgroup path, unless drift is detected in the same reconcile.
+func (c *reconcileCache) record(cgroupPath string, entry *reconcileCacheEntry) {
+	if c.invalidated[cgroupPath] {
+		delete(c.invalidated, cgroupPath)
+		return
+	}
+	c.entries[cgroupPath] = entry
+}
+
+// invalidate drops the cached entry of the cgroup path, and it's not cached in the in-progress reconcile either.
+func (c *reconcileCache) invalidate(cgroupPath string) {
+	delete(c.entries, cgroupPath)
+	c.invalidated[cgroupPath] = true
+}
+
+// hashCalculationInfo hashes the cgroup path and control knob values of the calculation info,
+// values are hashed in the order of their keys since the map order is random.
+func hashCalculationInfo(calculationInfo *advisorsvc.CalculationInfo) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(calculationInfo.CgroupPath))
+
+	values := calculationInfo.GetCalculationResult().GetValues()
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		_, _ = h.Write([]byte{0})
+		_, _ = h.Write([]byte(key))
+		_, _ = h.Write([]byte{0})
+		_, _ = h.Write([]byte(values[key]))
+	}
+	return h.Sum64()
+}
+
+// hashQuotaReconcileConf hashes the value of the quota reconcile configuration, so that configurations are told
+// apart by their values rather than their pointers, e.g. the default one which is created on every get.
+func hashQuotaReconcileConf(conf *quotareconcile.QuotaReconcileConfiguration) uint64 {
+	h := fnv.New64a()
+	// maps are printed in the order of their keys, and the label selector is printed by its string
+	_, _ = fmt.Fprintf(h, "%+v", *conf)
+	return h.Sum64()
+}
+
+// hashPodDirs hashes the pod dirs in order, so that pods added or removed under a cgroup path are detected.
+func hashPodDirs(podDirs []string) uint64 {
+	sorted := append([]string(nil), podDirs...)
+	sort.Strings(sorted)
+
+	h := fnv.New64a()
+	for _, podDir := range sorted {
+		_, _ = h.Write([]byte(podDir))
+		_, _ = h.Write([]byte{0})
+	}
+	return h.Sum64()
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/bytedance/mockey"
+	"github.com/smartystreets/goconvey/convey"
+	v1 "k8s.io/api/core/v1"
+	resource2 "k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/advisorsvc"
+	advisorapi "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/cpuadvisor"
+	"github.com/kubewharf/katalyst-core/pkg/config/agent/dynamic/quotareconcile"
+	"github.com/kubewharf/katalyst-core/pkg/config/generic"
+	"github.com/kubewharf/katalyst-core/pkg/metrics"
+	"github.com/kubewharf/katalyst-core/pkg/util/cgroup/common"
+	cgroupmgr "github.com/kubewharf/katalyst-core/pkg/util/cgroup/manager"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+)
+
+func TestDynamicPolicy_reconcileCache(t *testing.T) {
+	t.Parallel()
+
+	p := &DynamicPolicy{
+		emitter:   metrics.DummyMetrics{},
+		qosConfig: generic.NewQoSConfiguration(),
+	}
+
+	groupPath := "/test_cgroup_path"
+	testPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test-pod",
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{
+				{
+					Name: "test-container",
+					Resources: v1.ResourceRequirements{
+						Limits: v1.ResourceList{
+							v1.ResourceCPU: resource2.MustParse("1"),
+						},
+					},
+				},
+			},
+		},
+	}
+
+	resourcesBytes, _ := json.Marshal(&common.CgroupResources{CpuQuota: 1000000, CpuPeriod: 100000})
+	newResponse := func() *advisorapi.ListAndWatchResponse {
+		return &advisorapi.ListAndWatchResponse{
+			ExtraEntries: []*advisorsvc.CalculationInfo{
+				{
+					CgroupPath: groupPath,
+					CalculationResult: &advisorsvc.CalculationResult{
+						Values: map[string]string{
+							string(advisorapi.ControlKnobKeyCgroupConfig): string(resourcesBytes),
+						},
+					},
+				},
+			},
+		}
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	// the policy runs with the default quota reconcile configuration, which is created on every get
+	mockey.PatchConvey("test identical calculation infos are fast-pathed until drift is detected", t, func() {
+		mockey.Mock(general.IsPathExists).IncludeCurrentGoRoutine().Return(true).Build()
+		mockey.Mock(common.CheckCgroup2UnifiedMode).IncludeCurrentGoRoutine().Return(false).Build()
+		podDirs := []string{"test-pod-dir"}
+		mockey.Mock((*DynamicPolicy).getAllDirs).IncludeCurrentGoRoutine().To(func(_ *DynamicPolicy, _ string) ([]string, error) {
+			return podDirs, nil
+		}).Build()
+		mockey.Mock((*DynamicPolicy).getCurrentPathAllPodsDirAndMap).IncludeCurrentGoRoutine().To(
+			func(_ *DynamicPolicy, _ string) (map[string]*v1.Pod, []string, error) {
+				return map[string]*v1.Pod{}, podDirs, nil
+			}).Build()
+		mockey.Mock((*DynamicPolicy).getPodAndRelativePath).IncludeCurrentGoRoutine().To(
+			func(_ *DynamicPolicy, cgroupPath string, podDir string, _ map[string]*v1.Pod) (*v1.Pod, string, error) {
+				return testPod, filepath.Join(cgroupPath, podDir), nil
+			}).Build()
+		mockey.Mock((*DynamicPolicy).applyAllContainersQuota).IncludeCurrentGoRoutine().Return(nil).Build()
+		cgroupQuotas := map[string]int64{groupPath: 1000000}
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(path string) (*common.CPUStats, error) {
+			quota, ok := cgroupQuotas[path]
+			if !ok {
+				quota = -1
+			}
+			return &common.CPUStats{CpuQuota: quota, CpuPeriod: 100000}, nil
+		}).Build()
+		mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(path string, data *common.CPUData) error {
+			cgroupQuotas[path] = data.CpuQuota
+			return nil
+		}).Build()
+		apply := mockey.Mock(common.ApplyCgroupConfigs).IncludeCurrentGoRoutine().Return(nil).Build()
+
+		err := p.applyCgroupConfigs(newResponse())
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(apply.Times(), convey.ShouldEqual, 1)
+		convey.So(cgroupQuotas[filepath.Join(groupPath, "test-pod-dir")], convey.ShouldEqual, 100000)
+
+		// the identical calculation info is fast-pathed
+		err = p.applyCgroupConfigs(newResponse())
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(apply.Times(), convey.ShouldEqual, 1)
+
+		// the drift of the cgroup invalidates the cache
+		cgroupQuotas[groupPath] = 500000
+		err = p.applyCgroupConfigs(newResponse())
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(apply.Times(), convey.ShouldEqual, 2)
+
+		// so does the drift of a pod under the cgroup, which is corrected
+		cgroupQuotas[filepath.Join(groupPath, "test-pod-dir")] = 50000
+		err = p.applyCgroupConfigs(newResponse())
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(apply.Times(), convey.ShouldEqual, 3)
+		convey.So(cgroupQuotas[filepath.Join(groupPath, "test-pod-dir")], convey.ShouldEqual, 100000)
+
+		// and a new pod under the cgroup
+		podDirs = append(podDirs, "test-pod-dir-2")
+		err = p.applyCgroupConfigs(newResponse())
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(apply.Times(), convey.ShouldEqual, 4)
+		convey.So(cgroupQuotas[filepath.Join(groupPath, "test-pod-dir-2")], convey.ShouldEqual, 100000)
+
+		err = p.applyCgroupConfigs(newResponse())
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(apply.Times(), convey.ShouldEqual, 4)
+		convey.So(p.quotaReconcileConf, convey.ShouldBeNil)
+
+		// an equal configuration set later keeps the cache, while a changed one invalidates it
+		p.quotaReconcileConf = quotareconcile.NewQuotaReconcileConfiguration()
+		err = p.applyCgroupConfigs(newResponse())
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(apply.Times(), convey.ShouldEqual, 4)
+		p.quotaReconcileConf.AdvisorPayloadLogMaxBytes++
+		err = p.applyCgroupConfigs(newResponse())
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(apply.Times(), convey.ShouldEqual, 5)
+	})
+}
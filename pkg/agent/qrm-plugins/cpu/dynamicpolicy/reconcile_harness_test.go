@@ -0,0 +1,182 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/bytedance/mockey"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/advisorsvc"
+	"github.com/kubewharf/katalyst-core/pkg/util/cgroup/common"
+	cgroupmgr "github.com/kubewharf/katalyst-core/pkg/util/cgroup/manager"
+)
+
+// reconcileScenario is a golden scenario of quota reconcile, in which the cgroup configs given by advisor are
+// reconciled to pods under the cgroup path, and the resulting cgroup state is compared with the expected one.
+type reconcileScenario struct {
+	cgroupPath string
+	resources  *common.CgroupResources
+	// pods are the pods under the cgroup path, each in the pod dir "pod<uid>" with its containers in
+	// sub dirs named by their container ids
+	pods []*v1.Pod
+	// cgroupState is the initial cpu stats keyed by relative cgroup paths, and cgroups not in it have
+	// unlimited quota with the default period
+	cgroupState map[string]*common.CPUStats
+	// expectedQuotas are the expected quotas keyed by relative cgroup paths after the reconcile,
+	// which are compared with quotas of all cgroups in the resulting state
+	expectedQuotas map[string]int64
+	// strictHierarchy rejects writes as cgroup v1 does, once the quota of a cgroup exceeds the one of its
+	// parent or falls below the ones of its children in the state
+	strictHierarchy bool
+}
+
+// podRelativePath returns the relative cgroup path of the pod in the scenario.
+func (s *reconcileScenario) podRelativePath(pod *v1.Pod) string {
+	return filepath.Join(s.cgroupPath, fmt.Sprintf("%s%s", common.PodCgroupPathPrefix, pod.UID))
+}
+
+// runReconcileScenario runs checkAndApplyIfCgroupV1 of the policy for the scenario on a fake cgroup state, and
+// returns the resulting quotas keyed by relative cgroup paths. Cgroups and pods are faked by mockey, so it must
+// be called once in a mockey.PatchConvey.
+func runReconcileScenario(p *DynamicPolicy, s reconcileScenario) (map[string]int64, error) {
+	state, err := runReconcileScenarioStats(p, s)
+	quotas := make(map[string]int64, len(state))
+	for path, stats := range state {
+		quotas[path] = stats.CpuQuota
+	}
+	return quotas, err
+}
+
+// runReconcileScenarioStats is the same as runReconcileScenario, except that the resulting cpu stats keyed by
+// relative cgroup paths are returned, e.g. to check periods as well.
+func runReconcileScenarioStats(p *DynamicPolicy, s reconcileScenario) (map[string]*common.CPUStats, error) {
+	state := make(map[string]*common.CPUStats, len(s.cgroupState))
+	for path, stats := range s.cgroupState {
+		statsCopy := *stats
+		state[path] = &statsCopy
+	}
+	mockReconcileScenario(p, s, state)
+
+	_, err := p.checkAndApplyIfCgroupV1(&advisorsvc.CalculationInfo{CgroupPath: s.cgroupPath}, s.resources)
+	return state, err
+}
+
+// mockReconcileScenario fakes cgroups and pods of the scenario by mockey, where cgroups are read from and written
+// to the given cpu stats keyed by relative cgroup paths in place, so that the scenario can be reconciled in several
+// rounds on the same state. It must be called once in a mockey.PatchConvey.
+func mockReconcileScenario(p *DynamicPolicy, s reconcileScenario, state map[string]*common.CPUStats) {
+	getState := func(path string) *common.CPUStats {
+		if _, ok := state[path]; !ok {
+			state[path] = &common.CPUStats{CpuQuota: -1, CpuPeriod: 100000}
+		}
+		return state[path]
+	}
+
+	// absolute paths of pods are resolved in cgroup v1 as well
+	mockey.Mock(common.CheckCgroup2UnifiedMode).IncludeCurrentGoRoutine().Return(false).Build()
+	podsPathMap := make(map[string]*v1.Pod, len(s.pods))
+	podDirs := make([]string, 0, len(s.pods))
+	podsByUID := make(map[string]*v1.Pod, len(s.pods))
+	for _, pod := range s.pods {
+		podsPathMap[p.getAbsCgroupPath(common.DefaultSelectedSubsys, s.podRelativePath(pod))] = pod
+		podDirs = append(podDirs, filepath.Base(s.podRelativePath(pod)))
+		podsByUID[string(pod.UID)] = pod
+	}
+
+	mockey.Mock((*DynamicPolicy).getAllPodsPathMap).IncludeCurrentGoRoutine().Return(podsPathMap, nil).Build()
+	mockey.Mock((*DynamicPolicy).getAllDirs).IncludeCurrentGoRoutine().Return(podDirs, nil).Build()
+	mockey.Mock((*DynamicPolicy).getContainerRelativeCgroupPath).IncludeCurrentGoRoutine().To(
+		func(_ *DynamicPolicy, podUID, containerID string) (string, error) {
+			pod, ok := podsByUID[podUID]
+			if !ok {
+				return "", fmt.Errorf("pod %s not found", podUID)
+			}
+			return filepath.Join(s.podRelativePath(pod), containerID), nil
+		}).Build()
+	// containers in scenarios have no sub cgroups
+	mockey.Mock((*DynamicPolicy).applyAllSubCgroupQuotaToUnLimit).IncludeCurrentGoRoutine().Return(nil).Build()
+	mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(path string) (*common.CPUStats, error) {
+		stats := *getState(path)
+		return &stats, nil
+	}).Build()
+	mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(path string, data *common.CPUData) error {
+		stats := getState(path)
+		written := *stats
+		written.CpuQuota = data.CpuQuota
+		if data.CpuPeriod != 0 {
+			written.CpuPeriod = data.CpuPeriod
+		}
+		if s.strictHierarchy {
+			for otherPath, other := range state {
+				if filepath.Dir(path) == otherPath && exceedsCPUQuota(&written, other) ||
+					filepath.Dir(otherPath) == path && exceedsCPUQuota(other, &written) {
+					return fmt.Errorf("quota %d/%d of %s is out of its hierarchy with %s", written.CpuQuota, written.CpuPeriod, path, otherPath)
+				}
+			}
+		}
+		*stats = written
+		return nil
+	}).Build()
+}
+
+// exceedsCPUQuota returns whether the child allows more cpu than the limited parent, which is rejected by
+// cgroup v1, while an unlimited child is bounded by the parent instead.
+func exceedsCPUQuota(child, parent *common.CPUStats) bool {
+	if parent.CpuQuota <= 0 || child.CpuQuota <= 0 {
+		return false
+	}
+	return child.CpuQuota*int64(parent.CpuPeriod) > parent.CpuQuota*int64(child.CpuPeriod)
+}
+
+// scenarioContainer is a container of a pod in a scenario with its cpu limit.
+type scenarioContainer struct {
+	name     string
+	cpuLimit string
+}
+
+// newScenarioPod returns a running burstable pod with the given containers, whose container ids are "<uid>-<name>".
+func newScenarioPod(uid string, containers ...scenarioContainer) *v1.Pod {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: uid, UID: types.UID(uid)},
+		Status:     v1.PodStatus{Phase: v1.PodRunning},
+	}
+	for _, container := range containers {
+		pod.Spec.Containers = append(pod.Spec.Containers, v1.Container{
+			Name: container.name,
+			Resources: v1.ResourceRequirements{
+				Limits: v1.ResourceList{v1.ResourceCPU: resource.MustParse(container.cpuLimit)},
+			},
+		})
+		pod.Status.ContainerStatuses = append(pod.Status.ContainerStatuses, v1.ContainerStatus{
+			Name:        container.name,
+			ContainerID: "containerd://" + uid + "-" + container.name,
+		})
+	}
+	return pod
+}
+
+// withScenarioCPUOverhead sets the cpu overhead of the runtime sandbox of the scenario pod.
+func withScenarioCPUOverhead(pod *v1.Pod, cpuOverhead string) *v1.Pod {
+	pod.Spec.Overhead = v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpuOverhead)}
+	return pod
+}
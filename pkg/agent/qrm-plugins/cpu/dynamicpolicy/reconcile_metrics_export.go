@@ -0,0 +1,119 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"fmt"
+
+	"go.uber.org/atomic"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/util"
+	"github.com/kubewharf/katalyst-core/pkg/metrics"
+	metricspool "github.com/kubewharf/katalyst-core/pkg/metrics/metrics-pool"
+)
+
+// reconcileMetricsPath is where reconcile metrics are exposed in the Prometheus text format on the agent endpoint,
+// by the Prometheus emitter of the emitter pool of the agent.
+const reconcileMetricsPath metrics.PrometheusMetricPathName = "/metrics/quota-reconcile"
+
+// reconcileExportedMetricNames are metrics of quota reconcile exposed on reconcileMetricsPath.
+var reconcileExportedMetricNames = sets.NewString(
+	util.MetricNameQuotaReconcileDuration,
+	util.MetricNameQuotaReconcileSkippedPods,
+	util.MetricNameQuotaReconcileDriftedPods,
+	util.MetricNameQuotaReconcileBackoffPods,
+	util.MetricNameQuotaReconcilePaused,
+	util.MetricNameQuotaApplyOutcome,
+	util.MetricNameAppliedCPUQuotaMilliCores,
+	util.MetricNameContainerQuotaFloorClamped,
+	util.MetricNameQuotaStarvedPodSignaled,
+	util.MetricNamePodQuotaConvergenceLag,
+	util.MetricNameOrphanedQuotaCleared,
+	util.MetricNameCPUCgroupWrites,
+	util.MetricNameCgroupWriteBreakerOpen,
+	util.MetricNameCgroupWriteTimeout,
+	util.MetricNameCgroupWriteCapReached,
+	util.MetricNameAdvisorPlanStaleness,
+)
+
+// reconcileMetricsExporter wraps the emitter of the policy, it forwards all metrics to the wrapped emitter, and the
+// reconcile ones to the exported emitter as well if the export is enabled, so that the same values are scraped from
+// reconcileMetricsPath. Samples are kept by the exported emitter, which drops the ones no longer stored.
+type reconcileMetricsExporter struct {
+	metrics.MetricEmitter
+
+	exported metrics.MetricEmitter
+	enabled  atomic.Bool
+}
+
+var _ metrics.MetricEmitter = &reconcileMetricsExporter{}
+
+func newReconcileMetricsExporter(emitter, exported metrics.MetricEmitter) *reconcileMetricsExporter {
+	return &reconcileMetricsExporter{
+		MetricEmitter: emitter,
+		exported:      exported,
+	}
+}
+
+// newReconcileMetricsEmitter returns the emitter of the policy, which wraps the given one by a reconcileMetricsExporter
+// only if the export is enabled at startup, since the Prometheus emitter of the pool registers reconcileMetricsPath
+// on the agent endpoint once it's got; the exporter is nil if the export isn't enabled.
+func newReconcileMetricsEmitter(emitterPool metricspool.MetricsEmitterPool, emitter metrics.MetricEmitter,
+	enabled bool,
+) (metrics.MetricEmitter, *reconcileMetricsExporter, error) {
+	if !enabled {
+		return emitter, nil, nil
+	}
+
+	exported, err := emitterPool.GetMetricsEmitter(metricspool.PrometheusMetricOptions{
+		Path: reconcileMetricsPath,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("get reconcile metrics emitter failed with error: %v", err)
+	}
+	exporter := newReconcileMetricsExporter(emitter, exported)
+	exporter.enabled.Store(true)
+	return exporter, exporter, nil
+}
+
+func (e *reconcileMetricsExporter) StoreInt64(key string, val int64, emitType metrics.MetricTypeName, tags ...metrics.MetricTag) error {
+	if e.isExported(key) {
+		_ = e.exported.StoreInt64(key, val, emitType, tags...)
+	}
+	return e.MetricEmitter.StoreInt64(key, val, emitType, tags...)
+}
+
+func (e *reconcileMetricsExporter) StoreFloat64(key string, val float64, emitType metrics.MetricTypeName, tags ...metrics.MetricTag) error {
+	if e.isExported(key) {
+		_ = e.exported.StoreFloat64(key, val, emitType, tags...)
+	}
+	return e.MetricEmitter.StoreFloat64(key, val, emitType, tags...)
+}
+
+func (e *reconcileMetricsExporter) isExported(key string) bool {
+	return e.exported != nil && e.enabled.Load() && reconcileExportedMetricNames.Has(key)
+}
+
+// refreshReconcileMetricsExport enables or disables the export of reconcile metrics according to the latest
+// quota reconcile configuration, and it's a no-op if the export isn't enabled at startup.
+func (p *DynamicPolicy) refreshReconcileMetricsExport() {
+	if p.reconcileMetricsExporter == nil {
+		return
+	}
+	p.reconcileMetricsExporter.enabled.Store(p.getQuotaReconcileConf().EnableMetricsExport)
+}
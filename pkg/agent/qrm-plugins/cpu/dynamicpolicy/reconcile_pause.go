@@ -0,0 +1,85 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+)
+
+const (
+	reconcilePausedReasonAdmin = "admin"
+
+	// reconcilePausePath is where ReconcilePauseHandler is served on the agent endpoint, and it must not be under
+	// the debug path, which skips authentication
+	reconcilePausePath = "/qrm/cpu/quota-reconcile/pause"
+
+	reconcilePauseParamAction  = "action"
+	reconcilePauseActionPause  = "pause"
+	reconcilePauseActionResume = "resume"
+)
+
+// Pause pauses quota reconciles until Resume is called, e.g. to rule out reconciles while debugging a node. The
+// paused heartbeat keeps being emitted in every skipped reconcile, so that monitoring knows it's paused on purpose.
+func (p *DynamicPolicy) Pause() {
+	if !p.reconcilePausedByAdmin.Swap(true) {
+		general.Infof("quota reconcile is paused by admin")
+	}
+}
+
+// Resume resumes quota reconciles paused by Pause, and it's a no-op if reconciles aren't paused.
+func (p *DynamicPolicy) Resume() {
+	if p.reconcilePausedByAdmin.Swap(false) {
+		general.Infof("quota reconcile is resumed by admin")
+	}
+}
+
+// IsReconcilePaused returns whether quota reconciles are paused by Pause.
+func (p *DynamicPolicy) IsReconcilePaused() bool {
+	return p.reconcilePausedByAdmin.Load()
+}
+
+// ReconcilePauseHandler returns the admin endpoint to pause or resume quota reconciles, a POST with the action
+// query param of pause or resume does so, and a GET returns whether reconciles are paused.
+func (p *DynamicPolicy) ReconcilePauseHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			switch action := r.URL.Query().Get(reconcilePauseParamAction); action {
+			case reconcilePauseActionPause:
+				p.Pause()
+			case reconcilePauseActionResume:
+				p.Resume()
+			default:
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = fmt.Fprintf(w, "unknown action %q, it must be %s or %s",
+					action, reconcilePauseActionPause, reconcilePauseActionResume)
+				return
+			}
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			_, _ = fmt.Fprintf(w, "request must be GET or POST")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		_, _ = fmt.Fprintf(w, "paused: %v", p.IsReconcilePaused())
+	}
+}
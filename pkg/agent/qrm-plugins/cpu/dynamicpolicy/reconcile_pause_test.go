@@ -0,0 +1,173 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bytedance/mockey"
+	"github.com/smartystreets/goconvey/convey"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/util/sets"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+
+	katalystbase "github.com/kubewharf/katalyst-core/cmd/base"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/advisorsvc"
+	advisorapi "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/cpuadvisor"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/util"
+	"github.com/kubewharf/katalyst-core/pkg/client"
+	"github.com/kubewharf/katalyst-core/pkg/config/generic"
+	coreconsts "github.com/kubewharf/katalyst-core/pkg/consts"
+	"github.com/kubewharf/katalyst-core/pkg/metrics"
+	"github.com/kubewharf/katalyst-core/pkg/util/cgroup/common"
+	cgroupmgr "github.com/kubewharf/katalyst-core/pkg/util/cgroup/manager"
+	"github.com/kubewharf/katalyst-core/pkg/util/credential"
+	"github.com/kubewharf/katalyst-core/pkg/util/credential/authorization"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+	"github.com/kubewharf/katalyst-core/pkg/util/process"
+)
+
+func TestDynamicPolicy_PauseAndResume(t *testing.T) {
+	t.Parallel()
+
+	p := newTestDynamicPolicy()
+	resourcesBytes, _ := json.Marshal(&common.CgroupResources{CpuPeriod: 100000})
+	resp := &advisorapi.ListAndWatchResponse{
+		ExtraEntries: []*advisorsvc.CalculationInfo{
+			{
+				CgroupPath: "test_cgroup_path",
+				CalculationResult: &advisorsvc.CalculationResult{
+					Values: map[string]string{
+						string(advisorapi.ControlKnobKeyCgroupConfig): string(resourcesBytes),
+					},
+				},
+			},
+		},
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test reconcile is skipped while it's paused by admin", t, func() {
+		mockey.Mock(general.IsPathExists).IncludeCurrentGoRoutine().Return(true).Build()
+		mockey.Mock(common.CheckCgroup2UnifiedMode).IncludeCurrentGoRoutine().Return(true).Build()
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().Return(&common.CPUStats{CpuQuota: -1, CpuPeriod: 100000}, nil).Build()
+		mockey.Mock((*DynamicPolicy).checkAndApplyIfCgroupV1).IncludeCurrentGoRoutine().Return(nil, nil).Build()
+		mockey.Mock((*DynamicPolicy).checkAndApplyCPUBurst).IncludeCurrentGoRoutine().Return(nil).Build()
+		apply := mockey.Mock(common.ApplyCgroupConfigs).IncludeCurrentGoRoutine().Return(nil).Build()
+		var heartbeats []int64
+		mockey.Mock(metrics.DummyMetrics.StoreInt64).IncludeCurrentGoRoutine().To(
+			func(_ metrics.DummyMetrics, key string, val int64, _ metrics.MetricTypeName, _ ...metrics.MetricTag) error {
+				if key == util.MetricNameQuotaReconcilePaused {
+					heartbeats = append(heartbeats, val)
+				}
+				return nil
+			}).Build()
+
+		handler := p.ReconcilePauseHandler()
+		serve := func(method, target string) (int, string) {
+			recorder := httptest.NewRecorder()
+			handler(recorder, httptest.NewRequest(method, target, nil))
+			return recorder.Code, recorder.Body.String()
+		}
+
+		code, body := serve(http.MethodPost, "/?action=pause")
+		convey.So(code, convey.ShouldEqual, http.StatusOK)
+		convey.So(body, convey.ShouldEqual, "paused: true")
+		convey.So(p.IsReconcilePaused(), convey.ShouldBeTrue)
+
+		// nothing is applied while it's paused, and the heartbeat tells it's paused
+		for i := 0; i < 2; i++ {
+			err := p.applyCgroupConfigs(resp)
+			convey.So(err, convey.ShouldBeNil)
+		}
+		convey.So(apply.Times(), convey.ShouldEqual, 0)
+		convey.So(heartbeats, convey.ShouldResemble, []int64{1, 1})
+
+		// unknown actions are rejected without changing the state
+		code, _ = serve(http.MethodPost, "/?action=stop")
+		convey.So(code, convey.ShouldEqual, http.StatusBadRequest)
+		code, body = serve(http.MethodGet, "/")
+		convey.So(code, convey.ShouldEqual, http.StatusOK)
+		convey.So(body, convey.ShouldEqual, "paused: true")
+
+		// and reconciles go back to normal once it's resumed
+		code, body = serve(http.MethodPost, "/?action=resume")
+		convey.So(code, convey.ShouldEqual, http.StatusOK)
+		convey.So(body, convey.ShouldEqual, "paused: false")
+		err := p.applyCgroupConfigs(resp)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(apply.Times(), convey.ShouldEqual, 1)
+
+		p.Pause()
+		p.Resume()
+		err = p.applyCgroupConfigs(resp)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(apply.Times(), convey.ShouldEqual, 2)
+	})
+}
+
+func TestDynamicPolicy_ReconcilePauseHandler_genericEndpoint(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name              string
+		accessControlType string
+		wantCode          int
+		wantPaused        bool
+	}{
+		{
+			name:              "requests permitted by access control pause reconciles",
+			accessControlType: authorization.AccessControlTypeInsecure,
+			wantCode:          http.StatusOK,
+			wantPaused:        true,
+		},
+		{
+			name:              "requests without permission are rejected by strict authentication",
+			accessControlType: authorization.AccessControlTypeStatic,
+			wantCode:          http.StatusUnauthorized,
+			wantPaused:        false,
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			genericConf := generic.NewGenericConfiguration()
+			genericConf.GenericEndpointHandleChains = []string{process.HTTPChainCredential}
+			genericConf.AuthType = credential.AuthTypeInsecure
+			genericConf.AccessControlType = tc.accessControlType
+			genericConf.HttpStrictAuthentication = true
+			baseCtx, err := katalystbase.NewGenericContext(&client.GenericClientSet{KubeClient: kubefake.NewSimpleClientset()},
+				"", nil, sets.NewString(), genericConf, coreconsts.KatalystComponentAgent, nil)
+			assert.NoError(t, err)
+
+			p := newTestDynamicPolicy()
+			baseCtx.HandleFunc(reconcilePausePath, p.ReconcilePauseHandler())
+			server := httptest.NewServer(baseCtx.Server.Handler)
+			defer server.Close()
+
+			resp, err := http.Post(server.URL+reconcilePausePath+"?action=pause", "", nil)
+			assert.NoError(t, err)
+			_ = resp.Body.Close()
+			assert.Equal(t, tc.wantCode, resp.StatusCode)
+			assert.Equal(t, tc.wantPaused, p.IsReconcilePaused())
+		})
+	}
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"fmt"
+
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/advisorsvc"
+	"github.com/kubewharf/katalyst-core/pkg/metrics"
+	"github.com/kubewharf/katalyst-core/pkg/util/cgroup/common"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+)
+
+// SimulatedApply is a quota write that a simulated reconcile would make to a cgroup.
+type SimulatedApply struct {
+	Path         string
+	CurrentQuota int64
+	DesiredQuota int64
+	// Delta is DesiredQuota minus CurrentQuota, and it's 0 if either of them is unlimited
+	Delta int64
+}
+
+// reconcileSimulation records the quota writes of a simulated reconcile instead of making them.
+type reconcileSimulation struct {
+	applies []SimulatedApply
+}
+
+func (s *reconcileSimulation) record(path string, currentQuota, desiredQuota int64) {
+	var delta int64
+	if currentQuota >= 0 && desiredQuota >= 0 {
+		delta = desiredQuota - currentQuota
+	}
+	s.applies = append(s.applies, SimulatedApply{
+		Path:         path,
+		CurrentQuota: currentQuota,
+		DesiredQuota: desiredQuota,
+		Delta:        delta,
+	})
+}
+
+// recordWithRelativePath records the quota of cpu data to be applied to the relative cgroup path
+// along with the current one read back from it by getCPU, and writes of other cpu data are left out.
+func (s *reconcileSimulation) recordWithRelativePath(relativePath string, data *common.CPUData,
+	getCPU func(string) (*common.CPUStats, error),
+) error {
+	if data.CpuQuota == 0 {
+		return nil
+	}
+
+	cpuStats, err := getCPU(relativePath)
+	if err != nil {
+		return fmt.Errorf("%w: get cpu stats of %s failed with error: %v", ErrCgroupRead, relativePath, err)
+	}
+	s.record(relativePath, cpuStats.CpuQuota, data.CpuQuota)
+	return nil
+}
+
+// newSimulationPolicy returns a policy sharing the configurations and the read-back sources of p,
+// while trackers, caches, events, eviction signals and sinks of decisions are left out, so that a
+// simulation on it never changes the state of p, and it's derived with no history of previous rounds.
+func (p *DynamicPolicy) newSimulationPolicy() *DynamicPolicy {
+	quotaReconcileConf := *p.getQuotaReconcileConf()
+	quotaReconcileConf.WebhookURL = ""
+	quotaReconcileConf.DecisionLogFile = ""
+
+	return &DynamicPolicy{
+		name:                          p.name,
+		emitter:                       metrics.DummyMetrics{},
+		metaServer:                    p.metaServer,
+		machineInfo:                   p.machineInfo,
+		state:                         p.state,
+		reservedCPUs:                  p.reservedCPUs,
+		reclaimRelativeRootCgroupPath: p.reclaimRelativeRootCgroupPath,
+		numaBindingReclaimRelativeRootCgroupPaths: p.numaBindingReclaimRelativeRootCgroupPaths,
+		qosConfig:                  p.qosConfig,
+		dynamicConfig:              p.dynamicConfig,
+		conf:                       p.conf,
+		quotaReconcileConf:         &quotaReconcileConf,
+		cgroupRootOverride:         p.cgroupRootOverride,
+		annotationQuotaFallback:    p.annotationQuotaFallback,
+		qosLevelReconcileIntervals: p.qosLevelReconcileIntervals,
+		clock:                      p.clock,
+		tracer:                     p.tracer,
+		simulation:                 &reconcileSimulation{},
+	}
+}
+
+// SimulateReconcile runs the full derivation of quota reconcile of the calculation info against the current
+// read-back state of cgroups, but it never writes any cgroup, and the would-be quota writes are returned in
+// SimulatedApplies of the result. It's meant for what-if checks of a plan before it's pushed for real.
+func (p *DynamicPolicy) SimulateReconcile(plan *advisorsvc.CalculationInfo) (*ReconcileResult, error) {
+	if plan == nil || plan.CalculationResult == nil {
+		return nil, fmt.Errorf("empty plan to simulate")
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	cgroupPath, err := p.normalizeCgroupPath(plan.CgroupPath)
+	if err != nil {
+		return nil, err
+	}
+	calculationInfo := &advisorsvc.CalculationInfo{
+		CgroupPath:        cgroupPath,
+		CalculationResult: plan.CalculationResult,
+	}
+	if !general.IsPathExists(p.getAbsCgroupPath(common.DefaultSelectedSubsys, cgroupPath)) {
+		return nil, fmt.Errorf("%w: cgroup path %s not exist", ErrPathResolve, cgroupPath)
+	}
+
+	sim := p.newSimulationPolicy()
+	resources, ok, err := sim.getCalculationInfoCgroupResources(calculationInfo)
+	if err != nil {
+		return nil, err
+	} else if !ok {
+		return &ReconcileResult{}, nil
+	}
+
+	result := &ReconcileResult{}
+	if sim.isPoolCgroupPath(cgroupPath) {
+		err = sim.applyPoolQuota(cgroupPath, resources)
+	} else if isKubepodsRootCgroupPath(cgroupPath) {
+		err = sim.applyKubepodsRootQuota(cgroupPath, resources)
+	} else {
+		result, err = sim.checkAndApplyIfCgroupV1(calculationInfo, resources)
+	}
+	if err != nil {
+		return result, fmt.Errorf("simulate reconcile of %s failed with error: %w", cgroupPath, err)
+	}
+
+	// the quota left in resources is the one of the cgroup itself, which is applied after its pods
+	if err = sim.simulation.recordWithRelativePath(cgroupPath, &common.CPUData{CpuQuota: resources.CpuQuota}, sim.getCPUWithRelativePath); err != nil {
+		return result, err
+	}
+	result.SimulatedApplies = sim.simulation.applies
+	return result, nil
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/bytedance/mockey"
+	"github.com/smartystreets/goconvey/convey"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/advisorsvc"
+	advisorapi "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/cpuadvisor"
+	"github.com/kubewharf/katalyst-core/pkg/util/cgroup/common"
+	cgroupmgr "github.com/kubewharf/katalyst-core/pkg/util/cgroup/manager"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+)
+
+func TestDynamicPolicy_SimulateReconcile(t *testing.T) {
+	t.Parallel()
+
+	p := newTestDynamicPolicy()
+	groupPath := "/kubepods/offline"
+	podPath := groupPath + "/poduid-1"
+	pod := newScenarioPod("uid-1",
+		scenarioContainer{name: "app", cpuLimit: "1"},
+		scenarioContainer{name: "logger", cpuLimit: "500m"})
+	resourcesBytes, _ := json.Marshal(&common.CgroupResources{CpuQuota: 1000000, CpuPeriod: 100000})
+	plan := &advisorsvc.CalculationInfo{
+		CgroupPath: groupPath,
+		CalculationResult: &advisorsvc.CalculationResult{
+			Values: map[string]string{
+				string(advisorapi.ControlKnobKeyCgroupConfig): string(resourcesBytes),
+			},
+		},
+	}
+	cgroupState := map[string]*common.CPUStats{
+		groupPath:                 {CpuQuota: 800000, CpuPeriod: 100000},
+		podPath:                   {CpuQuota: 200000, CpuPeriod: 100000},
+		podPath + "/uid-1-app":    {CpuQuota: 300000, CpuPeriod: 100000},
+		podPath + "/uid-1-logger": {CpuQuota: 50000, CpuPeriod: 100000},
+	}
+
+	var (
+		result *ReconcileResult
+		err    error
+	)
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test simulated reconcile computes deltas without writing any cgroup", t, func() {
+		mockey.Mock(general.IsPathExists).IncludeCurrentGoRoutine().Return(true).Build()
+		mockey.Mock(common.CheckCgroup2UnifiedMode).IncludeCurrentGoRoutine().Return(false).Build()
+		mockey.Mock((*DynamicPolicy).getAllPodsPathMap).IncludeCurrentGoRoutine().Return(map[string]*v1.Pod{
+			p.getAbsCgroupPath(common.DefaultSelectedSubsys, podPath): pod,
+		}, nil).Build()
+		mockey.Mock((*DynamicPolicy).getAllDirs).IncludeCurrentGoRoutine().Return([]string{"poduid-1"}, nil).Build()
+		mockey.Mock((*DynamicPolicy).getContainerRelativeCgroupPath).IncludeCurrentGoRoutine().To(
+			func(_ *DynamicPolicy, _, containerID string) (string, error) {
+				return filepath.Join(podPath, containerID), nil
+			}).Build()
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(path string) (*common.CPUStats, error) {
+			stats, ok := cgroupState[path]
+			if !ok {
+				return nil, fmt.Errorf("cgroup %s not found", path)
+			}
+			statsCopy := *stats
+			return &statsCopy, nil
+		}).Build()
+		applyRelative := mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().Return(nil).Build()
+		applyAbsolute := mockey.Mock(cgroupmgr.ApplyCPUWithAbsolutePath).IncludeCurrentGoRoutine().Return(nil).Build()
+		applyConfigs := mockey.Mock(common.ApplyCgroupConfigs).IncludeCurrentGoRoutine().Return(nil).Build()
+
+		result, err = p.SimulateReconcile(plan)
+		convey.So(applyRelative.Times(), convey.ShouldEqual, 0)
+		convey.So(applyAbsolute.Times(), convey.ShouldEqual, 0)
+		convey.So(applyConfigs.Times(), convey.ShouldEqual, 0)
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), result.Applied)
+	// the logger is already at its limit, and the group is scaled up after its pods
+	assert.ElementsMatch(t, []SimulatedApply{
+		{Path: podPath + "/uid-1-app", CurrentQuota: 300000, DesiredQuota: 100000, Delta: -200000},
+		{Path: podPath, CurrentQuota: 200000, DesiredQuota: 150000, Delta: -50000},
+		{Path: groupPath, CurrentQuota: 800000, DesiredQuota: 1000000, Delta: 200000},
+	}, result.SimulatedApplies)
+	// trackers of the policy are untouched by the simulation
+	_, tracked := p.getPodQuotaTracker().get(podPath)
+	assert.False(t, tracked)
+}
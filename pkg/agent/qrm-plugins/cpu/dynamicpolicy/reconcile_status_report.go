@@ -0,0 +1,162 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	nodev1alpha1 "github.com/kubewharf/katalyst-api/pkg/apis/node/v1alpha1"
+	katalystutil "github.com/kubewharf/katalyst-core/pkg/util"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+)
+
+const (
+	// cnrConditionTypeQuotaReconcileHealthy is the condition of the CNR of the node reporting the reconcile status
+	cnrConditionTypeQuotaReconcileHealthy nodev1alpha1.CNRConditionType = "QuotaReconcileHealthy"
+
+	reconcileStatusReasonSucceeded = "ReconcileSucceeded"
+	reconcileStatusReasonFailed    = "ReconcileFailed"
+
+	// reconcileStatusTopErrorMaxLen is the max length of the top error in the reported status, beyond which it's truncated
+	reconcileStatusTopErrorMaxLen = 256
+	reconcileStatusReportTimeout  = 5 * time.Second
+)
+
+// reconcileStatus summarizes the in-progress round for the status reported to the CNR of the node.
+type reconcileStatus struct {
+	processed int64
+	failed    int64
+	// errorCounts counts errors of pods by their messages, from which the top error is told
+	errorCounts map[string]int
+}
+
+// record accumulates the result of reconciling pods under a cgroup path into the status.
+func (s *reconcileStatus) record(result *ReconcileResult) {
+	if result == nil {
+		return
+	}
+	s.processed += result.Processed
+	s.failed += result.Failed
+	for _, err := range result.PodErrors {
+		if err != nil {
+			s.errorCounts[err.Error()]++
+		}
+	}
+}
+
+// topError returns the error failing the round if any, or else the most frequent error of pods.
+func (s *reconcileStatus) topError(roundErr error) string {
+	if roundErr != nil {
+		return roundErr.Error()
+	}
+
+	messages := make([]string, 0, len(s.errorCounts))
+	for message := range s.errorCounts {
+		messages = append(messages, message)
+	}
+	// ties are broken by messages, so that the reported error doesn't flap between rounds
+	sort.Slice(messages, func(i, j int) bool {
+		if s.errorCounts[messages[i]] != s.errorCounts[messages[j]] {
+			return s.errorCounts[messages[i]] > s.errorCounts[messages[j]]
+		}
+		return messages[i] < messages[j]
+	})
+	if len(messages) == 0 {
+		return ""
+	}
+	return messages[0]
+}
+
+// beginReconcileStatus starts summarizing the in-progress round, and it's a no-op if the status isn't reported.
+func (p *DynamicPolicy) beginReconcileStatus() {
+	p.reconcileStatus = nil
+	if p.getQuotaReconcileConf().StatusReportInterval <= 0 {
+		return
+	}
+	p.reconcileStatus = &reconcileStatus{errorCounts: make(map[string]int)}
+}
+
+// recordReconcileStatus records the result of reconciling pods under a cgroup path in the in-progress round.
+func (p *DynamicPolicy) recordReconcileStatus(result *ReconcileResult) {
+	if p.reconcileStatus == nil {
+		return
+	}
+	p.reconcileStatus.record(result)
+}
+
+// reportReconcileStatus writes the status of the finished round to a condition of the CNR of the node.
+// Writes are rate-limited by StatusReportInterval to avoid churning the API server, and failures are only
+// logged since the status is merely for visibility. The round is reported under the lock of the policy, so
+// the status is snapshotted here and written in the background, and the round is skipped if the write of a
+// previous one is still in flight.
+func (p *DynamicPolicy) reportReconcileStatus(roundErr error) {
+	status := p.reconcileStatus
+	p.reconcileStatus = nil
+	if status == nil || p.cnrControl == nil || p.metaServer == nil || p.metaServer.MetaAgent == nil ||
+		p.metaServer.CNRFetcher == nil {
+		return
+	}
+
+	now := p.getClock().Now()
+	if !p.lastReconcileStatusReportTime.IsZero() &&
+		now.Sub(p.lastReconcileStatusReportTime) < p.getQuotaReconcileConf().StatusReportInterval {
+		return
+	}
+	if !p.reconcileStatusReporting.CAS(false, true) {
+		general.InfofV(4, "reconcile status of the previous round is still being reported, skip this round")
+		return
+	}
+	p.lastReconcileStatusReportTime = now
+
+	conditionStatus, reason := v1.ConditionTrue, reconcileStatusReasonSucceeded
+	if roundErr != nil || status.failed > 0 {
+		conditionStatus, reason = v1.ConditionFalse, reconcileStatusReasonFailed
+	}
+	topError := status.topError(roundErr)
+	if len(topError) > reconcileStatusTopErrorMaxLen {
+		topError = topError[:reconcileStatusTopErrorMaxLen]
+	}
+	message := fmt.Sprintf("lastReconcileTime=%s, processed=%d, failed=%d, topError=%q",
+		now.UTC().Format(time.RFC3339), status.processed, status.failed, topError)
+
+	p.reconcileStatusReportWG.Add(1)
+	go func() {
+		defer p.reconcileStatusReportWG.Done()
+		defer p.reconcileStatusReporting.Store(false)
+
+		ctx, cancel := context.WithTimeout(context.Background(), reconcileStatusReportTimeout)
+		defer cancel()
+
+		cnr, err := p.metaServer.GetCNR(ctx)
+		if err != nil || cnr == nil {
+			general.Warningf("get cnr failed with error: %v, skip reporting reconcile status", err)
+			return
+		}
+
+		newCNR := cnr.DeepCopy()
+		katalystutil.SetCNRCondition(newCNR, cnrConditionTypeQuotaReconcileHealthy, conditionStatus, reason, message, metav1.NewTime(now))
+		if _, err := p.cnrControl.PatchCNRStatus(ctx, cnr.Name, cnr, newCNR); err != nil {
+			general.Warningf("patch reconcile status to cnr %s failed with error: %v", cnr.Name, err)
+		}
+	}()
+}
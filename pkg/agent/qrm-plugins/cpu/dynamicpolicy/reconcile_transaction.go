@@ -0,0 +1,65 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"github.com/kubewharf/katalyst-core/pkg/util/cgroup/common"
+)
+
+// cgroupPriorCPUStats is the cpu stats of a cgroup before it's changed in a reconcile.
+type cgroupPriorCPUStats struct {
+	relativePath string
+	stats        common.CPUStats
+}
+
+// reconcileTransaction records the prior cpu stats of all cgroups whose cpu stats are changed in a reconcile,
+// so that cpu quotas of the reconcile can be undone by reapplying them; other knobs aren't recorded.
+type reconcileTransaction struct {
+	// priorStats are in the order of the first change of each cgroup in the reconcile
+	priorStats []cgroupPriorCPUStats
+	captured   map[string]bool
+}
+
+func newReconcileTransaction() *reconcileTransaction {
+	return &reconcileTransaction{
+		captured: make(map[string]bool),
+	}
+}
+
+// capture records the cpu stats read by getStats as the prior ones of the cgroup,
+// and only the stats before the first change of the cgroup in the reconcile are kept.
+func (t *reconcileTransaction) capture(relativePath string, getStats func(string) (*common.CPUStats, error)) error {
+	if t.captured[relativePath] {
+		return nil
+	}
+
+	stats, err := getStats(relativePath)
+	if err != nil {
+		return err
+	}
+
+	t.priorStats = append(t.priorStats, cgroupPriorCPUStats{
+		relativePath: relativePath,
+		stats:        *stats,
+	})
+	t.captured[relativePath] = true
+	return nil
+}
+
+func (t *reconcileTransaction) isEmpty() bool {
+	return len(t.priorStats) == 0
+}
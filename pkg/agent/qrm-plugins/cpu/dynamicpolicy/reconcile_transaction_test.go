@@ -0,0 +1,157 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/bytedance/mockey"
+	"github.com/smartystreets/goconvey/convey"
+	v1 "k8s.io/api/core/v1"
+	resource2 "k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/advisorsvc"
+	advisorapi "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/cpuadvisor"
+	"github.com/kubewharf/katalyst-core/pkg/config/generic"
+	"github.com/kubewharf/katalyst-core/pkg/metrics"
+	"github.com/kubewharf/katalyst-core/pkg/util/cgroup/common"
+	cgroupmgr "github.com/kubewharf/katalyst-core/pkg/util/cgroup/manager"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+	"github.com/kubewharf/katalyst-core/pkg/util/machine"
+)
+
+func TestDynamicPolicy_UndoLastCPUQuotaReconcile(t *testing.T) {
+	t.Parallel()
+
+	p := &DynamicPolicy{
+		emitter:   metrics.DummyMetrics{},
+		qosConfig: generic.NewQoSConfiguration(),
+	}
+
+	testPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test-pod",
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{
+				{
+					Name: "test-container",
+					Resources: v1.ResourceRequirements{
+						Limits: v1.ResourceList{
+							v1.ResourceCPU: resource2.MustParse("1"),
+						},
+					},
+				},
+			},
+		},
+	}
+	groupPath := "/test_cgroup_path"
+	podPath := filepath.Join(groupPath, "test-pod-dir")
+	containerPath := filepath.Join(podPath, "test-container")
+
+	resourcesBytes, _ := json.Marshal(&common.CgroupResources{CpuQuota: 400000, CpuPeriod: 100000})
+	resp := &advisorapi.ListAndWatchResponse{
+		ExtraEntries: []*advisorsvc.CalculationInfo{
+			{
+				CgroupPath: groupPath,
+				CalculationResult: &advisorsvc.CalculationResult{
+					Values: map[string]string{
+						string(advisorapi.ControlKnobKeyCgroupConfig): string(resourcesBytes),
+						string(advisorapi.ControlKnobKeyCPUSetMems):   "1",
+					},
+				},
+			},
+		},
+	}
+
+	advisorTestMutex.Lock()
+	defer advisorTestMutex.Unlock()
+	mockey.PatchConvey("test cpu quotas of the last reconcile are undone", t, func() {
+		originalStats := map[string]common.CPUStats{
+			groupPath:     {CpuQuota: -1, CpuPeriod: 100000},
+			podPath:       {CpuQuota: -1, CpuPeriod: 100000},
+			containerPath: {CpuQuota: -1, CpuPeriod: 100000},
+		}
+		cgroupStats := map[string]common.CPUStats{}
+		for path, stats := range originalStats {
+			cgroupStats[path] = stats
+		}
+		writeStats := func(path string, quota int64, period uint64) {
+			stats := cgroupStats[path]
+			if quota != 0 {
+				stats.CpuQuota = quota
+			}
+			if period != 0 {
+				stats.CpuPeriod = period
+			}
+			cgroupStats[path] = stats
+		}
+
+		mockey.Mock(general.IsPathExists).IncludeCurrentGoRoutine().Return(true).Build()
+		mockey.Mock(common.CheckCgroup2UnifiedMode).IncludeCurrentGoRoutine().Return(false).Build()
+		mockey.Mock((*DynamicPolicy).getCurrentPathAllPodsDirAndMap).IncludeCurrentGoRoutine().Return(
+			map[string]*v1.Pod{}, []string{"test-pod-dir"}, nil).Build()
+		mockey.Mock((*DynamicPolicy).getPodAndRelativePath).IncludeCurrentGoRoutine().Return(testPod, podPath, nil).Build()
+		mockey.Mock((*DynamicPolicy).getAllContainersRelativePathMap).IncludeCurrentGoRoutine().Return(
+			map[string]*v1.Container{containerPath: &testPod.Spec.Containers[0]}).Build()
+		mockey.Mock(cgroupmgr.GetCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(path string) (*common.CPUStats, error) {
+			stats := cgroupStats[path]
+			return &stats, nil
+		}).Build()
+		mockey.Mock(cgroupmgr.ApplyCPUWithRelativePath).IncludeCurrentGoRoutine().To(func(path string, data *common.CPUData) error {
+			writeStats(path, data.CpuQuota, data.CpuPeriod)
+			return nil
+		}).Build()
+		mockey.Mock(common.ApplyCgroupConfigs).IncludeCurrentGoRoutine().To(func(path string, resources *common.CgroupResources) error {
+			writeStats(path, resources.CpuQuota, resources.CpuPeriod)
+			return nil
+		}).Build()
+		cgroupMems := map[string]string{groupPath: "0-1"}
+		mems := machine.NewCPUSet(1)
+		mockey.Mock((*DynamicPolicy).parseCPUSetMems).IncludeCurrentGoRoutine().Return(&mems, nil).Build()
+		mockey.Mock(cgroupmgr.GetCPUSetWithRelativePath).IncludeCurrentGoRoutine().To(func(path string) (*common.CPUSetStats, error) {
+			return &common.CPUSetStats{Mems: cgroupMems[path]}, nil
+		}).Build()
+		mockey.Mock(cgroupmgr.ApplyCPUSetWithRelativePath).IncludeCurrentGoRoutine().To(func(path string, data *common.CPUSetData) error {
+			cgroupMems[path] = data.Mems
+			return nil
+		}).Build()
+
+		// nothing to undo before any reconcile
+		convey.So(p.UndoLastCPUQuotaReconcile(), convey.ShouldNotBeNil)
+
+		err := p.applyCgroupConfigs(resp)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(cgroupStats[groupPath].CpuQuota, convey.ShouldEqual, 400000)
+		convey.So(cgroupStats[podPath].CpuQuota, convey.ShouldEqual, 100000)
+		convey.So(cgroupStats[containerPath].CpuQuota, convey.ShouldEqual, 100000)
+
+		convey.So(cgroupMems[groupPath], convey.ShouldEqual, "1")
+
+		err = p.UndoLastCPUQuotaReconcile()
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(cgroupStats, convey.ShouldResemble, originalStats)
+		// other knobs aren't recorded in the transaction, so they are left as applied
+		convey.So(cgroupMems[groupPath], convey.ShouldEqual, "1")
+
+		// the reconcile can only be undone once
+		convey.So(p.UndoLastCPUQuotaReconcile(), convey.ShouldNotBeNil)
+	})
+}
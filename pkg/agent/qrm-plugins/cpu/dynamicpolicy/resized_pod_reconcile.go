@@ -0,0 +1,144 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/advisorsvc"
+	"github.com/kubewharf/katalyst-core/pkg/util/cgroup/common"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+)
+
+// markPodResized records the pod as resized in place, e.g. by vertical pod autoscaler, so that its quota is
+// reconciled with its new resources by the next check of resized pods instead of waiting for the next plan.
+// The reconcile cache is dropped as well, since the fast path of unchanged plans never reads pods again.
+// It must be called with the policy mutex held.
+func (p *DynamicPolicy) markPodResized(podUID string) {
+	if p.resizedPodUIDs == nil {
+		p.resizedPodUIDs = make(map[string]bool)
+	}
+	p.resizedPodUIDs[podUID] = true
+	p.reconcileCache = nil
+	// the resize is allocated by kubelet, so the spec of the pod is recorded as allocated in the next reconcile
+	p.getAllocatedPodResourcesTracker().remove(podUID)
+}
+
+// reconcileResizedPods reconciles quota of pods resized since the last check through the normal pod pipeline,
+// with the current resources of the pods, under advisor cgroup paths of the last applied plan. The pods are
+// bounded by the current quota of their groups, which are left to plans of cpu-advisor.
+func (p *DynamicPolicy) reconcileResizedPods() {
+	p.Lock()
+	defer p.Unlock()
+
+	if len(p.resizedPodUIDs) == 0 || len(p.lastCgroupConfigs) == 0 || common.CheckCgroup2UnifiedMode() || !isQuotaReconcileEnabled() {
+		return
+	}
+	resizedPodUIDs := p.resizedPodUIDs
+	p.resizedPodUIDs = nil
+
+	p.refreshQuotaReconcileConf()
+	if p.isReconcilePausedForMaintenance(context.Background()) {
+		return
+	}
+
+	podsPathMap, err := p.getAllPodsPathMap()
+	if err != nil {
+		general.Errorf("getAllPodsPathMap for resized pods failed with error: %v", err)
+		return
+	}
+
+	startTime := time.Now()
+	p.beginReconcileTransaction()
+	defer p.endReconcileTransaction()
+	p.cgroupWriteBreaker = newCgroupWriteBreaker(p.getQuotaReconcileConf().CgroupWriteFailureThreshold)
+	p.cgroupWriteCap = newCgroupWriteCap(p.getQuotaReconcileConf().MaxCgroupWritesPerRound)
+	p.cpuCgroupWrites = 0
+	defer p.emitCPUCgroupWrites()
+
+	podAbsPaths := make([]string, 0, len(resizedPodUIDs))
+	for podAbsPath, pod := range podsPathMap {
+		if resizedPodUIDs[string(pod.UID)] {
+			podAbsPaths = append(podAbsPaths, podAbsPath)
+		}
+	}
+	sort.Strings(podAbsPaths)
+
+	round := &podQuotaRound{
+		appliedQuotaByQoSLevel: make(map[string]int64),
+		skippedPodsByReason:    make(map[string]int64),
+		livePodPaths:           make(map[string]bool),
+		podErrors:              make(map[string]error),
+	}
+	rootPath := p.getAbsCgroupPath(common.DefaultSelectedSubsys, "/")
+	for _, podAbsPath := range podAbsPaths {
+		if err := p.checkCgroupWritesAllowed(); err != nil {
+			general.Warningf("%v, skip reconciling the remaining resized pods", err)
+			break
+		}
+
+		relativePath, err := filepath.Rel(rootPath, podAbsPath)
+		if err != nil {
+			general.Warningf("get relative path of %s failed with error: %v", podAbsPath, err)
+			continue
+		}
+		podRelativePath := filepath.Join("/", relativePath)
+		calculationInfo := p.getAdvisorCalculationInfoOfPod(podRelativePath)
+		if calculationInfo == nil {
+			general.InfofV(4, "resized pod under %s is not under any advisor cgroup path, skip reconciling it", podRelativePath)
+			continue
+		}
+
+		groupCPU, err := p.getCPUWithRelativePath(calculationInfo.CgroupPath)
+		if err != nil {
+			general.Warningf("get quota of %s failed with error: %v", calculationInfo.CgroupPath, err)
+			continue
+		}
+
+		// the group quota is passed as it is, as checkAndApplyIfCgroupV1 does, and an unlimited one is -1
+		cgroupPath, podDir := filepath.Dir(podRelativePath), filepath.Base(podRelativePath)
+		round.processedPods++
+		err = p.checkAndApplyPodQuota(context.Background(), cgroupPath, podDir, podsPathMap, groupCPU.CpuQuota, round)
+		if err != nil {
+			round.failedPods++
+			round.podErrors[podDir] = err
+			general.Errorf("reconcile quota of resized pod under %s failed with error: %v", podRelativePath, err)
+		}
+	}
+
+	general.Infof("reconciled quota of %d resized pods: applied %d, unchanged %d, failed %d, took %v",
+		round.processedPods, round.appliedPods, round.unchangedPods, round.failedPods, time.Since(startTime))
+}
+
+// getAdvisorCalculationInfoOfPod returns the calculation info of the last applied plan whose cgroup path the pod
+// is reconciled under, and nil is returned if there is none. Pools and the kubepods root are left out, since
+// their quota is applied to themselves instead of pods under them.
+func (p *DynamicPolicy) getAdvisorCalculationInfoOfPod(podRelativePath string) *advisorsvc.CalculationInfo {
+	for _, calculationInfo := range p.lastCgroupConfigs {
+		if p.isPoolCgroupPath(calculationInfo.CgroupPath) || isKubepodsRootCgroupPath(calculationInfo.CgroupPath) {
+			continue
+		}
+		if strings.HasPrefix(podRelativePath, strings.TrimSuffix(calculationInfo.CgroupPath, "/")+"/") {
+			return calculationInfo
+		}
+	}
+	return nil
+}
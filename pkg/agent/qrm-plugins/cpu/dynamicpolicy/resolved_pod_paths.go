@@ -0,0 +1,57 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// ResolvedPodPath is a pod and the absolute cgroup path it's resolved to in quota reconcile.
+type ResolvedPodPath struct {
+	PodUID        string `json:"podUID"`
+	PodNamespace  string `json:"podNamespace"`
+	PodName       string `json:"podName"`
+	AbsCgroupPath string `json:"absCgroupPath"`
+}
+
+// GetResolvedPodPaths returns pods and their absolute cgroup paths resolved in the latest reconcile, sorted by
+// the paths, which helps debugging pods mapped to wrong cgroups; the returned data is a copy and it's safe to
+// be used by callers like admin endpoints.
+func (p *DynamicPolicy) GetResolvedPodPaths() []ResolvedPodPath {
+	p.RLock()
+	defer p.RUnlock()
+
+	resolvedPodPaths := make([]ResolvedPodPath, 0, len(p.podPathMap))
+	for absCgroupPath, pod := range p.podPathMap {
+		resolvedPodPaths = append(resolvedPodPaths, ResolvedPodPath{
+			PodUID:        string(pod.UID),
+			PodNamespace:  pod.Namespace,
+			PodName:       pod.Name,
+			AbsCgroupPath: absCgroupPath,
+		})
+	}
+	sort.Slice(resolvedPodPaths, func(i, j int) bool {
+		return resolvedPodPaths[i].AbsCgroupPath < resolvedPodPaths[j].AbsCgroupPath
+	})
+	return resolvedPodPaths
+}
+
+// DumpResolvedPodPaths returns the resolved pod paths given by GetResolvedPodPaths as JSON.
+func (p *DynamicPolicy) DumpResolvedPodPaths() ([]byte, error) {
+	return json.Marshal(p.GetResolvedPodPaths())
+}
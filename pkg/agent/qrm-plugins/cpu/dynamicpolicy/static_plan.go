@@ -0,0 +1,74 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/advisorsvc"
+	advisorapi "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/cpuadvisor"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+)
+
+// staticPlanFileApplyPeriod is the period at which the static plan file is re-read and applied,
+// so that changes of the file are picked up and drifts of cgroups are corrected
+const staticPlanFileApplyPeriod = 30 * time.Second
+
+// loadStaticPlan reads the calculation infos of a static plan from the file, which is a JSON list of
+// calculation infos in the same format as the ones pushed by cpu-advisor, e.g.
+// [{"cgroup_path": "/kubepods/besteffort", "calculation_result": {"values": {"cgroup_config": "{\"cpu_quota\": 200000}"}}}]
+func loadStaticPlan(file string) ([]*advisorsvc.CalculationInfo, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read static plan file %s failed with error: %v", file, err)
+	}
+
+	var calculationInfos []*advisorsvc.CalculationInfo
+	if err = json.Unmarshal(data, &calculationInfos); err != nil {
+		return nil, fmt.Errorf("unmarshal static plan file %s failed with error: %v", file, err)
+	}
+
+	for i, calculationInfo := range calculationInfos {
+		if calculationInfo == nil || calculationInfo.CgroupPath == "" || calculationInfo.CalculationResult == nil {
+			return nil, fmt.Errorf("invalid calculation info %d in static plan file %s", i, file)
+		}
+	}
+	return calculationInfos, nil
+}
+
+// applyStaticPlanFile applies the static plan in the file with the same pipeline as cgroup configs of cpu-advisor.
+func (p *DynamicPolicy) applyStaticPlanFile() {
+	calculationInfos, err := loadStaticPlan(p.staticPlanFile)
+	if err != nil {
+		general.Errorf("load static plan failed with error: %v", err)
+		return
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	err = p.applyCgroupConfigs(&advisorapi.ListAndWatchResponse{ExtraEntries: calculationInfos})
+	if err != nil {
+		general.Errorf("apply static plan in %s failed with error: %v", p.staticPlanFile, err)
+		return
+	}
+	p.lastAdvisorPlanTime = p.getClock().Now()
+	general.Infof("static plan in %s with %d calculation infos is applied", p.staticPlanFile, len(calculationInfos))
+}
@@ -27,6 +27,7 @@ const (
 	MetricNameGetAdviceFeatureNotSupported = "get_advice_feature_not_supported"
 	MetricNameHandleAdvisorRespCalled      = "handle_advisor_resp_called"
 	MetricNameHandleAdvisorRespFailed      = "handle_advisor_resp_failed"
+	MetricNameAdvisorPlanCoalesced         = "advisor_plan_coalesced"
 	MetricNameAdvisorUnhealthy             = "advisor_unhealthy"
 	MetricNameCheckApplyV1Error            = "check_apply_v1_error"
 
@@ -40,6 +41,25 @@ const (
 	MetricNameGetMemBWPreferenceFailed    = "get_mem_bw_preference_failed"
 	MetricNameGetNUMAAllocatedMemBWFailed = "get_numa_allocated_mem_bw_failed"
 	MetricNameSetExclusiveIRQCPUSize      = "set_exclusive_irq_cpu_size"
+	MetricNameAppliedCPUQuotaMilliCores   = "applied_cpu_quota_millicores"
+	MetricNameCgroupWriteBreakerOpen      = "cgroup_write_breaker_open"
+	MetricNameCgroupWriteTimeout          = "cgroup_write_timeout"
+	MetricNameCgroupWriteCapReached       = "cgroup_write_cap_reached"
+	MetricNameQuotaReconcileSkippedPods   = "quota_reconcile_skipped_pods"
+	MetricNameContainerQuotaFloorClamped  = "container_quota_floor_clamped"
+	MetricNameQuotaReconcileDriftedPods   = "quota_reconcile_drifted_pods"
+	MetricNameQuotaReconcileBackoffPods   = "quota_reconcile_backoff_pods"
+	MetricNamePodCPUThrottleRatio         = "pod_cpu_throttle_ratio"
+	MetricNameQuotaStarvedPodSignaled     = "quota_starved_pod_signaled"
+	MetricNameQuotaReconcilePaused        = "quota_reconcile_paused"
+	MetricNameQuotaReconcileDuration      = "quota_reconcile_duration_seconds"
+	MetricNameQuotaApplyOutcome           = "quota_apply_outcome"
+	MetricNameCPUCgroupWrites             = "cpu_cgroup_writes"
+	MetricNamePodQuotaConvergenceLag      = "pod_quota_convergence_lag"
+	MetricNameOrphanedQuotaCleared        = "orphaned_quota_cleared"
+	MetricNameAdvisorPlanStaleness        = "advisor_plan_staleness_seconds"
+
+	MetricNameAdvisorQuotaAllocatableRatio = "advisor_quota_allocatable_ratio"
 
 	// metrics for memory plugin
 	MetricNameMemSetInvalid                           = "memset_invalid"
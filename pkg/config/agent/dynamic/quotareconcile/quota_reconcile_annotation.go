@@ -0,0 +1,118 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quotareconcile
+
+import (
+	"strconv"
+	"time"
+)
+
+// AnnotationKeyPrefix is the prefix of annotations of the AdminQoSConfiguration setting quota reconcile knobs,
+// e.g. "quota-reconcile.katalyst.kubewharf.io/max-cgroup-writes-per-round: 100".
+const AnnotationKeyPrefix = "quota-reconcile.katalyst.kubewharf.io/"
+
+// annotationKnobAppliers parses and applies knobs keyed by their names in annotations, only knobs meant to be tuned
+// at runtime are included, and the others are only set by flags.
+var annotationKnobAppliers = map[string]func(c *QuotaReconcileConfiguration, value string) error{
+	"max-cgroup-writes-per-round": intKnob(func(c *QuotaReconcileConfiguration) *int { return &c.MaxCgroupWritesPerRound }),
+	"budget":                      durationKnob(func(c *QuotaReconcileConfiguration) *time.Duration { return &c.ReconcileBudget }),
+	"pause-on-node-maintenance":   boolKnob(func(c *QuotaReconcileConfiguration) *bool { return &c.PauseOnNodeMaintenance }),
+	"full-audit-round-interval":   intKnob(func(c *QuotaReconcileConfiguration) *int { return &c.FullAuditRoundInterval }),
+	"new-pod-grace-period":        durationKnob(func(c *QuotaReconcileConfiguration) *time.Duration { return &c.NewPodQuotaGracePeriod }),
+	"container-quota-floor-millicores": int64Knob(func(c *QuotaReconcileConfiguration) *int64 {
+		return &c.ContainerQuotaFloorMilliCores
+	}),
+	"container-quota-floor-request-ratio": float64Knob(func(c *QuotaReconcileConfiguration) *float64 {
+		return &c.ContainerQuotaFloorRequestRatio
+	}),
+	"unlimited-quota-cap-millicores": int64Knob(func(c *QuotaReconcileConfiguration) *int64 {
+		return &c.UnlimitedQuotaCapMilliCores
+	}),
+	"usage-weighted-container-quota": boolKnob(func(c *QuotaReconcileConfiguration) *bool { return &c.UsageWeightedContainerQuota }),
+	"reset-stale-pod-quota":          boolKnob(func(c *QuotaReconcileConfiguration) *bool { return &c.ResetStalePodQuota }),
+	"audit-orphaned-quota":           boolKnob(func(c *QuotaReconcileConfiguration) *bool { return &c.AuditOrphanedQuota }),
+	"quota-ramp-step-millicores":     int64Knob(func(c *QuotaReconcileConfiguration) *int64 { return &c.QuotaRampStepMilliCores }),
+	"quota-max-increase-step-millicores": int64Knob(func(c *QuotaReconcileConfiguration) *int64 {
+		return &c.QuotaMaxIncreaseStepMilliCores
+	}),
+	"quota-rounding-policy":         stringKnob(func(c *QuotaReconcileConfiguration) *string { return &c.QuotaRoundingPolicy }),
+	"zero-request-container-policy": stringKnob(func(c *QuotaReconcileConfiguration) *string { return &c.ZeroRequestContainerPolicy }),
+}
+
+// intKnob returns the applier of an int knob, and like the other appliers, the knob is only set if its value
+// is parsed successfully, so that a typo doesn't reset it.
+func intKnob(field func(c *QuotaReconcileConfiguration) *int) func(c *QuotaReconcileConfiguration, value string) error {
+	return func(c *QuotaReconcileConfiguration, value string) error {
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = v
+		return nil
+	}
+}
+
+func int64Knob(field func(c *QuotaReconcileConfiguration) *int64) func(c *QuotaReconcileConfiguration, value string) error {
+	return func(c *QuotaReconcileConfiguration, value string) error {
+		v, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		*field(c) = v
+		return nil
+	}
+}
+
+func float64Knob(field func(c *QuotaReconcileConfiguration) *float64) func(c *QuotaReconcileConfiguration, value string) error {
+	return func(c *QuotaReconcileConfiguration, value string) error {
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		*field(c) = v
+		return nil
+	}
+}
+
+func boolKnob(field func(c *QuotaReconcileConfiguration) *bool) func(c *QuotaReconcileConfiguration, value string) error {
+	return func(c *QuotaReconcileConfiguration, value string) error {
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = v
+		return nil
+	}
+}
+
+func durationKnob(field func(c *QuotaReconcileConfiguration) *time.Duration) func(c *QuotaReconcileConfiguration, value string) error {
+	return func(c *QuotaReconcileConfiguration, value string) error {
+		v, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = v
+		return nil
+	}
+}
+
+func stringKnob(field func(c *QuotaReconcileConfiguration) *string) func(c *QuotaReconcileConfiguration, value string) error {
+	return func(c *QuotaReconcileConfiguration, value string) error {
+		*field(c) = value
+		return nil
+	}
+}
@@ -0,0 +1,388 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quotareconcile
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/kubewharf/katalyst-core/pkg/config/agent/dynamic/crd"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+)
+
+// policies of rounding cpu quota to whole ticks, see QuotaRoundingPolicy
+const (
+	QuotaRoundingPolicyNone    = "none"
+	QuotaRoundingPolicyUp      = "up"
+	QuotaRoundingPolicyNearest = "nearest"
+)
+
+// strategies of merging calculation infos of multiple advisor sources for the same cgroup, see AdvisorSourceMergeStrategy
+const (
+	AdvisorSourceMergeStrategyLastWriter = "last-writer"
+	AdvisorSourceMergeStrategyPriority   = "priority"
+)
+
+// policies of applying quota to containers with no cpu request, see ZeroRequestContainerPolicy
+const (
+	ZeroRequestContainerPolicyLimit     = "limit"
+	ZeroRequestContainerPolicySkip      = "skip"
+	ZeroRequestContainerPolicyUnlimited = "unlimited"
+	ZeroRequestContainerPolicyDefault   = "default"
+)
+
+// QuotaReconcileConfiguration stores the configurations used for reconciling cpu quota
+// of pods under cgroup paths whose cgroup configs are calculated by cpu-advisor.
+type QuotaReconcileConfiguration struct {
+	// PodDirSearchDepth indicates how many directory levels under the advisor cgroup path are
+	// searched for pod cgroup directories, it's useful when pods are nested under sub-slices
+	PodDirSearchDepth int
+	// CgroupWriteFailureThreshold is the number of consecutive cgroup write failures after which
+	// the remaining writes in the same round are skipped; zero means never skipping
+	CgroupWriteFailureThreshold int
+	// CgroupWriteTimeout is the timeout of a cgroup write, after which the write is abandoned and counted
+	// as a failure, so that a hung cgroup filesystem won't stall the reconcile; zero means no timeout
+	CgroupWriteTimeout time.Duration
+	// MaxCgroupWritesPerRound is the max number of cgroup writes in a round, after which the remaining writes are
+	// left for the next round, to limit the blast radius of a bad plan rewriting every pod; zero means no cap
+	MaxCgroupWritesPerRound int
+	// ReconcileBudget is the max duration of a round, after which the round stops after the current pod and the next
+	// round resumes from where it's cut off, to keep rounds within the push interval on huge nodes; zero means no budget
+	ReconcileBudget time.Duration
+	// PodFailureBackoffBase is the backoff after which a pod that fails to be applied is retried, and it's doubled
+	// with each consecutive failure up to PodFailureBackoffMax, so that persistently failing pods are retried less
+	// often; zero means retrying in every round
+	PodFailureBackoffBase time.Duration
+	// PodFailureBackoffMax is the max backoff of a failing pod, zero means no max
+	PodFailureBackoffMax time.Duration
+	// WaitForNodeReady indicates whether the first reconcile is deferred until the node is ready by the metaserver,
+	// since the cgroup hierarchy and pod list may not be fully populated at node boot
+	WaitForNodeReady bool
+	// MinNodeUptime is the min uptime of the node before the first reconcile, for the same reason as WaitForNodeReady;
+	// zero means no min uptime
+	MinNodeUptime time.Duration
+	// PauseOnNodeMaintenance indicates whether reconciles are paused while the node is under maintenance, i.e. it's
+	// cordoned or annotated with the maintenance annotation, to avoid quota churn during drains
+	PauseOnNodeMaintenance bool
+	// FullAuditRoundInterval is the interval (in rounds) of full audit rounds, in which every pod is read back
+	// and re-applied regardless of the fast path of unchanged calculation infos and pods, so that drift
+	// accumulated from stale records is corrected. The fast path only reads back quotas of the cgroup, its pods
+	// and containers, so drift of the other knobs (e.g. memory, pids, freeze, uclamp and oom_score_adj of new
+	// processes) is only corrected in full audit rounds; zero means no full audit
+	FullAuditRoundInterval int
+	// QuotaReadBackSettleDelay is the delay after a quota write to a pod cgroup before it's read back for the drift
+	// check, so that the comparison isn't fooled by transient values of in-flight kernel updates; pods read back
+	// earlier are compared with their last-applied quota, and their drift check is deferred to a later round
+	// instead of being waited for, and zero means no delay
+	QuotaReadBackSettleDelay time.Duration
+	// AdvisorPlanStalenessThreshold is the age of the last applied plan of cpu-advisor after which a warning is logged,
+	// since cgroups are still reconciled with the stale plan if cpu-advisor stops pushing; zero means no warning
+	AdvisorPlanStalenessThreshold time.Duration
+	// StatusReportInterval is the min interval between writes of the reconcile status to a condition of the CNR
+	// of the node, which surfaces reconcile health e.g. for GitOps; zero means the status isn't written
+	StatusReportInterval time.Duration
+	// EnableMetricsExport indicates whether reconcile metrics fed to the emitter are also exposed in the Prometheus
+	// text format on the /metrics/quota-reconcile path of the agent endpoint, for operators scraping the agent directly;
+	// the path is only registered if it's enabled at startup, and later changes only pause or resume the export
+	EnableMetricsExport bool
+	// AnnotationQuotaFallbackStaleness is the age of the last applied plan of cpu-advisor, or the time without any plan,
+	// after which quota annotations of pods are applied as a fallback, so that explicit quotas are still honored when
+	// cpu-advisor is unavailable; zero means no fallback
+	AnnotationQuotaFallbackStaleness time.Duration
+	// QuotaOvercommitWarningRatio is the ratio of quotas summed over cgroup configs of cpu-advisor to node allocatable
+	// cpu, beyond which a warning is logged since it signals a planning bug; zero means no warning
+	QuotaOvercommitWarningRatio float64
+	// ThrottleAlertRatio is the ratio of throttled periods to all periods of a pod between rounds, beyond which
+	// a warning event is recorded for the pod since its quota may be too tight; zero means no alert
+	ThrottleAlertRatio float64
+	// QuotaStarvationEvictionRounds is the number of consecutive rounds in which a pod's quota is held at the floor
+	// with its throttle ratio beyond QuotaStarvationThrottleRatio, after which the pod is signaled to the cpu eviction,
+	// since evicting it may be better than keeping it starved; zero means never signaling
+	QuotaStarvationEvictionRounds int
+	// QuotaStarvationThrottleRatio is the throttle ratio beyond which a pod at the quota floor is considered starved
+	QuotaStarvationThrottleRatio float64
+	// NewPodQuotaGracePeriod is the period after a pod's creation during which its quota is left
+	// untouched, so that the pod can start up without being throttled; zero means no grace period
+	NewPodQuotaGracePeriod time.Duration
+	// ContainerQuotaFloorMilliCores is the absolute minimum quota (in milli-cores) applied to a container
+	ContainerQuotaFloorMilliCores int64
+	// ContainerQuotaFloorRequestRatio is the minimum quota applied to a container as a fraction of its cpu request,
+	// the larger one of the two floors takes effect but never exceeds the cpu limit of the container, and zero for
+	// both means no floor
+	ContainerQuotaFloorRequestRatio float64
+	// UnlimitedQuotaCapMilliCores is the quota (in milli-cores) applied instead when cpu-advisor requests unlimited
+	// quota for a cgroup, as a node-wide default cap; zero means keeping it unlimited
+	UnlimitedQuotaCapMilliCores int64
+	// UsageWeightedContainerQuota indicates whether the pod quota is distributed among its containers in proportion
+	// to their cpu usage on top of their floors, instead of by their own limits, so that bursting containers can
+	// make use of the quota left by idle ones; it falls back to limits if usage of any container is unavailable
+	UsageWeightedContainerQuota bool
+	// UsageQuotaSafetyMarginPercent is the headroom (in percent of usage) above cpu usage of a container kept in its
+	// usage-weighted quota, so that transient spikes aren't throttled; the quota with the margin is clamped to the
+	// limit of the container, and zero means no margin
+	UsageQuotaSafetyMarginPercent float64
+	// PodQuotaUsagePercentile is the percentile (in (0, 100]) of historical cpu usage of a pod its quota is sized to
+	// instead of its limit, so that batch workloads with periodic spikes aren't sized by instantaneous usage; usage
+	// of pods is periodically sampled from the metric store of metaserver, the quota is clamped to the pod limit,
+	// and zero means pod quota is sized by limits
+	PodQuotaUsagePercentile float64
+	// PodQuotaUsagePercentileMarginPercent is the headroom (in percent of usage) above the usage percentile kept in
+	// the percentile-based pod quota; zero means no margin
+	PodQuotaUsagePercentileMarginPercent float64
+	// PodQuotaUsageHistorySamples is the number of latest usage samples of a pod the percentile is taken from, and
+	// pods are sized by limits until as many samples are collected
+	PodQuotaUsageHistorySamples int
+	// ZeroRequestContainerPolicy is how quota is applied to containers with no cpu request, even after defaulting by
+	// LimitRange: "limit" (or empty) sizes them by their limits as other containers, "skip" leaves their quota as is,
+	// "unlimited" resets it to unlimited so that they are only capped by the pod, and "default" applies
+	// ZeroRequestContainerDefaultQuotaMilliCores instead of their limits
+	ZeroRequestContainerPolicy string
+	// ZeroRequestContainerDefaultQuotaMilliCores is the quota (in milli-cores) applied to containers with no cpu
+	// request with the "default" policy, and it's clamped to the cpu limit of the container if there is one
+	ZeroRequestContainerDefaultQuotaMilliCores int64
+	// ResetStalePodQuota indicates whether to reset quota of pod cgroups with no live pod to unlimited
+	// before pruning their records, in case that the cgroups linger for a while
+	ResetStalePodQuota bool
+	// AuditOrphanedQuota indicates whether tracked quota records are audited in each round, and quota of cgroups
+	// matching no pod, pool or kubepods root, which must have been applied by mistake, is reset to unlimited
+	AuditOrphanedQuota bool
+	// KubeletCPUManagerStateFile is the checkpoint file of cpu manager of kubelet, pods with exclusive cpus in it
+	// under the static policy are owned by kubelet and skipped, and empty means no pods are skipped for it
+	KubeletCPUManagerStateFile string
+	// QuotaRampStepMilliCores is the max change of quota (in milli-cores) applied to a pod in a round, so that
+	// quota converges to the target gradually over rounds; quotas of its containers follow the ramped one of the
+	// pod in proportion, and zero means applying the target directly
+	QuotaRampStepMilliCores int64
+	// QuotaRampDecreaseOnly indicates whether only decreases of quota are ramped, and increases are applied directly
+	QuotaRampDecreaseOnly bool
+	// QuotaMaxIncreaseStepMilliCores is the max increase of quota (in milli-cores) applied to a pod in a round
+	// regardless of the ramp, so that a single plan doesn't un-throttle all pods at once and quota converges to the
+	// target over rounds instead; decreases are never limited by it, and zero means no limit
+	QuotaMaxIncreaseStepMilliCores int64
+	// QuotaRoundingPolicy is how computed quota is rounded to whole milliseconds of the period before it's applied.
+	// Quota ending in a partial tick may leave a cgroup throttled for the remainder of a period on some kernels:
+	// "up" avoids that at the cost of granting slightly more cpu than the limit, "nearest" keeps the total closest
+	// to the limit but may still round down, and "none" (or empty) applies the exact quota.
+	QuotaRoundingPolicy string
+	// QoSLevelCPUPeriods are cfs periods keyed by qos levels, in which quota of pods of the qos levels is derived and
+	// written along with it, e.g. a shorter period gives latency-sensitive pods finer throttling granularity than
+	// batch ones; qos levels with no period keep the period of their cgroups
+	QoSLevelCPUPeriods map[string]time.Duration
+	// PodLabelSelector limits quota reconcile to pods matching the selector, e.g. for canary rollouts,
+	// and nil or an empty selector means all pods
+	PodLabelSelector labels.Selector
+	// IncludeEphemeralContainers indicates whether quota is also applied to ephemeral containers, e.g. debug ones,
+	// besides app containers and restartable init containers
+	IncludeEphemeralContainers bool
+	// AdvisorSourceMergeStrategy is how knobs of calculation infos of multiple advisor sources for the same cgroup are
+	// merged before they are applied: "priority" takes each knob from the source first in AdvisorSourcePriorities,
+	// and "last-writer" (or empty) takes it from the last calculation info of the plan carrying it
+	AdvisorSourceMergeStrategy string
+	// AdvisorSourcePriorities are advisor sources in descending priority for the priority merge strategy, sources
+	// not in it have the lowest priority; calculation infos without a source are of the default cpu-advisor source
+	AdvisorSourcePriorities []string
+	// PoolCgroupPathPrefixes are prefixes of cgroup paths of shared pools pushed by cpu-advisor, quota of
+	// pool cgroups is applied to the pools themselves instead of being reconciled to pods under them
+	PoolCgroupPathPrefixes []string
+	// DecisionLogFile is the file to which decisions of quota reconcile are written as JSON lines
+	// for offline analysis, and empty means the decision log is disabled
+	DecisionLogFile string
+	// DecisionLogMaxSizeMB is the max size (in megabytes) of the decision log file before it's rotated
+	DecisionLogMaxSizeMB int
+	// DecisionLogMaxBackups is the max number of rotated decision log files to retain, zero means retaining all
+	DecisionLogMaxBackups int
+	// WebhookURL is the url to which quota changes of pods exceeding WebhookChangeThresholdMilliCores are posted
+	// as JSON payloads for audit, and empty means the webhook is disabled
+	WebhookURL string
+	// WebhookChangeThresholdMilliCores is the min change of quota (in milli-cores) posted to the webhook,
+	// and changes from or to unlimited are always posted
+	WebhookChangeThresholdMilliCores int64
+	// WebhookTimeout is the timeout of a post to the webhook, zero means no timeout
+	WebhookTimeout time.Duration
+	// WebhookMaxRetries is the max number of retries of a failed post to the webhook
+	WebhookMaxRetries int
+	// AdvisorPayloadLogEnabled indicates whether the raw control knobs of each calculation info pushed by cpu-advisor
+	// are logged before they are decoded, which is meant for diagnosing bad plans and is disabled by default
+	AdvisorPayloadLogEnabled bool
+	// AdvisorPayloadLogMaxBytes is the max size (in bytes) of a logged payload, beyond which it's truncated,
+	// and zero means the default size
+	AdvisorPayloadLogMaxBytes int
+	// AdvisorPayloadLogRedactedKeys are control knobs whose values are redacted in logged payloads
+	AdvisorPayloadLogRedactedKeys []string
+}
+
+func NewQuotaReconcileConfiguration() *QuotaReconcileConfiguration {
+	return &QuotaReconcileConfiguration{}
+}
+
+// ApplyConfiguration applies knobs set by annotations of the AdminQoSConfiguration, since there is no section of
+// quota reconcile in its spec yet; annotations are keyed by AnnotationKeyPrefix followed by the flag name of the knob
+// without its "quota-reconcile-" prefix, and knobs with values failing to be parsed are left as is.
+func (c *QuotaReconcileConfiguration) ApplyConfiguration(conf *crd.DynamicConfigCRD) {
+	aqc := conf.AdminQoSConfiguration
+	if aqc == nil {
+		return
+	}
+
+	for key, value := range aqc.GetAnnotations() {
+		if !strings.HasPrefix(key, AnnotationKeyPrefix) {
+			continue
+		}
+
+		knob := strings.TrimPrefix(key, AnnotationKeyPrefix)
+		apply, ok := annotationKnobAppliers[knob]
+		if !ok {
+			general.Warningf("unknown quota reconcile knob %s, ignore this configuration", knob)
+			continue
+		}
+		if err := apply(c, value); err != nil {
+			general.Warningf("failed to parse quota reconcile knob %s, ignore this configuration: %q", knob, err)
+		}
+	}
+}
+
+// Validate checks whether the configuration can be used for reconciling quota.
+func (c *QuotaReconcileConfiguration) Validate() error {
+	if c.PodDirSearchDepth < 0 {
+		return fmt.Errorf("invalid pod dir search depth: %d", c.PodDirSearchDepth)
+	}
+	if c.CgroupWriteFailureThreshold < 0 {
+		return fmt.Errorf("invalid cgroup write failure threshold: %d", c.CgroupWriteFailureThreshold)
+	}
+	if c.CgroupWriteTimeout < 0 {
+		return fmt.Errorf("invalid cgroup write timeout: %v", c.CgroupWriteTimeout)
+	}
+	if c.MaxCgroupWritesPerRound < 0 {
+		return fmt.Errorf("invalid max cgroup writes per round: %d", c.MaxCgroupWritesPerRound)
+	}
+	if c.ReconcileBudget < 0 {
+		return fmt.Errorf("invalid reconcile budget: %v", c.ReconcileBudget)
+	}
+	if c.PodFailureBackoffBase < 0 {
+		return fmt.Errorf("invalid pod failure backoff base: %v", c.PodFailureBackoffBase)
+	}
+	if c.PodFailureBackoffMax < 0 {
+		return fmt.Errorf("invalid pod failure backoff max: %v", c.PodFailureBackoffMax)
+	}
+	if c.MinNodeUptime < 0 {
+		return fmt.Errorf("invalid min node uptime: %v", c.MinNodeUptime)
+	}
+	if c.QuotaReadBackSettleDelay < 0 {
+		return fmt.Errorf("invalid quota read-back settle delay: %v", c.QuotaReadBackSettleDelay)
+	}
+	if c.FullAuditRoundInterval < 0 {
+		return fmt.Errorf("invalid full audit round interval: %d", c.FullAuditRoundInterval)
+	}
+	if c.StatusReportInterval < 0 {
+		return fmt.Errorf("invalid status report interval: %v", c.StatusReportInterval)
+	}
+	if c.AdvisorPlanStalenessThreshold < 0 {
+		return fmt.Errorf("invalid advisor plan staleness threshold: %v", c.AdvisorPlanStalenessThreshold)
+	}
+	if c.AnnotationQuotaFallbackStaleness < 0 {
+		return fmt.Errorf("invalid annotation quota fallback staleness: %v", c.AnnotationQuotaFallbackStaleness)
+	}
+	if c.QuotaOvercommitWarningRatio < 0 {
+		return fmt.Errorf("invalid quota overcommit warning ratio: %v", c.QuotaOvercommitWarningRatio)
+	}
+	if c.ThrottleAlertRatio < 0 || c.ThrottleAlertRatio > 1 {
+		return fmt.Errorf("invalid throttle alert ratio: %v", c.ThrottleAlertRatio)
+	}
+	if c.QuotaStarvationEvictionRounds < 0 {
+		return fmt.Errorf("invalid quota starvation eviction rounds: %d", c.QuotaStarvationEvictionRounds)
+	}
+	if c.QuotaStarvationThrottleRatio < 0 || c.QuotaStarvationThrottleRatio > 1 {
+		return fmt.Errorf("invalid quota starvation throttle ratio: %v", c.QuotaStarvationThrottleRatio)
+	}
+	if c.NewPodQuotaGracePeriod < 0 {
+		return fmt.Errorf("invalid new pod quota grace period: %v", c.NewPodQuotaGracePeriod)
+	}
+	if c.ContainerQuotaFloorMilliCores < 0 {
+		return fmt.Errorf("invalid container quota floor: %d", c.ContainerQuotaFloorMilliCores)
+	}
+	if c.UsageQuotaSafetyMarginPercent < 0 {
+		return fmt.Errorf("invalid usage quota safety margin percent: %v", c.UsageQuotaSafetyMarginPercent)
+	}
+	if c.PodQuotaUsagePercentile < 0 || c.PodQuotaUsagePercentile > 100 {
+		return fmt.Errorf("invalid pod quota usage percentile: %v", c.PodQuotaUsagePercentile)
+	}
+	if c.PodQuotaUsagePercentileMarginPercent < 0 {
+		return fmt.Errorf("invalid pod quota usage percentile margin percent: %v", c.PodQuotaUsagePercentileMarginPercent)
+	}
+	switch c.ZeroRequestContainerPolicy {
+	case "", ZeroRequestContainerPolicyLimit, ZeroRequestContainerPolicySkip, ZeroRequestContainerPolicyUnlimited:
+	case ZeroRequestContainerPolicyDefault:
+		if c.ZeroRequestContainerDefaultQuotaMilliCores <= 0 {
+			return fmt.Errorf("invalid zero-request container default quota: %d", c.ZeroRequestContainerDefaultQuotaMilliCores)
+		}
+	default:
+		return fmt.Errorf("invalid zero-request container policy: %s", c.ZeroRequestContainerPolicy)
+	}
+	if c.PodQuotaUsageHistorySamples < 0 || (c.PodQuotaUsagePercentile > 0 && c.PodQuotaUsageHistorySamples == 0) {
+		return fmt.Errorf("invalid pod quota usage history samples: %d", c.PodQuotaUsageHistorySamples)
+	}
+	if c.ContainerQuotaFloorRequestRatio < 0 || c.ContainerQuotaFloorRequestRatio > 1 {
+		return fmt.Errorf("invalid container quota floor request ratio: %v", c.ContainerQuotaFloorRequestRatio)
+	}
+	if c.UnlimitedQuotaCapMilliCores < 0 {
+		return fmt.Errorf("invalid unlimited quota cap: %d", c.UnlimitedQuotaCapMilliCores)
+	}
+	if c.QuotaRampStepMilliCores < 0 {
+		return fmt.Errorf("invalid quota ramp step: %d", c.QuotaRampStepMilliCores)
+	}
+	if c.QuotaMaxIncreaseStepMilliCores < 0 {
+		return fmt.Errorf("invalid quota max increase step: %d", c.QuotaMaxIncreaseStepMilliCores)
+	}
+	switch c.QuotaRoundingPolicy {
+	case "", QuotaRoundingPolicyNone, QuotaRoundingPolicyUp, QuotaRoundingPolicyNearest:
+	default:
+		return fmt.Errorf("invalid quota rounding policy: %s", c.QuotaRoundingPolicy)
+	}
+	switch c.AdvisorSourceMergeStrategy {
+	case "", AdvisorSourceMergeStrategyLastWriter, AdvisorSourceMergeStrategyPriority:
+	default:
+		return fmt.Errorf("invalid advisor source merge strategy: %s", c.AdvisorSourceMergeStrategy)
+	}
+	for qosLevel, period := range c.QoSLevelCPUPeriods {
+		// the bounds of cpu.cfs_period_us accepted by the kernel
+		if period < time.Millisecond || period > time.Second {
+			return fmt.Errorf("invalid cpu period %v of qos level %s", period, qosLevel)
+		}
+	}
+	if c.DecisionLogMaxSizeMB < 0 {
+		return fmt.Errorf("invalid decision log max size: %d", c.DecisionLogMaxSizeMB)
+	}
+	if c.DecisionLogMaxBackups < 0 {
+		return fmt.Errorf("invalid decision log max backups: %d", c.DecisionLogMaxBackups)
+	}
+	if c.WebhookChangeThresholdMilliCores < 0 {
+		return fmt.Errorf("invalid webhook change threshold: %d", c.WebhookChangeThresholdMilliCores)
+	}
+	if c.WebhookTimeout < 0 {
+		return fmt.Errorf("invalid webhook timeout: %v", c.WebhookTimeout)
+	}
+	if c.WebhookMaxRetries < 0 {
+		return fmt.Errorf("invalid webhook max retries: %d", c.WebhookMaxRetries)
+	}
+	if c.AdvisorPayloadLogMaxBytes < 0 {
+		return fmt.Errorf("invalid advisor payload log max bytes: %d", c.AdvisorPayloadLogMaxBytes)
+	}
+	return nil
+}
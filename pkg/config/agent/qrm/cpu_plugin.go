@@ -40,6 +40,9 @@ type CPUDynamicPolicyConfig struct {
 	EnableCPUAdvisor bool
 	// Interval at which we get advice from sys-advisor
 	GetAdviceInterval time.Duration
+	// MinReconcileInterval is the minimum interval between two reconciles by advice from sys-advisor,
+	// advice pushed within the interval is coalesced into a single deferred reconcile
+	MinReconcileInterval time.Duration
 	// EnableCPUPressureEviction indicates whether to enable cpu-pressure eviction, such as cpu load eviction or cpu
 	// suppress eviction
 	EnableCPUPressureEviction bool
@@ -55,6 +58,16 @@ type CPUDynamicPolicyConfig struct {
 	SharedCoresNUMABindingResultAnnotationKey string
 	// EnableReserveCPUReversely indicates whether to reserve cpu reversely
 	EnableReserveCPUReversely bool
+	// StaticPlanFile is the file of a static plan applied periodically instead of advice from sys-advisor,
+	// e.g. on offline nodes, and the plan is a JSON list of calculation infos; empty means no static plan
+	StaticPlanFile string
+	// CgroupRootOverride overrides the cgroupfs mount point on which cgroup paths of advisor are resolved,
+	// e.g. in nested environments like kind, and empty means the host mount point
+	CgroupRootOverride string
+	// QoSLevelReconcileIntervals are intervals keyed by qos levels, at which quota of pods of the qos levels is
+	// reconciled again with the last applied plan besides plans pushed by cpu-advisor, so that pods of high
+	// priorities are checked more often; qos levels with no interval are only reconciled with pushed plans
+	QoSLevelReconcileIntervals map[string]time.Duration
 
 	*hintoptimizer.HintOptimizerConfiguration
 	*irqtuner.IRQTunerConfiguration
@@ -381,6 +381,10 @@ const (
 	MetricLoad15MinCgroup = "cpu.load.15min.cgroup"
 
 	MetricUpdateTimeCgroup = "cpu.updatetime.cgroup"
+
+	// MetricCPUQuotaAppliedCgroup is the quota applied to a pod cgroup by quota reconcile of the cpu plugin,
+	// which may differ from MetricCPUQuotaCgroup read from the cgroup until it converges; -1 means unlimited
+	MetricCPUQuotaAppliedCgroup = "cpu.quota.applied.cgroup"
 )
 
 // Cgroup memory metrics
@@ -0,0 +1,41 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package features defines feature gates of katalyst components, which can be enabled or disabled
+// per node by the standard --feature-gates flag, e.g. --feature-gates=QuotaReconcile=false.
+package features // import "github.com/kubewharf/katalyst-core/pkg/features"
+
+import (
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	utilfeature "k8s.io/apiserver/pkg/util/feature"
+	"k8s.io/component-base/featuregate"
+)
+
+const (
+	// QuotaReconcile enables reconciling cpu quota given by cpu-advisor to pods under the cgroup paths,
+	// i.e. the checkAndApplyIfCgroupV1 pipeline of the cpu dynamic policy and the rounds applying pod quota
+	// along with it; cgroup configs of the cgroup paths themselves are still applied when it's disabled.
+	QuotaReconcile featuregate.Feature = "QuotaReconcile"
+)
+
+func init() {
+	utilruntime.Must(utilfeature.DefaultMutableFeatureGate.Add(defaultKatalystFeatureGates))
+}
+
+// defaultKatalystFeatureGates consists of all known katalyst-specific feature keys.
+var defaultKatalystFeatureGates = map[featuregate.Feature]featuregate.FeatureSpec{
+	QuotaReconcile: {Default: true, PreRelease: featuregate.Beta},
+}
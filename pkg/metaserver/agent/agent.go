@@ -34,6 +34,7 @@ import (
 	"github.com/kubewharf/katalyst-core/pkg/metaserver/agent/cnc"
 	"github.com/kubewharf/katalyst-core/pkg/metaserver/agent/cnr"
 	"github.com/kubewharf/katalyst-core/pkg/metaserver/agent/kubeletconfig"
+	"github.com/kubewharf/katalyst-core/pkg/metaserver/agent/limitrange"
 	"github.com/kubewharf/katalyst-core/pkg/metaserver/agent/metric"
 	"github.com/kubewharf/katalyst-core/pkg/metaserver/agent/metric/types"
 	"github.com/kubewharf/katalyst-core/pkg/metaserver/agent/node"
@@ -62,6 +63,7 @@ type MetaAgent struct {
 	cnr.CNRFetcher
 	cnc.CNCFetcher
 	kubeletconfig.KubeletConfigFetcher
+	limitrange.LimitRangeFetcher
 
 	// ObjectFetchers provide a way to expand fetcher for objects
 	ObjectFetchers sync.Map
@@ -99,6 +101,7 @@ func NewMetaAgent(conf *config.Configuration, clientSet *client.GenericClientSet
 		CNRFetcher: cnr.NewCachedCNRFetcher(conf.BaseConfiguration,
 			conf.MetaServerConfiguration.CNRConfiguration, clientSet.InternalClient.NodeV1alpha1().CustomNodeResources()),
 		KubeletConfigFetcher: kubeletconfig.NewKubeletConfigFetcher(conf.BaseConfiguration, emitter),
+		LimitRangeFetcher:    limitrange.NewCachedLimitRangeFetcher(clientSet.KubeClient.CoreV1()),
 	}
 
 	if conf.EnableMetricsFetcher {
@@ -171,6 +174,12 @@ func (a *MetaAgent) SetKubeletConfigFetcher(k kubeletconfig.KubeletConfigFetcher
 	})
 }
 
+func (a *MetaAgent) SetLimitRangeFetcher(l limitrange.LimitRangeFetcher) {
+	a.setComponentImplementation(func() {
+		a.LimitRangeFetcher = l
+	})
+}
+
 func (a *MetaAgent) Run(ctx context.Context) {
 	a.Lock()
 	if a.start {
@@ -0,0 +1,78 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package limitrange
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// limitRangeCacheTTL is how long limit ranges of a namespace are cached before they are listed again,
+// since limit ranges rarely change while they are looked up for containers in every reconcile.
+const limitRangeCacheTTL = time.Minute
+
+// LimitRangeFetcher is used to get K8S LimitRange information.
+type LimitRangeFetcher interface {
+	// GetLimitRanges returns limit ranges of the namespace, and they must not be modified.
+	GetLimitRanges(ctx context.Context, namespace string) ([]*v1.LimitRange, error)
+}
+
+type cachedLimitRanges struct {
+	limitRanges  []*v1.LimitRange
+	lastSyncTime time.Time
+}
+
+type cachedLimitRangeFetcher struct {
+	sync.Mutex
+	cache map[string]*cachedLimitRanges
+
+	client corev1.LimitRangesGetter
+}
+
+func NewCachedLimitRangeFetcher(client corev1.LimitRangesGetter) LimitRangeFetcher {
+	return &cachedLimitRangeFetcher{
+		cache:  make(map[string]*cachedLimitRanges),
+		client: client,
+	}
+}
+
+func (c *cachedLimitRangeFetcher) GetLimitRanges(ctx context.Context, namespace string) ([]*v1.LimitRange, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	now := time.Now()
+	if cached, ok := c.cache[namespace]; ok && cached.lastSyncTime.Add(limitRangeCacheTTL).After(now) {
+		return cached.limitRanges, nil
+	}
+
+	limitRangeList, err := c.client.LimitRanges(namespace).List(ctx, metav1.ListOptions{ResourceVersion: "0"})
+	if err != nil {
+		return nil, err
+	}
+
+	limitRanges := make([]*v1.LimitRange, 0, len(limitRangeList.Items))
+	for i := range limitRangeList.Items {
+		limitRanges = append(limitRanges, &limitRangeList.Items[i])
+	}
+	c.cache[namespace] = &cachedLimitRanges{limitRanges: limitRanges, lastSyncTime: now}
+	return limitRanges, nil
+}
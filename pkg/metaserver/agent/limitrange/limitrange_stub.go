@@ -0,0 +1,43 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package limitrange
+
+import (
+	"context"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+type LimitRangeFetcherStub struct {
+	mutex       sync.Mutex
+	LimitRanges []*v1.LimitRange
+}
+
+var _ LimitRangeFetcher = &LimitRangeFetcherStub{}
+
+func (l *LimitRangeFetcherStub) GetLimitRanges(_ context.Context, namespace string) ([]*v1.LimitRange, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	limitRanges := make([]*v1.LimitRange, 0, len(l.LimitRanges))
+	for _, limitRange := range l.LimitRanges {
+		if limitRange.Namespace == namespace {
+			limitRanges = append(limitRanges, limitRange)
+		}
+	}
+	return limitRanges, nil
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// CPUMaxUnlimited is the literal of unlimited quota in the cgroup v2 cpu.max file
+const CPUMaxUnlimited = "max"
+
+// ParseCPUMax parses the content of the cgroup v2 cpu.max file in the form of "$MAX $PERIOD",
+// and the unlimited quota "max" is returned as math.MaxInt64.
+func ParseCPUMax(content string) (quota, period int64, err error) {
+	fields := strings.Fields(content)
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("cpu.max content %q does not have 2 fields", content)
+	}
+
+	if fields[0] == CPUMaxUnlimited {
+		quota = math.MaxInt64
+	} else {
+		quota, err = strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("parse quota %q of cpu.max failed with error: %v", fields[0], err)
+		} else if quota <= 0 {
+			return 0, 0, fmt.Errorf("invalid quota %d of cpu.max", quota)
+		}
+	}
+
+	period, err = strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("parse period %q of cpu.max failed with error: %v", fields[1], err)
+	} else if period <= 0 {
+		return 0, 0, fmt.Errorf("invalid period %d of cpu.max", period)
+	}
+	return quota, period, nil
+}
+
+// FormatCPUMax formats quota and period to the content of the cgroup v2 cpu.max file, where non-positive quota
+// (e.g. -1 of cgroup v1) and math.MaxInt64 are both formatted as "max", so that it round-trips with ParseCPUMax.
+func FormatCPUMax(quota, period int64) string {
+	quotaStr := CPUMaxUnlimited
+	if quota > 0 && quota != math.MaxInt64 {
+		quotaStr = strconv.FormatInt(quota, 10)
+	}
+	return quotaStr + " " + strconv.FormatInt(period, 10)
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseCPUMax(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		content    string
+		wantQuota  int64
+		wantPeriod int64
+		wantErr    bool
+	}{
+		{
+			name:       "unlimited quota",
+			content:    "max 100000",
+			wantQuota:  math.MaxInt64,
+			wantPeriod: 100000,
+		},
+		{
+			name:       "limited quota",
+			content:    "50000 100000",
+			wantQuota:  50000,
+			wantPeriod: 100000,
+		},
+		{
+			name:       "trailing newline",
+			content:    "50000 100000\n",
+			wantQuota:  50000,
+			wantPeriod: 100000,
+		},
+		{
+			name:    "empty content",
+			content: "",
+			wantErr: true,
+		},
+		{
+			name:    "missing period",
+			content: "max",
+			wantErr: true,
+		},
+		{
+			name:    "extra fields",
+			content: "50000 100000 1",
+			wantErr: true,
+		},
+		{
+			name:    "invalid quota",
+			content: "abc 100000",
+			wantErr: true,
+		},
+		{
+			name:    "negative quota",
+			content: "-1 100000",
+			wantErr: true,
+		},
+		{
+			name:    "unlimited period",
+			content: "50000 max",
+			wantErr: true,
+		},
+		{
+			name:    "zero period",
+			content: "50000 0",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			quota, period, err := ParseCPUMax(tt.content)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseCPUMax() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if quota != tt.wantQuota || period != tt.wantPeriod {
+				t.Errorf("ParseCPUMax() = %v %v, want %v %v", quota, period, tt.wantQuota, tt.wantPeriod)
+			}
+		})
+	}
+}
+
+func TestFormatCPUMax(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		quota  int64
+		period int64
+		want   string
+	}{
+		{
+			name:   "limited quota",
+			quota:  50000,
+			period: 100000,
+			want:   "50000 100000",
+		},
+		{
+			name:   "unlimited quota of cgroup v1",
+			quota:  -1,
+			period: 100000,
+			want:   "max 100000",
+		},
+		{
+			name:   "unlimited quota of cgroup v2",
+			quota:  math.MaxInt64,
+			period: 100000,
+			want:   "max 100000",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got := FormatCPUMax(tt.quota, tt.period)
+			if got != tt.want {
+				t.Errorf("FormatCPUMax() = %v, want %v", got, tt.want)
+				return
+			}
+
+			// the formatted content round-trips with ParseCPUMax
+			quota, period, err := ParseCPUMax(got)
+			if err != nil {
+				t.Errorf("ParseCPUMax() error = %v", err)
+				return
+			}
+			if FormatCPUMax(quota, period) != got {
+				t.Errorf("FormatCPUMax() of parsed %v %v = %v, want %v", quota, period, FormatCPUMax(quota, period), got)
+			}
+		})
+	}
+}
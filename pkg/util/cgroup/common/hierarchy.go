@@ -0,0 +1,62 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+)
+
+// CgroupFSUnifiedDir is the dir of the cgroup v2 unified hierarchy under the cgroupfs mount point in hybrid mode,
+// in which some controllers are mounted as cgroup v1 hierarchies while the others are enabled in the unified one
+const CgroupFSUnifiedDir = "unified"
+
+// GetSubsysCgroupRoot returns the root of the hierarchy serving the subsystem under the mount point, along with
+// whether it's a cgroup v2 one. Controllers are detected one by one, so that in hybrid mode the subsystem is served
+// by its cgroup v1 hierarchy if it's mounted, otherwise by the unified hierarchy if it's enabled there, and it
+// falls back to the cgroup v1 hierarchy if neither is found.
+func GetSubsysCgroupRoot(mountPoint, subsys string) (string, bool) {
+	if CheckCgroup2UnifiedMode() {
+		return mountPoint, true
+	}
+
+	v1Root := filepath.Join(mountPoint, subsys)
+	if general.IsPathExists(v1Root) {
+		return v1Root, false
+	}
+
+	unifiedRoot := filepath.Join(mountPoint, CgroupFSUnifiedDir)
+	content, err := os.ReadFile(filepath.Join(unifiedRoot, "cgroup.controllers"))
+	if err == nil {
+		for _, controller := range strings.Fields(string(content)) {
+			if controller == subsys {
+				return unifiedRoot, true
+			}
+		}
+	}
+	return v1Root, false
+}
+
+// IsSubsysOnCgroupV2 returns whether the subsystem is served by a cgroup v2 hierarchy on the host,
+// either in unified mode or by the unified hierarchy in hybrid mode.
+func IsSubsysOnCgroupV2(subsys string) bool {
+	_, v2 := GetSubsysCgroupRoot(CgroupFSMountPoint, subsys)
+	return v2
+}
@@ -98,13 +98,10 @@ func InitKubernetesCGroupPath(cgroupType CgroupType, additionalK8SCGroupPath []s
 	})
 }
 
-// GetCgroupRootPath get cgroupfs root path compatible with v1 and v2
+// GetCgroupRootPath get cgroupfs root path compatible with v1, v2 and the hybrid mode of them
 func GetCgroupRootPath(subsys string) string {
-	if CheckCgroup2UnifiedMode() {
-		return CgroupFSMountPoint
-	}
-
-	return filepath.Join(CgroupFSMountPoint, subsys)
+	root, _ := GetSubsysCgroupRoot(CgroupFSMountPoint, subsys)
+	return root
 }
 
 // GetAbsCgroupPath get absolute cgroup path for relative cgroup path
@@ -127,6 +124,15 @@ func GetKubernetesCgroupRootPathWithSubSys(subsys string) []string {
 	return subsysCgroupPathList
 }
 
+// GetKubernetesCgroupRootPaths returns all relative cgroup paths to run container for kubernetes.
+// note: this function is not thread-safe, and it should be called after InitKubernetesCGroupPath.
+func GetKubernetesCgroupRootPaths() []string {
+	k8sCgroupPathLock.RLock()
+	defer k8sCgroupPathLock.RUnlock()
+
+	return k8sCgroupPathList.List()
+}
+
 // GetKubernetesAbsCgroupPath returns absolute cgroup path for kubernetes with the given
 // suffix without considering whether the path exists or not.
 func GetKubernetesAbsCgroupPath(subsys, suffix string) string {
@@ -20,8 +20,11 @@ limitations under the License.
 package common
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/bytedance/mockey"
 	"github.com/stretchr/testify/require"
 )
 
@@ -86,3 +89,37 @@ func TestIsContainerCgroupFileExist(t *testing.T) {
 	_, err := IsContainerCgroupFileExist("cpuset", "fake-pod-uid", "fake-container-id", "nonexistentfile")
 	as.NotNil(err)
 }
+
+func TestGetSubsysCgroupRoot(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+	mountPoint := t.TempDir()
+	as.NoError(os.MkdirAll(filepath.Join(mountPoint, CgroupSubsysCPU), 0o755))
+	as.NoError(os.MkdirAll(filepath.Join(mountPoint, CgroupFSUnifiedDir), 0o755))
+	as.NoError(os.WriteFile(filepath.Join(mountPoint, CgroupFSUnifiedDir, "cgroup.controllers"), []byte("memory pids\n"), 0o644))
+
+	mockey.PatchConvey("test hierarchies are detected per controller", t, func() {
+		mockey.Mock(CheckCgroup2UnifiedMode).IncludeCurrentGoRoutine().Return(false).Build()
+
+		root, v2 := GetSubsysCgroupRoot(mountPoint, CgroupSubsysCPU)
+		as.Equal(filepath.Join(mountPoint, CgroupSubsysCPU), root)
+		as.False(v2)
+
+		root, v2 = GetSubsysCgroupRoot(mountPoint, CgroupSubsysMemory)
+		as.Equal(filepath.Join(mountPoint, CgroupFSUnifiedDir), root)
+		as.True(v2)
+
+		root, v2 = GetSubsysCgroupRoot(mountPoint, CgroupSubsysCPUSet)
+		as.Equal(filepath.Join(mountPoint, CgroupSubsysCPUSet), root)
+		as.False(v2)
+	})
+
+	mockey.PatchConvey("test the mount point is the root in unified mode", t, func() {
+		mockey.Mock(CheckCgroup2UnifiedMode).IncludeCurrentGoRoutine().Return(true).Build()
+
+		root, v2 := GetSubsysCgroupRoot(mountPoint, CgroupSubsysCPU)
+		as.Equal(mountPoint, root)
+		as.True(v2)
+	})
+}
@@ -29,6 +29,10 @@ const (
 	CgroupTasksFileV1 = "tasks"
 	// CgroupTasksFileV2 thread id file for cgroupv2
 	CgroupTasksFileV2 = "cgroup.threads"
+	// CgroupProcsFile process id file for both cgroupv1 and cgroupv2
+	CgroupProcsFile = "cgroup.procs"
+	// CgroupCPUStatFile cpu statistics file for both cgroupv1 and cgroupv2
+	CgroupCPUStatFile = "cpu.stat"
 
 	CgroupSubsysCPUSet = "cpuset"
 	CgroupSubsysMemory = "memory"
@@ -36,6 +40,10 @@ const (
 	CgroupSubsysIO     = "io"
 	// CgroupSubsysNetCls is the net_cls sub-system
 	CgroupSubsysNetCls = "net_cls"
+	// CgroupSubsysPids is the pids sub-system
+	CgroupSubsysPids = "pids"
+	// CgroupSubsysFreezer is the freezer sub-system
+	CgroupSubsysFreezer = "freezer"
 
 	PodCgroupPathPrefix        = "pod"
 	CgroupFsRootPath           = "/kubepods"
@@ -49,6 +57,7 @@ const (
 
 const (
 	CPUQuotaUnlimit = -1
+	PidsMaxUnlimit  = -1
 )
 
 // defaultSelectedSubsysList cgroupv1 most common subsystems
@@ -94,10 +103,14 @@ type MemoryPressure struct {
 
 // CPUData set cgroup cpu data
 type CPUData struct {
-	Shares     uint64
-	CpuPeriod  uint64
-	CpuQuota   int64
-	CpuIdlePtr *bool
+	Shares      uint64
+	CpuPeriod   uint64
+	CpuQuota    int64
+	CpuIdlePtr  *bool
+	CpuBurstPtr *uint64
+	// CpuUclampMinPtr and CpuUclampMaxPtr are utilization clamps in percentage, only supported in cgroup v2
+	CpuUclampMinPtr *float64
+	CpuUclampMaxPtr *float64
 }
 
 // CPUSetData set cgroup cpuset data
@@ -117,6 +130,18 @@ type NetClsData struct {
 	Attributes map[string]string
 }
 
+// PidsData is the pids data.
+type PidsData struct {
+	// PidsMax is the max number of pids in the cgroup, -1 means unlimited (max) and 0 means not set.
+	PidsMax int64
+}
+
+// FreezerData is the freezer data.
+type FreezerData struct {
+	// FrozenPtr indicates whether tasks in the cgroup are frozen or thawed, and nil means not set.
+	FrozenPtr *bool
+}
+
 type (
 	IOCostCtrlMode string
 	IOCostModel    string
@@ -184,6 +209,8 @@ type MemoryStats struct {
 type CPUStats struct {
 	CpuPeriod uint64
 	CpuQuota  int64
+	// CpuBurst is nil if cpu burst is not supported by the kernel
+	CpuBurst *uint64
 }
 
 // CPUSetStats get cgroup cpuset data
@@ -236,6 +263,8 @@ type CgroupMetrics struct {
 type CgroupResources struct {
 	CpuQuota  int64  `json:"cpu_quota"`
 	CpuPeriod uint64 `json:"cpu_period"`
+	// CpuBurst isn't supported by libcontainer, it's applied by katalyst cgroup manager instead
+	CpuBurst *uint64 `json:"cpu_burst,omitempty"`
 
 	SkipDevices     bool `json:"-"`
 	SkipFreezeOnSet bool `json:"-"`
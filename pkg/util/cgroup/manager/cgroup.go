@@ -53,7 +53,7 @@ func ApplyMemoryWithRelativePath(relCgroupPath string, data *common.MemoryData)
 	}
 
 	absCgroupPath := common.GetAbsCgroupPath("memory", relCgroupPath)
-	return GetManager().ApplyMemory(absCgroupPath, data)
+	return GetManagerForSubsys("memory").ApplyMemory(absCgroupPath, data)
 }
 
 func ApplyCPUWithRelativePath(relCgroupPath string, data *common.CPUData) error {
@@ -62,7 +62,7 @@ func ApplyCPUWithRelativePath(relCgroupPath string, data *common.CPUData) error
 	}
 
 	absCgroupPath := common.GetAbsCgroupPath("cpu", relCgroupPath)
-	return GetManager().ApplyCPU(absCgroupPath, data)
+	return GetManagerForSubsys("cpu").ApplyCPU(absCgroupPath, data)
 }
 
 func ApplyCPUWithAbsolutePath(absCgroupPath string, data *common.CPUData) error {
@@ -79,7 +79,7 @@ func ApplyCPUSetWithRelativePath(relCgroupPath string, data *common.CPUSetData)
 	}
 
 	absCgroupPath := common.GetAbsCgroupPath("cpuset", relCgroupPath)
-	return GetManager().ApplyCPUSet(absCgroupPath, data)
+	return GetManagerForSubsys("cpuset").ApplyCPUSet(absCgroupPath, data)
 }
 
 func ApplyCPUSetWithAbsolutePath(absCgroupPath string, data *common.CPUSetData) error {
@@ -109,7 +109,7 @@ func ApplyNetClsWithRelativePath(relCgroupPath string, data *common.NetClsData)
 	}
 
 	absCgroupPath := common.GetAbsCgroupPath("net_cls", relCgroupPath)
-	return GetManager().ApplyNetCls(absCgroupPath, data)
+	return GetManagerForSubsys("net_cls").ApplyNetCls(absCgroupPath, data)
 }
 
 func ApplyNetClsWithAbsolutePath(absCgroupPath string, data *common.NetClsData) error {
@@ -134,6 +134,40 @@ func ApplyNetClsForContainer(podUID, containerId string, data *common.NetClsData
 	return ApplyNetClsWithAbsolutePath(netClsAbsCGPath, data)
 }
 
+func ApplyPidsWithRelativePath(relCgroupPath string, data *common.PidsData) error {
+	if data == nil {
+		return fmt.Errorf("ApplyPidsWithRelativePath with nil cgroup data")
+	}
+
+	absCgroupPath := common.GetAbsCgroupPath(common.CgroupSubsysPids, relCgroupPath)
+	return GetManagerForSubsys(common.CgroupSubsysPids).ApplyPids(absCgroupPath, data)
+}
+
+func ApplyPidsWithAbsolutePath(absCgroupPath string, data *common.PidsData) error {
+	if data == nil {
+		return fmt.Errorf("ApplyPidsWithAbsolutePath with nil cgroup data")
+	}
+
+	return GetManager().ApplyPids(absCgroupPath, data)
+}
+
+func ApplyFreezerWithRelativePath(relCgroupPath string, data *common.FreezerData) error {
+	if data == nil {
+		return fmt.Errorf("ApplyFreezerWithRelativePath with nil cgroup data")
+	}
+
+	absCgroupPath := common.GetAbsCgroupPath(common.CgroupSubsysFreezer, relCgroupPath)
+	return GetManagerForSubsys(common.CgroupSubsysFreezer).ApplyFreezer(absCgroupPath, data)
+}
+
+func ApplyFreezerWithAbsolutePath(absCgroupPath string, data *common.FreezerData) error {
+	if data == nil {
+		return fmt.Errorf("ApplyFreezerWithAbsolutePath with nil cgroup data")
+	}
+
+	return GetManager().ApplyFreezer(absCgroupPath, data)
+}
+
 func ApplyIOCostQoSWithRelativePath(relCgroupPath string, devID string, data *common.IOCostQoSData) error {
 	if data == nil {
 		return fmt.Errorf("ApplyIOCostQoSWithRelativePath with nil cgroup data")
@@ -193,7 +227,7 @@ func ApplyUnifiedDataForContainer(podUID, containerId, subsys, cgroupFileName, d
 
 func GetMemoryWithRelativePath(relCgroupPath string) (*common.MemoryStats, error) {
 	absCgroupPath := common.GetAbsCgroupPath("memory", relCgroupPath)
-	return GetManager().GetMemory(absCgroupPath)
+	return GetManagerForSubsys("memory").GetMemory(absCgroupPath)
 }
 
 func GetMemoryWithAbsolutePath(absCgroupPath string) (*common.MemoryStats, error) {
@@ -242,7 +276,7 @@ func GetIOStatWithAbsolutePath(absCgroupPath string) (map[string]map[string]stri
 
 func GetCPUWithRelativePath(relCgroupPath string) (*common.CPUStats, error) {
 	absCgroupPath := common.GetAbsCgroupPath("cpu", relCgroupPath)
-	return GetManager().GetCPU(absCgroupPath)
+	return GetManagerForSubsys("cpu").GetCPU(absCgroupPath)
 }
 
 func GetCPUWithAbsolutePath(absCgroupPath string) (*common.CPUStats, error) {
@@ -255,7 +289,7 @@ func GetCPUSetWithAbsolutePath(absCgroupPath string) (*common.CPUSetStats, error
 
 func GetCPUSetWithRelativePath(relCgroupPath string) (*common.CPUSetStats, error) {
 	absCgroupPath := common.GetAbsCgroupPath("cpuset", relCgroupPath)
-	return GetManager().GetCPUSet(absCgroupPath)
+	return GetManagerForSubsys("cpuset").GetCPUSet(absCgroupPath)
 }
 
 func GetMetricsWithRelativePath(relCgroupPath string, subsystems map[string]struct{}) (*common.CgroupMetrics, error) {
@@ -90,6 +90,14 @@ func testManager(t *testing.T, version string) {
 	assert.NoError(t, err)
 	err = ApplyCPUSetWithAbsolutePath("/test", &common.CPUSetData{})
 	assert.NoError(t, err)
+	err = ApplyPidsWithRelativePath("/test", &common.PidsData{})
+	assert.NoError(t, err)
+	err = ApplyPidsWithAbsolutePath("/test", &common.PidsData{})
+	assert.NoError(t, err)
+	err = ApplyFreezerWithRelativePath("/test", &common.FreezerData{})
+	assert.NoError(t, err)
+	err = ApplyFreezerWithAbsolutePath("/test", &common.FreezerData{})
+	assert.NoError(t, err)
 	err = ApplyCPUSetForContainer("fake-pod", "fake-container", &common.CPUSetData{})
 	assert.NotNil(t, err)
 	err = ApplyUnifiedDataForContainer("fake-pod", "fake-container", common.CgroupSubsysMemory, "memory.high", "max")
@@ -36,6 +36,14 @@ func (f *FakeCgroupManager) ApplyNetCls(absCgroupPath string, data *common.NetCl
 	return nil
 }
 
+func (f *FakeCgroupManager) ApplyPids(absCgroupPath string, data *common.PidsData) error {
+	return nil
+}
+
+func (f *FakeCgroupManager) ApplyFreezer(absCgroupPath string, data *common.FreezerData) error {
+	return nil
+}
+
 func (f *FakeCgroupManager) ApplyIOCostQoS(absCgroupPath string, devID string, data *common.IOCostQoSData) error {
 	return nil
 }
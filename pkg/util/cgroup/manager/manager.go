@@ -27,6 +27,12 @@ import (
 var (
 	initManagerOnce sync.Once
 	manager         Manager
+	// unifiedManager is the manager of controllers enabled in the unified hierarchy in hybrid mode
+	initUnifiedManagerOnce sync.Once
+	unifiedManager         Manager
+	// v1Manager is the manager of cgroup v1 hierarchies regardless of the mode of the default mount point
+	initV1ManagerOnce sync.Once
+	v1Manager         Manager
 )
 
 // Manager cgroup operation interface for different sub-systems.
@@ -38,6 +44,8 @@ type Manager interface {
 	ApplyCPU(absCgroupPath string, data *common.CPUData) error
 	ApplyCPUSet(absCgroupPath string, data *common.CPUSetData) error
 	ApplyNetCls(absCgroupPath string, data *common.NetClsData) error
+	ApplyPids(absCgroupPath string, data *common.PidsData) error
+	ApplyFreezer(absCgroupPath string, data *common.FreezerData) error
 	ApplyIOCostQoS(absCgroupPath string, devID string, data *common.IOCostQoSData) error
 	ApplyIOCostModel(absCgroupPath string, devID string, data *common.IOCostModelData) error
 	ApplyIOWeight(absCgroupPath string, devID string, weight uint64) error
@@ -69,3 +77,31 @@ func GetManager() Manager {
 	})
 	return manager
 }
+
+// GetManagerForSubsys returns the cgroup instance for the hierarchy serving the subsystem, which differs from
+// GetManager only in hybrid mode, where controllers enabled in the unified hierarchy are served by cgroup v2.
+func GetManagerForSubsys(subsys string) Manager {
+	if !common.CheckCgroup2UnifiedMode() && common.IsSubsysOnCgroupV2(subsys) {
+		initUnifiedManagerOnce.Do(func() {
+			unifiedManager = v2.NewManager()
+		})
+		return unifiedManager
+	}
+	return GetManager()
+}
+
+// GetManagerForHierarchy returns the cgroup instance of cgroup v2 if the hierarchy is unified and of cgroup v1
+// otherwise, which is for cgroups mounted somewhere other than the default mount point, where the hierarchy can't
+// be told by GetManagerForSubsys.
+func GetManagerForHierarchy(unified bool) Manager {
+	if unified {
+		initUnifiedManagerOnce.Do(func() {
+			unifiedManager = v2.NewManager()
+		})
+		return unifiedManager
+	}
+	initV1ManagerOnce.Do(func() {
+		v1Manager = v1.NewManager()
+	})
+	return v1Manager
+}
@@ -103,21 +103,7 @@ func (m *manager) ApplyCPU(absCgroupPath string, data *common.CPUData) error {
 		}
 	}
 
-	if data.CpuPeriod != 0 {
-		if err, applied, oldData := common.InstrumentedWriteFileIfChange(absCgroupPath, "cpu.cfs_period_us", strconv.FormatUint(data.CpuPeriod, 10)); err != nil {
-			lastErrors = append(lastErrors, err)
-		} else if applied {
-			klog.Infof("[CgroupV1] apply cpu cfs_period successfully, cgroupPath: %s, data: %v, old data: %v\n", absCgroupPath, data.CpuPeriod, oldData)
-		}
-	}
-
-	if data.CpuQuota != 0 {
-		if err, applied, oldData := common.InstrumentedWriteFileIfChange(absCgroupPath, "cpu.cfs_quota_us", strconv.FormatInt(data.CpuQuota, 10)); err != nil {
-			lastErrors = append(lastErrors, err)
-		} else if applied {
-			klog.Infof("[CgroupV1] apply cpu cfs_quota successfully, cgroupPath: %s, data: %v, old data: %v\n", absCgroupPath, data.CpuQuota, oldData)
-		}
-	}
+	lastErrors = append(lastErrors, applyCPUQuotaAndPeriod(absCgroupPath, data)...)
 
 	if data.CpuIdlePtr != nil {
 		var cpuIdleValue int64
@@ -134,6 +120,14 @@ func (m *manager) ApplyCPU(absCgroupPath string, data *common.CPUData) error {
 		}
 	}
 
+	if data.CpuBurstPtr != nil {
+		if err, applied, oldData := common.InstrumentedWriteFileIfChange(absCgroupPath, "cpu.cfs_burst_us", strconv.FormatUint(*data.CpuBurstPtr, 10)); err != nil {
+			lastErrors = append(lastErrors, err)
+		} else if applied {
+			klog.Infof("[CgroupV1] apply cpu cfs_burst successfully, cgroupPath: %s, data: %v, old data: %v\n", absCgroupPath, *data.CpuBurstPtr, oldData)
+		}
+	}
+
 	if len(lastErrors) == 0 {
 		return nil
 	}
@@ -149,6 +143,48 @@ func (m *manager) ApplyCPU(absCgroupPath string, data *common.CPUData) error {
 	return fmt.Errorf("%s", errMsg)
 }
 
+// applyCPUQuotaAndPeriod writes cfs quota and period, which are separate files in cgroup v1, in the order that
+// the cgroup is never transiently throttled by a mix of the old and new values, i.e. quota is written first
+// when the period is increased, and period is written first when it's decreased.
+func applyCPUQuotaAndPeriod(absCgroupPath string, data *common.CPUData) []error {
+	writePeriod := func() error {
+		if data.CpuPeriod == 0 {
+			return nil
+		}
+		err, applied, oldData := common.InstrumentedWriteFileIfChange(absCgroupPath, "cpu.cfs_period_us", strconv.FormatUint(data.CpuPeriod, 10))
+		if err == nil && applied {
+			klog.Infof("[CgroupV1] apply cpu cfs_period successfully, cgroupPath: %s, data: %v, old data: %v\n", absCgroupPath, data.CpuPeriod, oldData)
+		}
+		return err
+	}
+	writeQuota := func() error {
+		if data.CpuQuota == 0 {
+			return nil
+		}
+		err, applied, oldData := common.InstrumentedWriteFileIfChange(absCgroupPath, "cpu.cfs_quota_us", strconv.FormatInt(data.CpuQuota, 10))
+		if err == nil && applied {
+			klog.Infof("[CgroupV1] apply cpu cfs_quota successfully, cgroupPath: %s, data: %v, old data: %v\n", absCgroupPath, data.CpuQuota, oldData)
+		}
+		return err
+	}
+
+	writes := []func() error{writePeriod, writeQuota}
+	if data.CpuPeriod != 0 && data.CpuQuota != 0 {
+		currentPeriod, err := fscommon.GetCgroupParamUint(absCgroupPath, "cpu.cfs_period_us")
+		if err == nil && data.CpuPeriod > currentPeriod {
+			writes = []func() error{writeQuota, writePeriod}
+		}
+	}
+
+	var errs []error
+	for _, write := range writes {
+		if err := write(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
 func (m *manager) ApplyCPUSet(absCgroupPath string, data *common.CPUSetData) error {
 	if len(data.CPUs) != 0 {
 		if err, applied, oldData := common.InstrumentedWriteFileIfChange(absCgroupPath, "cpuset.cpus", data.CPUs); err != nil {
@@ -194,6 +230,38 @@ func (m *manager) ApplyNetCls(absCgroupPath string, data *common.NetClsData) err
 	return nil
 }
 
+func (m *manager) ApplyPids(absCgroupPath string, data *common.PidsData) error {
+	if data.PidsMax != 0 {
+		pidsMax := "max"
+		if data.PidsMax > 0 {
+			pidsMax = strconv.FormatInt(data.PidsMax, 10)
+		}
+		if err, applied, oldData := common.InstrumentedWriteFileIfChange(absCgroupPath, "pids.max", pidsMax); err != nil {
+			return err
+		} else if applied {
+			klog.Infof("[CgroupV1] apply pids max successfully, cgroupPath: %s, data: %v, old data: %v\n", absCgroupPath, pidsMax, oldData)
+		}
+	}
+
+	return nil
+}
+
+func (m *manager) ApplyFreezer(absCgroupPath string, data *common.FreezerData) error {
+	if data.FrozenPtr != nil {
+		state := "THAWED"
+		if *data.FrozenPtr {
+			state = "FROZEN"
+		}
+		if err, applied, oldData := common.InstrumentedWriteFileIfChange(absCgroupPath, "freezer.state", state); err != nil {
+			return err
+		} else if applied {
+			klog.Infof("[CgroupV1] apply freezer state successfully, cgroupPath: %s, data: %v, old data: %v\n", absCgroupPath, state, oldData)
+		}
+	}
+
+	return nil
+}
+
 func (m *manager) ApplyIOCostQoS(absCgroupPath string, devID string, data *common.IOCostQoSData) error {
 	return errors.New("cgroups v1 does not support io.cost.qos")
 }
@@ -303,6 +371,15 @@ func (m *manager) GetCPU(absCgroupPath string) (*common.CPUStats, error) {
 		return nil, fmt.Errorf("get cfs quota %s err, %v", absCgroupPath, err)
 	}
 
+	// cpu.cfs_burst_us only exists on kernels supporting cpu burst
+	if general.IsPathExists(filepath.Join(absCgroupPath, "cpu.cfs_burst_us")) {
+		burst, err := fscommon.GetCgroupParamUint(absCgroupPath, "cpu.cfs_burst_us")
+		if err != nil {
+			return nil, fmt.Errorf("get cfs burst %s err, %v", absCgroupPath, err)
+		}
+		cpuStats.CpuBurst = &burst
+	}
+
 	cpuStats.CpuPeriod = period
 	cpuStats.CpuQuota = quota
 	return cpuStats, nil
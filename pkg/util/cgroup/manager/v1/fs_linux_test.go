@@ -23,6 +23,10 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/bytedance/mockey"
+	"github.com/opencontainers/runc/libcontainer/cgroups/fscommon"
+	"github.com/stretchr/testify/assert"
+
 	"github.com/kubewharf/katalyst-core/pkg/util/cgroup/common"
 )
 
@@ -97,3 +101,56 @@ func Test_manager_ApplyMemory(t *testing.T) {
 		})
 	}
 }
+
+func Test_manager_ApplyCPU_writeOrder(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		currentPeriod uint64
+		data          *common.CPUData
+		wantWrites    []string
+	}{
+		{
+			name:          "quota is written first when the period is increased",
+			currentPeriod: 100000,
+			data:          &common.CPUData{CpuQuota: 400000, CpuPeriod: 200000},
+			wantWrites:    []string{"cpu.cfs_quota_us", "cpu.cfs_period_us"},
+		},
+		{
+			name:          "period is written first when the period is decreased",
+			currentPeriod: 100000,
+			data:          &common.CPUData{CpuQuota: 100000, CpuPeriod: 50000},
+			wantWrites:    []string{"cpu.cfs_period_us", "cpu.cfs_quota_us"},
+		},
+		{
+			name:          "period is written first when the period is unchanged",
+			currentPeriod: 100000,
+			data:          &common.CPUData{CpuQuota: 100000, CpuPeriod: 100000},
+			wantWrites:    []string{"cpu.cfs_period_us", "cpu.cfs_quota_us"},
+		},
+		{
+			name:          "only quota is written without period",
+			currentPeriod: 100000,
+			data:          &common.CPUData{CpuQuota: 100000},
+			wantWrites:    []string{"cpu.cfs_quota_us"},
+		},
+	}
+
+	mockey.PatchConvey("test cfs quota and period are written in a safe order", t, func() {
+		for _, tt := range tests {
+			var writes []string
+			mockey.Mock(common.InstrumentedWriteFileIfChange).IncludeCurrentGoRoutine().To(
+				func(_, file, _ string) (error, bool, string) {
+					writes = append(writes, file)
+					return nil, true, ""
+				}).Build()
+			mockey.Mock(fscommon.GetCgroupParamUint).IncludeCurrentGoRoutine().Return(tt.currentPeriod, nil).Build()
+
+			m := &manager{}
+			assert.NoError(t, m.ApplyCPU("test-fake-path", tt.data), tt.name)
+			assert.Equal(t, tt.wantWrites, writes, tt.name)
+			mockey.UnPatchAll()
+		}
+	})
+}
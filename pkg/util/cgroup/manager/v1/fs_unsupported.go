@@ -48,6 +48,14 @@ func (m *unsupportedManager) ApplyNetCls(_ string, _ *common.NetClsData) error {
 	return fmt.Errorf("unsupported manager v1")
 }
 
+func (m *unsupportedManager) ApplyPids(_ string, _ *common.PidsData) error {
+	return fmt.Errorf("unsupported manager v1")
+}
+
+func (m *unsupportedManager) ApplyFreezer(_ string, _ *common.FreezerData) error {
+	return fmt.Errorf("unsupported manager v1")
+}
+
 func (m *unsupportedManager) ApplyIOCostQoS(absCgroupPath string, devID string, data *common.IOCostQoSData) error {
 	return fmt.Errorf("unsupported manager v1")
 }
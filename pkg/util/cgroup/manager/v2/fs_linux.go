@@ -25,7 +25,6 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
-	"math"
 	"os"
 	"path"
 	"path/filepath"
@@ -109,17 +108,13 @@ func (m *manager) ApplyCPU(absCgroupPath string, data *common.CPUData) error {
 	}
 
 	if data.CpuQuota != 0 || data.CpuPeriod != 0 {
-		str := "max"
-		if data.CpuQuota > 0 {
-			str = strconv.FormatInt(data.CpuQuota, 10)
-		}
 		period := data.CpuPeriod
 		if period == 0 {
 			period = 100000
 		}
 
 		// refer to https://www.kernel.org/doc/html/latest/admin-guide/cgroup-v2.html
-		str += " " + strconv.FormatUint(period, 10)
+		str := common.FormatCPUMax(data.CpuQuota, int64(period))
 		if err, applied, oldData := common.InstrumentedWriteFileIfChange(absCgroupPath, "cpu.max", str); err != nil {
 			lastErrors = append(lastErrors, err)
 		} else if applied {
@@ -142,6 +137,32 @@ func (m *manager) ApplyCPU(absCgroupPath string, data *common.CPUData) error {
 		}
 	}
 
+	if data.CpuBurstPtr != nil {
+		if err, applied, oldData := common.InstrumentedWriteFileIfChange(absCgroupPath, "cpu.max.burst", strconv.FormatUint(*data.CpuBurstPtr, 10)); err != nil {
+			lastErrors = append(lastErrors, err)
+		} else if applied {
+			klog.Infof("[CgroupV2] apply cpu max burst successfully, cgroupPath: %s, data: %v, old data: %v\n", absCgroupPath, *data.CpuBurstPtr, oldData)
+		}
+	}
+
+	if data.CpuUclampMinPtr != nil {
+		uclampMin := strconv.FormatFloat(*data.CpuUclampMinPtr, 'f', 2, 64)
+		if err, applied, oldData := common.InstrumentedWriteFileIfChange(absCgroupPath, "cpu.uclamp.min", uclampMin); err != nil {
+			lastErrors = append(lastErrors, err)
+		} else if applied {
+			klog.Infof("[CgroupV2] apply cpu uclamp min successfully, cgroupPath: %s, data: %v, old data: %v\n", absCgroupPath, uclampMin, oldData)
+		}
+	}
+
+	if data.CpuUclampMaxPtr != nil {
+		uclampMax := strconv.FormatFloat(*data.CpuUclampMaxPtr, 'f', 2, 64)
+		if err, applied, oldData := common.InstrumentedWriteFileIfChange(absCgroupPath, "cpu.uclamp.max", uclampMax); err != nil {
+			lastErrors = append(lastErrors, err)
+		} else if applied {
+			klog.Infof("[CgroupV2] apply cpu uclamp max successfully, cgroupPath: %s, data: %v, old data: %v\n", absCgroupPath, uclampMax, oldData)
+		}
+	}
+
 	if len(lastErrors) == 0 {
 		return nil
 	}
@@ -189,6 +210,38 @@ func (m *manager) ApplyNetCls(_ string, _ *common.NetClsData) error {
 	return errors.New("cgroups v2 does not support net_cls cgroup, please use eBPF via external manager")
 }
 
+func (m *manager) ApplyPids(absCgroupPath string, data *common.PidsData) error {
+	if data.PidsMax != 0 {
+		pidsMax := "max"
+		if data.PidsMax > 0 {
+			pidsMax = strconv.FormatInt(data.PidsMax, 10)
+		}
+		if err, applied, oldData := common.InstrumentedWriteFileIfChange(absCgroupPath, "pids.max", pidsMax); err != nil {
+			return err
+		} else if applied {
+			klog.Infof("[CgroupV2] apply pids max successfully, cgroupPath: %s, data: %v, old data: %v\n", absCgroupPath, pidsMax, oldData)
+		}
+	}
+
+	return nil
+}
+
+func (m *manager) ApplyFreezer(absCgroupPath string, data *common.FreezerData) error {
+	if data.FrozenPtr != nil {
+		state := "0"
+		if *data.FrozenPtr {
+			state = "1"
+		}
+		if err, applied, oldData := common.InstrumentedWriteFileIfChange(absCgroupPath, "cgroup.freeze", state); err != nil {
+			return err
+		} else if applied {
+			klog.Infof("[CgroupV2] apply cgroup freeze successfully, cgroupPath: %s, data: %v, old data: %v\n", absCgroupPath, state, oldData)
+		}
+	}
+
+	return nil
+}
+
 func (m *manager) ApplyIOCostQoS(absCgroupPath string, devID string, data *common.IOCostQoSData) error {
 	if data == nil {
 		return fmt.Errorf("ApplyIOCostQoS got nil data")
@@ -463,28 +516,21 @@ func (m *manager) GetCPU(absCgroupPath string) (*common.CPUStats, error) {
 		return nil, err
 	}
 
-	trimmed := strings.TrimSpace(string(contents))
-	parts := strings.Split(trimmed, " ")
-	if len(parts) != 2 {
+	quota, period, err := common.ParseCPUMax(string(contents))
+	if err != nil {
 		return nil, fmt.Errorf("get cpu %s err, %v", absCgroupPath, err)
 	}
 
-	var quota int64
-	if parts[0] == "max" {
-		quota = math.MaxInt64
-	} else {
-		quota, err = strconv.ParseInt(parts[0], 10, 64)
+	// cpu.max.burst only exists on kernels supporting cpu burst
+	if general.IsPathExists(filepath.Join(absCgroupPath, "cpu.max.burst")) {
+		burst, err := fscommon.GetCgroupParamUint(absCgroupPath, "cpu.max.burst")
 		if err != nil {
-			return nil, fmt.Errorf("parse int %s err, err %v", parts[0], err)
+			return nil, fmt.Errorf("get cpu max burst %s err, %v", absCgroupPath, err)
 		}
+		cpuStats.CpuBurst = &burst
 	}
 
-	period, err := strconv.ParseUint(parts[1], 10, 64)
-	if err != nil {
-		return nil, fmt.Errorf("parse uint %s err, err %v", parts[1], err)
-	}
-
-	cpuStats.CpuPeriod = period
+	cpuStats.CpuPeriod = uint64(period)
 	cpuStats.CpuQuota = quota
 	return cpuStats, nil
 }
@@ -222,6 +222,115 @@ func Test_manager_ApplyNetCls(t *testing.T) {
 	}
 }
 
+func Test_manager_ApplyPids(t *testing.T) {
+	t.Parallel()
+
+	type args struct {
+		absCgroupPath string
+		data          *common.PidsData
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr bool
+	}{
+		{
+			name: "test apply pids max",
+			args: args{
+				absCgroupPath: "test-fake-path",
+				data: &common.PidsData{
+					PidsMax: 100,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "test apply unlimited pids max",
+			args: args{
+				absCgroupPath: "test-fake-path",
+				data: &common.PidsData{
+					PidsMax: common.PidsMaxUnlimit,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "test apply no pids max",
+			args: args{
+				absCgroupPath: "test-fake-path",
+				data:          &common.PidsData{},
+			},
+			wantErr: false,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			m := &manager{}
+			if err := m.ApplyPids(tt.args.absCgroupPath, tt.args.data); (err != nil) != tt.wantErr {
+				t.Errorf("manager.ApplyPids() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_manager_ApplyFreezer(t *testing.T) {
+	t.Parallel()
+
+	frozen, thawed := true, false
+	type args struct {
+		absCgroupPath string
+		data          *common.FreezerData
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr bool
+	}{
+		{
+			name: "test apply freeze",
+			args: args{
+				absCgroupPath: "test-fake-path",
+				data: &common.FreezerData{
+					FrozenPtr: &frozen,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "test apply thaw",
+			args: args{
+				absCgroupPath: "test-fake-path",
+				data: &common.FreezerData{
+					FrozenPtr: &thawed,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "test apply no freezer state",
+			args: args{
+				absCgroupPath: "test-fake-path",
+				data:          &common.FreezerData{},
+			},
+			wantErr: false,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			m := &manager{}
+			if err := m.ApplyFreezer(tt.args.absCgroupPath, tt.args.data); (err != nil) != tt.wantErr {
+				t.Errorf("manager.ApplyFreezer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func Test_manager_ApplyIOCostQoS(t *testing.T) {
 	t.Parallel()
 
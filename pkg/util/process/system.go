@@ -250,6 +250,25 @@ func SetProcessNice(pid int, niceness int) error {
 	return nil
 }
 
+// SetProcessOOMScoreAdj writes the oom_score_adj of the process, which biases the kernel
+// in selecting OOM victims, and it must be in the range [-1000, 1000].
+func SetProcessOOMScoreAdj(pid int, oomScoreAdj int) error {
+	if pid < 0 {
+		return fmt.Errorf("invalid pid %d", pid)
+	}
+
+	if oomScoreAdj < -1000 || oomScoreAdj > 1000 {
+		return fmt.Errorf("invalid oom_score_adj %d", oomScoreAdj)
+	}
+
+	oomScoreAdjFile := filepath.Join("/proc", strconv.Itoa(pid), "oom_score_adj")
+	if err := os.WriteFile(oomScoreAdjFile, []byte(strconv.Itoa(oomScoreAdj)), 0o644); err != nil {
+		return fmt.Errorf("failed to write oom_score_adj for pid %d, oom_score_adj %d, err %w", pid, oomScoreAdj, err)
+	}
+
+	return nil
+}
+
 func GetProcessNice(pid int) (int, error) {
 	if pid < 0 {
 		return 0, fmt.Errorf("invalid pid %d", pid)
@@ -30,3 +30,15 @@ func TestIsCommandInDState(t *testing.T) {
 		t.Errorf("IsCommandInDState() = %v, want false", got)
 	}
 }
+
+func TestSetProcessOOMScoreAdj(t *testing.T) {
+	t.Parallel()
+	for _, oomScoreAdj := range []int{-1001, 1001} {
+		if err := SetProcessOOMScoreAdj(1, oomScoreAdj); err == nil {
+			t.Errorf("SetProcessOOMScoreAdj() with oom_score_adj %d, want error", oomScoreAdj)
+		}
+	}
+	if err := SetProcessOOMScoreAdj(-1, 0); err == nil {
+		t.Errorf("SetProcessOOMScoreAdj() with pid -1, want error")
+	}
+}